@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aqstack/mimir/internal/config"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// runCommand runs the subcommand named by args[0] and returns its exit
+// code. ok is false when args name no subcommand, so the server starts.
+func runCommand(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	var err error
+	switch args[0] {
+	case "dump":
+		err = runDump(args[1:])
+	case "load":
+		err = runLoad(args[1:])
+	default:
+		return 0, false
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0, true
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mimir %s: %v\n", args[0], err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// cliClient talks to a running instance on behalf of a subcommand.
+type cliClient struct {
+	url        string
+	authToken  string
+	adminToken string
+	client     *http.Client
+}
+
+// addClientFlags registers the flags locating a running instance on fs.
+// They default to the local instance configured by the environment; the
+// tokens are filled in by resolve so they never show up in -help.
+func addClientFlags(fs *flag.FlagSet) *cliClient {
+	c := &cliClient{client: &http.Client{Timeout: 10 * time.Minute}}
+	fs.StringVar(&c.url, "url", "", "Base URL of the running mimir instance (default http://localhost:$MIMIR_PORT)")
+	fs.StringVar(&c.authToken, "auth-token", "", "Bearer token when MIMIR_AUTH_TOKEN is set (default its first token)")
+	fs.StringVar(&c.adminToken, "admin-token", "", "Admin token (default $MIMIR_ADMIN_TOKEN)")
+	return c
+}
+
+// resolve fills in the flags left unset from cfg.
+func (c *cliClient) resolve(cfg *config.Config) {
+	if c.url == "" {
+		c.url = fmt.Sprintf("http://localhost:%d", cfg.Port)
+	}
+	if c.authToken == "" && len(cfg.AuthTokens) > 0 {
+		c.authToken = cfg.AuthTokens[0]
+	}
+	if c.adminToken == "" {
+		c.adminToken = cfg.AdminToken
+	}
+}
+
+// do sends a request to path on the instance and returns the response,
+// which must be a success.
+func (c *cliClient) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(c.url, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	if c.adminToken != "" {
+		req.Header.Set("X-Mimir-Admin-Token", c.adminToken)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// runDump writes the entries of a running instance, or of a persisted
+// snapshot with -file, as JSON lines.
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	client := addClientFlags(fs)
+	out := fs.String("out", "-", "File to write entries to, or - for stdout")
+	file := fs.String("file", "", "Read a MIMIR_CACHE_PERSIST_PATH snapshot instead of a running instance")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client.resolve(config.LoadFromEnv())
+
+	var src io.Reader
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	} else {
+		resp, err := client.do(http.MethodGet, "/cache/dump", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		src = resp.Body
+	}
+
+	dst := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	}
+
+	n, expired, err := copyEntries(dst, src)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d entries (%d expired skipped)\n", n, expired)
+	return nil
+}
+
+// copyEntries copies the unexpired cache entries in src to dst as JSON
+// lines, returning how many were copied and how many had expired.
+func copyEntries(dst io.Writer, src io.Reader) (int, int, error) {
+	now := time.Now()
+	copied, expired := 0, 0
+	w := bufio.NewWriter(dst)
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry api.CacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return copied, expired, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt) {
+			expired++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+		copied++
+	}
+	if err := scanner.Err(); err != nil {
+		return copied, expired, err
+	}
+	return copied, expired, w.Flush()
+}
+
+// runLoad posts JSON lines of cache entries to a running instance.
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	client := addClientFlags(fs)
+	in := fs.String("in", "-", "File of entries in the dump format, or - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client.resolve(config.LoadFromEnv())
+
+	src := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	resp, err := client.do(http.MethodPost, "/cache/load", src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Loaded     int   `json:"loaded"`
+		Embedded   int   `json:"embedded"`
+		Failed     int   `json:"failed"`
+		DurationMs int64 `json:"duration_ms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d entries (%d embedded, %d failed) in %dms\n",
+		result.Loaded, result.Embedded, result.Failed, result.DurationMs)
+	return nil
+}
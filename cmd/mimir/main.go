@@ -8,6 +8,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -19,6 +20,8 @@ import (
 	"github.com/aqstack/mimir/internal/embedding"
 	"github.com/aqstack/mimir/internal/logger"
 	"github.com/aqstack/mimir/internal/proxy"
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/internal/tracing"
 )
 
 var (
@@ -28,6 +31,11 @@ var (
 )
 
 func main() {
+	// Run a subcommand instead of the server when one is named
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	// Parse flags
 	showVersion := flag.Bool("version", false, "Show version information")
 	flag.Parse()
@@ -40,8 +48,9 @@ func main() {
 	// Load configuration
 	cfg := config.LoadFromEnv()
 
-	// Setup logger
-	log := logger.New(cfg.LogJSON)
+	// Setup logger; an unknown level falls back to info until Validate rejects it
+	level, _ := logger.ParseLevel(cfg.LogLevel)
+	log := logger.New(cfg.LogJSON, level)
 
 	log.Info("starting mimir",
 		"version", version,
@@ -57,49 +66,145 @@ func main() {
 	}
 
 	// Initialize embedder based on provider
-	var embedder embedding.Embedder
-	switch cfg.EmbeddingProvider {
-	case "ollama":
-		embedder = embedding.NewOllamaEmbedder(&embedding.OllamaConfig{
-			BaseURL: cfg.OllamaBaseURL,
-			Model:   cfg.EmbeddingModel,
-		})
-		log.Info("initialized Ollama embedder",
-			"base_url", cfg.OllamaBaseURL,
-			"model", embedder.Model(),
-			"dimensions", embedder.Dimensions(),
-		)
-	case "openai":
-		embedder = embedding.NewOpenAIEmbedder(&embedding.OpenAIConfig{
-			APIKey:  cfg.OpenAIAPIKey,
-			BaseURL: cfg.OpenAIBaseURL,
-			Model:   cfg.EmbeddingModel,
-		})
-		log.Info("initialized OpenAI embedder",
-			"model", embedder.Model(),
-			"dimensions", embedder.Dimensions(),
-		)
-	}
+	embedder := newEmbedder(cfg, cfg.EmbeddingModel)
+	log.Info("initialized embedder",
+		"provider", cfg.EmbeddingProvider,
+		"model", embedder.Model(),
+		"dimensions", embedder.Dimensions(),
+	)
 
 	// Initialize cache
-	semanticCache := cache.NewMemoryCache(&cache.Options{
-		MaxSize:             cfg.MaxCacheSize,
-		DefaultTTL:          cfg.CacheTTL,
-		CleanupInterval:     5 * time.Minute,
-		SimilarityThreshold: cfg.SimilarityThreshold,
-	})
+	semanticCache, err := newCache(cfg)
+	if err != nil {
+		log.Error("failed to initialize cache", "backend", cfg.CacheBackend, "error", err)
+		os.Exit(1)
+	}
 
 	log.Info("initialized cache",
+		"backend", cfg.CacheBackend,
 		"max_size", cfg.MaxCacheSize,
 		"ttl", cfg.CacheTTL.String(),
+		"eviction_policy", cfg.EvictionPolicy,
+		"read_only", cfg.CacheReadOnly,
 	)
 
+	// Reload the previous snapshot
+	if mc, ok := semanticCache.(*cache.MemoryCache); ok && cfg.CachePersistPath != "" {
+		start := time.Now()
+		n, err := mc.RestoreFile(cfg.CachePersistPath)
+		if err != nil {
+			log.Error("failed to restore cache snapshot", "path", cfg.CachePersistPath, "error", err)
+		} else {
+			log.Info("restored cache snapshot",
+				"path", cfg.CachePersistPath,
+				"entries", n,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+		}
+	}
+
+	// Start periodic snapshots
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	defer stopSnapshots()
+	if cfg.SnapshotInterval > 0 {
+		if mc, ok := semanticCache.(*cache.MemoryCache); ok {
+			go runSnapshots(snapshotCtx, mc, cfg.CachePersistPath, cfg.SnapshotInterval, log)
+			log.Info("periodic snapshots enabled",
+				"path", cfg.CachePersistPath,
+				"interval", cfg.SnapshotInterval.String(),
+			)
+		} else {
+			log.Warn("periodic snapshots are only supported by the memory backend", "backend", cfg.CacheBackend)
+		}
+	}
+
+	// A response can't outlive the server write timeout, whatever the upstream timeout
+	if cfg.ServerWriteTimeout > 0 && cfg.UpstreamTimeout > cfg.ServerWriteTimeout {
+		log.Warn("upstream timeout exceeds server write timeout, responses will be cut off",
+			"upstream_timeout", cfg.UpstreamTimeout.String(),
+			"write_timeout", cfg.ServerWriteTimeout.String(),
+		)
+	}
+	for path, timeout := range cfg.RouteTimeouts {
+		if cfg.ServerWriteTimeout > 0 && timeout > cfg.ServerWriteTimeout {
+			log.Warn("route timeout exceeds server write timeout, responses will be cut off",
+				"route", path,
+				"route_timeout", timeout.String(),
+				"write_timeout", cfg.ServerWriteTimeout.String(),
+			)
+		}
+	}
+
+	for model, ttl := range cfg.ModelTTLs {
+		if cfg.MaxTTL > 0 && ttl > cfg.MaxTTL {
+			log.Warn("model TTL exceeds max TTL, clamping",
+				"model", model,
+				"ttl", ttl.String(),
+				"max_ttl", cfg.MaxTTL.String(),
+			)
+		}
+	}
+
 	// Create handler
 	handler := proxy.NewHandler(cfg, semanticCache, embedder, log)
+	for _, model := range cfg.ExtraEmbeddingModels {
+		extra := newEmbedder(cfg, model)
+		handler.RegisterEmbedder(extra)
+		log.Info("registered additional embedder",
+			"model", extra.Model(),
+			"dimensions", extra.Dimensions(),
+		)
+	}
+
+	if cfg.CompareEmbeddingModel != "" {
+		candidate := newEmbedder(cfg, cfg.CompareEmbeddingModel)
+		handler.SetCompareEmbedder(candidate)
+		log.Info("comparing embedders on sampled traffic",
+			"candidate", candidate.Model(),
+			"sample_rate", cfg.CompareSampleRate,
+		)
+	}
+
+	if cfg.PreloadPath != "" {
+		preloadCache(handler, cfg, log)
+	}
+
+	// Export request traces when a collector is configured
+	var tracer *tracing.Tracer
+	if cfg.OTelEndpoint != "" {
+		tracer = tracing.New(cfg.OTelEndpoint, version, log)
+		handler.SetTracer(tracer)
+		log.Info("tracing enabled", "endpoint", cfg.OTelEndpoint)
+	}
+
+	// Watch for stored vectors the dot metric would misjudge
+	normCtx, stopNormChecks := context.WithCancel(context.Background())
+	defer stopNormChecks()
+	if cfg.NormCheck == "warn" || cfg.NormCheck == "reject" {
+		if cfg.SimilarityMetric != cache.MetricDot {
+			log.Info("norm check only applies to the dot metric, skipping", "metric", cfg.SimilarityMetric)
+		} else if mc, ok := semanticCache.(*cache.MemoryCache); ok {
+			checkNorms(mc, log)
+			go runNormChecks(normCtx, mc, cfg.NormCheckInterval, log)
+		} else {
+			log.Warn("norm checks are only supported by the memory backend", "backend", cfg.CacheBackend)
+		}
+	}
+
+	// Alert on hours that miss the hit-rate target
+	alertCtx, stopAlerts := context.WithCancel(context.Background())
+	defer stopAlerts()
+	if cfg.HourlyHitRateTarget > 0 {
+		alerter := reports.NewHitRateAlerter(handler.Collector(), cfg.HourlyHitRateTarget, cfg.AlertWebhookURL)
+		go runHitRateAlerts(alertCtx, alerter, log)
+		log.Info("hourly hit rate alerts enabled", "target", cfg.HourlyHitRateTarget)
+	}
 
 	// Apply middleware
 	var h http.Handler = handler
-	h = proxy.CORSMiddleware(h)
+	h = proxy.AuthMiddleware(cfg.AuthTokens)(h)
+	h = proxy.BlockMiddleware(cfg.BlockRules, cfg.BlockLogOnly, log)(h)
+	h = proxy.CORSMiddleware(cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders)(h)
 	h = proxy.LoggingMiddleware(log)(h)
 	h = proxy.RecoveryMiddleware(log)(h)
 
@@ -108,7 +213,7 @@ func main() {
 		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		Handler:      h,
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 2 * time.Minute,
+		WriteTimeout: cfg.ServerWriteTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -121,12 +226,32 @@ func main() {
 		}
 	}()
 
+	// Serve Prometheus metrics on their own port unless they share the main one
+	var metricsServer *http.Server
+	if cfg.MetricsEnabled && !cfg.MetricsOnMainPort() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", handler.HandleMetrics)
+		metricsServer = &http.Server{
+			Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.MetricsPort),
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Info("metrics listening", "addr", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("metrics server error", "error", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info("shutting down server...")
+	stopSnapshots()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -136,6 +261,19 @@ func main() {
 		log.Error("server forced to shutdown", "error", err)
 		os.Exit(1)
 	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	tracer.Shutdown(ctx)
+
+	// Persist the cache for the next start
+	if mc, ok := semanticCache.(*cache.MemoryCache); ok && cfg.CachePersistPath != "" {
+		if n, err := mc.SnapshotFile(cfg.CachePersistPath); err != nil {
+			log.Error("shutdown snapshot failed", "path", cfg.CachePersistPath, "error", err)
+		} else {
+			log.Info("shutdown snapshot written", "path", cfg.CachePersistPath, "entries", n)
+		}
+	}
 
 	// Print final stats
 	stats := semanticCache.Stats(context.Background())
@@ -149,3 +287,240 @@ func main() {
 
 	log.Info("server stopped")
 }
+
+// preloadCache seeds the cache from cfg.PreloadPath. Failures are logged
+// and never stop startup.
+func preloadCache(handler *proxy.Handler, cfg *config.Config, log *logger.Logger) {
+	f, err := os.Open(cfg.PreloadPath)
+	if err != nil {
+		log.Error("failed to open preload file", "path", cfg.PreloadPath, "error", err)
+		return
+	}
+	defer f.Close()
+
+	log.Info("preloading cache",
+		"path", cfg.PreloadPath,
+		"concurrency", cfg.MaxEmbedConcurrency,
+	)
+	result, err := handler.Preload(context.Background(), f)
+	if err != nil {
+		log.Error("preload stopped early", "error", err)
+	}
+	log.Info("preload complete",
+		"loaded", result.Loaded,
+		"embedded", result.Embedded,
+		"failed", result.Failed,
+		"duration", result.Duration.String(),
+	)
+}
+
+// newCache creates the configured cache backend.
+func newCache(cfg *config.Config) (cache.Cache, error) {
+	opts := &cache.Options{
+		MaxSize:             cfg.MaxCacheSize,
+		DefaultTTL:          cfg.CacheTTL,
+		CleanupInterval:     cfg.CleanupInterval,
+		CleanupChunkSize:    cfg.CleanupChunkSize,
+		SimilarityThreshold: cfg.SimilarityThreshold,
+		EvictionPolicy:      cfg.EvictionPolicy,
+		DiversityCandidates: cfg.DiversityCandidates,
+		RetainRawEmbeddings: cfg.RetainRawEmbeddings,
+		BatchSimilarity:     cfg.BatchSimilarity,
+		IndexType:           cfg.IndexType,
+		Shards:              cfg.CacheShards,
+		Metric:              cfg.SimilarityMetric,
+		RecencyHalfLife:     cfg.RecencyHalfLife,
+		Precision:           cfg.EmbeddingPrecision,
+		CompressResponses:   cfg.CompressResponses,
+		GhostSize:           cfg.GhostSize,
+		GhostTTL:            cfg.GhostTTL,
+		RedisURL:            cfg.RedisURL,
+		QdrantURL:           cfg.QdrantURL,
+		QdrantCollection:    cfg.QdrantCollection,
+		QdrantAPIKey:        cfg.QdrantAPIKey,
+		PostgresDSN:         cfg.PostgresDSN,
+		PostgresTable:       cfg.PostgresTable,
+	}
+	switch cfg.CacheBackend {
+	case "redis":
+		return cache.NewRedisCache(opts)
+	case "qdrant":
+		return cache.NewQdrantCache(opts)
+	case "postgres":
+		return cache.NewPostgresCache(opts)
+	}
+	return cache.NewMemoryCache(opts), nil
+}
+
+// newEmbedder creates an embedder for model on the configured provider,
+// retrying failed calls when MIMIR_EMBED_RETRIES is set, deduplicating
+// batch inputs unless MIMIR_EMBED_DEDUPE=false and batching concurrent
+// lookups when MIMIR_EMBED_BATCH_WINDOW is set.
+func newEmbedder(cfg *config.Config, model string) embedding.Embedder {
+	e := newProviderEmbedder(cfg, model)
+	if cfg.EmbedRetries > 0 {
+		e = embedding.NewRetryEmbedder(e, cfg.EmbedRetries, cfg.EmbedRetryBackoff)
+	}
+	if cfg.EmbedDedupe {
+		e = embedding.NewDedupeEmbedder(e)
+	}
+	if cfg.EmbedBatchWindow > 0 {
+		e = embedding.NewBatchEmbedder(e, cfg.EmbedBatchWindow, cfg.EmbedBatchSize)
+	}
+	return e
+}
+
+// newProviderEmbedder creates an embedder for model on the configured provider.
+// On Azure, models other than the primary one are addressed by a deployment
+// named after the model.
+func newProviderEmbedder(cfg *config.Config, model string) embedding.Embedder {
+	if cfg.EmbeddingProvider == "azure" {
+		deployment := model
+		if model == cfg.EmbeddingModel && cfg.AzureEmbeddingDeployment != "" {
+			deployment = cfg.AzureEmbeddingDeployment
+		}
+		return embedding.NewAzureOpenAIEmbedder(&embedding.AzureOpenAIConfig{
+			APIKey:     cfg.AzureOpenAIAPIKey,
+			Endpoint:   cfg.AzureOpenAIEndpoint,
+			Deployment: deployment,
+			APIVersion: cfg.AzureOpenAIAPIVersion,
+			Model:      model,
+		})
+	}
+	if cfg.EmbeddingProvider == "hf-tei" {
+		return embedding.NewHFTEIEmbedder(&embedding.HFTEIConfig{
+			BaseURL:    cfg.HFTEIBaseURL,
+			APIKey:     cfg.HFTEIAPIKey,
+			Model:      model,
+			Dimensions: cfg.EmbeddingDimensions,
+		})
+	}
+	if cfg.EmbeddingProvider == "gemini" {
+		return embedding.NewGeminiEmbedder(&embedding.GeminiConfig{
+			APIKey:     cfg.GeminiAPIKey,
+			BaseURL:    cfg.GeminiBaseURL,
+			Model:      model,
+			Dimensions: cfg.EmbeddingDimensions,
+		})
+	}
+	if cfg.EmbeddingProvider == "openai" {
+		return embedding.NewOpenAIEmbedder(&embedding.OpenAIConfig{
+			APIKey:       cfg.OpenAIAPIKey,
+			Organization: cfg.OpenAIOrg,
+			Project:      cfg.OpenAIProject,
+			BaseURL:      cfg.OpenAIBaseURL,
+			Model:        model,
+		})
+	}
+	return embedding.NewOllamaEmbedder(&embedding.OllamaConfig{
+		BaseURL: cfg.OllamaBaseURL,
+		Model:   model,
+	})
+}
+
+// runSnapshots periodically writes the cache to path until ctx is cancelled.
+// Each wait is jittered by 10% and unchanged caches are not rewritten.
+func runSnapshots(ctx context.Context, c *cache.MemoryCache, path string, interval time.Duration, log *logger.Logger) {
+	// The restored snapshot already matches the cache, so the first tick
+	// only writes if something changed since startup
+	lastVersion := c.Version()
+	for {
+		timer := time.NewTimer(cache.JitteredInterval(interval, 0.1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		version := c.Version()
+		if version == lastVersion {
+			log.Debug("skipping snapshot, cache unchanged")
+			continue
+		}
+
+		start := time.Now()
+		n, err := c.SnapshotFile(path)
+		if err != nil {
+			log.Error("snapshot failed", "path", path, "error", err)
+			continue
+		}
+		lastVersion = version
+
+		log.Info("snapshot written",
+			"path", path,
+			"entries", n,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
+
+// normSampleSize is how many stored vectors each norm check inspects.
+const normSampleSize = 100
+
+// runNormChecks checks the lengths of stored vectors every interval until
+// ctx is cancelled.
+func runNormChecks(ctx context.Context, c *cache.MemoryCache, interval time.Duration, log *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkNorms(c, log)
+		}
+	}
+}
+
+// checkNorms warns when sampled vectors are not unit-length. The dot metric
+// scores them by length as much as by direction, so thresholds tuned for
+// normalized vectors misjudge matches.
+func checkNorms(c *cache.MemoryCache, log *logger.Logger) {
+	norms := c.SampleNorms(normSampleSize)
+	var off int
+	minNorm, maxNorm := math.Inf(1), math.Inf(-1)
+	for _, norm := range norms {
+		if !cache.UnitNorm(norm) {
+			off++
+		}
+		minNorm = math.Min(minNorm, norm)
+		maxNorm = math.Max(maxNorm, norm)
+	}
+	if off == 0 {
+		return
+	}
+	log.Warn("stored embeddings are not unit-length, dot-product scores are skewed",
+		"sampled", len(norms),
+		"not_unit", off,
+		"min_norm", fmt.Sprintf("%.4f", minNorm),
+		"max_norm", fmt.Sprintf("%.4f", maxNorm),
+		"hint", "set MIMIR_SIMILARITY_METRIC=cosine or use a model with normalized output",
+	)
+}
+
+// runHitRateAlerts checks completed hours against the hit-rate target every
+// minute until ctx is cancelled.
+func runHitRateAlerts(ctx context.Context, alerter *reports.HitRateAlerter, log *logger.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent, err := alerter.Check(ctx, now)
+			for _, alert := range sent {
+				log.Warn("hourly hit rate below target",
+					"hour", alert.Hour.Format(time.RFC3339),
+					"hit_rate", fmt.Sprintf("%.2f%%", alert.HitRate*100),
+					"target", fmt.Sprintf("%.2f%%", alert.Target*100),
+					"requests", alert.Requests,
+				)
+			}
+			if err != nil {
+				log.Error("failed to send hit rate alert", "error", err)
+			}
+		}
+	}
+}
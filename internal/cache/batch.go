@@ -0,0 +1,125 @@
+package cache
+
+// vectorMatrix stores unit-length entry embeddings row by row in one
+// contiguous backing array, in the same order as MemoryCache.entries, so a
+// lookup scans memory sequentially instead of chasing a pointer per entry.
+type vectorMatrix struct {
+	dim  int
+	data []float64
+	// mismatched counts rows whose length differs from dim; those are kept
+	// zeroed and force Get back onto the per-entry path
+	mismatched int
+	// broken is set when rows can no longer be kept aligned with entries
+	// (an empty first vector); it lasts until the next reset
+	broken bool
+}
+
+// reset empties the matrix, keeping its capacity.
+func (v *vectorMatrix) reset() {
+	v.dim = 0
+	v.data = v.data[:0]
+	v.mismatched = 0
+	v.broken = false
+}
+
+// rows returns the number of stored rows.
+func (v *vectorMatrix) rows() int {
+	if v.dim == 0 {
+		return 0
+	}
+	return len(v.data) / v.dim
+}
+
+// usable reports whether the matrix can score query in a batch.
+func (v *vectorMatrix) usable(query []float64) bool {
+	return !v.broken && v.dim > 0 && v.mismatched == 0 && len(query) == v.dim
+}
+
+// append adds a row at the end.
+func (v *vectorMatrix) append(vec []float64) {
+	if v.broken {
+		return
+	}
+	if v.dim == 0 {
+		if len(vec) == 0 {
+			v.broken = true
+			return
+		}
+		v.dim = len(vec)
+	}
+	start := len(v.data)
+	v.data = append(v.data, make([]float64, v.dim)...)
+	v.write(start, vec)
+}
+
+// set overwrites row i, which held old.
+func (v *vectorMatrix) set(i int, old, vec []float64) {
+	if v.broken {
+		return
+	}
+	if len(old) != v.dim {
+		v.mismatched--
+	}
+	v.write(i*v.dim, vec)
+}
+
+// swapRemove removes row i, which held old, by moving the last row into its
+// place, mirroring how entries are removed.
+func (v *vectorMatrix) swapRemove(i int, old []float64) {
+	if v.broken {
+		return
+	}
+	if len(old) != v.dim {
+		v.mismatched--
+	}
+	last := v.rows() - 1
+	copy(v.data[i*v.dim:(i+1)*v.dim], v.data[last*v.dim:])
+	v.data = v.data[:last*v.dim]
+	if last == 0 {
+		v.reset()
+	}
+}
+
+// write stores vec at start, zeroing the row when its length differs.
+func (v *vectorMatrix) write(start int, vec []float64) {
+	row := v.data[start : start+v.dim]
+	if len(vec) != v.dim {
+		for i := range row {
+			row[i] = 0
+		}
+		v.mismatched++
+		return
+	}
+	copy(row, vec)
+}
+
+// dotBatch computes the dot product of query against every row of matrix,
+// a row-major array of dim-length vectors. With a unit-length query and
+// rows this is their cosine similarity. The inner loop is unrolled by four
+// with independent accumulators so the compiler can keep them in registers
+// and pipeline the multiplies.
+func dotBatch(query, matrix []float64, dim int) []float64 {
+	if dim == 0 || len(query) != dim {
+		return nil
+	}
+
+	rows := len(matrix) / dim
+	scores := make([]float64, rows)
+	for r := 0; r < rows; r++ {
+		row := matrix[r*dim : (r+1)*dim : (r+1)*dim]
+		var d0, d1, d2, d3 float64
+		i := 0
+		for ; i+4 <= dim; i += 4 {
+			d0 += row[i] * query[i]
+			d1 += row[i+1] * query[i+1]
+			d2 += row[i+2] * query[i+2]
+			d3 += row[i+3] * query[i+3]
+		}
+		for ; i < dim; i++ {
+			d0 += row[i] * query[i]
+		}
+		scores[r] = d0 + d1 + d2 + d3
+	}
+
+	return scores
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// cleanupJitter is the fraction of CleanupInterval each background cleanup
+// run is moved earlier or later by, so replicas started together do not
+// sweep their caches in lockstep.
+const cleanupJitter = 0.1
+
+// CleanupFunc is told how many expired entries a background cleanup run
+// removed and how long the run took.
+type CleanupFunc func(removed int, took time.Duration)
+
+// cleanupReporter holds the CleanupFunc registered with a cache. Caches
+// embed it to gain OnCleanup.
+type cleanupReporter struct {
+	fn atomic.Pointer[CleanupFunc]
+}
+
+// OnCleanup registers fn to be called after every background cleanup run,
+// replacing any function registered before.
+func (r *cleanupReporter) OnCleanup(fn CleanupFunc) {
+	r.fn.Store(&fn)
+}
+
+// report passes a finished run to the registered CleanupFunc, if any.
+func (r *cleanupReporter) report(removed int, took time.Duration) {
+	if fn := r.fn.Load(); fn != nil && *fn != nil {
+		(*fn)(removed, took)
+	}
+}
+
+// runCleanups calls cleanup every interval, give or take cleanupJitter,
+// reporting each run to r. It never returns; a non-positive interval
+// disables background cleanup.
+func runCleanups(interval time.Duration, cleanup func(ctx context.Context) int, r *cleanupReporter) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(jitter(interval))
+		start := time.Now()
+		removed := cleanup(context.Background())
+		r.report(removed, time.Since(start))
+	}
+}
+
+// jitter returns d moved by a random amount of up to cleanupJitter of d in
+// either direction.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(float64(d) * cleanupJitter)
+	if spread <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Minute); d < 54*time.Second || d > 66*time.Second {
+			t.Fatalf("expected a jittered interval within 10%% of a minute, got %v", d)
+		}
+	}
+	if d := jitter(time.Nanosecond); d != time.Nanosecond {
+		t.Errorf("expected a tiny interval to be left alone, got %v", d)
+	}
+}
+
+func TestOnCleanup(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, DefaultTTL: time.Hour, CleanupInterval: time.Hour})
+
+	// Reporting without a registered function is a no-op
+	cache.report(1, time.Millisecond)
+
+	var removed int
+	cache.OnCleanup(func(n int, took time.Duration) { removed += n })
+	cache.report(3, time.Millisecond)
+	cache.report(2, time.Millisecond)
+	if removed != 5 {
+		t.Errorf("expected 5 removals reported, got %d", removed)
+	}
+}
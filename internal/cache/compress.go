@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// compressMinBytes is the smallest response body worth compressing; below
+// it the gzip header and footer eat most of the savings.
+const compressMinBytes = 512
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// compressResponse replaces entry.Response with its gzipped JSON, keeping
+// the metadata and usage that are read without serving the body. Verbatim
+// bodies in RawResponse are gzipped as they are. Small or already
+// compressed responses are left alone.
+func compressResponse(entry *api.CacheEntry) {
+	if entry.CompressedResponse != nil {
+		return
+	}
+	if len(entry.RawResponse) > 0 {
+		if len(entry.RawResponse) < compressMinBytes {
+			return
+		}
+		compressed, err := gzipBody(entry.RawResponse)
+		if err != nil {
+			return
+		}
+		entry.CompressedResponse = compressed
+		entry.CompressedRaw = true
+		entry.RawResponse = nil
+		return
+	}
+
+	body, err := json.Marshal(entry.Response)
+	if err != nil || len(body) < compressMinBytes {
+		return
+	}
+	compressed, err := gzipBody(body)
+	if err != nil {
+		return
+	}
+
+	entry.CompressedResponse = compressed
+	entry.Response = api.ChatCompletionResponse{
+		ID:                entry.Response.ID,
+		Object:            entry.Response.Object,
+		Created:           entry.Response.Created,
+		Model:             entry.Response.Model,
+		Usage:             entry.Response.Usage,
+		SystemFingerprint: entry.Response.SystemFingerprint,
+	}
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(&buf)
+	zw.Write(body)
+	err := zw.Close()
+	gzipWriters.Put(zw)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBody(compressed []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress response: %w", err)
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress response: %w", err)
+	}
+	return body, nil
+}
+
+// ResponseBody returns the JSON body of the cached response, decompressing
+// it if needed. Compressed bodies are returned without being parsed.
+func ResponseBody(entry *api.CacheEntry) ([]byte, error) {
+	if entry.CompressedResponse == nil {
+		return json.Marshal(entry.Response)
+	}
+	return gunzipBody(entry.CompressedResponse)
+}
+
+// HasRawResponse reports whether entry holds a verbatim body, compressed
+// or not, rather than a chat completion.
+func HasRawResponse(entry *api.CacheEntry) bool {
+	return len(entry.RawResponse) > 0 || entry.CompressedRaw
+}
+
+// RawBody returns the verbatim body of the cached response, decompressing
+// it if needed.
+func RawBody(entry *api.CacheEntry) ([]byte, error) {
+	if !entry.CompressedRaw {
+		return entry.RawResponse, nil
+	}
+	return gunzipBody(entry.CompressedResponse)
+}
+
+// DecodeResponse returns the full cached response, decompressing and
+// parsing it if needed.
+func DecodeResponse(entry *api.CacheEntry) (api.ChatCompletionResponse, error) {
+	if entry.CompressedResponse == nil {
+		return entry.Response, nil
+	}
+	body, err := ResponseBody(entry)
+	if err != nil {
+		return api.ChatCompletionResponse{}, err
+	}
+	var resp api.ChatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return api.ChatCompletionResponse{}, fmt.Errorf("failed to parse cached response: %w", err)
+	}
+	return resp, nil
+}
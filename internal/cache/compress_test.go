@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// essayResponse returns a response with a long, essay-style answer.
+func essayResponse() api.ChatCompletionResponse {
+	paragraph := "The French Revolution reshaped European politics, ending absolute monarchy and spreading ideas of citizenship and rights. "
+	return api.ChatCompletionResponse{
+		ID:      "chatcmpl-essay",
+		Object:  "chat.completion",
+		Created: 1700000000,
+		Model:   "gpt-4",
+		Choices: []api.Choice{{
+			Message:      api.Message{Role: "assistant", Content: strings.Repeat(paragraph, 40)},
+			FinishReason: "stop",
+		}},
+		Usage: api.Usage{PromptTokens: 20, CompletionTokens: 900, TotalTokens: 920},
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	original := essayResponse()
+	entry := &api.CacheEntry{Response: original}
+	compressResponse(entry)
+
+	if entry.CompressedResponse == nil {
+		t.Fatal("expected a large response to be compressed")
+	}
+	if len(entry.Response.Choices) != 0 {
+		t.Error("expected choices to be dropped from the uncompressed copy")
+	}
+	if entry.Response.Usage != original.Usage || entry.Response.Model != original.Model {
+		t.Errorf("expected metadata and usage to be kept, got %+v", entry.Response)
+	}
+
+	raw, _ := json.Marshal(original)
+	if len(entry.CompressedResponse) >= len(raw)/2 {
+		t.Errorf("expected at least 2x compression, got %d -> %d bytes", len(raw), len(entry.CompressedResponse))
+	}
+
+	body, err := ResponseBody(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != string(raw) {
+		t.Error("expected the decompressed body to match the original JSON")
+	}
+
+	decoded, err := DecodeResponse(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.Choices[0].Message.Content != original.Choices[0].Message.Content {
+		t.Error("expected the decoded response to match the original")
+	}
+
+	// Short answers are not worth compressing
+	small := &api.CacheEntry{Response: api.ChatCompletionResponse{ID: "x", Choices: []api.Choice{{Message: api.Message{Content: "Paris"}}}}}
+	compressResponse(small)
+	if small.CompressedResponse != nil || len(small.Response.Choices) != 1 {
+		t.Error("expected a small response to be left uncompressed")
+	}
+}
+
+func TestCompressRawResponse(t *testing.T) {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"type":    "message",
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": essayResponse().Choices[0].Message.Content}},
+	})
+	entry := &api.CacheEntry{RawResponse: raw, Response: api.ChatCompletionResponse{Usage: api.Usage{TotalTokens: 920}}}
+	compressResponse(entry)
+
+	if !entry.CompressedRaw || entry.CompressedResponse == nil || entry.RawResponse != nil {
+		t.Fatal("expected a large raw response to be compressed")
+	}
+	if !HasRawResponse(entry) || entry.Response.Usage.TotalTokens != 920 {
+		t.Errorf("expected the entry to keep its raw marker and usage, got %+v", entry.Response)
+	}
+	body, err := RawBody(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != string(raw) {
+		t.Error("expected the decompressed body to match the original")
+	}
+
+	small := &api.CacheEntry{RawResponse: []byte(`{"type":"message"}`)}
+	compressResponse(small)
+	if small.CompressedRaw || small.CompressedResponse != nil {
+		t.Error("expected a small raw response to be left uncompressed")
+	}
+	if body, _ := RawBody(small); string(body) != `{"type":"message"}` {
+		t.Errorf("expected the raw body as stored, got %s", body)
+	}
+}
+
+func TestMemoryCacheCompressResponses(t *testing.T) {
+	mc := NewMemoryCache(&Options{
+		MaxSize:           10,
+		DefaultTTL:        time.Hour,
+		CleanupInterval:   time.Hour,
+		CompressResponses: true,
+	})
+	ctx := context.Background()
+
+	mc.Set(ctx, &api.CacheEntry{
+		Response:  essayResponse(),
+		Embedding: []float64{1, 0, 0},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	entry, _, found := mc.Get(ctx, []float64{1, 0, 0}, 0.9)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	if entry.CompressedResponse == nil {
+		t.Fatal("expected the stored entry to be compressed")
+	}
+	resp, err := DecodeResponse(entry)
+	if err != nil || len(resp.Choices) != 1 {
+		t.Fatalf("expected the response to decode, got %+v, %v", resp, err)
+	}
+}
+
+// BenchmarkCompressedEntryMemory reports the bytes a cached essay response
+// occupies with and without compression.
+func BenchmarkCompressedEntryMemory(b *testing.B) {
+	raw, _ := json.Marshal(essayResponse())
+	var compressed int
+	for i := 0; i < b.N; i++ {
+		entry := &api.CacheEntry{Response: essayResponse()}
+		compressResponse(entry)
+		compressed = len(entry.CompressedResponse)
+	}
+	b.ReportMetric(float64(len(raw)), "raw-bytes")
+	b.ReportMetric(float64(compressed), "compressed-bytes")
+}
+
+// BenchmarkServeResponse measures the serve-time cost of producing the body
+// of a hit from a plain versus a compressed entry.
+func BenchmarkServeResponse(b *testing.B) {
+	plain := &api.CacheEntry{Response: essayResponse()}
+	compressed := &api.CacheEntry{Response: essayResponse()}
+	compressResponse(compressed)
+
+	for _, bc := range []struct {
+		name  string
+		entry *api.CacheEntry
+	}{
+		{"plain", plain},
+		{"compressed", compressed},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ResponseBody(bc.entry); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// DimensionError is returned by MemoryCache.Set for an entry whose
+// embedding has a different number of dimensions than the live entries in
+// its partition. Vectors of different lengths never match, so this usually
+// means the embedding model was changed while the cache still held entries
+// from the old one.
+type DimensionError struct {
+	Partition string
+	Got       int
+	Want      int
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("embedding has %d dimensions, cached entries have %d", e.Got, e.Want)
+}
+
+// vectorDim returns the number of dimensions of entry's embedding.
+func vectorDim(entry *api.CacheEntry) int {
+	switch {
+	case entry.UnitEmbedding32 != nil:
+		return len(entry.UnitEmbedding32)
+	case entry.UnitEmbedding != nil:
+		return len(entry.UnitEmbedding)
+	}
+	return len(rawVector(entry))
+}
+
+// partitionDim returns the dimensions of the live entries in partition, or
+// 0 when it has none. Entries are compared against the first live entry
+// found, since Set keeps a partition from mixing dimensions.
+func (m *MemoryCache) partitionDim(partition string) int {
+	now := time.Now()
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		for _, e := range s.entries {
+			if e.Partition == partition && !now.After(e.ExpiresAt) {
+				s.mu.RUnlock()
+				return vectorDim(e)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return 0
+}
+
+// checkDim counts a mismatch and returns a *DimensionError when an
+// embedding of dim dimensions does not fit the live entries of partition.
+func (m *MemoryCache) checkDim(partition string, dim int) error {
+	if want := m.partitionDim(partition); want != 0 && want != dim {
+		m.dimMismatches.Add(1)
+		return &DimensionError{Partition: partition, Got: dim, Want: want}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheDimensionMismatch(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		ctx := context.Background()
+		c := newShardedCache(100, shards)
+		if err := c.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		// A vector from another model is refused and counted
+		err := c.Set(ctx, newTestEntry([]float64{1, 0}, time.Hour))
+		var dimErr *DimensionError
+		if !errors.As(err, &dimErr) || dimErr.Got != 2 || dimErr.Want != 3 {
+			t.Fatalf("shards=%d: expected a dimension error, got %v", shards, err)
+		}
+		if c.Size(ctx) != 1 {
+			t.Errorf("shards=%d: expected the mismatched entry not to be stored", shards)
+		}
+
+		// So is a lookup that misses because of it, but not an ordinary miss
+		c.Get(ctx, []float64{1, 0}, 0.9)
+		c.Get(ctx, []float64{0, 1, 0}, 0.9)
+		if got := c.Stats(ctx).DimensionMismatches; got != 2 {
+			t.Errorf("shards=%d: expected 2 dimension mismatches, got %d", shards, got)
+		}
+
+		// Other partitions may use other dimensions
+		other := newTestEntry([]float64{1, 0}, time.Hour)
+		other.Partition = "v2"
+		if err := c.Set(ctx, other); err != nil {
+			t.Errorf("shards=%d: expected another partition to accept the entry, got %v", shards, err)
+		}
+
+		// Once the old entries are gone the new dimensions are accepted
+		c.Delete(ctx, []float64{1, 0, 0})
+		if err := c.Set(ctx, newTestEntry([]float64{1, 0}, time.Hour)); err != nil {
+			t.Errorf("shards=%d: expected the entry to be stored in an empty partition, got %v", shards, err)
+		}
+	}
+}
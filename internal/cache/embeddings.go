@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EmbeddingStore is an exact-match cache of embedding vectors keyed by input.
+// Unlike MemoryCache it does no similarity search; it lets the proxy reuse
+// embeddings for individual inputs across differently-ordered batches.
+type EmbeddingStore struct {
+	mu      sync.Mutex
+	items   map[string]*embeddingItem
+	maxSize int
+	ttl     time.Duration
+}
+
+type embeddingItem struct {
+	vector    []float64
+	expiresAt time.Time
+	lastUsed  time.Time
+}
+
+// NewEmbeddingStore creates an embedding store bounded to maxSize vectors.
+func NewEmbeddingStore(maxSize int, ttl time.Duration) *EmbeddingStore {
+	return &EmbeddingStore{
+		items:   make(map[string]*embeddingItem),
+		maxSize: maxSize,
+		ttl:     ttl,
+	}
+}
+
+// EmbeddingKey hashes an input together with the parameters that affect its vector.
+func EmbeddingKey(model string, dimensions int, input string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(dimensions)))
+	h.Write([]byte{0})
+	h.Write([]byte(input))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the cached vector for key, if present and not expired.
+func (s *EmbeddingStore) Get(key string) ([]float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(item.expiresAt) {
+		delete(s.items, key)
+		return nil, false
+	}
+	item.lastUsed = now
+	return item.vector, true
+}
+
+// Set stores a vector, evicting the least recently used one when full.
+func (s *EmbeddingStore) Set(key string, vector []float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := s.items[key]; !exists && len(s.items) >= s.maxSize {
+		s.evictOldest()
+	}
+	s.items[key] = &embeddingItem{
+		vector:    vector,
+		expiresAt: now.Add(s.ttl),
+		lastUsed:  now,
+	}
+}
+
+// Len returns the number of stored vectors.
+func (s *EmbeddingStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// evictOldest removes the least recently used vector. Caller must hold the lock.
+func (s *EmbeddingStore) evictOldest() {
+	var oldestKey string
+	var oldestTime time.Time
+	for k, item := range s.items {
+		if oldestKey == "" || item.lastUsed.Before(oldestTime) {
+			oldestKey = k
+			oldestTime = item.lastUsed
+		}
+	}
+	if oldestKey != "" {
+		delete(s.items, oldestKey)
+	}
+}
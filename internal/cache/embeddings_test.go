@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmbeddingStore(t *testing.T) {
+	store := NewEmbeddingStore(2, time.Hour)
+
+	keyA := EmbeddingKey("model", 0, "a")
+	keyB := EmbeddingKey("model", 0, "b")
+	keyC := EmbeddingKey("model", 0, "c")
+
+	store.Set(keyA, []float64{1})
+	time.Sleep(time.Millisecond)
+	store.Set(keyB, []float64{2})
+
+	if vec, ok := store.Get(keyA); !ok || vec[0] != 1 {
+		t.Fatal("expected to find vector for a")
+	}
+
+	// a was used more recently than b, so b is evicted
+	store.Set(keyC, []float64{3})
+	if store.Len() != 2 {
+		t.Errorf("expected 2 vectors, got %d", store.Len())
+	}
+	if _, ok := store.Get(keyB); ok {
+		t.Error("expected least recently used vector to be evicted")
+	}
+	if _, ok := store.Get(keyA); !ok {
+		t.Error("expected recently used vector to survive eviction")
+	}
+}
+
+func TestEmbeddingStoreExpiry(t *testing.T) {
+	store := NewEmbeddingStore(10, -time.Second)
+	key := EmbeddingKey("model", 0, "a")
+	store.Set(key, []float64{1})
+
+	if _, ok := store.Get(key); ok {
+		t.Error("expected expired vector not to be returned")
+	}
+}
+
+func TestEmbeddingKey(t *testing.T) {
+	if EmbeddingKey("m1", 0, "x") == EmbeddingKey("m2", 0, "x") {
+		t.Error("expected different models to produce different keys")
+	}
+	if EmbeddingKey("m", 256, "x") == EmbeddingKey("m", 512, "x") {
+		t.Error("expected different dimensions to produce different keys")
+	}
+	if EmbeddingKey("m", 0, "x") != EmbeddingKey("m", 0, "x") {
+		t.Error("expected identical inputs to produce identical keys")
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// ghost is an entry evicted to make room. It can be resurrected by a
+// matching lookup until GhostTTL after its eviction, so a burst of writes
+// that pushes out a hot entry doesn't cost an upstream call.
+type ghost struct {
+	entry     *api.CacheEntry
+	evictedAt time.Time
+}
+
+// addGhost keeps entry as a ghost, dropping the oldest ghost once there
+// are GhostSize of them. Caller must hold the write lock.
+func (m *MemoryCache) addGhost(entry *api.CacheEntry) {
+	if m.opts.GhostSize <= 0 {
+		return
+	}
+	if len(m.ghosts) >= m.opts.GhostSize {
+		n := copy(m.ghosts, m.ghosts[1:])
+		m.ghosts[n] = ghost{}
+		m.ghosts = m.ghosts[:n]
+	}
+	m.ghosts = append(m.ghosts, ghost{entry: entry, evictedAt: time.Now()})
+}
+
+// ghostLive reports whether g can still be resurrected at now.
+func (m *MemoryCache) ghostLive(g ghost, now time.Time) bool {
+	return now.Before(g.entry.ExpiresAt) && now.Sub(g.evictedAt) <= m.opts.GhostTTL
+}
+
+// pruneGhosts drops ghosts that can no longer be resurrected. Caller must
+// hold the write lock.
+func (m *MemoryCache) pruneGhosts(now time.Time) {
+	live := m.ghosts[:0]
+	for _, g := range m.ghosts {
+		if m.ghostLive(g, now) {
+			live = append(live, g)
+		}
+	}
+	for i := len(live); i < len(m.ghosts); i++ {
+		m.ghosts[i] = ghost{}
+	}
+	m.ghosts = live
+}
+
+// resurrect finds the best live ghost in ctx's partition that matches
+// embedding under the configured metric and moves it back into the cache,
+// evicting as Set would.
+func (m *MemoryCache) resurrect(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	var query *unitVector
+	if m.opts.Metric != MetricDot && m.opts.Metric != MetricEuclidean {
+		unit := m.unitQuery(embedding)
+		query = &unit
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	partition := PartitionFromContext(ctx)
+	euclidean := m.opts.Metric == MetricEuclidean
+
+	best := -1
+	var bestScore float64
+	for i, g := range m.ghosts {
+		if g.entry.Partition != partition || !m.ghostLive(g, now) {
+			continue
+		}
+		score, ok := m.ghostScore(g.entry, embedding, query, threshold, now)
+		if !ok {
+			continue
+		}
+		if best < 0 || (euclidean && score < bestScore) || (!euclidean && score > bestScore) {
+			best = i
+			bestScore = score
+		}
+	}
+	if best < 0 {
+		return nil, 0, false
+	}
+
+	entry := m.ghosts[best].entry
+	m.ghosts = append(m.ghosts[:best], m.ghosts[best+1:]...)
+	// A concurrent Set may have stored a near-duplicate since the miss
+	if m.findDuplicate(entry) < 0 {
+		m.insert(entry)
+	}
+	m.resurrections.Add(1)
+	return entry, bestScore, true
+}
+
+// ghostScore scores a ghost's entry against the query the way lookup
+// scores live entries, reporting whether it clears threshold.
+func (m *MemoryCache) ghostScore(entry *api.CacheEntry, embedding []float64, query *unitVector, threshold float64, now time.Time) (float64, bool) {
+	decay := m.decay(entry, now)
+	if query != nil {
+		if len(entry.UnitEmbedding) != len(query.f64) || len(entry.UnitEmbedding32) != len(query.f32) {
+			return 0, false
+		}
+		similarity := query.dot(entry) * decay
+		return similarity, similarity >= threshold+entry.ThresholdOffset
+	}
+
+	vec := rawVector(entry)
+	if len(vec) != len(embedding) || len(vec) == 0 {
+		return 0, false
+	}
+	if m.opts.Metric == MetricEuclidean {
+		distance := EuclideanDistance(embedding, vec) / decay
+		return distance, distance <= threshold-entry.ThresholdOffset
+	}
+	score := dot(embedding, vec) * decay
+	return score, score >= threshold+entry.ThresholdOffset
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// HNSW parameters. M bounds links per node on upper layers (twice that on
+// layer 0); the ef values size the candidate lists kept while inserting and
+// searching, trading speed for recall.
+const (
+	hnswM              = 16
+	hnswEfConstruction = 100
+	hnswEfSearch       = 64
+)
+
+// hnswNode is one indexed entry. vec is the unit-length embedding, so
+// similarity is a plain dot product.
+type hnswNode struct {
+	entry   *api.CacheEntry
+	vec     []float64
+	level   int
+	friends [][]*hnswNode
+	// in records, per layer, the nodes linking to this one so removal can
+	// unlink them even when links are not symmetric
+	in []map[*hnswNode]struct{}
+}
+
+// hnswIndex is a hierarchical navigable small world graph over the entries
+// of one partition. It is not safe for concurrent mutation; MemoryCache
+// guards it with its own lock.
+type hnswIndex struct {
+	entry     *hnswNode
+	nodes     map[*api.CacheEntry]*hnswNode
+	levelMult float64
+	rng       *rand.Rand
+}
+
+func newHNSWIndex() *hnswIndex {
+	return &hnswIndex{
+		nodes:     make(map[*api.CacheEntry]*hnswNode),
+		levelMult: 1 / math.Log(hnswM),
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (idx *hnswIndex) len() int { return len(idx.nodes) }
+
+func maxLinks(level int) int {
+	if level == 0 {
+		return 2 * hnswM
+	}
+	return hnswM
+}
+
+func dot(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+type hnswCandidate struct {
+	node *hnswNode
+	sim  float64
+}
+
+// nearestFirst pops the most similar candidate first.
+type nearestFirst []hnswCandidate
+
+func (h nearestFirst) Len() int            { return len(h) }
+func (h nearestFirst) Less(i, j int) bool  { return h[i].sim > h[j].sim }
+func (h nearestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearestFirst) Push(x interface{}) { *h = append(*h, x.(hnswCandidate)) }
+func (h *nearestFirst) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// farthestFirst pops the least similar candidate first.
+type farthestFirst []hnswCandidate
+
+func (h farthestFirst) Len() int            { return len(h) }
+func (h farthestFirst) Less(i, j int) bool  { return h[i].sim < h[j].sim }
+func (h farthestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(x interface{}) { *h = append(*h, x.(hnswCandidate)) }
+func (h *farthestFirst) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// searchLayer returns up to ef nodes on level closest to q, most similar
+// first, starting from ep.
+func (idx *hnswIndex) searchLayer(q []float64, ep *hnswNode, ef, level int) []hnswCandidate {
+	start := hnswCandidate{node: ep, sim: dot(q, ep.vec)}
+	visited := map[*hnswNode]bool{ep: true}
+	candidates := &nearestFirst{start}
+	results := &farthestFirst{start}
+
+	for candidates.Len() > 0 {
+		cur := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && cur.sim < (*results)[0].sim {
+			break
+		}
+		for _, f := range cur.node.friends[level] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			sim := dot(q, f.vec)
+			if results.Len() < ef || sim > (*results)[0].sim {
+				heap.Push(candidates, hnswCandidate{node: f, sim: sim})
+				heap.Push(results, hnswCandidate{node: f, sim: sim})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := []hnswCandidate(*results)
+	sort.Slice(out, func(i, j int) bool { return out[i].sim > out[j].sim })
+	return out
+}
+
+// descend walks greedily from the entry point down to level.
+func (idx *hnswIndex) descend(q []float64, level int) *hnswNode {
+	ep := idx.entry
+	for l := ep.level; l > level; l-- {
+		ep = idx.searchLayer(q, ep, 1, l)[0].node
+	}
+	return ep
+}
+
+// search returns up to ef entries nearest to the unit-length query q, most
+// similar first.
+func (idx *hnswIndex) search(q []float64, ef int) []hnswCandidate {
+	if idx.entry == nil {
+		return nil
+	}
+	return idx.searchLayer(q, idx.descend(q, 0), ef, 0)
+}
+
+func (idx *hnswIndex) link(from, to *hnswNode, level int) {
+	from.friends[level] = append(from.friends[level], to)
+	to.in[level][from] = struct{}{}
+}
+
+func (idx *hnswIndex) unlink(from, to *hnswNode, level int) {
+	friends := from.friends[level]
+	for i, f := range friends {
+		if f == to {
+			friends[i] = friends[len(friends)-1]
+			from.friends[level] = friends[:len(friends)-1]
+			break
+		}
+	}
+	delete(to.in[level], from)
+}
+
+// selectNeighbors picks up to limit of candidates (most similar first) with
+// the HNSW heuristic: a candidate is kept only if it is closer to the base
+// node than to every neighbor already kept. Plain top-k selection would link
+// only within tight clusters and leave the graph split into islands.
+func selectNeighbors(candidates []hnswCandidate, limit int) []*hnswNode {
+	selected := make([]*hnswNode, 0, limit)
+	for _, c := range candidates {
+		if len(selected) == limit {
+			break
+		}
+		diverse := true
+		for _, s := range selected {
+			if dot(c.node.vec, s.vec) > c.sim {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, c.node)
+		}
+	}
+	return selected
+}
+
+// prune trims the links of n on level to the layer's limit.
+func (idx *hnswIndex) prune(n *hnswNode, level int) {
+	limit := maxLinks(level)
+	if len(n.friends[level]) <= limit {
+		return
+	}
+	candidates := make([]hnswCandidate, len(n.friends[level]))
+	for i, f := range n.friends[level] {
+		candidates[i] = hnswCandidate{node: f, sim: dot(n.vec, f.vec)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].sim > candidates[j].sim })
+
+	keep := make(map[*hnswNode]bool, limit)
+	for _, f := range selectNeighbors(candidates, limit) {
+		keep[f] = true
+	}
+	for _, c := range candidates {
+		if !keep[c.node] {
+			idx.unlink(n, c.node, level)
+		}
+	}
+}
+
+// insert adds entry to the graph.
+func (idx *hnswIndex) insert(entry *api.CacheEntry) {
+	level := int(-math.Log(1-idx.rng.Float64()) * idx.levelMult)
+	node := &hnswNode{
+		entry:   entry,
+		vec:     entry.UnitEmbedding,
+		level:   level,
+		friends: make([][]*hnswNode, level+1),
+		in:      make([]map[*hnswNode]struct{}, level+1),
+	}
+	for l := range node.in {
+		node.in[l] = make(map[*hnswNode]struct{})
+	}
+	idx.nodes[entry] = node
+
+	if idx.entry == nil {
+		idx.entry = node
+		return
+	}
+
+	top := idx.entry.level
+	if level < top {
+		top = level
+	}
+	ep := idx.descend(node.vec, top)
+	for l := top; l >= 0; l-- {
+		candidates := idx.searchLayer(node.vec, ep, hnswEfConstruction, l)
+		for _, n := range selectNeighbors(candidates, hnswM) {
+			idx.link(node, n, l)
+			idx.link(n, node, l)
+			idx.prune(n, l)
+		}
+		ep = candidates[0].node
+	}
+
+	if level > idx.entry.level {
+		idx.entry = node
+	}
+}
+
+// remove deletes entry from the graph, reconnecting nodes that linked to it
+// with its other neighbors.
+func (idx *hnswIndex) remove(entry *api.CacheEntry) {
+	node, ok := idx.nodes[entry]
+	if !ok {
+		return
+	}
+	delete(idx.nodes, entry)
+
+	for l := 0; l <= node.level; l++ {
+		orphans := make([]*hnswNode, 0, len(node.in[l]))
+		for from := range node.in[l] {
+			orphans = append(orphans, from)
+		}
+		for _, from := range orphans {
+			idx.unlink(from, node, l)
+		}
+		neighbors := append([]*hnswNode(nil), node.friends[l]...)
+		for _, to := range neighbors {
+			idx.unlink(node, to, l)
+		}
+
+		// Give each orphan the removed node's neighbors as candidates
+		for _, from := range orphans {
+			for _, to := range neighbors {
+				if to == from || len(from.friends[l]) >= maxLinks(l) {
+					continue
+				}
+				if _, linked := to.in[l][from]; linked {
+					continue
+				}
+				idx.link(from, to, l)
+			}
+		}
+	}
+
+	if idx.entry == node {
+		idx.entry = nil
+		for _, n := range idx.nodes {
+			if idx.entry == nil || n.level > idx.entry.level {
+				idx.entry = n
+			}
+		}
+	}
+}
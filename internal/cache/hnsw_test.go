@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// clusteredVectors returns n random vectors of dim drawn around a handful
+// of centers, roughly how prompt embeddings cluster by topic.
+func clusteredVectors(rng *rand.Rand, n, dim int) [][]float64 {
+	centers := make([][]float64, 20)
+	for c := range centers {
+		centers[c] = make([]float64, dim)
+		for i := range centers[c] {
+			centers[c][i] = rng.NormFloat64()
+		}
+	}
+	vecs := make([][]float64, n)
+	for v := range vecs {
+		center := centers[rng.Intn(len(centers))]
+		vecs[v] = make([]float64, dim)
+		for i := range vecs[v] {
+			vecs[v][i] = center[i] + 0.5*rng.NormFloat64()
+		}
+	}
+	return vecs
+}
+
+// perturb returns a copy of v with small noise added.
+func perturb(rng *rand.Rand, v []float64, scale float64) []float64 {
+	out := make([]float64, len(v))
+	for i := range v {
+		out[i] = v[i] + scale*rng.NormFloat64()
+	}
+	return out
+}
+
+func newIndexedCaches(size int) (*MemoryCache, *MemoryCache) {
+	opts := func(index string) *Options {
+		return &Options{
+			MaxSize:         size,
+			DefaultTTL:      time.Hour,
+			CleanupInterval: time.Hour,
+			IndexType:       index,
+		}
+	}
+	return NewMemoryCache(opts(IndexLinear)), NewMemoryCache(opts(IndexHNSW))
+}
+
+func TestMemoryCacheHNSWMatchesLinear(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ctx := context.Background()
+	vecs := clusteredVectors(rng, 2000, 64)
+
+	linear, hnsw := newIndexedCaches(len(vecs))
+	for _, v := range vecs {
+		linear.Set(ctx, newTestEntry(v, time.Hour))
+		hnsw.Set(ctx, newTestEntry(v, time.Hour))
+	}
+	if linear.Size(ctx) != hnsw.Size(ctx) {
+		t.Fatalf("expected equal sizes, got %d and %d", linear.Size(ctx), hnsw.Size(ctx))
+	}
+
+	check := func(stage string) {
+		for q := 0; q < 200; q++ {
+			query := perturb(rng, vecs[rng.Intn(len(vecs))], 0.05)
+			le, ls, lfound := linear.Get(ctx, query, 0.95)
+			he, hs, hfound := hnsw.Get(ctx, query, 0.95)
+			if lfound != hfound {
+				t.Fatalf("%s: query %d: linear found=%v, hnsw found=%v", stage, q, lfound, hfound)
+			}
+			if lfound && (ls != hs || fmt.Sprint(le.Embedding) != fmt.Sprint(he.Embedding)) {
+				t.Fatalf("%s: query %d: linear %.6f, hnsw %.6f", stage, q, ls, hs)
+			}
+		}
+	}
+	check("after inserts")
+
+	// Deleting a third of the entries must keep the graph navigable
+	for i := 0; i < len(vecs); i += 3 {
+		linear.Delete(ctx, vecs[i])
+		hnsw.Delete(ctx, vecs[i])
+	}
+	check("after deletes")
+}
+
+func TestMemoryCacheHNSWEvictionAndCleanup(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	ctx := context.Background()
+	_, cache := newIndexedCaches(100)
+
+	vecs := clusteredVectors(rng, 300, 32)
+	for i, v := range vecs {
+		ttl := time.Hour
+		if i%2 == 0 {
+			ttl = -time.Second
+		}
+		cache.Set(ctx, newTestEntry(v, ttl))
+	}
+	if size := cache.Size(ctx); size != 100 {
+		t.Fatalf("expected eviction to cap the cache at 100, got %d", size)
+	}
+
+	cache.Cleanup(ctx)
+	indexed := 0
+	for _, idx := range cache.indexes {
+		indexed += idx.len()
+	}
+	if indexed != cache.Size(ctx) {
+		t.Errorf("expected the index to track %d entries, got %d", cache.Size(ctx), indexed)
+	}
+
+	cache.Clear(ctx)
+	if len(cache.indexes) != 0 {
+		t.Error("expected Clear to drop the index")
+	}
+}
+
+func TestMemoryCacheHNSWPartitions(t *testing.T) {
+	_, cache := newIndexedCaches(10)
+	ctx := context.Background()
+	v2 := WithPartition(ctx, "v2")
+
+	entry := newTestEntry([]float64{1, 0, 0}, time.Hour)
+	entry.Partition = "v2"
+	cache.Set(v2, entry)
+
+	if _, _, found := cache.Get(v2, []float64{1, 0, 0}, 0.99); !found {
+		t.Error("expected a hit in the same partition")
+	}
+	if _, _, found := cache.Get(ctx, []float64{1, 0, 0}, 0.99); found {
+		t.Error("expected a miss in another partition")
+	}
+}
+
+// BenchmarkMemoryCacheIndex compares linear and HNSW lookups as the cache
+// grows.
+func BenchmarkMemoryCacheIndex(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		rng := rand.New(rand.NewSource(3))
+		ctx := context.Background()
+		vecs := clusteredVectors(rng, size, 256)
+		linear, hnsw := newIndexedCaches(size)
+		for _, v := range vecs {
+			linear.Set(ctx, newTestEntry(v, time.Hour))
+			hnsw.Set(ctx, newTestEntry(v, time.Hour))
+		}
+		queries := make([][]float64, 100)
+		for i := range queries {
+			queries[i] = perturb(rng, vecs[rng.Intn(size)], 0.05)
+		}
+
+		for name, cache := range map[string]*MemoryCache{"linear": linear, "hnsw": hnsw} {
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					cache.Get(ctx, queries[i%len(queries)], 0.95)
+				}
+			})
+		}
+	}
+}
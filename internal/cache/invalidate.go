@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// promptContains reports whether any message text of the entry's request
+// contains substring.
+func promptContains(entry *api.CacheEntry, substring string) bool {
+	for _, msg := range entry.Request.Messages {
+		switch content := msg.Content.(type) {
+		case string:
+			if strings.Contains(content, substring) {
+				return true
+			}
+		case []interface{}:
+			for _, part := range content {
+				if p, ok := part.(map[string]interface{}); ok {
+					if text, ok := p["text"].(string); ok && strings.Contains(text, substring) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// DeleteByModel removes every entry cached for model.
+func (m *MemoryCache) DeleteByModel(ctx context.Context, model string) (int, error) {
+	return m.deleteWhere(func(e *api.CacheEntry) bool { return e.Request.Model == model }), nil
+}
+
+// DeleteByPrompt removes every entry whose prompt contains substring.
+func (m *MemoryCache) DeleteByPrompt(ctx context.Context, substring string) (int, error) {
+	return m.deleteWhere(func(e *api.CacheEntry) bool { return promptContains(e, substring) }), nil
+}
+
+// deleteWhere removes the entries matching match and returns how many were
+// removed. Invalidated entries do not count toward churn stats.
+func (m *MemoryCache) deleteWhere(match func(*api.CacheEntry) bool) int {
+	if m.shards != nil {
+		removed := 0
+		for _, s := range m.shards {
+			removed += s.deleteWhere(match)
+		}
+		return removed
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	kept := make([]*api.CacheEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if match(e) {
+			m.indexRemove(e)
+			removed++
+		} else {
+			kept = append(kept, e)
+		}
+	}
+
+	m.entries = kept
+	if removed > 0 {
+		m.rebuildMatrix()
+		m.version.Add(1)
+	}
+	return removed
+}
+
+// DeleteByModel removes every entry cached for model.
+func (r *RedisCache) DeleteByModel(ctx context.Context, model string) (int, error) {
+	return r.deleteWhere(ctx, func(e *api.CacheEntry) bool { return e.Request.Model == model })
+}
+
+// DeleteByPrompt removes every entry whose prompt contains substring.
+func (r *RedisCache) DeleteByPrompt(ctx context.Context, substring string) (int, error) {
+	return r.deleteWhere(ctx, func(e *api.CacheEntry) bool { return promptContains(e, substring) })
+}
+
+// deleteWhere removes the entries matching match. Every entry body is read,
+// so this scans the whole cache.
+func (r *RedisCache) deleteWhere(ctx context.Context, match func(*api.CacheEntry) bool) (int, error) {
+	all, err := r.client.Do(ctx, "ZRANGE", redisLRUKey, "0", "-1")
+	if err != nil {
+		return 0, err
+	}
+	ids := replyStrings(all)
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	cmds := make([][]string, len(ids))
+	for i, id := range ids {
+		cmds[i] = []string{"HGET", redisEntryKey(id), "entry"}
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return 0, err
+	}
+
+	var matched []string
+	for i, reply := range replies {
+		body, ok := reply.([]byte)
+		if !ok {
+			continue
+		}
+		var entry api.CacheEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			continue
+		}
+		if match(&entry) {
+			matched = append(matched, ids[i])
+		}
+	}
+	return r.remove(ctx, matched, false)
+}
+
+// DeleteByModel removes every entry cached for model.
+func (q *QdrantCache) DeleteByModel(ctx context.Context, model string) (int, error) {
+	return q.deleteFilter(ctx, map[string]interface{}{
+		"must": []interface{}{matchFilter(qdrantModelField, model)},
+	})
+}
+
+// DeleteByPrompt removes every entry whose prompt contains substring. Every
+// entry is read, so this scans the whole collection.
+func (q *QdrantCache) DeleteByPrompt(ctx context.Context, substring string) (int, error) {
+	points, err := q.scroll(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var matched []string
+	for i := range points {
+		if entry := points[i].entry(); entry != nil && promptContains(entry, substring) {
+			matched = append(matched, points[i].ID)
+		}
+	}
+	if err := q.deletePoints(ctx, matched); err != nil {
+		return 0, err
+	}
+	return len(matched), nil
+}
+
+// DeleteByModel removes every entry cached for model.
+func (p *PostgresCache) DeleteByModel(ctx context.Context, model string) (int, error) {
+	result, err := p.client.Query(ctx, p.sql(postgresDeleteModel), model)
+	if err != nil {
+		return 0, err
+	}
+	return result.affected(), nil
+}
+
+// DeleteByPrompt removes every entry whose prompt contains substring. The
+// request of every entry is read, so this scans the whole table.
+func (p *PostgresCache) DeleteByPrompt(ctx context.Context, substring string) (int, error) {
+	result, err := p.client.Query(ctx, p.sql(postgresPrompts))
+	if err != nil {
+		return 0, err
+	}
+	var matched []string
+	for _, row := range result.rows {
+		var entry api.CacheEntry
+		if err := json.Unmarshal(row[1], &entry.Request); err != nil {
+			continue
+		}
+		if promptContains(&entry, substring) {
+			matched = append(matched, string(row[0]))
+		}
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+	result, err = p.client.Query(ctx, p.sql(postgresDeleteIDs), matched)
+	if err != nil {
+		return 0, err
+	}
+	return result.affected(), nil
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+func invalidateTestEntry(model, prompt string, embedding []float64) *api.CacheEntry {
+	entry := newTestEntry(embedding, time.Hour)
+	entry.Request = api.ChatCompletionRequest{
+		Model:    model,
+		Messages: []api.Message{{Role: "user", Content: prompt}},
+	}
+	return entry
+}
+
+func TestMemoryCacheDeleteByModelAndPrompt(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         10,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+
+	cache.Set(ctx, invalidateTestEntry("gpt-4", "What is the capital of France?", []float64{1, 0, 0}))
+	cache.Set(WithPartition(ctx, "v2"), invalidateTestEntry("gpt-4", "Summarize the refund policy", []float64{0, 1, 0}))
+	cache.Set(ctx, invalidateTestEntry("gpt-3.5-turbo", "Explain the refund policy", []float64{0, 0, 1}))
+
+	removed, err := cache.DeleteByPrompt(ctx, "refund policy")
+	if err != nil || removed != 2 {
+		t.Fatalf("expected 2 entries removed by prompt across partitions, got %d (%v)", removed, err)
+	}
+	if _, _, found := cache.Get(ctx, []float64{0, 0, 1}, 0.95); found {
+		t.Error("expected the invalidated entry to miss")
+	}
+
+	removed, err = cache.DeleteByModel(ctx, "gpt-3.5-turbo")
+	if err != nil || removed != 0 {
+		t.Errorf("expected nothing left to remove for gpt-3.5-turbo, got %d (%v)", removed, err)
+	}
+	removed, _ = cache.DeleteByModel(ctx, "gpt-4")
+	if removed != 1 || cache.Size(ctx) != 0 {
+		t.Errorf("expected the last entry removed, got %d removed and %d left", removed, cache.Size(ctx))
+	}
+	if stats := cache.Stats(ctx); stats.Evictions != 0 {
+		t.Errorf("expected invalidations not to count as evictions, got %d", stats.Evictions)
+	}
+}
+
+func TestRedisCacheDeleteByModelAndPrompt(t *testing.T) {
+	rc := newTestRedisCache(t, 100)
+	ctx := context.Background()
+
+	rc.Set(ctx, invalidateTestEntry("gpt-4", "What is the capital of France?", []float64{1, 0, 0}))
+	rc.Set(ctx, invalidateTestEntry("gpt-4", "Summarize the refund policy", []float64{0, 1, 0}))
+	rc.Set(ctx, invalidateTestEntry("gpt-3.5-turbo", "Explain the refund policy", []float64{0, 0, 1}))
+
+	removed, err := rc.DeleteByModel(ctx, "gpt-4")
+	if err != nil || removed != 2 {
+		t.Fatalf("expected 2 entries removed by model, got %d (%v)", removed, err)
+	}
+	if _, _, found := rc.Get(ctx, []float64{1, 0, 0}, 0.95); found {
+		t.Error("expected the invalidated entry to miss")
+	}
+
+	removed, err = rc.DeleteByPrompt(ctx, "refund policy")
+	if err != nil || removed != 1 {
+		t.Errorf("expected 1 entry removed by prompt, got %d (%v)", removed, err)
+	}
+	if size := rc.Size(ctx); size != 0 {
+		t.Errorf("expected an empty cache, got %d entries", size)
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// pageEntries orders entries oldest first, ties broken by ID so pages are
+// stable, and returns at most limit of them after skipping offset. A
+// non-positive limit returns everything after offset.
+func pageEntries(entries []*api.CacheEntry, offset, limit int) []*api.CacheEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(entries) {
+		return []*api.CacheEntry{}
+	}
+	entries = entries[offset:]
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// List returns a page of entries cached for model, or for any model when
+// model is empty, and how many entries match in total. Entries are
+// copies, so their hit counts don't change under the caller.
+func (m *MemoryCache) List(ctx context.Context, model string, offset, limit int) ([]*api.CacheEntry, int, error) {
+	var matched []*api.CacheEntry
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		for _, e := range s.entries {
+			if model == "" || e.Request.Model == model {
+				entry := *exportEntry(e)
+				matched = append(matched, &entry)
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	return pageEntries(matched, offset, limit), len(matched), nil
+}
+
+// List returns a page of entries cached for model, or for any model when
+// model is empty, and how many entries match in total. Every entry body is
+// read, so this scans the whole cache.
+func (r *RedisCache) List(ctx context.Context, model string, offset, limit int) ([]*api.CacheEntry, int, error) {
+	all, err := r.client.Do(ctx, "ZRANGE", redisLRUKey, "0", "-1")
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := replyStrings(all)
+	if len(ids) == 0 {
+		return []*api.CacheEntry{}, 0, nil
+	}
+
+	cmds := make([][]string, len(ids))
+	for i, id := range ids {
+		cmds[i] = []string{"HMGET", redisEntryKey(id), "entry", "embedding", "hits"}
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var matched []*api.CacheEntry
+	for _, reply := range replies {
+		entry := decodeRedisEntry(reply)
+		if entry != nil && (model == "" || entry.Request.Model == model) {
+			matched = append(matched, entry)
+		}
+	}
+	return pageEntries(matched, offset, limit), len(matched), nil
+}
+
+// List returns a page of entries cached for model, or for any model when
+// model is empty, and how many entries match in total. Every matching entry
+// is read, so this scans the collection.
+func (q *QdrantCache) List(ctx context.Context, model string, offset, limit int) ([]*api.CacheEntry, int, error) {
+	var filter map[string]interface{}
+	if model != "" {
+		filter = map[string]interface{}{"must": []interface{}{matchFilter(qdrantModelField, model)}}
+	}
+	points, err := q.scroll(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matched := []*api.CacheEntry{}
+	for i := range points {
+		if entry := points[i].entry(); entry != nil {
+			matched = append(matched, entry)
+		}
+	}
+	return pageEntries(matched, offset, limit), len(matched), nil
+}
+
+// List returns a page of entries cached for model, or for any model when
+// model is empty, and how many entries match in total. Paging is done by
+// the database.
+func (p *PostgresCache) List(ctx context.Context, model string, offset, limit int) ([]*api.CacheEntry, int, error) {
+	total, err := p.count(ctx, postgresCountModel, model)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	var rowLimit interface{}
+	if limit > 0 {
+		rowLimit = limit
+	}
+	result, err := p.client.Query(ctx, p.sql(postgresListModel), model, offset, rowLimit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*api.CacheEntry, 0, len(result.rows))
+	for _, row := range result.rows {
+		if entry, err := decodePostgresEntry(row); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, total, nil
+}
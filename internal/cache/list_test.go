@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheList(t *testing.T) {
+	backends := map[string]func(t *testing.T) Cache{
+		"memory": func(t *testing.T) Cache {
+			return NewMemoryCache(&Options{
+				MaxSize:         10,
+				DefaultTTL:      time.Hour,
+				CleanupInterval: time.Hour,
+			})
+		},
+		"redis": func(t *testing.T) Cache { return newTestRedisCache(t, 100) },
+	}
+
+	for name, newCache := range backends {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+			ctx := context.Background()
+			start := time.Now().Add(-time.Hour)
+			for i, e := range []struct {
+				model, prompt string
+				emb           []float64
+			}{
+				{"gpt-4", "first", []float64{1, 0, 0}},
+				{"gpt-3.5-turbo", "second", []float64{0, 1, 0}},
+				{"gpt-4", "third", []float64{0, 0, 1}},
+			} {
+				entry := invalidateTestEntry(e.model, e.prompt, e.emb)
+				entry.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+				c.Set(ctx, entry)
+			}
+
+			entries, total, err := c.List(ctx, "", 1, 1)
+			if err != nil || total != 3 || len(entries) != 1 {
+				t.Fatalf("expected 1 of 3 entries, got %d of %d (%v)", len(entries), total, err)
+			}
+			if got := entries[0].Request.Messages[0].Content; got != "second" {
+				t.Errorf("expected entries oldest first, got %v at offset 1", got)
+			}
+
+			entries, total, _ = c.List(ctx, "gpt-4", 0, 10)
+			if total != 2 || len(entries) != 2 || entries[1].Request.Messages[0].Content != "third" {
+				t.Errorf("expected the two gpt-4 entries, got %d of %d", len(entries), total)
+			}
+
+			entries, total, _ = c.List(ctx, "", 5, 10)
+			if total != 3 || len(entries) != 0 {
+				t.Errorf("expected an empty page past the end, got %d of %d", len(entries), total)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,13 +13,36 @@ import (
 
 // MemoryCache implements an in-memory semantic cache.
 type MemoryCache struct {
+	cleanupReporter
 	mu      sync.RWMutex
 	entries []*api.CacheEntry
 	opts    *Options
 
+	// matrix mirrors entry embeddings contiguously when BatchSimilarity is set
+	matrix vectorMatrix
+
+	// indexes holds one HNSW graph per partition when IndexType is IndexHNSW
+	indexes map[string]*hnswIndex
+
+	// ghosts holds recently evicted entries, oldest first, when GhostSize is set
+	ghosts []ghost
+
 	// Stats
-	hits   atomic.Int64
-	misses atomic.Int64
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	evictedUnused atomic.Int64
+	resurrections atomic.Int64
+	dimMismatches atomic.Int64
+
+	// version is bumped on every mutation so snapshots can skip unchanged state
+	version atomic.Uint64
+
+	// shards spread entries across independently locked caches when
+	// Shards is above 1. A sharded cache holds no entries itself and only
+	// counts hits and misses; setMu serializes its writes.
+	shards []*MemoryCache
+	setMu  sync.Mutex
 }
 
 // NewMemoryCache creates a new in-memory cache.
@@ -26,9 +51,11 @@ func NewMemoryCache(opts *Options) *MemoryCache {
 		opts = DefaultOptions()
 	}
 
-	mc := &MemoryCache{
-		entries: make([]*api.CacheEntry, 0, opts.MaxSize),
-		opts:    opts,
+	var mc *MemoryCache
+	if n := shardCount(opts); n > 1 {
+		mc = &MemoryCache{opts: opts, shards: newShards(opts, n)}
+	} else {
+		mc = newMemoryShard(opts)
 	}
 
 	// Start cleanup goroutine
@@ -37,8 +64,75 @@ func NewMemoryCache(opts *Options) *MemoryCache {
 	return mc
 }
 
-// Get retrieves a cached response based on semantic similarity.
+// newMemoryShard creates an unsharded cache without a cleanup goroutine.
+func newMemoryShard(opts *Options) *MemoryCache {
+	mc := &MemoryCache{
+		entries: make([]*api.CacheEntry, 0, opts.MaxSize),
+		opts:    opts,
+	}
+	if opts.IndexType == IndexHNSW && !mc.useFloat32() {
+		mc.indexes = make(map[string]*hnswIndex)
+	}
+	return mc
+}
+
+// Get retrieves a cached response based on semantic similarity. Each
+// entry's ThresholdOffset is added to threshold when it is compared.
+// Entries are stored with a unit-length copy of their embedding, so after
+// normalizing the query once each comparison is a dot product. With
+// MetricDot or MetricEuclidean the raw embeddings are compared instead,
+// and for MetricEuclidean the returned score is a distance. With
+// RecencyHalfLife set, scores decay with entry age before they are ranked
+// and compared. With GhostSize set, a miss falls back to recently evicted
+// entries and resurrects the best match. A sharded cache searches its
+// shards in parallel. A miss for an embedding whose dimensions differ from
+// the partition's entries is counted as a dimension mismatch.
 func (m *MemoryCache) Get(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	owner, entry, score, ok := m.search(ctx, embedding, threshold)
+	if !ok && m.opts.GhostSize > 0 {
+		for _, s := range m.leaves() {
+			if entry, score, ok = s.resurrect(ctx, embedding, threshold); ok {
+				owner = s
+				break
+			}
+		}
+	}
+	if !ok {
+		m.misses.Add(1)
+		m.checkDim(PartitionFromContext(ctx), len(embedding))
+		return nil, 0, false
+	}
+	m.hits.Add(1)
+	// Callers get a copy, since hit stats and sliding expiry keep changing
+	// the stored entry while they read it
+	owner.mu.RLock()
+	hit := *entry
+	owner.mu.RUnlock()
+	// Update hit stats (requires write lock, but we defer to avoid complexity)
+	go owner.updateHitStats(entry)
+	return &hit, score, true
+}
+
+// Peek finds the best live match for embedding like Get, but counts no hit
+// or miss and leaves the entry's hit stats alone.
+func (m *MemoryCache) Peek(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	owner, entry, score, ok := m.search(ctx, embedding, threshold)
+	if !ok {
+		return nil, 0, false
+	}
+	owner.mu.RLock()
+	found := *entry
+	owner.mu.RUnlock()
+	return &found, score, true
+}
+
+// lookup finds the best live match for embedding without touching stats.
+func (m *MemoryCache) lookup(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	if m.opts.Metric == MetricDot || m.opts.Metric == MetricEuclidean {
+		return m.lookupRaw(ctx, embedding, threshold)
+	}
+	query := m.unitQuery(embedding)
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -46,29 +140,96 @@ func (m *MemoryCache) Get(ctx context.Context, embedding []float64, threshold fl
 	var bestSimilarity float64
 
 	now := time.Now()
+	partition := PartitionFromContext(ctx)
 
-	for _, entry := range m.entries {
-		// Skip expired entries
-		if now.After(entry.ExpiresAt) {
+	if m.indexes != nil {
+		bestMatch, bestSimilarity = m.searchIndex(partition, query.f64, threshold, now)
+		return bestMatch, bestSimilarity, bestMatch != nil
+	}
+
+	var scores []float64
+	if m.opts.BatchSimilarity && query.f64 != nil && m.matrix.usable(query.f64) {
+		scores = dotBatch(query.f64, m.matrix.data, m.matrix.dim)
+	}
+
+	for i, entry := range m.entries {
+		// Skip expired entries and entries from other partitions
+		if now.After(entry.ExpiresAt) || entry.Partition != partition {
 			continue
 		}
 
-		similarity := CosineSimilarity(embedding, entry.Embedding)
-		if similarity >= threshold && similarity > bestSimilarity {
+		var similarity float64
+		if scores != nil {
+			similarity = scores[i]
+		} else {
+			similarity = query.dot(entry)
+		}
+		similarity *= m.decay(entry, now)
+		if similarity >= threshold+entry.ThresholdOffset && similarity > bestSimilarity {
 			bestSimilarity = similarity
 			bestMatch = entry
 		}
 	}
 
-	if bestMatch != nil {
-		m.hits.Add(1)
-		// Update hit stats (requires write lock, but we defer to avoid complexity)
-		go m.updateHitStats(bestMatch)
-		return bestMatch, bestSimilarity, true
+	return bestMatch, bestSimilarity, bestMatch != nil
+}
+
+// lookupRaw is lookup for the metrics that compare raw embeddings. A dot
+// product must reach threshold plus the entry's ThresholdOffset, while a
+// Euclidean distance must not exceed threshold minus it, so a positive
+// offset makes an entry stricter under either metric. Recency decay
+// likewise divides distances, so older entries look farther away.
+func (m *MemoryCache) lookupRaw(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var bestMatch *api.CacheEntry
+	var bestScore float64
+
+	now := time.Now()
+	partition := PartitionFromContext(ctx)
+	euclidean := m.opts.Metric == MetricEuclidean
+
+	for _, entry := range m.entries {
+		if now.After(entry.ExpiresAt) || entry.Partition != partition {
+			continue
+		}
+		vec := rawVector(entry)
+		if len(vec) != len(embedding) || len(vec) == 0 {
+			continue
+		}
+
+		decay := m.decay(entry, now)
+		if euclidean {
+			distance := EuclideanDistance(embedding, vec) / decay
+			if distance <= threshold-entry.ThresholdOffset && (bestMatch == nil || distance < bestScore) {
+				bestScore = distance
+				bestMatch = entry
+			}
+			continue
+		}
+		score := dot(embedding, vec) * decay
+		if score >= threshold+entry.ThresholdOffset && (bestMatch == nil || score > bestScore) {
+			bestScore = score
+			bestMatch = entry
+		}
 	}
 
-	m.misses.Add(1)
-	return nil, 0, false
+	return bestMatch, bestScore, bestMatch != nil
+}
+
+// decay returns the factor an entry's score is scaled by for its age:
+// 1 when new, halving every RecencyHalfLife, and always 1 when decay is
+// disabled.
+func (m *MemoryCache) decay(entry *api.CacheEntry, now time.Time) float64 {
+	if m.opts.RecencyHalfLife <= 0 {
+		return 1
+	}
+	age := now.Sub(entry.CreatedAt)
+	if age <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(m.opts.RecencyHalfLife))
 }
 
 // updateHitStats updates the hit statistics for an entry.
@@ -79,28 +240,217 @@ func (m *MemoryCache) updateHitStats(entry *api.CacheEntry) {
 	entry.LastHitAt = time.Now()
 }
 
-// Set stores a response with its embedding.
+// Touch marks the stored entry with entry's ID, such as the copy returned
+// by Get, as just hit and slides its expiry to ttl from now. The expiry
+// never moves earlier, nor past maxAge after the entry was created (no cap
+// when zero). It returns the entry's expiry, or entry's own once the entry
+// has left the cache.
+func (m *MemoryCache) Touch(entry *api.CacheEntry, ttl, maxAge time.Duration) time.Time {
+	if m.shards != nil {
+		return m.shardFor(entry).Touch(entry, ttl, maxAge)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.stored(entry)
+	if stored == nil {
+		return entry.ExpiresAt
+	}
+	now := time.Now()
+	stored.LastHitAt = now
+	expires := now.Add(ttl)
+	if limit := stored.CreatedAt.Add(maxAge); maxAge > 0 && expires.After(limit) {
+		expires = limit
+	}
+	if expires.After(stored.ExpiresAt) {
+		stored.ExpiresAt = expires
+		m.version.Add(1)
+	}
+	return stored.ExpiresAt
+}
+
+// stored returns the entry held by the cache that entry is, or copies, or
+// nil when there is none. Caller must hold the lock.
+func (m *MemoryCache) stored(entry *api.CacheEntry) *api.CacheEntry {
+	for _, e := range m.entries {
+		if e == entry || (entry.ID != "" && e.ID == entry.ID) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Set stores a response with its embedding. An entry whose embedding has
+// different dimensions than the live entries in its partition is refused
+// with a *DimensionError.
 func (m *MemoryCache) Set(ctx context.Context, entry *api.CacheEntry) error {
+	if err := m.checkDim(entry.Partition, len(rawVector(entry))); err != nil {
+		return err
+	}
+	m.prepare(entry)
+	if m.shards != nil {
+		m.setSharded(entry)
+		return nil
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.store(entry)
+	return nil
+}
 
-	// Check for duplicate (update if exists)
-	for i, e := range m.entries {
-		similarity := CosineSimilarity(entry.Embedding, e.Embedding)
-		if similarity > 0.99 {
-			// Update existing entry
-			m.entries[i] = entry
-			return nil
+// prepare assigns entry its ID and stored vectors and compresses its
+// response as configured.
+func (m *MemoryCache) prepare(entry *api.CacheEntry) {
+	if m.opts.RetainRawEmbeddings && entry.RawEmbedding == nil {
+		entry.RawEmbedding = entry.Embedding
+		entry.Embedding = NormalizeVector(entry.Embedding)
+	}
+	assignID(entry)
+	unit := entry.Embedding
+	if !m.opts.RetainRawEmbeddings {
+		unit = NormalizeVector(entry.Embedding)
+	}
+	m.storeUnit(entry, unit)
+	if m.opts.CompressResponses {
+		compressResponse(entry)
+	}
+}
+
+// store replaces the entry near-identical to entry, or inserts entry when
+// there is none. Caller must hold the write lock.
+func (m *MemoryCache) store(entry *api.CacheEntry) {
+	if i := m.findDuplicate(entry); i >= 0 {
+		e := m.entries[i]
+		if m.opts.BatchSimilarity {
+			m.matrix.set(i, e.UnitEmbedding, entry.UnitEmbedding)
 		}
+		m.indexRemove(e)
+		m.indexAdd(entry)
+		m.entries[i] = entry
+		m.version.Add(1)
+		return
 	}
 
-	// Evict if at capacity (LRU-style: remove oldest)
+	m.insert(entry)
+}
+
+// insert appends entry, evicting first if the cache is full. Caller must
+// hold the write lock.
+func (m *MemoryCache) insert(entry *api.CacheEntry) {
+	// Evict if at capacity
 	if len(m.entries) >= m.opts.MaxSize {
-		m.evictOldest()
+		m.evict()
 	}
 
 	m.entries = append(m.entries, entry)
-	return nil
+	if m.opts.BatchSimilarity {
+		m.matrix.append(entry.UnitEmbedding)
+	}
+	m.indexAdd(entry)
+	m.version.Add(1)
+}
+
+// findDuplicate returns the position of an entry in entry's partition with
+// a near-identical embedding, or -1. Caller must hold the write lock.
+func (m *MemoryCache) findDuplicate(entry *api.CacheEntry) int {
+	unit := m.entryUnit(entry)
+	if m.indexes != nil {
+		idx := m.indexes[entry.Partition]
+		if idx == nil {
+			return -1
+		}
+		for _, c := range idx.search(unit.f64, hnswEfSearch) {
+			if unit.dot(c.node.entry) <= 0.99 {
+				continue
+			}
+			for i, e := range m.entries {
+				if e == c.node.entry {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	for i, e := range m.entries {
+		if e.Partition != entry.Partition {
+			continue
+		}
+		if unit.dot(e) > 0.99 {
+			return i
+		}
+	}
+	return -1
+}
+
+// searchIndex finds the best unexpired match for the unit-length query at
+// or above threshold among the HNSW candidates of partition. Similarities
+// are recomputed exactly so they match the linear scan. Caller must hold
+// the read lock.
+func (m *MemoryCache) searchIndex(partition string, query []float64, threshold float64, now time.Time) (*api.CacheEntry, float64) {
+	idx := m.indexes[partition]
+	if idx == nil {
+		return nil, 0
+	}
+
+	var bestMatch *api.CacheEntry
+	var bestSimilarity float64
+	for _, c := range idx.search(query, hnswEfSearch) {
+		if now.After(c.node.entry.ExpiresAt) {
+			continue
+		}
+		similarity := dot(query, c.node.entry.UnitEmbedding) * m.decay(c.node.entry, now)
+		if similarity >= threshold+c.node.entry.ThresholdOffset && similarity > bestSimilarity {
+			bestSimilarity = similarity
+			bestMatch = c.node.entry
+		}
+	}
+	return bestMatch, bestSimilarity
+}
+
+// indexAdd adds entry to its partition's graph. Caller must hold the write lock.
+func (m *MemoryCache) indexAdd(entry *api.CacheEntry) {
+	if m.indexes == nil {
+		return
+	}
+	idx := m.indexes[entry.Partition]
+	if idx == nil {
+		idx = newHNSWIndex()
+		m.indexes[entry.Partition] = idx
+	}
+	idx.insert(entry)
+}
+
+// indexRemove removes entry from its partition's graph. Caller must hold
+// the write lock.
+func (m *MemoryCache) indexRemove(entry *api.CacheEntry) {
+	if m.indexes == nil {
+		return
+	}
+	idx := m.indexes[entry.Partition]
+	if idx == nil {
+		return
+	}
+	idx.remove(entry)
+	if idx.len() == 0 {
+		delete(m.indexes, entry.Partition)
+	}
+}
+
+// evict removes one entry according to the configured eviction policy.
+func (m *MemoryCache) evict() {
+	switch m.opts.EvictionPolicy {
+	case EvictionDiversity:
+		m.evictRedundant()
+	case EvictionLFU:
+		m.evictLeastFrequent()
+	case EvictionTTLLRU:
+		m.evictNearestExpiry()
+	default:
+		m.evictOldest()
+	}
 }
 
 // evictOldest removes the oldest entry based on last hit time.
@@ -119,21 +469,157 @@ func (m *MemoryCache) evictOldest() {
 		}
 	}
 
-	// Remove by swapping with last element
-	m.entries[oldestIdx] = m.entries[len(m.entries)-1]
+	m.evictAt(oldestIdx)
+}
+
+// evictLeastFrequent removes the entry with the fewest hits, preferring the
+// least recently hit among equals.
+func (m *MemoryCache) evictLeastFrequent() {
+	if len(m.entries) == 0 {
+		return
+	}
+
+	victim := 0
+	for i, e := range m.entries {
+		v := m.entries[victim]
+		if e.HitCount < v.HitCount || (e.HitCount == v.HitCount && e.LastHitAt.Before(v.LastHitAt)) {
+			victim = i
+		}
+	}
+
+	m.evictAt(victim)
+}
+
+// evictNearestExpiry removes the entry that expires first, preferring the
+// least recently hit among equals.
+func (m *MemoryCache) evictNearestExpiry() {
+	if len(m.entries) == 0 {
+		return
+	}
+
+	victim := 0
+	for i, e := range m.entries {
+		v := m.entries[victim]
+		if e.ExpiresAt.Before(v.ExpiresAt) || (e.ExpiresAt.Equal(v.ExpiresAt) && e.LastHitAt.Before(v.LastHitAt)) {
+			victim = i
+		}
+	}
+
+	m.evictAt(victim)
+}
+
+// evictRedundant removes the entry whose nearest neighbor in the same
+// partition is most similar, considering only the least recently used
+// candidates. Ties go to the older entry, so with no close neighbors this
+// degrades to LRU.
+func (m *MemoryCache) evictRedundant() {
+	if len(m.entries) == 0 {
+		return
+	}
+
+	candidates := make([]int, len(m.entries))
+	for i := range candidates {
+		candidates[i] = i
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return m.entries[candidates[a]].LastHitAt.Before(m.entries[candidates[b]].LastHitAt)
+	})
+	limit := m.opts.DiversityCandidates
+	if limit <= 0 {
+		limit = 32
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	victim := candidates[0]
+	bestNeighbor := -2.0
+	for _, i := range candidates {
+		unit := m.entryUnit(m.entries[i])
+		nearest := -1.0
+		for j, other := range m.entries {
+			if j == i || other.Partition != m.entries[i].Partition {
+				continue
+			}
+			if sim := unit.dot(other); sim > nearest {
+				nearest = sim
+			}
+		}
+		if nearest > bestNeighbor {
+			bestNeighbor = nearest
+			victim = i
+		}
+	}
+
+	m.evictAt(victim)
+}
+
+// evictAt removes the entry at idx to make room, keeping it as a ghost
+// when GhostSize is set. Caller must hold the write lock.
+func (m *MemoryCache) evictAt(idx int) {
+	m.addGhost(m.entries[idx])
+	m.removeAt(idx)
+}
+
+// removeAt evicts the entry at idx. Caller must hold the write lock.
+func (m *MemoryCache) removeAt(idx int) {
+	m.recordRemoval(m.entries[idx])
+	m.swapRemove(idx)
+}
+
+// swapRemove removes the entry at idx by swapping with the last element.
+// Caller must hold the write lock.
+func (m *MemoryCache) swapRemove(idx int) {
+	if m.opts.BatchSimilarity {
+		m.matrix.swapRemove(idx, m.entries[idx].UnitEmbedding)
+	}
+	m.indexRemove(m.entries[idx])
+	m.entries[idx] = m.entries[len(m.entries)-1]
 	m.entries = m.entries[:len(m.entries)-1]
 }
 
+// rebuildMatrix repopulates the embedding matrix from entries.
+// Caller must hold the write lock.
+func (m *MemoryCache) rebuildMatrix() {
+	if !m.opts.BatchSimilarity {
+		return
+	}
+	m.matrix.reset()
+	for _, e := range m.entries {
+		m.matrix.append(e.UnitEmbedding)
+	}
+}
+
+// recordRemoval counts an evicted or expired entry toward churn stats.
+func (m *MemoryCache) recordRemoval(entry *api.CacheEntry) {
+	m.evictions.Add(1)
+	if entry.HitCount == 0 {
+		m.evictedUnused.Add(1)
+	}
+}
+
 // Delete removes an entry by its embedding.
 func (m *MemoryCache) Delete(ctx context.Context, embedding []float64) error {
+	if m.shards != nil {
+		for _, s := range m.shards {
+			s.Delete(ctx, embedding)
+		}
+		return nil
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	query := m.unitQuery(embedding)
+	partition := PartitionFromContext(ctx)
 	for i, e := range m.entries {
-		similarity := CosineSimilarity(embedding, e.Embedding)
+		if e.Partition != partition {
+			continue
+		}
+		similarity := query.dot(e)
 		if similarity > 0.99 {
-			m.entries[i] = m.entries[len(m.entries)-1]
-			m.entries = m.entries[:len(m.entries)-1]
+			m.swapRemove(i)
+			m.version.Add(1)
 			return nil
 		}
 	}
@@ -143,20 +629,49 @@ func (m *MemoryCache) Delete(ctx context.Context, embedding []float64) error {
 
 // Clear removes all entries from the cache.
 func (m *MemoryCache) Clear(ctx context.Context) error {
+	if m.shards != nil {
+		for _, s := range m.shards {
+			s.Clear(ctx)
+		}
+		m.hits.Store(0)
+		m.misses.Store(0)
+		m.dimMismatches.Store(0)
+		m.version.Add(1)
+		return nil
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.entries = make([]*api.CacheEntry, 0, m.opts.MaxSize)
+	m.ghosts = nil
+	m.matrix.reset()
+	if m.indexes != nil {
+		m.indexes = make(map[string]*hnswIndex)
+	}
 	m.hits.Store(0)
 	m.misses.Store(0)
+	m.evictions.Store(0)
+	m.evictedUnused.Store(0)
+	m.resurrections.Store(0)
+	m.dimMismatches.Store(0)
+	m.version.Add(1)
 
 	return nil
 }
 
 // Stats returns cache statistics.
 func (m *MemoryCache) Stats(ctx context.Context) *api.CacheStats {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	var entries, evictions, evictedUnused, resurrections int64
+	dimMismatches := m.dimMismatches.Load()
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		entries += int64(len(s.entries))
+		s.mu.RUnlock()
+		evictions += s.evictions.Load()
+		evictedUnused += s.evictedUnused.Load()
+		resurrections += s.resurrections.Load()
+	}
 
 	hits := m.hits.Load()
 	misses := m.misses.Load()
@@ -170,50 +685,115 @@ func (m *MemoryCache) Stats(ctx context.Context) *api.CacheStats {
 	// Estimate cost savings (rough: $0.002 per 1K tokens, assume 500 tokens per request)
 	estimatedSaved := float64(hits) * 0.001
 
+	var churnRate float64
+	if evictions > 0 {
+		churnRate = float64(evictedUnused) / float64(evictions)
+	}
+
 	return &api.CacheStats{
-		TotalEntries:   int64(len(m.entries)),
-		TotalHits:      hits,
-		TotalMisses:    misses,
-		HitRate:        hitRate,
-		EstimatedSaved: estimatedSaved,
+		TotalEntries:        entries,
+		TotalHits:           hits,
+		TotalMisses:         misses,
+		HitRate:             hitRate,
+		EstimatedSaved:      estimatedSaved,
+		Evictions:           evictions,
+		EvictedUnused:       evictedUnused,
+		ChurnRate:           churnRate,
+		Resurrections:       resurrections,
+		DimensionMismatches: dimMismatches,
 	}
 }
 
-// Cleanup removes expired entries.
+// Cleanup removes expired entries. With CleanupChunkSize set, entries are
+// checked that many at a time and the lock is released between chunks, so
+// entries added or moved during a run may be left for the next one.
 func (m *MemoryCache) Cleanup(ctx context.Context) int {
+	if m.shards != nil {
+		removed := 0
+		for _, s := range m.shards {
+			removed += s.Cleanup(ctx)
+		}
+		return removed
+	}
+
+	removed := 0
+	for next := 0; ; {
+		n, done := m.cleanupChunk(next)
+		removed += n
+		if done || ctx.Err() != nil {
+			return removed
+		}
+		next += m.opts.CleanupChunkSize
+	}
+}
+
+// cleanupChunk removes expired entries among the CleanupChunkSize entries
+// from start, or all of them when CleanupChunkSize is unset, and reports
+// how many it removed and whether it reached the end. Each expired entry
+// is replaced by the last one, which is checked in its place, so entries
+// past the chunk keep their positions for the next one.
+func (m *MemoryCache) cleanupChunk(start int) (int, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	now := time.Now()
-	removed := 0
+	if start == 0 {
+		m.pruneGhosts(now)
+	}
+	end := len(m.entries)
+	if m.opts.CleanupChunkSize > 0 && start+m.opts.CleanupChunkSize < end {
+		end = start + m.opts.CleanupChunkSize
+	}
 
-	// Filter out expired entries
-	active := make([]*api.CacheEntry, 0, len(m.entries))
-	for _, e := range m.entries {
-		if now.Before(e.ExpiresAt) {
-			active = append(active, e)
-		} else {
-			removed++
+	removed := 0
+	for i := start; i < end && i < len(m.entries); {
+		if now.Before(m.entries[i].ExpiresAt) {
+			i++
+			continue
 		}
+		m.removeAt(i)
+		removed++
 	}
-
-	m.entries = active
-	return removed
+	if removed > 0 {
+		m.version.Add(1)
+	}
+	return removed, end >= len(m.entries)
 }
 
 // Size returns the number of entries in the cache.
 func (m *MemoryCache) Size(ctx context.Context) int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return len(m.entries)
+	size := 0
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		size += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return size
+}
+
+// SampleNorms returns the lengths of up to n stored vectors, as compared by
+// the dot and euclidean metrics: raw embeddings when they are retained.
+func (m *MemoryCache) SampleNorms(n int) []float64 {
+	var norms []float64
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		for _, entry := range s.entries {
+			if len(norms) >= n {
+				break
+			}
+			norms = append(norms, VectorNorm(rawVector(entry)))
+		}
+		s.mu.RUnlock()
+	}
+	return norms
+}
+
+// Ping always succeeds; the memory cache has no backing store to reach.
+func (m *MemoryCache) Ping(ctx context.Context) error {
+	return nil
 }
 
 // cleanupLoop periodically removes expired entries.
 func (m *MemoryCache) cleanupLoop() {
-	ticker := time.NewTicker(m.opts.CleanupInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		m.Cleanup(context.Background())
-	}
+	runCleanups(m.opts.CleanupInterval, m.Cleanup, &m.cleanupReporter)
 }
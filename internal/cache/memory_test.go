@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"math"
 	"testing"
 	"time"
 
@@ -151,11 +152,11 @@ func TestMemoryCacheStats(t *testing.T) {
 	cache.Set(ctx, entry)
 
 	// Generate some hits and misses
-	cache.Get(ctx, embedding, 0.9)                  // hit
-	cache.Get(ctx, embedding, 0.9)                  // hit
-	cache.Get(ctx, []float64{0, 1, 0}, 0.9)         // miss
-	cache.Get(ctx, []float64{0, 0, 1}, 0.9)         // miss
-	cache.Get(ctx, []float64{-1, 0, 0}, 0.9)        // miss
+	cache.Get(ctx, embedding, 0.9)           // hit
+	cache.Get(ctx, embedding, 0.9)           // hit
+	cache.Get(ctx, []float64{0, 1, 0}, 0.9)  // miss
+	cache.Get(ctx, []float64{0, 0, 1}, 0.9)  // miss
+	cache.Get(ctx, []float64{-1, 0, 0}, 0.9) // miss
 
 	// Allow async hit stats update
 	time.Sleep(10 * time.Millisecond)
@@ -274,6 +275,108 @@ func TestMemoryCacheEviction(t *testing.T) {
 	}
 }
 
+func TestMemoryCacheEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		evicted string
+	}{
+		// popular was hit most but longest ago; oneOff was never hit
+		{policy: EvictionLRU, evicted: "popular"},
+		{policy: EvictionLFU, evicted: "stale"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cache := NewMemoryCache(&Options{
+				MaxSize:         3,
+				DefaultTTL:      time.Hour,
+				CleanupInterval: time.Hour,
+				EvictionPolicy:  tt.policy,
+			})
+			ctx := context.Background()
+			now := time.Now()
+
+			entries := []struct {
+				id      string
+				emb     []float64
+				hits    int64
+				lastHit time.Duration
+			}{
+				{"popular", []float64{1, 0, 0}, 50, -3 * time.Minute},
+				{"stale", []float64{0, 1, 0}, 0, -2 * time.Minute},
+				{"oneOff", []float64{0, 0, 1}, 0, -time.Minute},
+			}
+			for _, e := range entries {
+				entry := newTestEntry(e.emb, time.Hour)
+				entry.Response.ID = e.id
+				entry.HitCount = e.hits
+				entry.LastHitAt = now.Add(e.lastHit)
+				cache.Set(ctx, entry)
+			}
+			cache.Set(ctx, newTestEntry([]float64{1, 1, 1}, time.Hour))
+
+			for _, e := range entries {
+				_, _, found := cache.Get(ctx, e.emb, 0.99)
+				if want := e.id != tt.evicted; found != want {
+					t.Errorf("%s: expected found=%v, got %v", e.id, want, found)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCacheEvictionTTLLRU(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(23 * time.Hour)
+	tests := []struct {
+		name    string
+		expires []time.Time
+		evicted string
+	}{
+		// expiring was hit most recently but has a minute left
+		{name: "nearest expiry", expires: []time.Time{now.Add(time.Minute), expires, expires}, evicted: "expiring"},
+		// with equal expiries the least recently hit goes
+		{name: "lru among equals", expires: []time.Time{expires, expires, expires}, evicted: "cold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewMemoryCache(&Options{
+				MaxSize:         3,
+				DefaultTTL:      time.Hour,
+				CleanupInterval: time.Hour,
+				EvictionPolicy:  EvictionTTLLRU,
+			})
+			ctx := context.Background()
+
+			entries := []struct {
+				id      string
+				emb     []float64
+				lastHit time.Duration
+			}{
+				{"expiring", []float64{1, 0, 0}, -time.Second},
+				{"cold", []float64{0, 1, 0}, -3 * time.Hour},
+				{"warm", []float64{0, 0, 1}, -time.Hour},
+			}
+			for i, e := range entries {
+				entry := newTestEntry(e.emb, time.Hour)
+				entry.Response.ID = e.id
+				entry.ExpiresAt = tt.expires[i]
+				entry.LastHitAt = now.Add(e.lastHit)
+				cache.Set(ctx, entry)
+			}
+			cache.Set(ctx, newTestEntry([]float64{1, 1, 1}, 23*time.Hour))
+
+			for _, e := range entries {
+				_, _, found := cache.Get(ctx, e.emb, 0.99)
+				if want := e.id != tt.evicted; found != want {
+					t.Errorf("%s: expected found=%v, got %v", e.id, want, found)
+				}
+			}
+		})
+	}
+}
+
 func TestMemoryCacheCleanup(t *testing.T) {
 	cache := NewMemoryCache(&Options{
 		MaxSize:         100,
@@ -305,6 +408,113 @@ func TestMemoryCacheCleanup(t *testing.T) {
 	}
 }
 
+func TestMemoryCacheCleanupChunks(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:          100,
+		DefaultTTL:       time.Hour,
+		CleanupInterval:  time.Hour,
+		CleanupChunkSize: 4,
+		BatchSimilarity:  true,
+	})
+	ctx := context.Background()
+
+	// Every third entry is live, the rest already expired
+	const n = 25
+	axis := func(i int) []float64 {
+		emb := make([]float64, n)
+		emb[i] = 1
+		return emb
+	}
+	live := 0
+	for i := 0; i < n; i++ {
+		ttl := -time.Hour
+		if i%3 == 0 {
+			ttl = time.Hour
+			live++
+		}
+		cache.Set(ctx, newTestEntry(axis(i), ttl))
+	}
+
+	if removed := cache.Cleanup(ctx); removed != n-live {
+		t.Errorf("expected %d removed, got %d", n-live, removed)
+	}
+	if cache.Size(ctx) != live {
+		t.Errorf("expected size=%d after cleanup, got %d", live, cache.Size(ctx))
+	}
+	for i := 0; i < n; i += 3 {
+		if _, _, found := cache.Get(ctx, axis(i), 0.99); !found {
+			t.Errorf("expected live entry %d to survive cleanup", i)
+		}
+	}
+}
+
+func TestMemoryCacheTouch(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, DefaultTTL: time.Hour, CleanupInterval: time.Hour})
+	ctx := context.Background()
+
+	entry := newTestEntry([]float64{1, 0, 0}, time.Minute)
+	entry.CreatedAt = time.Now().Add(-50 * time.Minute)
+	cache.Set(ctx, entry)
+
+	// The expiry slides to ttl from now
+	expires := cache.Touch(entry, 5*time.Minute, time.Hour)
+	if d := time.Until(expires); d < 4*time.Minute || d > 5*time.Minute {
+		t.Errorf("expected expiry about 5m away, got %v", d)
+	}
+
+	// but never past maxAge after creation
+	expires = cache.Touch(entry, 30*time.Minute, time.Hour)
+	if want := entry.CreatedAt.Add(time.Hour); !expires.Equal(want) {
+		t.Errorf("expected expiry capped at %v, got %v", want, expires)
+	}
+
+	// and never earlier than it was
+	if got := cache.Touch(entry, time.Second, time.Hour); !got.Equal(expires) {
+		t.Errorf("expected expiry to stay at %v, got %v", expires, got)
+	}
+	if time.Since(entry.LastHitAt) > time.Second {
+		t.Error("expected LastHitAt to be updated")
+	}
+}
+
+func TestMemoryCacheGetReturnsCopy(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, DefaultTTL: time.Hour, CleanupInterval: time.Hour})
+	ctx := context.Background()
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Minute))
+
+	hit, _, found := cache.Get(ctx, []float64{1, 0, 0}, 0.99)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	hit.Response.ID = "changed"
+
+	// Touching the copy slides the stored entry's expiry
+	expires := cache.Touch(hit, 30*time.Minute, 0)
+	again, _, _ := cache.Get(ctx, []float64{1, 0, 0}, 0.99)
+	if again == hit || again.Response.ID == "changed" {
+		t.Error("expected Get to return a copy of the stored entry")
+	}
+	if !again.ExpiresAt.Equal(expires) || time.Until(expires) < 29*time.Minute {
+		t.Errorf("expected the stored expiry slid to %v, got %v", expires, again.ExpiresAt)
+	}
+}
+
+func TestMemoryCachePeek(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, DefaultTTL: time.Hour, CleanupInterval: time.Hour, Shards: 2})
+	ctx := context.Background()
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Minute))
+
+	if _, similarity, found := cache.Peek(ctx, []float64{1, 0, 0}, 0.99); !found || similarity < 0.99 {
+		t.Fatalf("expected a match, got %v at %v", found, similarity)
+	}
+	if _, _, found := cache.Peek(ctx, []float64{0, 1, 0}, 0.99); found {
+		t.Error("expected no match for an orthogonal embedding")
+	}
+	if stats := cache.Stats(ctx); stats.TotalHits != 0 || stats.TotalMisses != 0 {
+		t.Errorf("expected Peek not to count hits or misses, got %d and %d", stats.TotalHits, stats.TotalMisses)
+	}
+}
+
 func TestMemoryCacheUpdateExisting(t *testing.T) {
 	cache := NewMemoryCache(&Options{
 		MaxSize:         100,
@@ -366,3 +576,507 @@ func BenchmarkMemoryCacheGet(b *testing.B) {
 		cache.Get(ctx, queryEmb, 0.95)
 	}
 }
+
+func TestMemoryCachePartitions(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+	v1 := WithPartition(ctx, "kb-v1")
+	v2 := WithPartition(ctx, "kb-v2")
+
+	embedding := []float64{1, 0, 0}
+	entry := newTestEntry(embedding, time.Hour)
+	entry.Partition = PartitionFromContext(v1)
+	cache.Set(v1, entry)
+
+	if _, _, found := cache.Get(v1, embedding, 0.99); !found {
+		t.Error("expected hit in the same partition")
+	}
+	if _, _, found := cache.Get(v2, embedding, 0.99); found {
+		t.Error("expected miss in a different partition")
+	}
+	if _, _, found := cache.Get(ctx, embedding, 0.99); found {
+		t.Error("expected miss in the default partition")
+	}
+
+	// Identical embeddings in different partitions are stored separately
+	other := newTestEntry(embedding, time.Hour)
+	other.Partition = PartitionFromContext(v2)
+	cache.Set(v2, other)
+	if cache.Size(ctx) != 2 {
+		t.Errorf("expected size=2, got %d", cache.Size(ctx))
+	}
+}
+
+func TestMemoryCacheChurn(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         2,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+
+	hot := newTestEntry([]float64{1, 0, 0}, time.Hour)
+	hot.HitCount = 3
+	hot.LastHitAt = time.Now().Add(-time.Minute)
+	cache.Set(ctx, hot)
+	cache.Set(ctx, newTestEntry([]float64{0, 1, 0}, -time.Hour)) // expired, never hit
+
+	// Full: evicts hot (oldest LastHitAt), which had been used
+	cache.Set(ctx, newTestEntry([]float64{0, 0, 1}, time.Hour))
+
+	// Removes the expired entry, which was never used
+	cache.Cleanup(ctx)
+
+	stats := cache.Stats(ctx)
+	if stats.Evictions != 2 {
+		t.Errorf("expected Evictions=2, got %d", stats.Evictions)
+	}
+	if stats.EvictedUnused != 1 {
+		t.Errorf("expected EvictedUnused=1, got %d", stats.EvictedUnused)
+	}
+	if stats.ChurnRate != 0.5 {
+		t.Errorf("expected ChurnRate=0.5, got %f", stats.ChurnRate)
+	}
+}
+
+func TestMemoryCacheDiversityEviction(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         3,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		EvictionPolicy:  EvictionDiversity,
+	})
+	ctx := context.Background()
+	now := time.Now()
+
+	// unique is the least recently used, but near-duplicates cover the same space
+	unique := newTestEntry([]float64{0, 0, 1}, time.Hour)
+	unique.Response.ID = "unique"
+	unique.LastHitAt = now.Add(-3 * time.Minute)
+	dupA := newTestEntry([]float64{1, 0.05, 0}, time.Hour)
+	dupA.Response.ID = "dupA"
+	dupA.LastHitAt = now.Add(-2 * time.Minute)
+	dupB := newTestEntry([]float64{1, 0.1, 0}, time.Hour)
+	dupB.Response.ID = "dupB"
+	dupB.LastHitAt = now.Add(-time.Minute)
+
+	cache.Set(ctx, unique)
+	cache.Set(ctx, dupA)
+	cache.Set(ctx, dupB)
+	cache.Set(ctx, newTestEntry([]float64{0, 1, 0}, time.Hour))
+
+	if result, _, found := cache.Get(ctx, []float64{0, 0, 1}, 0.99); !found || result.Response.ID != "unique" {
+		t.Error("expected the unique entry to survive eviction")
+	}
+	if result, _, found := cache.Get(ctx, []float64{1, 0.05, 0}, 0.999); found && result.Response.ID == "dupA" {
+		t.Error("expected the older redundant entry to be evicted")
+	}
+	if cache.Size(ctx) != 3 {
+		t.Errorf("expected size=3, got %d", cache.Size(ctx))
+	}
+}
+
+func TestMemoryCacheRetainRawEmbeddings(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:             10,
+		DefaultTTL:          time.Hour,
+		CleanupInterval:     time.Hour,
+		RetainRawEmbeddings: true,
+	})
+	ctx := context.Background()
+
+	cache.Set(ctx, newTestEntry([]float64{3, 4, 0}, time.Hour))
+
+	result, similarity, found := cache.Get(ctx, []float64{6, 8, 0}, 0.99)
+	if !found {
+		t.Fatal("expected to find entry")
+	}
+	if math.Abs(similarity-1) > 1e-9 {
+		t.Errorf("expected similarity=1, got %f", similarity)
+	}
+	if result.Embedding[0] != 0.6 || result.Embedding[1] != 0.8 {
+		t.Errorf("expected normalized embedding, got %v", result.Embedding)
+	}
+	if result.RawEmbedding[0] != 3 || result.RawEmbedding[1] != 4 {
+		t.Errorf("expected raw embedding to be retained, got %v", result.RawEmbedding)
+	}
+}
+
+func TestMemoryCacheBatchSimilarity(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         2,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		BatchSimilarity: true,
+	})
+	ctx := context.Background()
+
+	a := newTestEntry([]float64{1, 0, 0}, time.Hour)
+	a.Response.ID = "a"
+	a.LastHitAt = time.Now().Add(-time.Minute)
+	b := newTestEntry([]float64{0, 1, 0}, time.Hour)
+	b.Response.ID = "b"
+	cache.Set(ctx, a)
+	cache.Set(ctx, b)
+
+	result, similarity, found := cache.Get(ctx, []float64{0, 2, 0}, 0.99)
+	if !found || result.Response.ID != "b" {
+		t.Fatal("expected to find b")
+	}
+	if math.Abs(similarity-1) > 1e-9 {
+		t.Errorf("expected similarity=1, got %f", similarity)
+	}
+
+	// Evicting a moves the last row; the matrix must stay aligned
+	c := newTestEntry([]float64{0, 0, 1}, time.Hour)
+	c.Response.ID = "c"
+	cache.Set(ctx, c)
+
+	if _, _, found := cache.Get(ctx, []float64{1, 0, 0}, 0.99); found {
+		t.Error("expected a to be evicted")
+	}
+	for _, tc := range []struct {
+		query []float64
+		id    string
+	}{
+		{[]float64{0, 1, 0}, "b"},
+		{[]float64{0, 0, 1}, "c"},
+	} {
+		result, _, found := cache.Get(ctx, tc.query, 0.99)
+		if !found || result.Response.ID != tc.id {
+			t.Errorf("expected to find %s", tc.id)
+		}
+	}
+
+	// A vector of another dimension, in another partition, falls back to
+	// per-entry comparison
+	cache.Delete(ctx, []float64{0, 0, 1})
+	other := newTestEntry([]float64{1, 1}, time.Hour)
+	other.Partition = "v2"
+	cache.Set(ctx, other)
+	if _, _, found := cache.Get(WithPartition(ctx, "v2"), []float64{1, 1}, 0.99); !found {
+		t.Error("expected mixed-dimension entry to be found")
+	}
+	if _, _, found := cache.Get(ctx, []float64{0, 1, 0}, 0.99); !found {
+		t.Error("expected b to still be found")
+	}
+}
+
+func TestMemoryCacheSimilarityMatchesCosine(t *testing.T) {
+	stored := []float64{0.3, -1.2, 2.5, 0.7, 4.1}
+	query := []float64{0.5, -1, 2.2, 1.1, 3.9}
+	want := CosineSimilarity(query, stored)
+
+	for name, opts := range map[string]*Options{
+		"linear": {},
+		"batch":  {BatchSimilarity: true},
+		"hnsw":   {IndexType: IndexHNSW},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.MaxSize = 10
+			opts.DefaultTTL = time.Hour
+			opts.CleanupInterval = time.Hour
+			cache := NewMemoryCache(opts)
+			ctx := context.Background()
+
+			entry := newTestEntry(stored, time.Hour)
+			cache.Set(ctx, entry)
+
+			_, similarity, found := cache.Get(ctx, query, 0.5)
+			if !found {
+				t.Fatal("expected to find entry")
+			}
+			if math.Abs(similarity-want) > 1e-12 {
+				t.Errorf("expected similarity %v, got %v", want, similarity)
+			}
+			if entry.Embedding[0] != 0.3 {
+				t.Errorf("expected the stored embedding to be left as given, got %v", entry.Embedding)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheEntryID(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         10,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+
+	first := newTestEntry([]float64{1, 0}, time.Hour)
+	cache.Set(ctx, first)
+	if first.ID == "" {
+		t.Fatal("expected Set to assign an ID")
+	}
+
+	// A new response stored over the entry is a new version
+	second := newTestEntry([]float64{1, 0}, time.Hour)
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	cache.Set(ctx, second)
+	if second.ID == first.ID {
+		t.Error("expected a replaced entry to get a new ID")
+	}
+
+	// Entries that already carry an ID, such as restored ones, keep it
+	restored := newTestEntry([]float64{0, 1}, time.Hour)
+	restored.ID = "restored-id"
+	cache.Set(ctx, restored)
+	if got, _, _ := cache.Get(ctx, []float64{0, 1}, 0.99); got == nil || got.ID != "restored-id" {
+		t.Errorf("expected the existing ID to be kept, got %+v", got)
+	}
+}
+
+func TestMemoryCacheMetric(t *testing.T) {
+	// Relative to the query, aligned is closest in angle, long has the
+	// largest dot product and near is closest in space
+	vectors := map[string][]float64{
+		"aligned": {2, 0.02},
+		"long":    {5, 3},
+		"near":    {0.9, -0.2},
+	}
+	query := []float64{1, 0}
+
+	tests := []struct {
+		metric    string
+		retainRaw bool
+		threshold float64
+		want      string
+		score     float64
+	}{
+		{metric: MetricCosine, threshold: 0.9, want: "aligned", score: 2 / math.Hypot(2, 0.02)},
+		{metric: MetricDot, threshold: 0, want: "long", score: 5},
+		{metric: MetricDot, retainRaw: true, threshold: 0, want: "long", score: 5},
+		{metric: MetricDot, threshold: 6},
+		{metric: MetricEuclidean, threshold: 0.5, want: "near", score: math.Hypot(0.1, 0.2)},
+		{metric: MetricEuclidean, retainRaw: true, threshold: 0.5, want: "near", score: math.Hypot(0.1, 0.2)},
+		{metric: MetricEuclidean, threshold: 0.1},
+	}
+
+	for _, tt := range tests {
+		name := tt.metric
+		if tt.retainRaw {
+			name += " raw retained"
+		}
+		t.Run(name, func(t *testing.T) {
+			cache := NewMemoryCache(&Options{
+				MaxSize:             10,
+				DefaultTTL:          time.Hour,
+				CleanupInterval:     time.Hour,
+				Metric:              tt.metric,
+				RetainRawEmbeddings: tt.retainRaw,
+			})
+			ctx := context.Background()
+			for name, vec := range vectors {
+				entry := newTestEntry(vec, time.Hour)
+				entry.Response.ID = name
+				cache.Set(ctx, entry)
+			}
+
+			entry, score, found := cache.Get(ctx, query, tt.threshold)
+			if tt.want == "" {
+				if found {
+					t.Errorf("expected a miss, got %s with score %v", entry.Response.ID, score)
+				}
+				return
+			}
+			if !found {
+				t.Fatal("expected a hit")
+			}
+			if entry.Response.ID != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, entry.Response.ID)
+			}
+			if math.Abs(score-tt.score) > 1e-9 {
+				t.Errorf("expected score %v, got %v", tt.score, score)
+			}
+		})
+	}
+
+	t.Run("euclidean offset", func(t *testing.T) {
+		cache := NewMemoryCache(&Options{
+			MaxSize:         10,
+			DefaultTTL:      time.Hour,
+			CleanupInterval: time.Hour,
+			Metric:          MetricEuclidean,
+		})
+		ctx := context.Background()
+		entry := newTestEntry(vectors["near"], time.Hour)
+		cache.Set(ctx, entry)
+
+		if _, _, found := cache.Get(ctx, query, 0.4); !found {
+			t.Fatal("expected a hit within distance 0.4")
+		}
+		// A positive offset shrinks the distance the entry accepts
+		entry.ThresholdOffset = 0.2
+		if _, distance, found := cache.Get(ctx, query, 0.4); found {
+			t.Errorf("expected a miss with the offset applied, got distance %v", distance)
+		}
+	})
+}
+
+func TestMemoryCacheRecencyHalfLife(t *testing.T) {
+	newCache := func(halfLife time.Duration) *MemoryCache {
+		return NewMemoryCache(&Options{
+			MaxSize:         10,
+			DefaultTTL:      time.Hour,
+			CleanupInterval: time.Hour,
+			RecencyHalfLife: halfLife,
+		})
+	}
+	ctx := context.Background()
+	query := []float64{1, 0}
+
+	// stale matches the query slightly better but is one half-life old.
+	// Each cache gets its own entries, since a cache updates the entries it
+	// holds in the background after a hit.
+	entries := func() (stale, fresh *api.CacheEntry) {
+		stale = newTestEntry([]float64{1, 0.1}, 48*time.Hour)
+		stale.Response.ID = "stale"
+		stale.CreatedAt = time.Now().Add(-24 * time.Hour)
+		fresh = newTestEntry([]float64{1, 0.3}, 48*time.Hour)
+		fresh.Response.ID = "fresh"
+		return stale, fresh
+	}
+
+	c := newCache(0)
+	stale, fresh := entries()
+	c.Set(ctx, stale)
+	c.Set(ctx, fresh)
+	if entry, _, _ := c.Get(ctx, query, 0.4); entry == nil || entry.Response.ID != "stale" {
+		t.Errorf("expected the closest entry without decay, got %+v", entry)
+	}
+
+	c = newCache(24 * time.Hour)
+	stale, fresh = entries()
+	c.Set(ctx, stale)
+	c.Set(ctx, fresh)
+	entry, similarity, found := c.Get(ctx, query, 0.4)
+	if !found || entry.Response.ID != "fresh" {
+		t.Fatalf("expected the fresh entry to win, got %+v", entry)
+	}
+	if want := CosineSimilarity(query, fresh.Embedding); math.Abs(similarity-want) > 1e-6 {
+		t.Errorf("expected the fresh entry's similarity %v, got %v", want, similarity)
+	}
+
+	// The stale entry alone scores about 0.5, below a 0.6 threshold
+	c = newCache(24 * time.Hour)
+	stale, _ = entries()
+	c.Set(ctx, stale)
+	if entry, similarity, found := c.Get(ctx, query, 0.6); found {
+		t.Errorf("expected the decayed entry to miss, got %s at %v", entry.Response.ID, similarity)
+	}
+	if _, similarity, found := c.Get(ctx, query, 0.4); !found || math.Abs(similarity-0.5) > 0.01 {
+		t.Errorf("expected a decayed similarity near 0.5, got %v (found %v)", similarity, found)
+	}
+}
+
+func TestMemoryCacheSampleNorms(t *testing.T) {
+	c := NewMemoryCache(&Options{
+		MaxSize:         10,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		Metric:          MetricDot,
+	})
+	ctx := context.Background()
+
+	c.Set(ctx, newTestEntry([]float64{3, 4}, time.Hour))
+	c.Set(ctx, newTestEntry([]float64{0, 1}, time.Hour))
+
+	norms := c.SampleNorms(10)
+	if len(norms) != 2 || norms[0] != 5 || norms[1] != 1 {
+		t.Errorf("expected norms [5 1], got %v", norms)
+	}
+	if got := c.SampleNorms(1); len(got) != 1 {
+		t.Errorf("expected the sample to be capped at 1, got %d", len(got))
+	}
+}
+
+func TestMemoryCacheGhosts(t *testing.T) {
+	newCache := func(ghostSize int, ghostTTL time.Duration) *MemoryCache {
+		return NewMemoryCache(&Options{
+			MaxSize:         2,
+			DefaultTTL:      time.Hour,
+			CleanupInterval: time.Hour,
+			GhostSize:       ghostSize,
+			GhostTTL:        ghostTTL,
+		})
+	}
+	hot := []float64{1, 0, 0}
+	// spike evicts the hot entry, then n more, with distinct LastHitAt
+	spike := func(c *MemoryCache, n int) {
+		ctx := context.Background()
+		entry := newTestEntry(hot, time.Hour)
+		entry.Response.ID = "hot"
+		entry.LastHitAt = time.Now().Add(-time.Hour)
+		c.Set(ctx, entry)
+		burst := [][]float64{{0, 1, 0}, {0, 0, 1}, {0, 1, 1}, {1, 0, 1}}
+		for i := 0; i < 2+n; i++ {
+			e := newTestEntry(burst[i], time.Hour)
+			e.LastHitAt = time.Now().Add(time.Duration(i-len(burst)) * time.Minute)
+			c.Set(ctx, e)
+		}
+	}
+
+	t.Run("resurrects a just-evicted entry", func(t *testing.T) {
+		c := newCache(2, time.Minute)
+		ctx := context.Background()
+		spike(c, 0)
+
+		entry, _, found := c.Get(ctx, hot, 0.99)
+		if !found || entry.Response.ID != "hot" {
+			t.Fatalf("expected the evicted entry to be resurrected, got found=%v", found)
+		}
+		if c.Size(ctx) != 2 {
+			t.Errorf("expected size=2 after resurrection, got %d", c.Size(ctx))
+		}
+		stats := c.Stats(ctx)
+		if stats.TotalHits != 1 || stats.TotalMisses != 0 || stats.Resurrections != 1 {
+			t.Errorf("expected 1 hit, 0 misses and 1 resurrection, got %+v", stats)
+		}
+
+		// Back in the cache, the next lookup is an ordinary hit
+		if _, _, found := c.Get(ctx, hot, 0.99); !found {
+			t.Error("expected the resurrected entry to stay cached")
+		}
+		if got := c.Stats(ctx).Resurrections; got != 1 {
+			t.Errorf("expected 1 resurrection, got %d", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c := newCache(0, time.Minute)
+		spike(c, 0)
+		if _, _, found := c.Get(context.Background(), hot, 0.99); found {
+			t.Error("expected a miss without ghosts")
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		c := newCache(1, time.Minute)
+		spike(c, 1)
+		if _, _, found := c.Get(context.Background(), hot, 0.99); found {
+			t.Error("expected the oldest ghost to be dropped")
+		}
+	})
+
+	t.Run("window elapsed", func(t *testing.T) {
+		c := newCache(2, time.Millisecond)
+		spike(c, 0)
+		time.Sleep(5 * time.Millisecond)
+		if _, _, found := c.Get(context.Background(), hot, 0.99); found {
+			t.Error("expected the ghost to expire")
+		}
+	})
+
+	t.Run("other partition", func(t *testing.T) {
+		c := newCache(2, time.Minute)
+		spike(c, 0)
+		if _, _, found := c.Get(WithPartition(context.Background(), "other"), hot, 0.99); found {
+			t.Error("expected ghosts to stay in their partition")
+		}
+	})
+}
@@ -0,0 +1,368 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// postgresTablePattern restricts table names to plain identifiers, which
+// are interpolated into statements unquoted.
+var postgresTablePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// postgresSchema creates the pgvector extension and the cache table. The
+// embedding column has no fixed dimension, so it takes any embedding model;
+// fix it with ALTER TABLE to add an approximate index. {table} is replaced
+// by the table name.
+const postgresSchema = `
+CREATE EXTENSION IF NOT EXISTS vector;
+CREATE TABLE IF NOT EXISTS {table} (
+	id          TEXT PRIMARY KEY,
+	partition   TEXT NOT NULL,
+	model       TEXT NOT NULL,
+	embedding   vector NOT NULL,
+	response    JSONB NOT NULL,
+	entry       JSONB NOT NULL,
+	hits        BIGINT NOT NULL DEFAULT 0,
+	created_at  TIMESTAMPTZ NOT NULL,
+	expires_at  TIMESTAMPTZ NOT NULL,
+	last_hit_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS {table}_partition_idx ON {table} (partition);
+CREATE INDEX IF NOT EXISTS {table}_model_idx ON {table} (model);
+CREATE INDEX IF NOT EXISTS {table}_expires_at_idx ON {table} (expires_at);
+CREATE INDEX IF NOT EXISTS {table}_last_hit_at_idx ON {table} (last_hit_at);
+`
+
+// Statements run by PostgresCache. Rows read as entries select
+// postgresEntryColumns.
+const (
+	postgresEntryColumns = `id, entry, response, hits, embedding::text`
+
+	postgresNearest = `SELECT ` + postgresEntryColumns + `, embedding <=> $1 AS distance
+FROM {table} WHERE partition = $2 AND expires_at > $3
+ORDER BY embedding <=> $1 LIMIT 1`
+	postgresRecordHit = `UPDATE {table} SET hits = hits + 1, last_hit_at = $2 WHERE id = $1`
+	postgresUpsert    = `INSERT INTO {table}
+(id, partition, model, embedding, response, entry, hits, created_at, expires_at, last_hit_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (id) DO UPDATE SET
+partition = EXCLUDED.partition, model = EXCLUDED.model, embedding = EXCLUDED.embedding,
+response = EXCLUDED.response, entry = EXCLUDED.entry, hits = EXCLUDED.hits,
+created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, last_hit_at = EXCLUDED.last_hit_at`
+	postgresCount       = `SELECT count(*) FROM {table}`
+	postgresCountLive   = `SELECT count(*) FROM {table} WHERE expires_at > $1`
+	postgresEvictLRU    = `DELETE FROM {table} WHERE id = (SELECT id FROM {table} ORDER BY last_hit_at LIMIT 1) RETURNING hits`
+	postgresDeleteID    = `DELETE FROM {table} WHERE id = $1`
+	postgresDeleteIDs   = `DELETE FROM {table} WHERE id = ANY($1::text[])`
+	postgresDeleteModel = `DELETE FROM {table} WHERE model = $1`
+	postgresExpire      = `DELETE FROM {table} WHERE expires_at <= $1 RETURNING hits`
+	postgresTruncate    = `TRUNCATE {table}`
+	postgresPrompts     = `SELECT id, entry->'request' FROM {table}`
+	postgresCountModel  = `SELECT count(*) FROM {table} WHERE $1 = '' OR model = $1`
+	postgresListModel   = `SELECT ` + postgresEntryColumns + ` FROM {table} WHERE $1 = '' OR model = $1
+ORDER BY created_at, id OFFSET $2 LIMIT $3`
+)
+
+// PostgresCache implements a semantic cache stored in a Postgres table with
+// the pgvector extension, so entries are durable and can be queried with
+// SQL. Get orders the request's partition by cosine distance and applies
+// the threshold to the nearest row; the response is stored as JSONB.
+//
+// pgvector stores single-precision vectors, so embeddings read back are
+// rounded to float32. Hit and miss counters are kept per replica. As with
+// RedisCache, MaxSize is enforced approximately across replicas.
+type PostgresCache struct {
+	cleanupReporter
+	client *pgClient
+	opts   *Options
+	table  string
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	evictedUnused atomic.Int64
+}
+
+// NewPostgresCache creates a cache stored in the table opts.PostgresTable of
+// the database at opts.PostgresDSN, creating the pgvector extension and the
+// table if they do not exist.
+func NewPostgresCache(opts *Options) (*PostgresCache, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if opts.PostgresDSN == "" {
+		return nil, errors.New("postgres DSN is required")
+	}
+	if !postgresTablePattern.MatchString(opts.PostgresTable) {
+		return nil, fmt.Errorf("invalid postgres table name %q", opts.PostgresTable)
+	}
+
+	client, err := newPgClient(opts.PostgresDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &PostgresCache{client: client, opts: opts, table: opts.PostgresTable}
+	if err := client.Exec(context.Background(), pc.sql(postgresSchema)); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("postgres schema migration: %w", err)
+	}
+
+	// Start cleanup goroutine
+	go pc.cleanupLoop()
+
+	return pc, nil
+}
+
+// Close releases the cache's connections.
+func (p *PostgresCache) Close() error {
+	return p.client.Close()
+}
+
+// sql substitutes the table name into stmt.
+func (p *PostgresCache) sql(stmt string) string {
+	return strings.ReplaceAll(stmt, "{table}", p.table)
+}
+
+// Get retrieves a cached response based on semantic similarity. Postgres
+// errors are treated as misses.
+func (p *PostgresCache) Get(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	id, entry, similarity, err := p.nearest(ctx, PartitionFromContext(ctx), embedding)
+	if err == nil && entry != nil && similarity >= threshold {
+		if _, err := p.client.Query(ctx, p.sql(postgresRecordHit), id, time.Now()); err == nil {
+			p.hits.Add(1)
+			return entry, similarity, true
+		}
+	}
+
+	p.misses.Add(1)
+	return nil, 0, false
+}
+
+// Peek finds the best match for embedding like Get, but counts no hit or
+// miss and records no hit on the row.
+func (p *PostgresCache) Peek(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	_, entry, similarity, err := p.nearest(ctx, PartitionFromContext(ctx), embedding)
+	if err != nil || entry == nil || similarity < threshold {
+		return nil, 0, false
+	}
+	return entry, similarity, true
+}
+
+// nearest returns the row id, entry and cosine similarity of the closest
+// unexpired entry in partition, or a nil entry if the partition is empty.
+func (p *PostgresCache) nearest(ctx context.Context, partition string, embedding []float64) (string, *api.CacheEntry, float64, error) {
+	result, err := p.client.Query(ctx, p.sql(postgresNearest), embedding, partition, time.Now())
+	if err != nil || len(result.rows) == 0 {
+		return "", nil, 0, err
+	}
+	row := result.rows[0]
+	entry, err := decodePostgresEntry(row)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	distance, err := strconv.ParseFloat(string(row[5]), 64)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return string(row[0]), entry, 1 - distance, nil
+}
+
+// Set stores a response with its embedding. An existing entry in the same
+// partition with a near-identical embedding is overwritten.
+func (p *PostgresCache) Set(ctx context.Context, entry *api.CacheEntry) error {
+	if len(entry.Embedding) == 0 {
+		return errors.New("entry has no embedding")
+	}
+	if p.opts.RetainRawEmbeddings && entry.RawEmbedding == nil {
+		entry.RawEmbedding = entry.Embedding
+		entry.Embedding = NormalizeVector(entry.Embedding)
+	}
+	assignID(entry)
+	if p.opts.CompressResponses {
+		compressResponse(entry)
+	}
+
+	id, existing, similarity, err := p.nearest(ctx, entry.Partition, entry.Embedding)
+	if err != nil {
+		return err
+	}
+	if existing == nil || similarity < 0.99 {
+		id = entry.ID
+		if err := p.evictIfFull(ctx); err != nil {
+			return err
+		}
+	}
+
+	response, err := json.Marshal(entry.Response)
+	if err != nil {
+		return err
+	}
+	stored := *entry
+	stored.Embedding = nil
+	stored.Response = api.ChatCompletionResponse{}
+	body, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	lastHit := entry.LastHitAt
+	if lastHit.IsZero() {
+		lastHit = entry.CreatedAt
+	}
+	_, err = p.client.Query(ctx, p.sql(postgresUpsert),
+		id, entry.Partition, entry.Request.Model, entry.Embedding, response, body,
+		entry.HitCount, entry.CreatedAt, entry.ExpiresAt, lastHit)
+	return err
+}
+
+// evictIfFull removes the least recently used entry when the cache is at
+// capacity.
+func (p *PostgresCache) evictIfFull(ctx context.Context) error {
+	size, err := p.count(ctx, postgresCount)
+	if err != nil || size < p.opts.MaxSize {
+		return err
+	}
+	result, err := p.client.Query(ctx, p.sql(postgresEvictLRU))
+	if err != nil {
+		return err
+	}
+	p.countEvictions(result)
+	return nil
+}
+
+// countEvictions adds the rows of a DELETE ... RETURNING hits to the
+// eviction counters.
+func (p *PostgresCache) countEvictions(result *pgResult) {
+	for _, row := range result.rows {
+		p.evictions.Add(1)
+		if string(row[0]) == "0" {
+			p.evictedUnused.Add(1)
+		}
+	}
+}
+
+// count runs a count(*) statement.
+func (p *PostgresCache) count(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	result, err := p.client.Query(ctx, p.sql(stmt), args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(result.rows) == 0 {
+		return 0, errors.New("postgres: count returned no rows")
+	}
+	return strconv.Atoi(string(result.rows[0][0]))
+}
+
+// Delete removes an entry by its embedding.
+func (p *PostgresCache) Delete(ctx context.Context, embedding []float64) error {
+	id, entry, similarity, err := p.nearest(ctx, PartitionFromContext(ctx), embedding)
+	if err != nil || entry == nil || similarity < 0.99 {
+		return err
+	}
+	_, err = p.client.Query(ctx, p.sql(postgresDeleteID), id)
+	return err
+}
+
+// Clear removes all entries from the cache and resets this replica's
+// counters.
+func (p *PostgresCache) Clear(ctx context.Context) error {
+	if err := p.client.Exec(ctx, p.sql(postgresTruncate)); err != nil {
+		return err
+	}
+	p.hits.Store(0)
+	p.misses.Store(0)
+	p.evictions.Store(0)
+	p.evictedUnused.Store(0)
+	return nil
+}
+
+// Stats returns cache statistics. Entry counts cover the whole table; hits,
+// misses and evictions are this replica's.
+func (p *PostgresCache) Stats(ctx context.Context) *api.CacheStats {
+	hits := p.hits.Load()
+	misses := p.misses.Load()
+	evictions := p.evictions.Load()
+	evictedUnused := p.evictedUnused.Load()
+	total := hits + misses
+
+	var hitRate float64
+	if total > 0 {
+		hitRate = float64(hits) / float64(total)
+	}
+
+	var churnRate float64
+	if evictions > 0 {
+		churnRate = float64(evictedUnused) / float64(evictions)
+	}
+
+	return &api.CacheStats{
+		TotalEntries:   int64(p.Size(ctx)),
+		TotalHits:      hits,
+		TotalMisses:    misses,
+		HitRate:        hitRate,
+		EstimatedSaved: float64(hits) * 0.001,
+		Evictions:      evictions,
+		EvictedUnused:  evictedUnused,
+		ChurnRate:      churnRate,
+	}
+}
+
+// Cleanup removes expired entries.
+func (p *PostgresCache) Cleanup(ctx context.Context) int {
+	result, err := p.client.Query(ctx, p.sql(postgresExpire), time.Now())
+	if err != nil {
+		return 0
+	}
+	p.countEvictions(result)
+	return len(result.rows)
+}
+
+// Size returns the number of unexpired entries in the cache.
+func (p *PostgresCache) Size(ctx context.Context) int {
+	n, err := p.count(ctx, postgresCountLive, time.Now())
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Ping runs an empty query on the server.
+func (p *PostgresCache) Ping(ctx context.Context) error {
+	return p.client.Exec(ctx, "SELECT 1")
+}
+
+// cleanupLoop periodically removes expired entries.
+func (p *PostgresCache) cleanupLoop() {
+	runCleanups(p.opts.CleanupInterval, p.Cleanup, &p.cleanupReporter)
+}
+
+// decodePostgresEntry rebuilds an entry from a row of postgresEntryColumns.
+func decodePostgresEntry(row [][]byte) (*api.CacheEntry, error) {
+	if len(row) < 5 {
+		return nil, errors.New("postgres: short entry row")
+	}
+	var entry api.CacheEntry
+	if err := json.Unmarshal(row[1], &entry); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(row[2], &entry.Response); err != nil {
+		return nil, err
+	}
+	entry.HitCount, _ = strconv.ParseInt(string(row[3]), 10, 64)
+	embedding, err := parsePgVector(string(row[4]))
+	if err != nil {
+		return nil, err
+	}
+	entry.Embedding = embedding
+	return &entry, nil
+}
@@ -0,0 +1,577 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// fakePostgres is an in-process server speaking enough of the Postgres
+// protocol to run the statements PostgresCache sends against one table.
+type fakePostgres struct {
+	mu       sync.Mutex
+	table    string
+	password string // MD5 authentication when set
+	migrated bool
+	rows     map[string]*fakePgRow
+	ln       net.Listener
+}
+
+type fakePgRow struct {
+	id, partition, model string
+	embedding            []float64
+	response, entry      []byte
+	hits                 int64
+	createdAt, expiresAt time.Time
+	lastHitAt            time.Time
+}
+
+func newFakePostgres(t *testing.T, table string) *fakePostgres {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePostgres{table: table, rows: make(map[string]*fakePgRow), ln: ln}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakePostgres) DSN() string {
+	return "postgres://mimir:secret@" + f.ln.Addr().String() + "/mimir?sslmode=prefer"
+}
+
+func (f *fakePostgres) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakePostgres) handle(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	send := func(kind byte, body []byte) {
+		w.WriteByte(kind)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)))
+		w.Write(body)
+	}
+
+	// Startup, declining SSL
+	var startup []byte
+	for {
+		var n [4]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return
+		}
+		startup = make([]byte, binary.BigEndian.Uint32(n[:])-4)
+		if _, err := io.ReadFull(r, startup); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(startup) != 80877103 {
+			break
+		}
+		nc.Write([]byte{'N'})
+	}
+	params := strings.Split(string(startup[4:]), "\x00")
+	user := params[1]
+
+	if f.password != "" {
+		salt := []byte{1, 2, 3, 4}
+		send('R', append(binary.BigEndian.AppendUint32(nil, 5), salt...))
+		w.Flush()
+		kind, msg, err := readFakePgMessage(r)
+		if err != nil || kind != 'p' || strings.TrimRight(string(msg), "\x00") != md5Password(user, f.password, salt) {
+			send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			w.Flush()
+			return
+		}
+	}
+	send('R', binary.BigEndian.AppendUint32(nil, 0))
+	send('Z', []byte{'I'})
+	w.Flush()
+
+	var sql string
+	var args [][]byte
+	for {
+		kind, msg, err := readFakePgMessage(r)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case 'Q':
+			tag := f.simple(strings.TrimRight(string(msg), "\x00"))
+			send('C', append([]byte(tag), 0))
+			send('Z', []byte{'I'})
+			w.Flush()
+		case 'P':
+			sql = string(msg[1 : len(msg)-3])
+		case 'B':
+			args = parseFakeBind(msg)
+		case 'S':
+			send('1', nil)
+			send('2', nil)
+			rows, tag, err := f.exec(sql, args)
+			if err != nil {
+				send('E', []byte("SERROR\x00C42601\x00M"+err.Error()+"\x00\x00"))
+			} else {
+				for _, row := range rows {
+					body := binary.BigEndian.AppendUint16(nil, uint16(len(row)))
+					for _, col := range row {
+						if col == nil {
+							body = binary.BigEndian.AppendUint32(body, 0xFFFFFFFF)
+							continue
+						}
+						body = binary.BigEndian.AppendUint32(body, uint32(len(col)))
+						body = append(body, col...)
+					}
+					send('D', body)
+				}
+				send('C', append([]byte(tag), 0))
+			}
+			send('Z', []byte{'I'})
+			w.Flush()
+		case 'X':
+			return
+		}
+	}
+}
+
+func readFakePgMessage(r *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(r, body)
+	return header[0], body, err
+}
+
+// parseFakeBind returns the parameters of a Bind message for the unnamed
+// portal and statement with no parameter format codes.
+func parseFakeBind(msg []byte) [][]byte {
+	msg = msg[4:] // portal, statement, format code count
+	n := int(binary.BigEndian.Uint16(msg))
+	msg = msg[2:]
+	args := make([][]byte, n)
+	for i := range args {
+		size := int32(binary.BigEndian.Uint32(msg))
+		msg = msg[4:]
+		if size >= 0 {
+			args[i] = msg[:size]
+			msg = msg[size:]
+		}
+	}
+	return args
+}
+
+func (f *fakePostgres) stmt(s string) string {
+	return strings.ReplaceAll(s, "{table}", f.table)
+}
+
+func (f *fakePostgres) simple(sql string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch sql {
+	case f.stmt(postgresSchema):
+		f.migrated = true
+		return "CREATE INDEX"
+	case f.stmt(postgresTruncate):
+		f.rows = make(map[string]*fakePgRow)
+		return "TRUNCATE TABLE"
+	}
+	return "SELECT 1"
+}
+
+func fakePgTime(b []byte) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, string(b))
+	return t
+}
+
+func (f *fakePostgres) entryRow(row *fakePgRow) [][]byte {
+	return [][]byte{[]byte(row.id), row.entry, row.response, []byte(strconv.FormatInt(row.hits, 10)), []byte(formatPgVector(row.embedding))}
+}
+
+// sorted returns the rows ordered by created_at and id.
+func (f *fakePostgres) sorted() []*fakePgRow {
+	var rows []*fakePgRow
+	for _, row := range f.rows {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].createdAt.Equal(rows[j].createdAt) {
+			return rows[i].createdAt.Before(rows[j].createdAt)
+		}
+		return rows[i].id < rows[j].id
+	})
+	return rows
+}
+
+func (f *fakePostgres) exec(sql string, args [][]byte) ([][][]byte, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.migrated {
+		return nil, "", fmt.Errorf("relation %q does not exist", f.table)
+	}
+
+	count := func(match func(*fakePgRow) bool) ([][][]byte, string, error) {
+		n := 0
+		for _, row := range f.rows {
+			if match(row) {
+				n++
+			}
+		}
+		return [][][]byte{{[]byte(strconv.Itoa(n))}}, "SELECT 1", nil
+	}
+	deleteWhere := func(match func(*fakePgRow) bool) [][][]byte {
+		var returned [][][]byte
+		for id, row := range f.rows {
+			if match(row) {
+				returned = append(returned, [][]byte{[]byte(strconv.FormatInt(row.hits, 10))})
+				delete(f.rows, id)
+			}
+		}
+		return returned
+	}
+
+	switch sql {
+	case f.stmt(postgresNearest):
+		query, _ := parsePgVector(string(args[0]))
+		now := fakePgTime(args[2])
+		var best *fakePgRow
+		bestDistance := math.Inf(1)
+		for _, row := range f.sorted() {
+			if row.partition != string(args[1]) || !row.expiresAt.After(now) {
+				continue
+			}
+			if d := 1 - CosineSimilarity(query, row.embedding); d < bestDistance {
+				best, bestDistance = row, d
+			}
+		}
+		if best == nil {
+			return nil, "SELECT 0", nil
+		}
+		row := append(f.entryRow(best), []byte(strconv.FormatFloat(bestDistance, 'g', -1, 64)))
+		return [][][]byte{row}, "SELECT 1", nil
+	case f.stmt(postgresRecordHit):
+		if row, ok := f.rows[string(args[0])]; ok {
+			row.hits++
+			row.lastHitAt = fakePgTime(args[1])
+			return nil, "UPDATE 1", nil
+		}
+		return nil, "UPDATE 0", nil
+	case f.stmt(postgresUpsert):
+		embedding, err := parsePgVector(string(args[3]))
+		if err != nil {
+			return nil, "", err
+		}
+		// pgvector stores single precision
+		for i, x := range embedding {
+			embedding[i] = float64(float32(x))
+		}
+		hits, _ := strconv.ParseInt(string(args[6]), 10, 64)
+		f.rows[string(args[0])] = &fakePgRow{
+			id: string(args[0]), partition: string(args[1]), model: string(args[2]),
+			embedding: embedding, response: append([]byte(nil), args[4]...), entry: append([]byte(nil), args[5]...),
+			hits: hits, createdAt: fakePgTime(args[7]), expiresAt: fakePgTime(args[8]), lastHitAt: fakePgTime(args[9]),
+		}
+		return nil, "INSERT 0 1", nil
+	case f.stmt(postgresCount):
+		return count(func(*fakePgRow) bool { return true })
+	case f.stmt(postgresCountLive):
+		now := fakePgTime(args[0])
+		return count(func(row *fakePgRow) bool { return row.expiresAt.After(now) })
+	case f.stmt(postgresEvictLRU):
+		var oldest *fakePgRow
+		for _, row := range f.rows {
+			if oldest == nil || row.lastHitAt.Before(oldest.lastHitAt) {
+				oldest = row
+			}
+		}
+		returned := deleteWhere(func(row *fakePgRow) bool { return row == oldest })
+		return returned, "DELETE " + strconv.Itoa(len(returned)), nil
+	case f.stmt(postgresDeleteID):
+		returned := deleteWhere(func(row *fakePgRow) bool { return row.id == string(args[0]) })
+		return nil, "DELETE " + strconv.Itoa(len(returned)), nil
+	case f.stmt(postgresDeleteIDs):
+		ids := map[string]bool{}
+		for _, id := range strings.Split(strings.Trim(string(args[0]), "{}"), ",") {
+			ids[strings.Trim(id, `"`)] = true
+		}
+		returned := deleteWhere(func(row *fakePgRow) bool { return ids[row.id] })
+		return nil, "DELETE " + strconv.Itoa(len(returned)), nil
+	case f.stmt(postgresDeleteModel):
+		returned := deleteWhere(func(row *fakePgRow) bool { return row.model == string(args[0]) })
+		return nil, "DELETE " + strconv.Itoa(len(returned)), nil
+	case f.stmt(postgresExpire):
+		now := fakePgTime(args[0])
+		returned := deleteWhere(func(row *fakePgRow) bool { return !row.expiresAt.After(now) })
+		return returned, "DELETE " + strconv.Itoa(len(returned)), nil
+	case f.stmt(postgresPrompts):
+		var rows [][][]byte
+		for _, row := range f.sorted() {
+			var entry map[string]json.RawMessage
+			json.Unmarshal(row.entry, &entry)
+			rows = append(rows, [][]byte{[]byte(row.id), entry["request"]})
+		}
+		return rows, "SELECT " + strconv.Itoa(len(rows)), nil
+	case f.stmt(postgresCountModel):
+		model := string(args[0])
+		return count(func(row *fakePgRow) bool { return model == "" || row.model == model })
+	case f.stmt(postgresListModel):
+		model := string(args[0])
+		offset, _ := strconv.Atoi(string(args[1]))
+		var rows [][][]byte
+		for _, row := range f.sorted() {
+			if model == "" || row.model == model {
+				rows = append(rows, f.entryRow(row))
+			}
+		}
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		rows = rows[offset:]
+		if args[2] != nil {
+			if limit, _ := strconv.Atoi(string(args[2])); limit < len(rows) {
+				rows = rows[:limit]
+			}
+		}
+		return rows, "SELECT " + strconv.Itoa(len(rows)), nil
+	}
+	return nil, "", fmt.Errorf("unexpected statement %q", sql)
+}
+
+func newTestPostgresCache(t *testing.T, maxSize int) *PostgresCache {
+	server := newFakePostgres(t, "mimir_cache")
+	pc, err := NewPostgresCache(&Options{
+		MaxSize:         maxSize,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		PostgresDSN:     server.DSN(),
+		PostgresTable:   "mimir_cache",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func TestPostgresCacheGetSet(t *testing.T) {
+	pc := newTestPostgresCache(t, 100)
+	ctx := context.Background()
+
+	if err := pc.Set(ctx, redisTestEntry("Paris", []float64{1, 0, 0}, time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, similarity, found := pc.Get(ctx, []float64{0.99, 0.1, 0}, 0.9)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	if entry.Response.Choices[0].Message.Content != "Paris" || similarity < 0.9 {
+		t.Errorf("unexpected hit: %+v (similarity %f)", entry.Response, similarity)
+	}
+	if len(entry.Embedding) != 3 || entry.Embedding[0] != 1 {
+		t.Errorf("expected the embedding to round-trip, got %v", entry.Embedding)
+	}
+	if entry.Request.Model != "gpt-4" {
+		t.Errorf("expected the request to round-trip, got %+v", entry.Request)
+	}
+
+	// The threshold is applied to the nearest row
+	if _, _, found := pc.Get(ctx, []float64{0, 1, 0}, 0.9); found {
+		t.Error("expected a miss for an orthogonal vector")
+	}
+
+	// Other partitions never match
+	if _, _, found := pc.Get(WithPartition(ctx, "v2"), []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected a miss in another partition")
+	}
+
+	// Near-duplicates overwrite instead of adding
+	pc.Set(ctx, redisTestEntry("Paris, France", []float64{1, 0.0001, 0}, time.Hour))
+	if size := pc.Size(ctx); size != 1 {
+		t.Errorf("expected 1 entry, got %d", size)
+	}
+
+	// Expired rows are never served
+	pc.Set(ctx, redisTestEntry("stale", []float64{0, 0, 1}, -time.Second))
+	if _, _, found := pc.Get(ctx, []float64{0, 0, 1}, 0.9); found {
+		t.Error("expected a miss for an expired entry")
+	}
+
+	stats := pc.Stats(ctx)
+	if stats.TotalHits != 1 || stats.TotalMisses != 3 || stats.TotalEntries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPostgresCacheEvictionAndCleanup(t *testing.T) {
+	pc := newTestPostgresCache(t, 2)
+	ctx := context.Background()
+
+	old := redisTestEntry("old", []float64{1, 0, 0}, time.Hour)
+	old.LastHitAt = time.Now().Add(-time.Hour)
+	pc.Set(ctx, old)
+	pc.Set(ctx, redisTestEntry("new", []float64{0, 1, 0}, time.Hour))
+	pc.Set(ctx, redisTestEntry("newest", []float64{0, 0, 1}, time.Hour))
+
+	if size := pc.Size(ctx); size != 2 {
+		t.Fatalf("expected 2 entries, got %d", size)
+	}
+	if _, _, found := pc.Get(ctx, []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+
+	pc.Delete(ctx, []float64{0, 1, 0})
+	pc.Set(ctx, redisTestEntry("expired", []float64{0, 1, 1}, -time.Second))
+	if removed := pc.Cleanup(ctx); removed != 1 {
+		t.Errorf("expected 1 expired entry removed, got %d", removed)
+	}
+	stats := pc.Stats(ctx)
+	if stats.Evictions != 2 || stats.EvictedUnused != 2 {
+		t.Errorf("unexpected eviction stats: %+v", stats)
+	}
+
+	if err := pc.Clear(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := pc.Size(ctx); size != 0 {
+		t.Errorf("expected empty cache after Clear, got %d", size)
+	}
+}
+
+func TestPostgresCacheListAndInvalidate(t *testing.T) {
+	pc := newTestPostgresCache(t, 100)
+	ctx := context.Background()
+
+	for i := 0; i < 6; i++ {
+		embedding := make([]float64, 6)
+		embedding[i] = 1
+		entry := redisTestEntry(fmt.Sprintf("answer %d", i), embedding, time.Hour)
+		entry.Request.Messages = []api.Message{{Role: "user", Content: fmt.Sprintf("question %d", i)}}
+		entry.CreatedAt = entry.CreatedAt.Add(time.Duration(i) * time.Millisecond)
+		if i%2 == 0 {
+			entry.Request.Model = "gpt-3.5-turbo"
+		}
+		pc.Set(ctx, entry)
+	}
+
+	page, total, err := pc.List(ctx, "gpt-4", 1, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total != 3 || len(page) != 2 || page[0].Response.Choices[0].Message.Content != "answer 3" {
+		t.Errorf("expected the second page of 3 gpt-4 entries, got %d of %d", len(page), total)
+	}
+	if all, total, _ := pc.List(ctx, "", 0, 0); total != 6 || len(all) != 6 {
+		t.Errorf("expected all 6 entries listed, got %d of %d", len(all), total)
+	}
+
+	if removed, err := pc.DeleteByModel(ctx, "gpt-3.5-turbo"); err != nil || removed != 3 {
+		t.Errorf("expected 3 entries removed, got %d, %v", removed, err)
+	}
+	if removed, err := pc.DeleteByPrompt(ctx, "question 3"); err != nil || removed != 1 {
+		t.Errorf("expected 1 entry removed, got %d, %v", removed, err)
+	}
+	if size := pc.Size(ctx); size != 2 {
+		t.Errorf("expected 2 entries left, got %d", size)
+	}
+}
+
+func TestPostgresCacheAuthentication(t *testing.T) {
+	server := newFakePostgres(t, "mimir_cache")
+	server.password = "secret"
+	opts := &Options{
+		MaxSize:         10,
+		CleanupInterval: time.Hour,
+		PostgresDSN:     server.DSN(),
+		PostgresTable:   "mimir_cache",
+	}
+	pc, err := NewPostgresCache(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pc.Close()
+	if !server.migrated {
+		t.Error("expected the schema to be migrated on startup")
+	}
+	if err := pc.Ping(context.Background()); err != nil {
+		t.Errorf("expected ping to succeed, got %v", err)
+	}
+
+	opts.PostgresDSN = strings.Replace(server.DSN(), "secret", "wrong", 1)
+	if _, err := NewPostgresCache(opts); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestNewPgClientDSN(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		addr     string
+		database string
+		sslmode  string
+		wantErr  bool
+	}{
+		{dsn: "postgres://mimir@db", addr: "db:5432", database: "mimir", sslmode: "prefer"},
+		{dsn: "postgresql://mimir:pw@db:6543/cache?sslmode=verify-full", addr: "db:6543", database: "cache", sslmode: "verify-full"},
+		{dsn: "postgres://db/cache", wantErr: true},
+		{dsn: "postgres://mimir@db/cache?sslmode=allow", wantErr: true},
+		{dsn: "mysql://mimir@db/cache", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := newPgClient(tt.dsn)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.dsn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.dsn, err)
+		}
+		if c.addr != tt.addr || c.database != tt.database || c.sslmode != tt.sslmode {
+			t.Errorf("%s: got addr=%s database=%s sslmode=%s", tt.dsn, c.addr, c.database, c.sslmode)
+		}
+	}
+}
+
+func TestScramClient(t *testing.T) {
+	// Example exchange from RFC 7677
+	s := &scramClient{user: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if got := s.clientFirst(); got != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("unexpected client-first-message %q", got)
+	}
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("unexpected client-final-message %q", final)
+	}
+	if !s.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("expected the server signature to verify")
+	}
+	if s.verifyServer("v=AAAA") {
+		t.Error("expected a wrong server signature to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import "github.com/aqstack/mimir/pkg/api"
+
+// Embedding precisions for MemoryCache entries.
+const (
+	// PrecisionFloat64 stores unit-length embeddings as float64.
+	PrecisionFloat64 = "float64"
+	// PrecisionFloat32 stores a single unit-length float32 copy of each
+	// embedding and compares in float32, for a quarter of the vector
+	// memory. Scores differ from float64 by at most about 5e-5 at 3072
+	// dimensions, and in practice by a few millionths.
+	PrecisionFloat32 = "float32"
+)
+
+// unitVector is a unit-length vector at the precision entries are stored
+// in: exactly one of f64 and f32 is set.
+type unitVector struct {
+	f64 []float64
+	f32 []float32
+}
+
+// useFloat32 reports whether entries are stored at float32 precision.
+func (m *MemoryCache) useFloat32() bool {
+	return m.opts.Precision == PrecisionFloat32
+}
+
+// unitQuery normalizes embedding for comparison against stored entries.
+func (m *MemoryCache) unitQuery(embedding []float64) unitVector {
+	unit := NormalizeVector(embedding)
+	if m.useFloat32() {
+		return unitVector{f32: toFloat32(unit)}
+	}
+	return unitVector{f64: unit}
+}
+
+// entryUnit returns the unit vector stored with entry, or normalizes its
+// Embedding when it has not been through Set.
+func (m *MemoryCache) entryUnit(entry *api.CacheEntry) unitVector {
+	switch {
+	case entry.UnitEmbedding32 != nil:
+		return unitVector{f32: entry.UnitEmbedding32}
+	case entry.UnitEmbedding != nil:
+		return unitVector{f64: entry.UnitEmbedding}
+	}
+	return m.unitQuery(entry.Embedding)
+}
+
+// dot returns the cosine similarity of v and entry's stored unit vector.
+func (v unitVector) dot(entry *api.CacheEntry) float64 {
+	if v.f32 != nil {
+		return dot32(v.f32, entry.UnitEmbedding32)
+	}
+	return dot(v.f64, entry.UnitEmbedding)
+}
+
+// storeUnit sets entry's unit vector from unit at the configured precision.
+// At float32 the float64 Embedding is dropped; exportEntry restores it.
+func (m *MemoryCache) storeUnit(entry *api.CacheEntry, unit []float64) {
+	if !m.useFloat32() {
+		entry.UnitEmbedding = unit
+		return
+	}
+	entry.UnitEmbedding32 = toFloat32(unit)
+	entry.UnitEmbedding = nil
+	entry.Embedding = nil
+}
+
+// rawVector returns the vector the dot and euclidean metrics compare:
+// RawEmbedding when retained, else Embedding, else the float32 unit vector.
+func rawVector(entry *api.CacheEntry) []float64 {
+	switch {
+	case entry.RawEmbedding != nil:
+		return entry.RawEmbedding
+	case entry.Embedding != nil:
+		return entry.Embedding
+	}
+	return toFloat64(entry.UnitEmbedding32)
+}
+
+// exportEntry returns entry as it is written out by Snapshot and List,
+// with its Embedding restored from the float32 unit vector if it was
+// dropped. The entry itself is returned when nothing needs restoring.
+func exportEntry(entry *api.CacheEntry) *api.CacheEntry {
+	if entry.Embedding != nil || entry.UnitEmbedding32 == nil {
+		return entry
+	}
+	e := *entry
+	e.Embedding = toFloat64(entry.UnitEmbedding32)
+	return &e
+}
+
+// toFloat32 converts v to float32, rounding each component to nearest.
+func toFloat32(v []float64) []float32 {
+	if v == nil {
+		return nil
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(x)
+	}
+	return out
+}
+
+// toFloat64 widens v to float64.
+func toFloat64(v []float32) []float64 {
+	if v == nil {
+		return nil
+	}
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = float64(x)
+	}
+	return out
+}
+
+// dot32 is dot for float32 vectors, accumulated in float32. The loop is
+// unrolled by four like dotBatch, which also bounds rounding error.
+func dot32(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var d0, d1, d2, d3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		d0 += a[i] * b[i]
+		d1 += a[i+1] * b[i+1]
+		d2 += a[i+2] * b[i+2]
+		d3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		d0 += a[i] * b[i]
+	}
+	return float64(d0 + d1 + d2 + d3)
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// randomVectors returns n random dim-length vectors from a fixed seed.
+func randomVectors(n, dim int) [][]float64 {
+	rng := rand.New(rand.NewSource(1))
+	vecs := make([][]float64, n)
+	for i := range vecs {
+		vecs[i] = make([]float64, dim)
+		for j := range vecs[i] {
+			vecs[i][j] = rng.NormFloat64()
+		}
+	}
+	return vecs
+}
+
+func TestFloat32Accuracy(t *testing.T) {
+	// Nearby pairs, as a cache compares near its threshold
+	vecs := randomVectors(200, 3072)
+	var worst float64
+	for i := 0; i+1 < len(vecs); i += 2 {
+		a, b := vecs[i], vecs[i+1]
+		for j := range b {
+			b[j] = a[j] + 0.2*b[j]
+		}
+		want := CosineSimilarity(a, b)
+
+		ua, ub := NormalizeVector(a), NormalizeVector(b)
+		if d := math.Abs(dot32(toFloat32(ua), toFloat32(ub)) - want); d > worst {
+			worst = d
+		}
+		if d := math.Abs(CosineSimilarity32(toFloat32(a), toFloat32(b)) - want); d > worst {
+			worst = d
+		}
+	}
+	if worst > 1e-5 {
+		t.Errorf("float32 similarity strays %g from float64, expected under 1e-5", worst)
+	}
+}
+
+func TestMemoryCacheFloat32(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		Precision:       PrecisionFloat32,
+	})
+	ctx := context.Background()
+
+	embedding := []float64{3, 4, 0}
+	cache.Set(ctx, newTestEntry(embedding, time.Hour))
+	cache.Set(ctx, newTestEntry([]float64{0, 0, 1}, time.Hour))
+
+	entry, similarity, found := cache.Get(ctx, []float64{3, 4, 0.1}, 0.99)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	if want := CosineSimilarity(embedding, []float64{3, 4, 0.1}); math.Abs(similarity-want) > 1e-6 {
+		t.Errorf("expected similarity %f, got %f", want, similarity)
+	}
+	if entry.Embedding != nil || entry.UnitEmbedding != nil || len(entry.UnitEmbedding32) != 3 {
+		t.Errorf("expected only a float32 vector stored, got %v, %v, %v", entry.Embedding, entry.UnitEmbedding, entry.UnitEmbedding32)
+	}
+
+	// Duplicates are still detected, so the entry is replaced
+	cache.Set(ctx, newTestEntry([]float64{6, 8, 0}, time.Hour))
+	if size := cache.Size(ctx); size != 2 {
+		t.Errorf("expected duplicate to replace the entry, got %d entries", size)
+	}
+
+	// Snapshots carry the unit-length vector, widened back to float64
+	var buf bytes.Buffer
+	if _, err := cache.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	if n, err := restored.Restore(&buf); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries restored, got %d, %v", n, err)
+	}
+	if _, _, found := restored.Get(ctx, embedding, 0.99); !found {
+		t.Error("expected a hit after restoring a float32 snapshot")
+	}
+
+	entries, _, _ := cache.List(ctx, "", 0, 0)
+	for _, e := range entries {
+		if len(e.Embedding) != 3 {
+			t.Errorf("expected listed entries to carry an embedding, got %v", e.Embedding)
+		}
+	}
+}
+
+// vectorBytes returns the memory held by an entry's vectors.
+func vectorBytes(e *api.CacheEntry) int {
+	return 8*(len(e.Embedding)+len(e.RawEmbedding)+len(e.UnitEmbedding)) + 4*len(e.UnitEmbedding32)
+}
+
+// BenchmarkMemoryCachePrecision compares lookups and vector memory per
+// entry at float64 and float32 precision, with 3072-dimensional vectors.
+func BenchmarkMemoryCachePrecision(b *testing.B) {
+	vecs := randomVectors(1001, 3072)
+	for _, precision := range []string{PrecisionFloat64, PrecisionFloat32} {
+		b.Run(precision, func(b *testing.B) {
+			cache := NewMemoryCache(&Options{
+				MaxSize:         2000,
+				DefaultTTL:      time.Hour,
+				CleanupInterval: time.Hour,
+				Precision:       precision,
+			})
+			ctx := context.Background()
+			for _, vec := range vecs[1:] {
+				cache.Set(ctx, newTestEntry(vec, time.Hour))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Get(ctx, vecs[0], 0.95)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(vectorBytes(cache.entries[0])), "vector-bytes/entry")
+		})
+	}
+}
@@ -0,0 +1,612 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// Payload fields of Qdrant points. The entry body is stored as a JSON string
+// alongside indexed fields that searches and eviction filter and sort on.
+const (
+	qdrantEntryField     = "entry"
+	qdrantPartitionField = "partition"
+	qdrantModelField     = "model"
+	qdrantExpiresField   = "expires_at"
+	qdrantLastHitField   = "last_hit_at"
+	qdrantHitsField      = "hits"
+)
+
+// qdrantScrollPage is how many points a scroll request returns at once.
+const qdrantScrollPage = 256
+
+// QdrantCache implements a semantic cache stored in a Qdrant collection, so
+// lookups use Qdrant's vector index instead of scanning every entry. Each
+// entry is a point whose vector is the embedding and whose payload holds the
+// response; expired points are filtered out of searches and deleted by
+// Cleanup.
+//
+// The collection is created with cosine distance on the first Set, sized to
+// that embedding. Hit and miss counters are kept per replica. As with
+// RedisCache, writes from different replicas are not transactional and
+// MaxSize is enforced approximately.
+type QdrantCache struct {
+	cleanupReporter
+	client     *qdrantClient
+	opts       *Options
+	collection string
+
+	mu    sync.Mutex
+	ready bool // collection exists
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	evictedUnused atomic.Int64
+}
+
+// NewQdrantCache creates a cache stored in the Qdrant collection
+// opts.QdrantCollection at opts.QdrantURL. The server is checked for
+// reachability; a missing collection is created on the first Set.
+func NewQdrantCache(opts *Options) (*QdrantCache, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if opts.QdrantURL == "" {
+		return nil, errors.New("qdrant URL is required")
+	}
+	if opts.QdrantCollection == "" {
+		return nil, errors.New("qdrant collection is required")
+	}
+	u, err := url.Parse(opts.QdrantURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid qdrant URL %q", opts.QdrantURL)
+	}
+
+	qc := &QdrantCache{
+		client: &qdrantClient{
+			baseURL: strings.TrimRight(opts.QdrantURL, "/"),
+			apiKey:  opts.QdrantAPIKey,
+			http:    &http.Client{Timeout: 30 * time.Second},
+		},
+		opts:       opts,
+		collection: opts.QdrantCollection,
+	}
+	ready, err := qc.collectionExists(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	qc.ready = ready
+
+	// Start cleanup goroutine
+	go qc.cleanupLoop()
+
+	return qc, nil
+}
+
+// collectionPath returns the API path of the collection, followed by suffix.
+func (q *QdrantCache) collectionPath(suffix string) string {
+	return "/collections/" + url.PathEscape(q.collection) + suffix
+}
+
+// collectionExists reports whether the collection has been created.
+func (q *QdrantCache) collectionExists(ctx context.Context) (bool, error) {
+	err := q.client.do(ctx, http.MethodGet, q.collectionPath(""), nil, nil)
+	var status *qdrantError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isReady reports whether the collection is known to exist.
+func (q *QdrantCache) isReady() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.ready
+}
+
+// ensureCollection creates the collection for dim-dimensional vectors, with
+// payload indexes on the fields searches filter on, unless it exists.
+func (q *QdrantCache) ensureCollection(ctx context.Context, dim int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ready {
+		return nil
+	}
+
+	create := map[string]interface{}{
+		"vectors": map[string]interface{}{"size": dim, "distance": "Cosine"},
+	}
+	if err := q.client.do(ctx, http.MethodPut, q.collectionPath(""), create, nil); err != nil {
+		// Another replica may have created it first
+		if exists, _ := q.collectionExists(ctx); !exists {
+			return err
+		}
+	}
+
+	indexes := map[string]string{
+		qdrantPartitionField: "keyword",
+		qdrantModelField:     "keyword",
+		qdrantExpiresField:   "integer",
+		qdrantLastHitField:   "integer",
+	}
+	for field, schema := range indexes {
+		index := map[string]string{"field_name": field, "field_schema": schema}
+		if err := q.client.do(ctx, http.MethodPut, q.collectionPath("/index?wait=true"), index, nil); err != nil {
+			return err
+		}
+	}
+	q.ready = true
+	return nil
+}
+
+// qdrantPoint is a point as returned by search and scroll requests.
+type qdrantPoint struct {
+	ID      string                 `json:"id"`
+	Score   float64                `json:"score"`
+	Payload map[string]interface{} `json:"payload"`
+	Vector  []float64              `json:"vector"`
+}
+
+// entry decodes the cached entry held by the point, or returns nil.
+func (p *qdrantPoint) entry() *api.CacheEntry {
+	body, _ := p.Payload[qdrantEntryField].(string)
+	var entry api.CacheEntry
+	if err := json.Unmarshal([]byte(body), &entry); err != nil {
+		return nil
+	}
+	entry.Embedding = p.Vector
+	entry.HitCount = p.hits()
+	return &entry
+}
+
+// hits returns the point's hit count.
+func (p *qdrantPoint) hits() int64 {
+	n, _ := p.Payload[qdrantHitsField].(float64)
+	return int64(n)
+}
+
+// unexpiredFilter matches the points of partition that have not expired.
+func unexpiredFilter(partition string) map[string]interface{} {
+	return map[string]interface{}{
+		"must": []interface{}{matchFilter(qdrantPartitionField, partition), expiryCondition("gt")},
+	}
+}
+
+// expiryCondition compares expiry with now using the range operator op.
+func expiryCondition(op string) map[string]interface{} {
+	return map[string]interface{}{
+		"key":   qdrantExpiresField,
+		"range": map[string]interface{}{op: time.Now().UnixMilli()},
+	}
+}
+
+// matchFilter is a condition matching points whose field equals value.
+func matchFilter(field, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"key":   field,
+		"match": map[string]interface{}{"value": value},
+	}
+}
+
+// Get retrieves a cached response based on semantic similarity. Qdrant
+// errors are treated as misses.
+func (q *QdrantCache) Get(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	point, err := q.nearest(ctx, PartitionFromContext(ctx), embedding, threshold)
+	if err == nil && point != nil {
+		if entry := point.entry(); entry != nil {
+			q.setPayload(ctx, point.ID, map[string]interface{}{
+				qdrantHitsField:    entry.HitCount + 1,
+				qdrantLastHitField: time.Now().UnixMilli(),
+			})
+			q.hits.Add(1)
+			return entry, point.Score, true
+		}
+	}
+
+	q.misses.Add(1)
+	return nil, 0, false
+}
+
+// Peek finds the best match for embedding like Get, but counts no hit or
+// miss and leaves the point's hit payload alone.
+func (q *QdrantCache) Peek(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	point, err := q.nearest(ctx, PartitionFromContext(ctx), embedding, threshold)
+	if err != nil || point == nil {
+		return nil, 0, false
+	}
+	if entry := point.entry(); entry != nil {
+		return entry, point.Score, true
+	}
+	return nil, 0, false
+}
+
+// nearest returns the most similar unexpired point in partition scoring at
+// least threshold, or nil if there is none.
+func (q *QdrantCache) nearest(ctx context.Context, partition string, embedding []float64, threshold float64) (*qdrantPoint, error) {
+	if !q.isReady() {
+		return nil, nil
+	}
+	search := map[string]interface{}{
+		"vector":          embedding,
+		"filter":          unexpiredFilter(partition),
+		"limit":           1,
+		"score_threshold": threshold,
+		"with_payload":    true,
+		"with_vector":     true,
+	}
+	var points []qdrantPoint
+	if err := q.client.do(ctx, http.MethodPost, q.collectionPath("/points/search"), search, &points); err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, nil
+	}
+	return &points[0], nil
+}
+
+// setPayload overwrites the given payload fields of point id.
+func (q *QdrantCache) setPayload(ctx context.Context, id string, payload map[string]interface{}) error {
+	req := map[string]interface{}{"payload": payload, "points": []string{id}}
+	return q.client.do(ctx, http.MethodPost, q.collectionPath("/points/payload"), req, nil)
+}
+
+// Set stores a response with its embedding. An existing entry in the same
+// partition with a near-identical embedding is overwritten.
+func (q *QdrantCache) Set(ctx context.Context, entry *api.CacheEntry) error {
+	if len(entry.Embedding) == 0 {
+		return errors.New("entry has no embedding")
+	}
+	if q.opts.RetainRawEmbeddings && entry.RawEmbedding == nil {
+		entry.RawEmbedding = entry.Embedding
+		entry.Embedding = NormalizeVector(entry.Embedding)
+	}
+	assignID(entry)
+	if q.opts.CompressResponses {
+		compressResponse(entry)
+	}
+	if err := q.ensureCollection(ctx, len(entry.Embedding)); err != nil {
+		return err
+	}
+
+	existing, err := q.nearest(ctx, entry.Partition, entry.Embedding, 0.99)
+	if err != nil {
+		return err
+	}
+	id := qdrantPointID(entry.ID)
+	if existing != nil {
+		id = existing.ID
+	} else if err := q.evictIfFull(ctx); err != nil {
+		return err
+	}
+
+	stored := *entry
+	stored.Embedding = nil
+	body, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	lastHit := entry.LastHitAt
+	if lastHit.IsZero() {
+		lastHit = entry.CreatedAt
+	}
+	upsert := map[string]interface{}{
+		"points": []interface{}{map[string]interface{}{
+			"id":     id,
+			"vector": entry.Embedding,
+			"payload": map[string]interface{}{
+				qdrantEntryField:     string(body),
+				qdrantPartitionField: entry.Partition,
+				qdrantModelField:     entry.Request.Model,
+				qdrantExpiresField:   entry.ExpiresAt.UnixMilli(),
+				qdrantLastHitField:   lastHit.UnixMilli(),
+				qdrantHitsField:      entry.HitCount,
+			},
+		}},
+	}
+	return q.client.do(ctx, http.MethodPut, q.collectionPath("/points?wait=true"), upsert, nil)
+}
+
+// evictIfFull removes the least recently used entry when the cache is at
+// capacity.
+func (q *QdrantCache) evictIfFull(ctx context.Context) error {
+	size, err := q.count(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if size < q.opts.MaxSize {
+		return nil
+	}
+	scroll := map[string]interface{}{
+		"limit":        1,
+		"with_payload": []string{qdrantHitsField},
+		"order_by":     map[string]interface{}{"key": qdrantLastHitField, "direction": "asc"},
+	}
+	var page struct {
+		Points []qdrantPoint `json:"points"`
+	}
+	if err := q.client.do(ctx, http.MethodPost, q.collectionPath("/points/scroll"), scroll, &page); err != nil {
+		return err
+	}
+	if len(page.Points) == 0 {
+		return nil
+	}
+	oldest := page.Points[0]
+	if err := q.deletePoints(ctx, []string{oldest.ID}); err != nil {
+		return err
+	}
+	q.evictions.Add(1)
+	if oldest.hits() == 0 {
+		q.evictedUnused.Add(1)
+	}
+	return nil
+}
+
+// count returns how many points match filter, or every point when nil.
+func (q *QdrantCache) count(ctx context.Context, filter map[string]interface{}) (int, error) {
+	if !q.isReady() {
+		return 0, nil
+	}
+	req := map[string]interface{}{"exact": true}
+	if filter != nil {
+		req["filter"] = filter
+	}
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := q.client.do(ctx, http.MethodPost, q.collectionPath("/points/count"), req, &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// scroll returns every point matching filter, or every point when nil, with
+// payloads and vectors.
+func (q *QdrantCache) scroll(ctx context.Context, filter map[string]interface{}) ([]qdrantPoint, error) {
+	if !q.isReady() {
+		return nil, nil
+	}
+	var all []qdrantPoint
+	var offset interface{}
+	for {
+		req := map[string]interface{}{
+			"limit":        qdrantScrollPage,
+			"with_payload": true,
+			"with_vector":  true,
+		}
+		if filter != nil {
+			req["filter"] = filter
+		}
+		if offset != nil {
+			req["offset"] = offset
+		}
+		var page struct {
+			Points         []qdrantPoint `json:"points"`
+			NextPageOffset interface{}   `json:"next_page_offset"`
+		}
+		if err := q.client.do(ctx, http.MethodPost, q.collectionPath("/points/scroll"), req, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Points...)
+		if page.NextPageOffset == nil {
+			return all, nil
+		}
+		offset = page.NextPageOffset
+	}
+}
+
+// deletePoints removes the points with the given ids.
+func (q *QdrantCache) deletePoints(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	req := map[string]interface{}{"points": ids}
+	return q.client.do(ctx, http.MethodPost, q.collectionPath("/points/delete?wait=true"), req, nil)
+}
+
+// deleteFilter removes the points matching filter and returns how many
+// there were.
+func (q *QdrantCache) deleteFilter(ctx context.Context, filter map[string]interface{}) (int, error) {
+	n, err := q.count(ctx, filter)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	req := map[string]interface{}{"filter": filter}
+	if err := q.client.do(ctx, http.MethodPost, q.collectionPath("/points/delete?wait=true"), req, nil); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Delete removes an entry by its embedding.
+func (q *QdrantCache) Delete(ctx context.Context, embedding []float64) error {
+	point, err := q.nearest(ctx, PartitionFromContext(ctx), embedding, 0.99)
+	if err != nil || point == nil {
+		return err
+	}
+	return q.deletePoints(ctx, []string{point.ID})
+}
+
+// Clear drops the collection, to be recreated by the next Set, and resets
+// this replica's counters.
+func (q *QdrantCache) Clear(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.client.do(ctx, http.MethodDelete, q.collectionPath(""), nil, nil)
+	var status *qdrantError
+	if err != nil && !(errors.As(err, &status) && status.code == http.StatusNotFound) {
+		return err
+	}
+	q.ready = false
+	q.hits.Store(0)
+	q.misses.Store(0)
+	q.evictions.Store(0)
+	q.evictedUnused.Store(0)
+	return nil
+}
+
+// Stats returns cache statistics. Entry counts cover the whole collection;
+// hits, misses and evictions are this replica's.
+func (q *QdrantCache) Stats(ctx context.Context) *api.CacheStats {
+	hits := q.hits.Load()
+	misses := q.misses.Load()
+	evictions := q.evictions.Load()
+	evictedUnused := q.evictedUnused.Load()
+	total := hits + misses
+
+	var hitRate float64
+	if total > 0 {
+		hitRate = float64(hits) / float64(total)
+	}
+
+	var churnRate float64
+	if evictions > 0 {
+		churnRate = float64(evictedUnused) / float64(evictions)
+	}
+
+	return &api.CacheStats{
+		TotalEntries:   int64(q.Size(ctx)),
+		TotalHits:      hits,
+		TotalMisses:    misses,
+		HitRate:        hitRate,
+		EstimatedSaved: float64(hits) * 0.001,
+		Evictions:      evictions,
+		EvictedUnused:  evictedUnused,
+		ChurnRate:      churnRate,
+	}
+}
+
+// Cleanup removes expired entries.
+func (q *QdrantCache) Cleanup(ctx context.Context) int {
+	expired := map[string]interface{}{"must": []interface{}{expiryCondition("lte")}}
+	unused, err := q.count(ctx, map[string]interface{}{
+		"must": []interface{}{expiryCondition("lte"), map[string]interface{}{
+			"key":   qdrantHitsField,
+			"match": map[string]interface{}{"value": 0},
+		}},
+	})
+	if err != nil {
+		return 0
+	}
+	removed, err := q.deleteFilter(ctx, expired)
+	if err != nil {
+		return 0
+	}
+	q.evictions.Add(int64(removed))
+	q.evictedUnused.Add(int64(unused))
+	return removed
+}
+
+// Size returns the number of unexpired entries in the cache.
+func (q *QdrantCache) Size(ctx context.Context) int {
+	n, err := q.count(ctx, map[string]interface{}{"must": []interface{}{expiryCondition("gt")}})
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Ping checks that the Qdrant server is ready.
+func (q *QdrantCache) Ping(ctx context.Context) error {
+	return q.client.do(ctx, http.MethodGet, "/readyz", nil, nil)
+}
+
+// cleanupLoop periodically removes expired entries.
+func (q *QdrantCache) cleanupLoop() {
+	runCleanups(q.opts.CleanupInterval, q.Cleanup, &q.cleanupReporter)
+}
+
+// qdrantPointID formats an entry ID, 32 hex digits, as the UUID Qdrant
+// requires point IDs to be.
+func qdrantPointID(id string) string {
+	if len(id) != 32 {
+		return id
+	}
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// qdrantClient calls the Qdrant REST API.
+type qdrantClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// qdrantError is a non-2xx response from Qdrant.
+type qdrantError struct {
+	code    int
+	message string
+}
+
+func (e *qdrantError) Error() string {
+	return fmt.Sprintf("qdrant: status %d: %s", e.code, e.message)
+}
+
+// do sends body as JSON to path and decodes the "result" field of the
+// response into out, when out is not nil.
+func (c *qdrantClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("api-key", c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var failure struct {
+			Status struct {
+				Error string `json:"error"`
+			} `json:"status"`
+		}
+		message := strings.TrimSpace(string(respBody))
+		if json.Unmarshal(respBody, &failure) == nil && failure.Status.Error != "" {
+			message = failure.Status.Error
+		}
+		return &qdrantError{code: resp.StatusCode, message: message}
+	}
+	if out == nil {
+		return nil
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
@@ -0,0 +1,422 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// fakeQdrant is an in-process server implementing the subset of the Qdrant
+// REST API QdrantCache uses, for a single collection.
+type fakeQdrant struct {
+	mu     sync.Mutex
+	name   string
+	apiKey string
+	exists bool
+	points map[string]*fakeQdrantPoint
+	server *httptest.Server
+}
+
+type fakeQdrantPoint struct {
+	vector  []float64
+	payload map[string]interface{}
+}
+
+func newFakeQdrant(t *testing.T, name string) *fakeQdrant {
+	f := &fakeQdrant{name: name, points: make(map[string]*fakeQdrantPoint)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeQdrant) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.apiKey != "" && r.Header.Get("api-key") != f.apiKey {
+		fakeQdrantError(w, http.StatusForbidden, "invalid api key")
+		return
+	}
+	if r.URL.Path == "/readyz" {
+		w.Write([]byte("all shards are ready"))
+		return
+	}
+	prefix := "/collections/" + f.name
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		fakeQdrantError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	route := r.Method + " " + strings.TrimPrefix(r.URL.Path, prefix)
+	if route == "PUT " {
+		if f.exists {
+			fakeQdrantError(w, http.StatusConflict, "collection already exists")
+			return
+		}
+		f.exists = true
+		fakeQdrantResult(w, true)
+		return
+	}
+	if !f.exists {
+		fakeQdrantError(w, http.StatusNotFound, "collection not found")
+		return
+	}
+
+	switch route {
+	case "GET ":
+		fakeQdrantResult(w, map[string]interface{}{"status": "green"})
+	case "DELETE ":
+		f.exists = false
+		f.points = make(map[string]*fakeQdrantPoint)
+		fakeQdrantResult(w, true)
+	case "PUT /index":
+		fakeQdrantResult(w, map[string]interface{}{"status": "completed"})
+	case "PUT /points":
+		for _, p := range req["points"].([]interface{}) {
+			point := p.(map[string]interface{})
+			f.points[point["id"].(string)] = &fakeQdrantPoint{
+				vector:  toVector(point["vector"]),
+				payload: point["payload"].(map[string]interface{}),
+			}
+		}
+		fakeQdrantResult(w, map[string]interface{}{"status": "completed"})
+	case "POST /points/payload":
+		for _, id := range req["points"].([]interface{}) {
+			if p, ok := f.points[id.(string)]; ok {
+				for k, v := range req["payload"].(map[string]interface{}) {
+					p.payload[k] = v
+				}
+			}
+		}
+		fakeQdrantResult(w, map[string]interface{}{"status": "completed"})
+	case "POST /points/search":
+		query := toVector(req["vector"])
+		threshold, _ := req["score_threshold"].(float64)
+		var results []map[string]interface{}
+		for _, id := range f.matching(req["filter"]) {
+			p := f.points[id]
+			if score := CosineSimilarity(query, p.vector); score >= threshold {
+				results = append(results, map[string]interface{}{
+					"id": id, "score": score, "payload": p.payload, "vector": NormalizeVector(p.vector),
+				})
+			}
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i]["score"].(float64) > results[j]["score"].(float64) })
+		if limit := int(req["limit"].(float64)); len(results) > limit {
+			results = results[:limit]
+		}
+		fakeQdrantResult(w, results)
+	case "POST /points/count":
+		fakeQdrantResult(w, map[string]interface{}{"count": len(f.matching(req["filter"]))})
+	case "POST /points/scroll":
+		ids := f.matching(req["filter"])
+		if order, ok := req["order_by"].(map[string]interface{}); ok {
+			key := order["key"].(string)
+			sort.SliceStable(ids, func(i, j int) bool {
+				return f.points[ids[i]].payload[key].(float64) < f.points[ids[j]].payload[key].(float64)
+			})
+		} else if offset, ok := req["offset"].(string); ok {
+			ids = ids[sort.SearchStrings(ids, offset):]
+		}
+		var next interface{}
+		if limit := int(req["limit"].(float64)); len(ids) > limit {
+			next = ids[limit]
+			ids = ids[:limit]
+		}
+		points := []map[string]interface{}{}
+		for _, id := range ids {
+			points = append(points, map[string]interface{}{
+				"id": id, "payload": f.points[id].payload, "vector": NormalizeVector(f.points[id].vector),
+			})
+		}
+		fakeQdrantResult(w, map[string]interface{}{"points": points, "next_page_offset": next})
+	case "POST /points/delete":
+		if ids, ok := req["points"].([]interface{}); ok {
+			for _, id := range ids {
+				delete(f.points, id.(string))
+			}
+		} else {
+			for _, id := range f.matching(req["filter"]) {
+				delete(f.points, id)
+			}
+		}
+		fakeQdrantResult(w, map[string]interface{}{"status": "completed"})
+	default:
+		fakeQdrantError(w, http.StatusNotFound, "unsupported route "+route)
+	}
+}
+
+// matching returns the sorted ids of the points matching filter, supporting
+// must and must_not lists of match and range conditions.
+func (f *fakeQdrant) matching(filter interface{}) []string {
+	conds, _ := filter.(map[string]interface{})
+	var ids []string
+	for id, p := range f.points {
+		ok := true
+		for _, c := range asList(conds["must"]) {
+			ok = ok && fakeCondition(c.(map[string]interface{}), p.payload)
+		}
+		for _, c := range asList(conds["must_not"]) {
+			ok = ok && !fakeCondition(c.(map[string]interface{}), p.payload)
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func fakeCondition(c map[string]interface{}, payload map[string]interface{}) bool {
+	value := payload[c["key"].(string)]
+	if match, ok := c["match"].(map[string]interface{}); ok {
+		return value == match["value"]
+	}
+	n, _ := value.(float64)
+	for op, bound := range c["range"].(map[string]interface{}) {
+		b := bound.(float64)
+		switch op {
+		case "gt":
+			if !(n > b) {
+				return false
+			}
+		case "lte":
+			if !(n <= b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func asList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+func toVector(v interface{}) []float64 {
+	var out []float64
+	for _, x := range v.([]interface{}) {
+		out = append(out, x.(float64))
+	}
+	return out
+}
+
+func fakeQdrantResult(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "status": "ok"})
+}
+
+func fakeQdrantError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": map[string]string{"error": message}})
+}
+
+func newTestQdrantCache(t *testing.T, maxSize int) (*QdrantCache, *fakeQdrant) {
+	server := newFakeQdrant(t, "mimir")
+	qc, err := NewQdrantCache(&Options{
+		MaxSize:          maxSize,
+		DefaultTTL:       time.Hour,
+		CleanupInterval:  time.Hour,
+		QdrantURL:        server.server.URL,
+		QdrantCollection: "mimir",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return qc, server
+}
+
+func TestQdrantCacheGetSet(t *testing.T) {
+	qc, _ := newTestQdrantCache(t, 100)
+	ctx := context.Background()
+
+	// A missing collection is a miss, not an error
+	if _, _, found := qc.Get(ctx, []float64{1, 0, 0}, 0.9); found {
+		t.Fatal("expected a miss before the collection exists")
+	}
+
+	if err := qc.Set(ctx, redisTestEntry("Paris", []float64{2, 0, 0}, time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, similarity, found := qc.Get(ctx, []float64{0.99, 0.1, 0}, 0.9)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	if entry.Response.Choices[0].Message.Content != "Paris" || similarity < 0.9 {
+		t.Errorf("unexpected hit: %+v (similarity %f)", entry.Response, similarity)
+	}
+	if len(entry.Embedding) != 3 || math.Abs(entry.Embedding[0]-1) > 1e-9 {
+		t.Errorf("expected the normalized embedding back, got %v", entry.Embedding)
+	}
+
+	// Hit counts are kept in the payload
+	entry, _, _ = qc.Get(ctx, []float64{1, 0, 0}, 0.9)
+	if entry.HitCount != 1 {
+		t.Errorf("expected hit count 1, got %d", entry.HitCount)
+	}
+
+	if _, _, found := qc.Get(ctx, []float64{0, 1, 0}, 0.9); found {
+		t.Error("expected a miss for an orthogonal vector")
+	}
+
+	// Other partitions never match
+	if _, _, found := qc.Get(WithPartition(ctx, "v2"), []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected a miss in another partition")
+	}
+
+	// Near-duplicates overwrite instead of adding
+	qc.Set(ctx, redisTestEntry("Paris, France", []float64{1, 0.0001, 0}, time.Hour))
+	if size := qc.Size(ctx); size != 1 {
+		t.Errorf("expected 1 entry, got %d", size)
+	}
+
+	// Expired entries are filtered out of searches
+	qc.Set(ctx, redisTestEntry("stale", []float64{0, 0, 1}, -time.Second))
+	if _, _, found := qc.Get(ctx, []float64{0, 0, 1}, 0.9); found {
+		t.Error("expected a miss for an expired entry")
+	}
+
+	stats := qc.Stats(ctx)
+	if stats.TotalHits != 2 || stats.TotalMisses != 4 || stats.TotalEntries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQdrantCacheEvictionAndCleanup(t *testing.T) {
+	qc, _ := newTestQdrantCache(t, 2)
+	ctx := context.Background()
+
+	old := redisTestEntry("old", []float64{1, 0, 0}, time.Hour)
+	old.LastHitAt = time.Now().Add(-time.Hour)
+	qc.Set(ctx, old)
+	qc.Set(ctx, redisTestEntry("new", []float64{0, 1, 0}, time.Hour))
+	qc.Set(ctx, redisTestEntry("newest", []float64{0, 0, 1}, time.Hour))
+
+	if size := qc.Size(ctx); size != 2 {
+		t.Fatalf("expected 2 entries, got %d", size)
+	}
+	if _, _, found := qc.Get(ctx, []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+
+	qc.Delete(ctx, []float64{0, 1, 0})
+	qc.Set(ctx, redisTestEntry("expired", []float64{0, 1, 1}, -time.Second))
+	if removed := qc.Cleanup(ctx); removed != 1 {
+		t.Errorf("expected 1 expired entry removed, got %d", removed)
+	}
+	stats := qc.Stats(ctx)
+	if stats.Evictions != 2 || stats.EvictedUnused != 2 {
+		t.Errorf("unexpected eviction stats: %+v", stats)
+	}
+
+	if err := qc.Clear(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := qc.Size(ctx); size != 0 {
+		t.Errorf("expected empty cache after Clear, got %d", size)
+	}
+	// The collection is recreated on the next write
+	if err := qc.Set(ctx, redisTestEntry("again", []float64{1, 0, 0}, time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := qc.Size(ctx); size != 1 {
+		t.Errorf("expected 1 entry after Clear and Set, got %d", size)
+	}
+}
+
+func TestQdrantCacheListAndInvalidate(t *testing.T) {
+	qc, _ := newTestQdrantCache(t, 1000)
+	ctx := context.Background()
+
+	// More entries than fit in one scroll page
+	n := qdrantScrollPage + 10
+	for i := 0; i < n; i++ {
+		embedding := make([]float64, n)
+		embedding[i] = 1
+		entry := redisTestEntry("answer", embedding, time.Hour)
+		entry.Request.Messages = []api.Message{{Role: "user", Content: "question"}}
+		entry.CreatedAt = entry.CreatedAt.Add(time.Duration(i) * time.Millisecond)
+		if i%2 == 0 {
+			entry.Request.Model = "gpt-3.5-turbo"
+		}
+		if err := qc.Set(ctx, entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	total := qc.Size(ctx)
+	if total != n {
+		t.Fatalf("expected %d entries, got %d", n, total)
+	}
+
+	page, matched, err := qc.List(ctx, "gpt-4", 0, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matched != total/2 || len(page) != 5 || page[0].Request.Model != "gpt-4" {
+		t.Errorf("expected 5 of %d gpt-4 entries, got %d of %d", total/2, len(page), matched)
+	}
+	if all, matched, _ := qc.List(ctx, "", 0, 0); matched != total || len(all) != total {
+		t.Errorf("expected all %d entries listed, got %d of %d", total, len(all), matched)
+	}
+
+	removed, err := qc.DeleteByModel(ctx, "gpt-3.5-turbo")
+	if err != nil || removed != total/2 {
+		t.Errorf("expected %d entries removed, got %d, %v", total/2, removed, err)
+	}
+	removed, err = qc.DeleteByPrompt(ctx, "quest")
+	if err != nil || removed != total/2 {
+		t.Errorf("expected %d entries removed, got %d, %v", total/2, removed, err)
+	}
+	if size := qc.Size(ctx); size != 0 {
+		t.Errorf("expected an empty cache, got %d", size)
+	}
+}
+
+func TestQdrantCacheAPIKey(t *testing.T) {
+	server := newFakeQdrant(t, "mimir")
+	server.apiKey = "secret"
+	opts := &Options{
+		MaxSize:          10,
+		CleanupInterval:  time.Hour,
+		QdrantURL:        server.server.URL,
+		QdrantCollection: "mimir",
+	}
+	if _, err := NewQdrantCache(opts); err == nil {
+		t.Fatal("expected error without the API key")
+	}
+
+	opts.QdrantAPIKey = "secret"
+	qc, err := NewQdrantCache(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := qc.Ping(context.Background()); err != nil {
+		t.Errorf("expected ping to succeed, got %v", err)
+	}
+
+	server.server.Close()
+	if err := qc.Ping(context.Background()); err == nil {
+		t.Error("expected ping to fail once the server is gone")
+	}
+}
+
+func TestNewQdrantCacheInvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:6333", "redis://localhost:6379"} {
+		if _, err := NewQdrantCache(&Options{QdrantURL: u, QdrantCollection: "mimir"}); err == nil {
+			t.Errorf("%q: expected error", u)
+		}
+	}
+}
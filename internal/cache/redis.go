@@ -0,0 +1,433 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// redisKeyPrefix namespaces every key the cache writes.
+const redisKeyPrefix = "mimir:"
+
+// Redis keys. Each entry lives in a hash holding its JSON body, its embedding
+// as little-endian float64s, its partition, expiry and hit count. Sorted sets
+// index entries by expiry and by last hit, and one set per partition lists
+// the entries Get has to scan.
+const (
+	redisExpiryKey        = redisKeyPrefix + "expiry"
+	redisLRUKey           = redisKeyPrefix + "lru"
+	redisHitsKey          = redisKeyPrefix + "stats:hits"
+	redisMissesKey        = redisKeyPrefix + "stats:misses"
+	redisEvictionsKey     = redisKeyPrefix + "stats:evictions"
+	redisEvictedUnusedKey = redisKeyPrefix + "stats:evicted_unused"
+)
+
+func redisEntryKey(id string) string        { return redisKeyPrefix + "entry:" + id }
+func redisIndexKey(partition string) string { return redisKeyPrefix + "index:" + partition }
+
+// RedisCache implements a semantic cache stored in Redis, so several mimir
+// replicas share one cache. Lookups still scan every embedding in the
+// request's partition; hit, miss and eviction counters are Redis counters and
+// aggregate across replicas.
+//
+// Writes from different replicas are not transactional: two replicas storing
+// near-identical prompts at the same moment may both keep their entry, and
+// MaxSize is enforced approximately.
+type RedisCache struct {
+	cleanupReporter
+	client *redisClient
+	opts   *Options
+}
+
+// NewRedisCache creates a cache backed by the Redis server at opts.RedisURL.
+// The connection is verified with a PING.
+func NewRedisCache(opts *Options) (*RedisCache, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if opts.RedisURL == "" {
+		return nil, errors.New("redis URL is required")
+	}
+
+	client, err := newRedisClient(opts.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &RedisCache{client: client, opts: opts}
+	if _, err := client.Do(context.Background(), "PING"); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	// Start cleanup goroutine
+	go rc.cleanupLoop()
+
+	return rc, nil
+}
+
+// Close releases the cache's connections.
+func (r *RedisCache) Close() error {
+	return r.client.Close()
+}
+
+// Get retrieves a cached response based on semantic similarity. Redis errors
+// are treated as misses.
+func (r *RedisCache) Get(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	id, similarity, err := r.nearest(ctx, PartitionFromContext(ctx), embedding, threshold)
+	if err == nil && id != "" {
+		replies, err := r.client.Pipeline(ctx, [][]string{
+			{"HMGET", redisEntryKey(id), "entry", "embedding", "hits"},
+			{"HINCRBY", redisEntryKey(id), "hits", "1"},
+			{"ZADD", redisLRUKey, "XX", msString(time.Now()), id},
+			{"INCR", redisHitsKey},
+		})
+		if err == nil {
+			if entry := decodeRedisEntry(replies[0]); entry != nil {
+				return entry, similarity, true
+			}
+		}
+	}
+
+	r.client.Do(ctx, "INCR", redisMissesKey)
+	return nil, 0, false
+}
+
+// Peek finds the best match for embedding like Get, but counts no hit or
+// miss and leaves the entry's hit count and recency alone.
+func (r *RedisCache) Peek(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	id, similarity, err := r.nearest(ctx, PartitionFromContext(ctx), embedding, threshold)
+	if err != nil || id == "" {
+		return nil, 0, false
+	}
+	reply, err := r.client.Do(ctx, "HMGET", redisEntryKey(id), "entry", "embedding", "hits")
+	if err != nil {
+		return nil, 0, false
+	}
+	if entry := decodeRedisEntry(reply); entry != nil {
+		return entry, similarity, true
+	}
+	return nil, 0, false
+}
+
+// nearest returns the id of the most similar unexpired entry in partition
+// scoring at least threshold, or "" if there is none.
+func (r *RedisCache) nearest(ctx context.Context, partition string, embedding []float64, threshold float64) (string, float64, error) {
+	members, err := r.client.Do(ctx, "SMEMBERS", redisIndexKey(partition))
+	if err != nil {
+		return "", 0, err
+	}
+	ids := replyStrings(members)
+	if len(ids) == 0 {
+		return "", 0, nil
+	}
+
+	cmds := make([][]string, len(ids))
+	for i, id := range ids {
+		cmds[i] = []string{"HMGET", redisEntryKey(id), "embedding", "expires_at"}
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	var bestID string
+	var bestSimilarity float64
+	for i, reply := range replies {
+		fields, ok := reply.([]interface{})
+		if !ok || len(fields) != 2 || fields[0] == nil {
+			continue
+		}
+		if replyInt(fields[1]) <= now {
+			continue
+		}
+		raw, _ := fields[0].([]byte)
+		similarity := CosineSimilarity(embedding, decodeVector(raw))
+		if similarity >= threshold && similarity > bestSimilarity {
+			bestSimilarity = similarity
+			bestID = ids[i]
+		}
+	}
+	return bestID, bestSimilarity, nil
+}
+
+// Set stores a response with its embedding. An existing entry in the same
+// partition with a near-identical embedding is overwritten.
+func (r *RedisCache) Set(ctx context.Context, entry *api.CacheEntry) error {
+	if r.opts.RetainRawEmbeddings && entry.RawEmbedding == nil {
+		entry.RawEmbedding = entry.Embedding
+		entry.Embedding = NormalizeVector(entry.Embedding)
+	}
+	assignID(entry)
+	if r.opts.CompressResponses {
+		compressResponse(entry)
+	}
+
+	id, _, err := r.nearest(ctx, entry.Partition, entry.Embedding, 0.99)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		id = redisEntryID(entry.Partition, entry.Embedding)
+		if err := r.evictIfFull(ctx); err != nil {
+			return err
+		}
+	}
+
+	stored := *entry
+	stored.Embedding = nil
+	body, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	lastHit := entry.LastHitAt
+	if lastHit.IsZero() {
+		lastHit = entry.CreatedAt
+	}
+	replies, err := r.client.Pipeline(ctx, [][]string{
+		{"HSET", redisEntryKey(id),
+			"entry", string(body),
+			"embedding", string(encodeVector(entry.Embedding)),
+			"partition", entry.Partition,
+			"expires_at", msString(entry.ExpiresAt),
+			"hits", strconv.FormatInt(entry.HitCount, 10),
+		},
+		{"SADD", redisIndexKey(entry.Partition), id},
+		{"ZADD", redisExpiryKey, msString(entry.ExpiresAt), id},
+		{"ZADD", redisLRUKey, msString(lastHit), id},
+	})
+	if err != nil {
+		return err
+	}
+	return firstReplyError(replies)
+}
+
+// evictIfFull removes the least recently used entry when the cache is at
+// capacity.
+func (r *RedisCache) evictIfFull(ctx context.Context) error {
+	size, err := r.client.Do(ctx, "ZCARD", redisLRUKey)
+	if err != nil {
+		return err
+	}
+	if replyInt(size) < int64(r.opts.MaxSize) {
+		return nil
+	}
+	oldest, err := r.client.Do(ctx, "ZRANGE", redisLRUKey, "0", "0")
+	if err != nil {
+		return err
+	}
+	_, err = r.remove(ctx, replyStrings(oldest), true)
+	return err
+}
+
+// remove deletes the entries with the given ids and returns how many
+// existed. Evicted and expired entries are counted toward churn stats.
+func (r *RedisCache) remove(ctx context.Context, ids []string, count bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	cmds := make([][]string, len(ids))
+	for i, id := range ids {
+		cmds[i] = []string{"HMGET", redisEntryKey(id), "partition", "hits"}
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cmds = cmds[:0]
+	for i, reply := range replies {
+		id := ids[i]
+		cmds = append(cmds,
+			[]string{"DEL", redisEntryKey(id)},
+			[]string{"ZREM", redisExpiryKey, id},
+			[]string{"ZREM", redisLRUKey, id},
+		)
+		fields, ok := reply.([]interface{})
+		if !ok || len(fields) != 2 || fields[0] == nil {
+			continue
+		}
+		removed++
+		partition, _ := fields[0].([]byte)
+		cmds = append(cmds, []string{"SREM", redisIndexKey(string(partition)), id})
+		if count {
+			cmds = append(cmds, []string{"INCR", redisEvictionsKey})
+			if replyInt(fields[1]) == 0 {
+				cmds = append(cmds, []string{"INCR", redisEvictedUnusedKey})
+			}
+		}
+	}
+
+	replies, err = r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return 0, err
+	}
+	return removed, firstReplyError(replies)
+}
+
+// Delete removes an entry by its embedding.
+func (r *RedisCache) Delete(ctx context.Context, embedding []float64) error {
+	id, _, err := r.nearest(ctx, PartitionFromContext(ctx), embedding, 0.99)
+	if err != nil || id == "" {
+		return err
+	}
+	_, err = r.remove(ctx, []string{id}, false)
+	return err
+}
+
+// Clear removes all entries from the cache and resets the shared counters.
+func (r *RedisCache) Clear(ctx context.Context) error {
+	all, err := r.client.Do(ctx, "ZRANGE", redisLRUKey, "0", "-1")
+	if err != nil {
+		return err
+	}
+	if _, err := r.remove(ctx, replyStrings(all), false); err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, "DEL", redisExpiryKey, redisLRUKey,
+		redisHitsKey, redisMissesKey, redisEvictionsKey, redisEvictedUnusedKey)
+	return err
+}
+
+// Stats returns cache statistics aggregated across every replica sharing the
+// cache. Redis errors yield zeroed stats.
+func (r *RedisCache) Stats(ctx context.Context) *api.CacheStats {
+	replies, err := r.client.Pipeline(ctx, [][]string{
+		{"MGET", redisHitsKey, redisMissesKey, redisEvictionsKey, redisEvictedUnusedKey},
+		{"ZCOUNT", redisExpiryKey, "(" + msString(time.Now()), "+inf"},
+	})
+	if err != nil {
+		return &api.CacheStats{}
+	}
+
+	counters, _ := replies[0].([]interface{})
+	for len(counters) < 4 {
+		counters = append(counters, nil)
+	}
+	hits := replyInt(counters[0])
+	misses := replyInt(counters[1])
+	evictions := replyInt(counters[2])
+	evictedUnused := replyInt(counters[3])
+	total := hits + misses
+
+	var hitRate float64
+	if total > 0 {
+		hitRate = float64(hits) / float64(total)
+	}
+
+	var churnRate float64
+	if evictions > 0 {
+		churnRate = float64(evictedUnused) / float64(evictions)
+	}
+
+	return &api.CacheStats{
+		TotalEntries:   replyInt(replies[1]),
+		TotalHits:      hits,
+		TotalMisses:    misses,
+		HitRate:        hitRate,
+		EstimatedSaved: float64(hits) * 0.001,
+		Evictions:      evictions,
+		EvictedUnused:  evictedUnused,
+		ChurnRate:      churnRate,
+	}
+}
+
+// Cleanup removes expired entries.
+func (r *RedisCache) Cleanup(ctx context.Context) int {
+	expired, err := r.client.Do(ctx, "ZRANGEBYSCORE", redisExpiryKey, "-inf", msString(time.Now()))
+	if err != nil {
+		return 0
+	}
+	removed, _ := r.remove(ctx, replyStrings(expired), true)
+	return removed
+}
+
+// Size returns the number of unexpired entries in the cache.
+func (r *RedisCache) Size(ctx context.Context) int {
+	n, err := r.client.Do(ctx, "ZCOUNT", redisExpiryKey, "("+msString(time.Now()), "+inf")
+	if err != nil {
+		return 0
+	}
+	return int(replyInt(n))
+}
+
+// Ping sends a PING to the Redis server.
+func (r *RedisCache) Ping(ctx context.Context) error {
+	_, err := r.client.Do(ctx, "PING")
+	return err
+}
+
+// cleanupLoop periodically removes expired entries.
+func (r *RedisCache) cleanupLoop() {
+	runCleanups(r.opts.CleanupInterval, r.Cleanup, &r.cleanupReporter)
+}
+
+// redisEntryID derives an entry id from its partition and embedding.
+func redisEntryID(partition string, embedding []float64) string {
+	h := sha256.New()
+	h.Write([]byte(partition))
+	h.Write([]byte{0})
+	h.Write(encodeVector(embedding))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// decodeRedisEntry rebuilds an entry from an HMGET of entry, embedding and
+// hits, returning nil if the entry no longer exists.
+func decodeRedisEntry(reply interface{}) *api.CacheEntry {
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields) != 3 || fields[0] == nil {
+		return nil
+	}
+	body, _ := fields[0].([]byte)
+	var entry api.CacheEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return nil
+	}
+	raw, _ := fields[1].([]byte)
+	entry.Embedding = decodeVector(raw)
+	entry.HitCount = replyInt(fields[2])
+	return &entry
+}
+
+// encodeVector serializes v as little-endian float64s.
+func encodeVector(v []float64) []byte {
+	buf := make([]byte, 8*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(x))
+	}
+	return buf
+}
+
+// decodeVector is the inverse of encodeVector.
+func decodeVector(buf []byte) []float64 {
+	v := make([]float64, len(buf)/8)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8*i:]))
+	}
+	return v
+}
+
+func msString(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func firstReplyError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
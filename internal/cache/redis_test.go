@@ -0,0 +1,471 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// fakeRedis is an in-process server implementing the subset of Redis
+// commands RedisCache uses.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	zsets   map[string]map[string]float64
+	ln      net.Listener
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+		sets:    make(map[string]map[string]bool),
+		zsets:   make(map[string]map[string]float64),
+		ln:      ln,
+	}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) URL() string { return "redis://" + f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		f.mu.Lock()
+		out := f.exec(args)
+		f.mu.Unlock()
+		writeFakeReply(w, out)
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func writeFakeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case redisError:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeFakeReply(w, item)
+		}
+	}
+}
+
+func parseScore(s string) (float64, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch s {
+	case "-inf":
+		return math.Inf(-1), exclusive
+	case "+inf":
+		return math.Inf(1), exclusive
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v, exclusive
+}
+
+// zrange returns members of key within [min, max] ordered by score.
+func (f *fakeRedis) zrange(key, min, max string) []string {
+	lo, loEx := parseScore(min)
+	hi, hiEx := parseScore(max)
+	var members []string
+	for m, s := range f.zsets[key] {
+		if s < lo || s > hi || (loEx && s == lo) || (hiEx && s == hi) {
+			continue
+		}
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return f.zsets[key][members[i]] < f.zsets[key][members[j]]
+	})
+	return members
+}
+
+func bulks(ss []string) []interface{} {
+	out := make([]interface{}, len(ss))
+	for i, s := range ss {
+		out[i] = []byte(s)
+	}
+	return out
+}
+
+func (f *fakeRedis) exec(args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "PONG"
+	case "INCR":
+		n, _ := strconv.Atoi(f.strings[args[1]])
+		f.strings[args[1]] = strconv.Itoa(n + 1)
+		return n + 1
+	case "MGET":
+		out := make([]interface{}, len(args)-1)
+		for i, k := range args[1:] {
+			if v, ok := f.strings[k]; ok {
+				out[i] = []byte(v)
+			}
+		}
+		return out
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			_, s := f.strings[k]
+			_, h := f.hashes[k]
+			_, st := f.sets[k]
+			_, z := f.zsets[k]
+			if s || h || st || z {
+				n++
+			}
+			delete(f.strings, k)
+			delete(f.hashes, k)
+			delete(f.sets, k)
+			delete(f.zsets, k)
+		}
+		return n
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			f.hashes[args[1]][args[i]] = args[i+1]
+		}
+		return (len(args) - 2) / 2
+	case "HMGET":
+		h, ok := f.hashes[args[1]]
+		out := make([]interface{}, len(args)-2)
+		for i, field := range args[2:] {
+			if v, found := h[field]; ok && found {
+				out[i] = []byte(v)
+			}
+		}
+		return out
+	case "HGET":
+		if v, ok := f.hashes[args[1]][args[2]]; ok {
+			return []byte(v)
+		}
+		return nil
+	case "HINCRBY":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		n, _ := strconv.Atoi(f.hashes[args[1]][args[2]])
+		by, _ := strconv.Atoi(args[3])
+		f.hashes[args[1]][args[2]] = strconv.Itoa(n + by)
+		return n + by
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]bool)
+		}
+		for _, m := range args[2:] {
+			f.sets[args[1]][m] = true
+		}
+		return len(args) - 2
+	case "SREM":
+		for _, m := range args[2:] {
+			delete(f.sets[args[1]], m)
+		}
+		return len(args) - 2
+	case "SMEMBERS":
+		var members []string
+		for m := range f.sets[args[1]] {
+			members = append(members, m)
+		}
+		return bulks(members)
+	case "ZADD":
+		rest := args[2:]
+		xx := strings.ToUpper(rest[0]) == "XX"
+		if xx {
+			rest = rest[1:]
+		}
+		if f.zsets[args[1]] == nil {
+			f.zsets[args[1]] = make(map[string]float64)
+		}
+		for i := 0; i+1 < len(rest); i += 2 {
+			if _, exists := f.zsets[args[1]][rest[i+1]]; xx && !exists {
+				continue
+			}
+			score, _ := strconv.ParseFloat(rest[i], 64)
+			f.zsets[args[1]][rest[i+1]] = score
+		}
+		return len(rest) / 2
+	case "ZREM":
+		for _, m := range args[2:] {
+			delete(f.zsets[args[1]], m)
+		}
+		return len(args) - 2
+	case "ZCARD":
+		return len(f.zsets[args[1]])
+	case "ZCOUNT":
+		return len(f.zrange(args[1], args[2], args[3]))
+	case "ZRANGEBYSCORE":
+		return bulks(f.zrange(args[1], args[2], args[3]))
+	case "ZRANGE":
+		members := f.zrange(args[1], "-inf", "+inf")
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		if stop < 0 {
+			stop += len(members)
+		}
+		if start >= len(members) || start > stop {
+			return []interface{}{}
+		}
+		if stop >= len(members) {
+			stop = len(members) - 1
+		}
+		return bulks(members[start : stop+1])
+	}
+	return redisError("ERR unknown command '" + args[0] + "'")
+}
+
+func newTestRedisCache(t *testing.T, maxSize int) *RedisCache {
+	server := newFakeRedis(t)
+	rc, err := NewRedisCache(&Options{
+		MaxSize:         maxSize,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		RedisURL:        server.URL(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { rc.Close() })
+	return rc
+}
+
+func redisTestEntry(content string, embedding []float64, ttl time.Duration) *api.CacheEntry {
+	return &api.CacheEntry{
+		Embedding: embedding,
+		Request:   api.ChatCompletionRequest{Model: "gpt-4"},
+		Response: api.ChatCompletionResponse{
+			ID:      "resp-" + content,
+			Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: content}}},
+		},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+		LastHitAt: time.Now(),
+	}
+}
+
+func TestRedisCacheGetSet(t *testing.T) {
+	rc := newTestRedisCache(t, 100)
+	ctx := context.Background()
+
+	if err := rc.Set(ctx, redisTestEntry("Paris", []float64{1, 0, 0}, time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, similarity, found := rc.Get(ctx, []float64{0.99, 0.1, 0}, 0.9)
+	if !found {
+		t.Fatal("expected a hit")
+	}
+	if entry.Response.Choices[0].Message.Content != "Paris" || similarity < 0.9 {
+		t.Errorf("unexpected hit: %+v (similarity %f)", entry.Response, similarity)
+	}
+	if len(entry.Embedding) != 3 || entry.Embedding[0] != 1 {
+		t.Errorf("expected the embedding to round-trip, got %v", entry.Embedding)
+	}
+
+	if _, _, found := rc.Get(ctx, []float64{0, 1, 0}, 0.9); found {
+		t.Error("expected a miss for an orthogonal vector")
+	}
+
+	// Other partitions never match
+	if _, _, found := rc.Get(WithPartition(ctx, "v2"), []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected a miss in another partition")
+	}
+
+	// Near-duplicates overwrite instead of adding
+	rc.Set(ctx, redisTestEntry("Paris, France", []float64{1, 0.0001, 0}, time.Hour))
+	if size := rc.Size(ctx); size != 1 {
+		t.Errorf("expected 1 entry, got %d", size)
+	}
+
+	stats := rc.Stats(ctx)
+	if stats.TotalHits != 1 || stats.TotalMisses != 2 || stats.TotalEntries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRedisCacheSharedAcrossInstances(t *testing.T) {
+	server := newFakeRedis(t)
+	ctx := context.Background()
+	var replicas []*RedisCache
+	for i := 0; i < 2; i++ {
+		rc, err := NewRedisCache(&Options{MaxSize: 100, CleanupInterval: time.Hour, RedisURL: server.URL()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer rc.Close()
+		replicas = append(replicas, rc)
+	}
+
+	replicas[0].Set(ctx, redisTestEntry("shared", []float64{0, 0, 1}, time.Hour))
+	if _, _, found := replicas[1].Get(ctx, []float64{0, 0, 1}, 0.9); !found {
+		t.Fatal("expected the second replica to see the first replica's entry")
+	}
+	replicas[0].Get(ctx, []float64{1, 0, 0}, 0.9)
+
+	for i, rc := range replicas {
+		stats := rc.Stats(ctx)
+		if stats.TotalHits != 1 || stats.TotalMisses != 1 {
+			t.Errorf("replica %d: expected aggregated counters, got %+v", i, stats)
+		}
+	}
+}
+
+func TestRedisCacheEvictionAndCleanup(t *testing.T) {
+	rc := newTestRedisCache(t, 2)
+	ctx := context.Background()
+
+	old := redisTestEntry("old", []float64{1, 0, 0}, time.Hour)
+	old.LastHitAt = time.Now().Add(-time.Hour)
+	rc.Set(ctx, old)
+	rc.Set(ctx, redisTestEntry("new", []float64{0, 1, 0}, time.Hour))
+	rc.Set(ctx, redisTestEntry("newest", []float64{0, 0, 1}, time.Hour))
+
+	if size := rc.Size(ctx); size != 2 {
+		t.Fatalf("expected 2 entries, got %d", size)
+	}
+	if _, _, found := rc.Get(ctx, []float64{1, 0, 0}, 0.9); found {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+
+	rc.Set(ctx, redisTestEntry("expired", []float64{0, 1, 1}, -time.Second))
+	if removed := rc.Cleanup(ctx); removed != 1 {
+		t.Errorf("expected 1 expired entry removed, got %d", removed)
+	}
+	// Two capacity evictions plus one expiry
+	stats := rc.Stats(ctx)
+	if stats.Evictions != 3 || stats.EvictedUnused != 3 {
+		t.Errorf("unexpected eviction stats: %+v", stats)
+	}
+
+	if err := rc.Delete(ctx, []float64{0, 1, 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rc.Clear(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := rc.Size(ctx); size != 0 {
+		t.Errorf("expected empty cache after Clear, got %d", size)
+	}
+	if stats := rc.Stats(ctx); stats.TotalHits != 0 || stats.TotalMisses != 0 {
+		t.Errorf("expected counters reset, got %+v", stats)
+	}
+}
+
+func TestNewRedisClientURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		addr    string
+		db      int
+		pass    string
+		wantErr bool
+	}{
+		{url: "redis://localhost", addr: "localhost:6379"},
+		{url: "redis://:secret@cache:6380/2", addr: "cache:6380", db: 2, pass: "secret"},
+		{url: "http://localhost:6379", wantErr: true},
+		{url: "redis://localhost/x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := newRedisClient(tt.url)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.url, err)
+		}
+		if c.addr != tt.addr || c.db != tt.db || c.password != tt.pass {
+			t.Errorf("%s: got addr=%s db=%d password=%q", tt.url, c.addr, c.db, c.password)
+		}
+	}
+}
+
+func TestNewRedisCacheUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	if _, err := NewRedisCache(&Options{RedisURL: fmt.Sprintf("redis://%s", addr)}); err == nil {
+		t.Error("expected error for an unreachable server")
+	}
+}
+
+func TestRedisCachePing(t *testing.T) {
+	server := newFakeRedis(t)
+	rc, err := NewRedisCache(&Options{MaxSize: 10, CleanupInterval: time.Hour, RedisURL: server.URL()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rc.Close()
+
+	ctx := context.Background()
+	if err := rc.Ping(ctx); err != nil {
+		t.Fatalf("expected ping to succeed, got %v", err)
+	}
+
+	// Stop the server and drop pooled connections so the next ping redials
+	server.ln.Close()
+	rc.client.Close()
+	if err := rc.Ping(ctx); err == nil {
+		t.Error("expected ping to fail once the server is gone")
+	}
+}
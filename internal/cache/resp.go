@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply sent by the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a single connection speaking RESP2.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisClient is a minimal Redis client with a small connection pool. It
+// supports pipelining, which is all the cache needs; there is no pub/sub or
+// cluster support.
+type redisClient struct {
+	addr     string
+	password string
+	username string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// maxIdleRedisConns bounds the connections kept open between commands.
+const maxIdleRedisConns = 8
+
+// newRedisClient parses a redis:// URL of the form
+// redis://[[user]:password@]host[:port][/db].
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid redis URL: unsupported scheme %q", u.Scheme)
+	}
+
+	c := &redisClient{addr: u.Host, timeout: 5 * time.Second}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: bad database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Do runs a single command and returns its reply.
+func (c *redisClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends all commands in one round trip and returns their replies
+// in order. Error replies are returned in place as redisError values.
+func (c *redisClient) Pipeline(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.conn.SetDeadline(deadline)
+
+	replies, err := conn.roundTrip(cmds)
+	if err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return replies, nil
+}
+
+// get returns an idle connection or dials a new one.
+func (c *redisClient) get(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial: %w", err)
+	}
+	conn := &redisConn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		nc.SetDeadline(time.Now().Add(c.timeout))
+		replies, err := conn.roundTrip(setup)
+		if err == nil {
+			for _, r := range replies {
+				if rerr, ok := r.(error); ok {
+					err = rerr
+					break
+				}
+			}
+		}
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// put returns a healthy connection to the pool.
+func (c *redisClient) put(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdleRedisConns {
+		conn.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// Close closes all idle connections.
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.idle {
+		conn.conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// roundTrip writes cmds and reads one reply per command.
+func (rc *redisConn) roundTrip(cmds [][]string) ([]interface{}, error) {
+	for _, args := range cmds {
+		writeCommand(rc.w, args)
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis write: %w", err)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := readReply(rc.r)
+		if err != nil {
+			return nil, fmt.Errorf("redis read: %w", err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(w *bufio.Writer, args []string) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// readReply decodes one RESP2 reply. Simple strings become string, integers
+// int64, bulk strings []byte, nil bulks and arrays nil, arrays
+// []interface{}, and error replies redisError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
+
+// replyInt converts an integer or numeric bulk reply to int64.
+func replyInt(reply interface{}) int64 {
+	switch v := reply.(type) {
+	case int64:
+		return v
+	case []byte:
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		return n
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// replyStrings converts an array reply of bulk strings to strings.
+func replyStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if b, ok := item.([]byte); ok {
+			out = append(out, string(b))
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// shardCount returns how many shards a MemoryCache built with opts is
+// split into: Shards, but never more than MaxSize so every shard can hold
+// at least one entry.
+func shardCount(opts *Options) int {
+	n := opts.Shards
+	if n > opts.MaxSize {
+		n = opts.MaxSize
+	}
+	return n
+}
+
+// newShards creates n unsharded caches splitting opts.MaxSize and
+// opts.GhostSize between them as evenly as possible.
+func newShards(opts *Options, n int) []*MemoryCache {
+	shards := make([]*MemoryCache, n)
+	for i := range shards {
+		shardOpts := *opts
+		shardOpts.Shards = 0
+		shardOpts.MaxSize = splitEvenly(opts.MaxSize, n, i)
+		shardOpts.GhostSize = splitEvenly(opts.GhostSize, n, i)
+		shards[i] = newMemoryShard(&shardOpts)
+	}
+	return shards
+}
+
+// splitEvenly returns shard i's share of total split n ways.
+func splitEvenly(total, n, i int) int {
+	share := total / n
+	if i < total%n {
+		share++
+	}
+	return share
+}
+
+// leaves returns the caches that hold entries: the shards of a sharded
+// cache, or m itself.
+func (m *MemoryCache) leaves() []*MemoryCache {
+	if m.shards != nil {
+		return m.shards
+	}
+	return []*MemoryCache{m}
+}
+
+// shardFor returns the shard that owns entry, chosen by its ID.
+func (m *MemoryCache) shardFor(entry *api.CacheEntry) *MemoryCache {
+	h := fnv.New32a()
+	h.Write([]byte(entry.ID))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// search finds the best live match for embedding along with the cache
+// holding it. Shards are scanned concurrently, one goroutine each.
+func (m *MemoryCache) search(ctx context.Context, embedding []float64, threshold float64) (*MemoryCache, *api.CacheEntry, float64, bool) {
+	if m.shards == nil {
+		entry, score, ok := m.lookup(ctx, embedding, threshold)
+		return m, entry, score, ok
+	}
+
+	type match struct {
+		entry *api.CacheEntry
+		score float64
+		ok    bool
+	}
+	matches := make([]match, len(m.shards))
+	var wg sync.WaitGroup
+	for i, s := range m.shards {
+		wg.Add(1)
+		go func(i int, s *MemoryCache) {
+			defer wg.Done()
+			entry, score, ok := s.lookup(ctx, embedding, threshold)
+			matches[i] = match{entry, score, ok}
+		}(i, s)
+	}
+	wg.Wait()
+
+	// Euclidean scores are distances, so the smallest wins
+	euclidean := m.opts.Metric == MetricEuclidean
+	best := -1
+	for i, mt := range matches {
+		if !mt.ok {
+			continue
+		}
+		if best < 0 || (euclidean && mt.score < matches[best].score) || (!euclidean && mt.score > matches[best].score) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, nil, 0, false
+	}
+	return m.shards[best], matches[best].entry, matches[best].score, true
+}
+
+// setSharded stores a prepared entry in the shard that owns it. A
+// near-identical entry held by another shard is dropped first, so the
+// entry replaces it just as it would in an unsharded cache.
+func (m *MemoryCache) setSharded(entry *api.CacheEntry) {
+	m.setMu.Lock()
+	defer m.setMu.Unlock()
+
+	owner := m.shardFor(entry)
+	for _, s := range m.shards {
+		if s != owner {
+			s.dropDuplicate(entry)
+		}
+	}
+
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
+	owner.store(entry)
+}
+
+// dropDuplicate removes the entry near-identical to entry, if any, without
+// counting it as an eviction.
+func (m *MemoryCache) dropDuplicate(entry *api.CacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.findDuplicate(entry); i >= 0 {
+		m.swapRemove(i)
+		m.version.Add(1)
+	}
+}
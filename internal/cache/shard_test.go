@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newShardedCache(size, shards int) *MemoryCache {
+	return NewMemoryCache(&Options{
+		MaxSize:         size,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+		Shards:          shards,
+	})
+}
+
+func TestMemoryCacheShards(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	ctx := context.Background()
+	vecs := clusteredVectors(rng, 200, 64)
+
+	c := newShardedCache(1000, 8)
+	if len(c.shards) != 8 {
+		t.Fatalf("expected 8 shards, got %d", len(c.shards))
+	}
+	for i, v := range vecs {
+		entry := newTestEntry(v, time.Hour)
+		entry.Response.ID = fmt.Sprint(i)
+		c.Set(ctx, entry)
+	}
+	size := c.Size(ctx)
+	if size == 0 || size > len(vecs) {
+		t.Fatalf("expected up to %d entries, got %d", len(vecs), size)
+	}
+	for _, s := range c.shards {
+		if len(s.entries) == 0 {
+			t.Error("expected entries spread across every shard")
+		}
+	}
+
+	t.Run("finds the best match across shards", func(t *testing.T) {
+		linear := newShardedCache(1000, 1)
+		for _, v := range vecs {
+			linear.Set(ctx, newTestEntry(v, time.Hour))
+		}
+		for i := 0; i < 50; i++ {
+			query := perturb(rng, vecs[rng.Intn(len(vecs))], 0.05)
+			_, want, _ := linear.Get(ctx, query, 0.5)
+			_, got, _ := c.Get(ctx, query, 0.5)
+			if got != want {
+				t.Fatalf("expected similarity %v, got %v", want, got)
+			}
+		}
+	})
+
+	t.Run("near-duplicate replaces across shards", func(t *testing.T) {
+		// A new creation time gives the entry a new ID, so likely a new shard
+		entry := newTestEntry(vecs[0], time.Hour)
+		entry.CreatedAt = entry.CreatedAt.Add(time.Second)
+		entry.Response.ID = "replacement"
+		c.Set(ctx, entry)
+		if got := c.Size(ctx); got != size {
+			t.Errorf("expected size to stay %d, got %d", size, got)
+		}
+		if hit, _, _ := c.Get(ctx, vecs[0], 0.99); hit == nil || hit.Response.ID != "replacement" {
+			t.Errorf("expected the replacement to be served, got %+v", hit)
+		}
+	})
+
+	t.Run("snapshot round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := c.Snapshot(&buf)
+		if err != nil || n != size {
+			t.Fatalf("expected %d entries snapshotted, got %d (%v)", size, n, err)
+		}
+		restored := newShardedCache(1000, 4)
+		if n, err := restored.Restore(&buf); err != nil || n != size {
+			t.Fatalf("expected %d entries restored, got %d (%v)", size, n, err)
+		}
+		if restored.Size(ctx) != size {
+			t.Errorf("expected size=%d after restore, got %d", size, restored.Size(ctx))
+		}
+	})
+
+	t.Run("stats, delete and clear", func(t *testing.T) {
+		if stats := c.Stats(ctx); stats.TotalEntries != int64(size) || stats.TotalHits == 0 {
+			t.Errorf("expected %d entries and some hits, got %+v", size, stats)
+		}
+		c.Delete(ctx, vecs[0])
+		if c.Size(ctx) != size-1 {
+			t.Errorf("expected size=%d after delete, got %d", size-1, c.Size(ctx))
+		}
+		c.Clear(ctx)
+		if stats := c.Stats(ctx); stats.TotalEntries != 0 || stats.TotalHits != 0 {
+			t.Errorf("expected an empty cache, got %+v", stats)
+		}
+	})
+}
+
+func TestMemoryCacheShardCapacity(t *testing.T) {
+	// Never more shards than entries, and shares add up to MaxSize
+	c := newShardedCache(10, 16)
+	if len(c.shards) != 10 {
+		t.Fatalf("expected 10 shards, got %d", len(c.shards))
+	}
+	c = newShardedCache(100, 16)
+	total := 0
+	for _, s := range c.shards {
+		total += s.opts.MaxSize
+	}
+	if total != 100 {
+		t.Errorf("expected shard capacities to total 100, got %d", total)
+	}
+}
+
+// BenchmarkMemoryCacheParallelGet compares concurrent lookups against one
+// lock and against 16 shards.
+func BenchmarkMemoryCacheParallelGet(b *testing.B) {
+	rng := rand.New(rand.NewSource(5))
+	ctx := context.Background()
+	vecs := clusteredVectors(rng, 5000, 128)
+	queries := make([][]float64, 100)
+	for i := range queries {
+		queries[i] = perturb(rng, vecs[rng.Intn(len(vecs))], 0.05)
+	}
+
+	for _, shards := range []int{1, 16} {
+		c := newShardedCache(len(vecs), shards)
+		for _, v := range vecs {
+			c.Set(ctx, newTestEntry(v, time.Hour))
+		}
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					c.Get(ctx, queries[i%len(queries)], 0.95)
+				}
+			})
+		})
+	}
+}
@@ -25,6 +25,29 @@ func CosineSimilarity(a, b []float64) float64 {
 	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
+// CosineSimilarity32 is CosineSimilarity for float32 vectors, computed in
+// float32. It agrees with CosineSimilarity on the widened vectors to
+// within float32 rounding; see PrecisionFloat32.
+func CosineSimilarity32(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dotProduct, normA, normB float32
+
+	for i := range a {
+		dotProduct += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return float64(dotProduct) / (math.Sqrt(float64(normA)) * math.Sqrt(float64(normB)))
+}
+
 // EuclideanDistance calculates the Euclidean distance between two vectors.
 func EuclideanDistance(a, b []float64) float64 {
 	if len(a) != len(b) || len(a) == 0 {
@@ -40,6 +63,30 @@ func EuclideanDistance(a, b []float64) float64 {
 	return math.Sqrt(sum)
 }
 
+// normTolerance is how far a vector's length may stray from 1 and still
+// count as unit-length.
+const normTolerance = 0.01
+
+// VectorNorm returns the Euclidean length of v.
+func VectorNorm(v []float64) float64 {
+	var sum float64
+	for _, val := range v {
+		sum += val * val
+	}
+	return math.Sqrt(sum)
+}
+
+// UnitLength reports whether v has a length of 1, within a tolerance for
+// rounding.
+func UnitLength(v []float64) bool {
+	return UnitNorm(VectorNorm(v))
+}
+
+// UnitNorm reports whether norm is 1, within a tolerance for rounding.
+func UnitNorm(norm float64) bool {
+	return math.Abs(norm-1) <= normTolerance
+}
+
 // NormalizeVector normalizes a vector to unit length.
 func NormalizeVector(v []float64) []float64 {
 	var norm float64
@@ -212,6 +212,28 @@ func TestNormalizeVector(t *testing.T) {
 	})
 }
 
+func TestUnitLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []float64
+		want  bool
+	}{
+		{name: "unit vector", input: []float64{0.6, 0.8}, want: true},
+		{name: "within rounding", input: []float64{0.6, 0.805}, want: true},
+		{name: "too long", input: []float64{3, 4}, want: false},
+		{name: "too short", input: []float64{0.3, 0.4}, want: false},
+		{name: "zero vector", input: []float64{0, 0}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnitLength(tt.input); got != tt.want {
+				t.Errorf("UnitLength(%v) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
 func BenchmarkCosineSimilarity(b *testing.B) {
 	// Create 768-dimensional vectors (typical embedding size)
 	a := make([]float64, 768)
@@ -226,3 +248,74 @@ func BenchmarkCosineSimilarity(b *testing.B) {
 		CosineSimilarity(a, vecB)
 	}
 }
+
+func TestDotBatch(t *testing.T) {
+	dim := 7 // not a multiple of the unroll width
+	query := make([]float64, dim)
+	matrix := make([]float64, 0, 3*dim)
+	for i := range query {
+		query[i] = float64(i + 1)
+	}
+	rows := [][]float64{
+		{1, 2, 3, 4, 5, 6, 7},
+		{-1, 0.5, 2, 0, 3, -4, 1},
+		{0, 0, 0, 0, 0, 0, 0},
+	}
+	for _, row := range rows {
+		matrix = append(matrix, NormalizeVector(row)...)
+	}
+
+	// Unit-length inputs make the dot product their cosine similarity
+	scores := dotBatch(NormalizeVector(query), matrix, dim)
+	if len(scores) != len(rows) {
+		t.Fatalf("expected %d scores, got %d", len(rows), len(scores))
+	}
+	for i, row := range rows {
+		if want := CosineSimilarity(query, row); math.Abs(scores[i]-want) > 1e-12 {
+			t.Errorf("row %d: expected %f, got %f", i, want, scores[i])
+		}
+	}
+
+	if dotBatch(query[:3], matrix, dim) != nil {
+		t.Error("expected nil scores for a query of the wrong dimension")
+	}
+}
+
+// benchmarkMatrix returns a 768-dimensional query and 1000 stored rows.
+func benchmarkMatrix() ([]float64, [][]float64, []float64) {
+	const dim, n = 768, 1000
+	query := make([]float64, dim)
+	for i := range query {
+		query[i] = float64(i) / dim
+	}
+	rows := make([][]float64, n)
+	flat := make([]float64, 0, n*dim)
+	for r := range rows {
+		rows[r] = make([]float64, dim)
+		for i := range rows[r] {
+			rows[r][i] = float64(r*dim+i) / (n * dim)
+		}
+		flat = append(flat, rows[r]...)
+	}
+	return query, rows, flat
+}
+
+func BenchmarkScanPerEntry(b *testing.B) {
+	query, rows, _ := benchmarkMatrix()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, row := range rows {
+			CosineSimilarity(query, row)
+		}
+	}
+}
+
+func BenchmarkScanBatch(b *testing.B) {
+	query, _, flat := benchmarkMatrix()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dotBatch(query, flat, len(query))
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// Version returns a counter that changes whenever the cache contents change.
+func (m *MemoryCache) Version() uint64 {
+	v := m.version.Load()
+	for _, s := range m.shards {
+		v += s.version.Load()
+	}
+	return v
+}
+
+// Snapshot writes all entries to w as JSON lines and returns the number written.
+func (m *MemoryCache) Snapshot(w io.Writer) (int, error) {
+	// Entries are copied under the lock, since hits keep updating them
+	var entries []api.CacheEntry
+	for _, s := range m.leaves() {
+		s.mu.RLock()
+		for _, e := range s.entries {
+			entries = append(entries, *exportEntry(e))
+		}
+		s.mu.RUnlock()
+	}
+
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return i, fmt.Errorf("failed to encode entry: %w", err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// SnapshotFile atomically writes a snapshot to path.
+// The snapshot is written to a temporary file in the same directory and
+// renamed into place, so a crash mid-write never leaves a corrupt snapshot.
+func (m *MemoryCache) SnapshotFile(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := m.Snapshot(tmp)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+
+	return n, nil
+}
+
+// Restore loads entries written by Snapshot, skipping any that have
+// expired, and returns the number restored. Entries go through Set, so
+// capacity, eviction and the configured index apply as usual.
+func (m *MemoryCache) Restore(r io.Reader) (int, error) {
+	now := time.Now()
+	restored := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry api.CacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return restored, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if err := m.Set(context.Background(), &entry); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	if err := scanner.Err(); err != nil {
+		return restored, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	return restored, nil
+}
+
+// RestoreFile restores a snapshot from path. A missing file is not an
+// error, so the first start with persistence enabled begins empty.
+func (m *MemoryCache) RestoreFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	return m.Restore(f)
+}
+
+// JitteredInterval returns d randomly adjusted by up to ±fraction of d.
+// Jitter keeps replicas started together from snapshotting in lockstep.
+func JitteredInterval(d time.Duration, fraction float64) time.Duration {
+	if d <= 0 || fraction <= 0 {
+		return d
+	}
+	jitter := (rand.Float64()*2 - 1) * fraction * float64(d)
+	return d + time.Duration(jitter)
+}
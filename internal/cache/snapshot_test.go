@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheSnapshot(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Hour))
+	cache.Set(ctx, newTestEntry([]float64{0, 1, 0}, time.Hour))
+
+	var buf bytes.Buffer
+	n, err := cache.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries written, got %d", n)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 JSON lines, got %d", lines)
+	}
+}
+
+func TestMemoryCacheSnapshotDuringHits(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, DefaultTTL: time.Hour, CleanupInterval: time.Hour})
+	ctx := context.Background()
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Hour))
+
+	// Hits update the entry while it is encoded, which -race checks
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			hit, _, _ := cache.Get(ctx, []float64{1, 0, 0}, 0.99)
+			cache.Touch(hit, time.Hour, 0)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := cache.Snapshot(io.Discard); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestMemoryCacheSnapshotFile(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Hour))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.jsonl")
+
+	n, err := cache.SnapshotFile(path)
+	if err != nil {
+		t.Fatalf("SnapshotFile failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 entry written, got %d", n)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected snapshot file to exist: %v", err)
+	}
+
+	// No temp files should be left behind
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the snapshot file in dir, got %d files", len(files))
+	}
+}
+
+func TestMemoryCacheVersion(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+
+	v0 := cache.Version()
+	cache.Set(ctx, newTestEntry([]float64{1, 0, 0}, time.Hour))
+	v1 := cache.Version()
+	if v1 == v0 {
+		t.Error("expected version to change after Set")
+	}
+
+	cache.Get(ctx, []float64{1, 0, 0}, 0.9)
+	if cache.Version() != v1 {
+		t.Error("expected version to be unchanged after Get")
+	}
+
+	if cache.Cleanup(ctx); cache.Version() != v1 {
+		t.Error("expected version to be unchanged when nothing was cleaned up")
+	}
+}
+
+func TestJitteredInterval(t *testing.T) {
+	base := time.Minute
+	for i := 0; i < 100; i++ {
+		d := JitteredInterval(base, 0.1)
+		if d < 54*time.Second || d > 66*time.Second {
+			t.Fatalf("jittered interval %v outside ±10%% of %v", d, base)
+		}
+	}
+
+	if d := JitteredInterval(base, 0); d != base {
+		t.Errorf("expected no jitter with zero fraction, got %v", d)
+	}
+}
+
+func TestMemoryCacheRestore(t *testing.T) {
+	opts := &Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	}
+	source := NewMemoryCache(opts)
+	ctx := context.Background()
+
+	live := newTestEntry([]float64{1, 0, 0}, time.Hour)
+	live.HitCount = 7
+	source.Set(ctx, live)
+	source.Set(ctx, newTestEntry([]float64{0, 1, 0}, -time.Minute))
+
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	if _, err := source.SnapshotFile(path); err != nil {
+		t.Fatalf("SnapshotFile failed: %v", err)
+	}
+
+	restored := NewMemoryCache(opts)
+	n, err := restored.RestoreFile(path)
+	if err != nil {
+		t.Fatalf("RestoreFile failed: %v", err)
+	}
+	if n != 1 || restored.Size(ctx) != 1 {
+		t.Errorf("expected only the unexpired entry to be restored, got %d", n)
+	}
+
+	entry, _, found := restored.Get(ctx, []float64{1, 0, 0}, 0.99)
+	if !found {
+		t.Fatal("expected the restored entry to be found")
+	}
+	if entry.HitCount != 7 || !entry.ExpiresAt.Equal(live.ExpiresAt) {
+		t.Errorf("expected hit count and expiry to survive, got %d and %v", entry.HitCount, entry.ExpiresAt)
+	}
+	if entry.Response.Choices[0].Message.Content != "test response" {
+		t.Error("expected the response to survive")
+	}
+}
+
+func TestMemoryCacheRestoreMissingFile(t *testing.T) {
+	cache := NewMemoryCache(&Options{MaxSize: 10, CleanupInterval: time.Hour})
+	n, err := cache.RestoreFile(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || n != 0 {
+		t.Errorf("expected a missing snapshot to restore nothing, got %d, %v", n, err)
+	}
+
+	if _, err := cache.Restore(bytes.NewReader([]byte("not json\n"))); err == nil {
+		t.Error("expected an error for a corrupt snapshot")
+	}
+}
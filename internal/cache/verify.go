@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// VerifyOptions bounds how audit verdicts adjust an entry.
+type VerifyOptions struct {
+	// Step is how far one verdict moves the entry's threshold offset
+	Step float64
+	// MaxOffset bounds the offset in both directions; an entry that fails
+	// an audit at the strictest offset is evicted
+	MaxOffset float64
+	// Extension is added to the TTL of entries that pass
+	Extension time.Duration
+	// MaxTTL caps the remaining lifetime after an extension (0 = no cap)
+	MaxTTL time.Duration
+}
+
+// VerifyResult reports the effect of a verdict.
+type VerifyResult struct {
+	Found           bool      `json:"found"`
+	Evicted         bool      `json:"evicted"`
+	ThresholdOffset float64   `json:"threshold_offset"`
+	ExpiresAt       time.Time `json:"expires_at,omitempty"`
+}
+
+// Verify records an audit verdict for the entry in ctx's partition whose
+// embedding is near-identical to embedding. A pass lowers the entry's
+// threshold offset by opts.Step and extends its TTL; a failure raises the
+// offset, and evicts the entry once the offset would exceed opts.MaxOffset.
+func (m *MemoryCache) Verify(ctx context.Context, embedding []float64, passed bool, opts VerifyOptions) VerifyResult {
+	for _, s := range m.shards {
+		if result := s.Verify(ctx, embedding, passed, opts); result.Found {
+			return result
+		}
+	}
+	if m.shards != nil {
+		return VerifyResult{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.findDuplicate(&api.CacheEntry{Embedding: embedding, Partition: PartitionFromContext(ctx)})
+	if i < 0 {
+		return VerifyResult{}
+	}
+	entry := m.entries[i]
+	m.version.Add(1)
+
+	if !passed {
+		// The epsilon keeps float rounding from evicting one step early
+		if entry.ThresholdOffset+opts.Step > opts.MaxOffset+1e-9 {
+			m.removeAt(i)
+			return VerifyResult{Found: true, Evicted: true}
+		}
+		entry.ThresholdOffset += opts.Step
+		return VerifyResult{Found: true, ThresholdOffset: entry.ThresholdOffset, ExpiresAt: entry.ExpiresAt}
+	}
+
+	entry.ThresholdOffset -= opts.Step
+	if entry.ThresholdOffset < -opts.MaxOffset {
+		entry.ThresholdOffset = -opts.MaxOffset
+	}
+	entry.ExpiresAt = entry.ExpiresAt.Add(opts.Extension)
+	if limit := time.Now().Add(opts.MaxTTL); opts.MaxTTL > 0 && entry.ExpiresAt.After(limit) {
+		entry.ExpiresAt = limit
+	}
+	return VerifyResult{Found: true, ThresholdOffset: entry.ThresholdOffset, ExpiresAt: entry.ExpiresAt}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheVerify(t *testing.T) {
+	cache := NewMemoryCache(&Options{
+		MaxSize:         10,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	ctx := context.Background()
+	opts := VerifyOptions{Step: 0.01, MaxOffset: 0.02, Extension: time.Hour, MaxTTL: 90 * time.Minute}
+
+	stored := []float64{1, 0}
+	entry := newTestEntry(stored, time.Hour)
+	cache.Set(ctx, entry)
+
+	// A query at similarity ~0.945 misses a 0.95 threshold...
+	query := []float64{0.945, math.Sqrt(1 - 0.945*0.945)}
+	if _, _, found := cache.Get(ctx, query, 0.95); found {
+		t.Fatal("expected a miss before any verdict")
+	}
+
+	// ...but matches once the entry has passed an audit
+	result := cache.Verify(ctx, stored, true, opts)
+	if !result.Found || result.ThresholdOffset != -0.01 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, _, found := cache.Get(ctx, query, 0.95); !found {
+		t.Error("expected a hit after the entry passed an audit")
+	}
+
+	// The boost and TTL are bounded
+	cache.Verify(ctx, stored, true, opts)
+	result = cache.Verify(ctx, stored, true, opts)
+	if result.ThresholdOffset != -0.02 {
+		t.Errorf("expected the offset to be capped at -0.02, got %f", result.ThresholdOffset)
+	}
+	if result.ExpiresAt.After(time.Now().Add(opts.MaxTTL)) {
+		t.Errorf("expected the TTL to be capped, expires at %v", result.ExpiresAt)
+	}
+
+	// Failures demote the entry and finally evict it
+	for i := 0; i < 4; i++ {
+		result = cache.Verify(ctx, stored, false, opts)
+		if result.Evicted {
+			t.Fatalf("expected no eviction before reaching the strictest offset, failed after %d", i+1)
+		}
+	}
+	if result.ThresholdOffset != 0.02 {
+		t.Errorf("expected the offset to reach 0.02, got %f", result.ThresholdOffset)
+	}
+	if result = cache.Verify(ctx, stored, false, opts); !result.Evicted {
+		t.Error("expected a failure at the strictest offset to evict")
+	}
+	if cache.Size(ctx) != 0 {
+		t.Error("expected the entry to be gone")
+	}
+
+	if result := cache.Verify(ctx, stored, true, opts); result.Found {
+		t.Error("expected no entry to be found")
+	}
+}
@@ -2,9 +2,19 @@
 package config
 
 import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/aqstack/mimir/internal/logger"
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/internal/rules"
 )
 
 // Config holds the application configuration.
@@ -14,43 +24,433 @@ type Config struct {
 	Host    string `json:"host"`
 	LogJSON bool   `json:"log_json"`
 
+	// LogLevel is the minimum level logged: "debug", "info", "warn" or "error"
+	LogLevel string `json:"log_level"`
+
+	// Timeouts. WriteTimeout bounds the whole response, so it must exceed
+	// the longest upstream timeout or slow completions are cut off.
+	ServerWriteTimeout time.Duration            `json:"server_write_timeout"`
+	UpstreamTimeout    time.Duration            `json:"upstream_timeout"`
+	RouteTimeouts      map[string]time.Duration `json:"route_timeouts,omitempty"` // path prefix -> timeout
+
+	// EmbedTimeout bounds the embedding call of each lookup; a lookup that
+	// times out is forwarded uncached (no limit when zero)
+	EmbedTimeout time.Duration `json:"embed_timeout"`
+
+	// ReadyTimeout bounds the checks behind /health/ready (no limit when
+	// zero), which also request the upstream's model list when
+	// ReadyCheckUpstream is set
+	ReadyTimeout       time.Duration `json:"ready_timeout"`
+	ReadyCheckUpstream bool          `json:"ready_check_upstream"`
+
+	// DecompressRequests decodes gzip/deflate request bodies before parsing
+	DecompressRequests bool `json:"decompress_requests"`
+
+	// MaxBodyBytes caps request bodies, before and after decompression, and
+	// upstream response bodies (no limit when zero)
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
 	// Embedding settings
-	EmbeddingProvider string `json:"embedding_provider"` // "openai" or "ollama"
+	EmbeddingProvider string `json:"embedding_provider"` // "openai", "azure", "ollama", "hf-tei" or "gemini"
 	EmbeddingModel    string `json:"embedding_model"`
 
+	// ExtraEmbeddingModels are additional models on the same provider that
+	// clients may select per request with X-Mimir-Embed-Model
+	ExtraEmbeddingModels []string `json:"extra_embedding_models,omitempty"`
+	// CompareEmbeddingModel is a candidate model on the same provider whose
+	// would-be hit rate is measured on CompareSampleRate of requests,
+	// judged by CompareThreshold (SimilarityThreshold when zero)
+	CompareEmbeddingModel string  `json:"compare_embedding_model,omitempty"`
+	CompareSampleRate     float64 `json:"compare_sample_rate"`
+	CompareThreshold      float64 `json:"compare_threshold,omitempty"`
+	// EmbedModelHeader reports the lookup's embedding model in responses
+	EmbedModelHeader bool `json:"embed_model_header"`
+	// EmbedRetries retries failed embedding calls with exponential backoff
+	// starting at EmbedRetryBackoff (disabled when zero)
+	EmbedRetries      int           `json:"embed_retries"`
+	EmbedRetryBackoff time.Duration `json:"embed_retry_backoff"`
+
+	// EmbedDedupe embeds each distinct text of a batch only once
+	EmbedDedupe bool `json:"embed_dedupe"`
+	// EmbedBatchWindow collects lookups arriving within this window into
+	// one batch embedding call of up to EmbedBatchSize texts (off when zero)
+	EmbedBatchWindow time.Duration `json:"embed_batch_window"`
+	EmbedBatchSize   int           `json:"embed_batch_size"`
+
 	// OpenAI settings (when provider is "openai")
 	OpenAIAPIKey  string `json:"openai_api_key"`
 	OpenAIBaseURL string `json:"openai_base_url"`
+	OpenAIOrg     string `json:"openai_org"`
+	OpenAIProject string `json:"openai_project"`
+
+	// Azure OpenAI settings (when provider is "azure"). The deployment
+	// defaults to the embedding model's name.
+	AzureOpenAIEndpoint      string `json:"azure_openai_endpoint"`
+	AzureOpenAIAPIKey        string `json:"azure_openai_api_key"`
+	AzureOpenAIAPIVersion    string `json:"azure_openai_api_version"`
+	AzureEmbeddingDeployment string `json:"azure_embedding_deployment"`
+
+	// UpstreamRoutes send requests for matching models to another upstream
+	// instead of OpenAIBaseURL; the first matching route wins
+	UpstreamRoutes []UpstreamRoute `json:"upstream_routes,omitempty"`
+
+	// Upstream fallback for chat completions when the primary fails
+	UpstreamFallbackURL    string `json:"upstream_fallback_url"`
+	UpstreamFallbackAPIKey string `json:"upstream_fallback_api_key"`
+	FallbackModel          string `json:"fallback_model"`
+
+	// UpstreamMaxRetries bounds retries of upstream requests rejected with
+	// 429 or 503. Retries wait for Retry-After when the upstream sends it,
+	// and otherwise back off exponentially from UpstreamRetryBackoff
+	// (disabled when zero).
+	UpstreamMaxRetries   int           `json:"upstream_max_retries"`
+	UpstreamRetryBackoff time.Duration `json:"upstream_retry_backoff"`
 
 	// Ollama settings (when provider is "ollama")
 	OllamaBaseURL string `json:"ollama_base_url"`
 
+	// HuggingFace Text Embeddings Inference settings (when provider is
+	// "hf-tei"). Dimensions are learned from the first embedding when zero.
+	HFTEIBaseURL        string `json:"hf_tei_base_url"`
+	HFTEIAPIKey         string `json:"hf_tei_api_key"`
+	EmbeddingDimensions int    `json:"embedding_dimensions"`
+
+	// Google Gemini settings (when provider is "gemini")
+	GeminiAPIKey  string `json:"gemini_api_key"`
+	GeminiBaseURL string `json:"gemini_base_url"`
+
+	// Anthropic settings for the Messages API at /v1/messages
+	AnthropicAPIKey  string `json:"anthropic_api_key"`
+	AnthropicBaseURL string `json:"anthropic_base_url"`
+
+	// AllowedUpstreamHosts restricts the hosts upstream requests and
+	// embedders may contact; empty allows any host
+	AllowedUpstreamHosts []string `json:"allowed_upstream_hosts,omitempty"`
+
+	// StripInnerHeaders controls X-Mimir-* headers on upstream responses when
+	// the upstream is another mimir: "preserve", "strip" or "namespace"
+	StripInnerHeaders string `json:"strip_inner_headers"`
+
+	// ReplayHeaders are upstream response headers, such as openai-model,
+	// stored with each entry and sent again on hits
+	ReplayHeaders []string `json:"replay_headers,omitempty"`
+
+	// BlockRules reject or flag suspicious requests at the front door
+	BlockRules []BlockRule `json:"block_rules,omitempty"`
+	// BlockLogOnly logs requests matching BlockRules instead of rejecting them
+	BlockLogOnly bool `json:"block_log_only"`
+
+	// AdminToken guards destructive admin endpoints (disabled when empty)
+	AdminToken string `json:"admin_token"`
+	// AuthTokens are the bearer tokens clients must present on every
+	// endpoint but /health and /readyz (open when empty)
+	AuthTokens []string `json:"auth_tokens,omitempty"`
+
+	// CORSOrigins are the browser origins allowed to call mimir ("*" allows
+	// any); other origins get no CORS headers. CORSMethods and CORSHeaders
+	// are the methods and request headers allowed in preflight responses.
+	CORSOrigins []string `json:"cors_origins"`
+	CORSMethods []string `json:"cors_methods"`
+	CORSHeaders []string `json:"cors_headers"`
+
+	// Audit verdicts reported to /admin/verify move an entry's threshold by
+	// VerifyStep within ±VerifyMaxOffset; passes extend its TTL by
+	// VerifyTTLExtension up to VerifyMaxTTL of remaining life
+	VerifyStep         float64       `json:"verify_step"`
+	VerifyMaxOffset    float64       `json:"verify_max_offset"`
+	VerifyTTLExtension time.Duration `json:"verify_ttl_extension"`
+	VerifyMaxTTL       time.Duration `json:"verify_max_ttl"`
+
 	// Cache settings
 	SimilarityThreshold float64       `json:"similarity_threshold"`
 	CacheTTL            time.Duration `json:"cache_ttl"`
 	MaxCacheSize        int           `json:"max_cache_size"`
 
+	// MaxTTL caps every entry's lifetime, whatever its model or source
+	// asks for (no cap when zero)
+	MaxTTL time.Duration `json:"max_ttl"`
+
+	// CacheSlidingTTL extends an entry's expiry by its TTL on every hit, up
+	// to CacheMaxAge after it was stored (memory backend only)
+	CacheSlidingTTL bool          `json:"cache_sliding_ttl"`
+	CacheMaxAge     time.Duration `json:"cache_max_age"`
+
+	// NegativeCacheTTL caches deterministic upstream client errors for this
+	// long, so repeated bad prompts fail fast (disabled when zero)
+	NegativeCacheTTL time.Duration `json:"negative_cache_ttl"`
+
+	// CacheReadOnly serves hits but never stores misses, for read replicas
+	CacheReadOnly bool `json:"cache_read_only"`
+
+	// CompressResponses stores large cached response bodies gzipped
+	CompressResponses bool `json:"compress_responses"`
+
+	// PreloadPath seeds the cache at startup from JSON lines of cache entries
+	PreloadPath string `json:"preload_path,omitempty"`
+	// MaxEmbedConcurrency bounds concurrent embedding calls during preload
+	MaxEmbedConcurrency int `json:"max_embed_concurrency"`
+
+	// MaxUpstreamConcurrency bounds concurrent upstream requests; waiting
+	// requests are served high priority first (unbounded when zero)
+	MaxUpstreamConcurrency int `json:"max_upstream_concurrency"`
+	// DefaultPriority is "high" or "low", for requests without an
+	// X-Mimir-Priority header
+	DefaultPriority string `json:"default_priority"`
+	// WarmupLogEvery logs preload progress every N entries
+	WarmupLogEvery int `json:"warmup_log_every"`
+	// WarmupConcurrency bounds concurrent prompts seeded by /cache/warmup
+	WarmupConcurrency int `json:"warmup_concurrency"`
+
+	// CacheEmbeddings caches /v1/embeddings responses per input text
+	CacheEmbeddings bool `json:"cache_embeddings"`
+
+	// DefaultContextVersion partitions requests that omit X-Mimir-Context-Version
+	DefaultContextVersion string `json:"default_context_version"`
+
+	// Per-model overrides, keyed by request model name
+	ModelTTLs       map[string]time.Duration `json:"model_ttls,omitempty"`
+	ModelThresholds map[string]float64       `json:"model_thresholds,omitempty"`
+
+	// CacheKeyMode is "full" to embed the whole conversation, or
+	// "last-user" to embed only the latest user message
+	CacheKeyMode string `json:"cache_key_mode"`
+	// CacheKeyRoles limits the messages embedded for the cache key to these
+	// roles (all roles when empty)
+	CacheKeyRoles []string `json:"cache_key_roles,omitempty"`
+	// CacheKeyStrip is a regular expression whose matches, such as
+	// timestamps or request IDs, are removed from the cache key
+	CacheKeyStrip string `json:"cache_key_strip,omitempty"`
+
+	// ImageKeyStrategy controls how image parts affect the cache key:
+	// "ignore", "url" or "content"
+	ImageKeyStrategy string `json:"image_key_strategy"`
+	// ToolCaching is "strict" to key entries by tools, tool_choice and
+	// parallel_tool_calls, or "skip" to never cache requests using tools
+	ToolCaching string `json:"tool_caching"`
+	// PrefillCaching is "strict" to key entries by a trailing assistant
+	// prefill, or "skip" to never cache prefill requests
+	PrefillCaching string `json:"prefill_caching"`
+	// MaxCacheTemperature is the highest temperature a request may set and
+	// still be cached; requests that set none are always cacheable
+	MaxCacheTemperature float64 `json:"max_cache_temperature"`
+	// TemperatureKey keys entries by the request's temperature, so an answer
+	// sampled at one temperature never serves a request for another
+	TemperatureKey bool `json:"temperature_key"`
+	// FetchImages downloads remote images to hash their content
+	FetchImages bool `json:"fetch_images"`
+	// MaxEmbedChars bounds the cache key length sent to the embedder; longer
+	// keys are handled per LongPromptMode (unlimited when zero)
+	MaxEmbedChars int `json:"max_embed_chars"`
+	// LongPromptMode is "skip" to forward prompts over MaxEmbedChars
+	// uncached, or "truncate" to embed only their last MaxEmbedChars
+	LongPromptMode string `json:"long_prompt_mode"`
+
+	// BatchSimilarity scores lookups against a contiguous copy of all vectors
+	BatchSimilarity bool `json:"batch_similarity"`
+
+	// IndexType selects the lookup index: "linear" or "hnsw"
+	IndexType string `json:"index_type"`
+
+	// CacheShards splits the memory backend into this many independently
+	// locked shards searched in parallel (a single lock when 0 or 1)
+	CacheShards int `json:"cache_shards"`
+
+	// SimilarityMetric selects how embeddings are compared: "cosine",
+	// "dot" or "euclidean" (thresholds become maximum distances)
+	SimilarityMetric string `json:"similarity_metric"`
+
+	// NormCheck watches for embeddings that are not unit-length under the
+	// dot metric: "off", "warn" to sample stored vectors every
+	// NormCheckInterval, or "reject" to also refuse to cache them
+	NormCheck         string        `json:"norm_check"`
+	NormCheckInterval time.Duration `json:"norm_check_interval"`
+
+	// RecencyHalfLife halves an entry's match score for every half-life of
+	// age, favoring fresh entries (disabled when zero)
+	RecencyHalfLife time.Duration `json:"recency_half_life"`
+
+	// RetainRawEmbeddings keeps the original vector next to the normalized
+	// one in each cache entry, for export and re-ranking
+	RetainRawEmbeddings bool `json:"retain_raw_embeddings"`
+
+	// EmbeddingPrecision stores cached vectors as "float64" or "float32",
+	// a quarter of the memory at a negligible accuracy cost
+	EmbeddingPrecision string `json:"embedding_precision"`
+
+	// ErrorLogSize bounds the dead-letter log of failed requests
+	// (disabled when zero)
+	ErrorLogSize int `json:"error_log_size"`
+
+	// SeenPromptsSize bounds the prompt set used for the steady-state hit rate
+	SeenPromptsSize int `json:"seen_prompts_size"`
+
+	// SavingsRetentionDays is how many days of per-model totals the savings
+	// report can cover
+	SavingsRetentionDays int `json:"savings_retention_days"`
+	// PricingJSON overrides or extends the built-in per-model prices, as a
+	// JSON object of {"input": ..., "output": ...} USD per 1K tokens
+	PricingJSON string `json:"pricing_json,omitempty"`
+
+	// Eviction policy when the cache is full: "lru", "lfu", "diversity" or
+	// "ttl-lru"
+	EvictionPolicy      string `json:"eviction_policy"`
+	DiversityCandidates int    `json:"diversity_candidates"`
+
+	// GhostSize keeps this many evicted entries resurrectable for GhostTTL
+	// (memory backend only, disabled when zero)
+	GhostSize int           `json:"ghost_size"`
+	GhostTTL  time.Duration `json:"ghost_ttl"`
+
+	// CleanupInterval is how often expired entries are removed, give or
+	// take 10% (never when zero). The memory backend checks
+	// CleanupChunkSize entries per lock hold (all at once when zero)
+	CleanupInterval  time.Duration `json:"cleanup_interval"`
+	CleanupChunkSize int           `json:"cleanup_chunk_size"`
+
+	// SlowRequestThreshold logs requests slower than this at WARN (disabled when zero)
+	SlowRequestThreshold time.Duration `json:"slow_request_threshold"`
+
+	// CacheRules are JSONPath conditions deciding whether a request may be cached
+	CacheRules []rules.Rule `json:"cache_rules,omitempty"`
+
+	// NoCacheModels are model patterns, with * as a wildcard, whose
+	// requests are always forwarded without a lookup or a store
+	NoCacheModels []string `json:"no_cache_models,omitempty"`
+
+	// StreamIncludeUsage requests token usage on streaming completions even
+	// when the client did not, stripping the extra chunk from the response
+	StreamIncludeUsage bool `json:"stream_include_usage"`
+
+	// CacheStreams caches streamed completions and replays hits as a
+	// synthetic stream
+	CacheStreams bool `json:"cache_streams"`
+
+	// StreamPace spaces out the content chunks of replayed streams, each
+	// StreamPaceTokens words long (disabled when zero)
+	StreamPace       time.Duration `json:"stream_pace"`
+	StreamPaceTokens int           `json:"stream_pace_tokens"`
+
+	// CoalesceMisses shares one upstream call among concurrent identical
+	// cache misses
+	CoalesceMisses bool `json:"coalesce_misses"`
+
+	// InjectCacheMeta adds an x_mimir object to the body of cache hits
+	InjectCacheMeta bool `json:"inject_cache_meta"`
+
+	// ConditionalRequests tags cached responses with an ETag and answers
+	// a matching If-None-Match with 304 Not Modified
+	ConditionalRequests bool `json:"conditional_requests"`
+
+	// FallbackMessage is served as a canned completion when the upstream
+	// fails and nothing is cached (disabled when empty)
+	FallbackMessage string `json:"fallback_message,omitempty"`
+
+	// Response size band for caching (0 disables the bound)
+	MinCacheResponseTokens int `json:"min_cache_response_tokens"`
+	MaxCacheResponseTokens int `json:"max_cache_response_tokens"`
+
+	// Cache backend: "memory" (default), "redis" to share one cache
+	// between replicas, "qdrant" to search a Qdrant collection, or
+	// "postgres" to store entries in a pgvector table
+	CacheBackend     string `json:"cache_backend"`
+	RedisURL         string `json:"redis_url,omitempty"`
+	QdrantURL        string `json:"qdrant_url,omitempty"`
+	QdrantCollection string `json:"qdrant_collection"`
+	QdrantAPIKey     string `json:"qdrant_api_key,omitempty"`
+	PostgresDSN      string `json:"postgres_dsn,omitempty"`
+	PostgresTable    string `json:"postgres_table"`
+
+	// Persistence settings
+	CachePersistPath string        `json:"cache_persist_path"`
+	SnapshotInterval time.Duration `json:"snapshot_interval"` // 0 disables periodic snapshots
+
 	// Metrics settings
 	MetricsEnabled bool `json:"metrics_enabled"`
 	MetricsPort    int  `json:"metrics_port"`
+	// MetricsReport adds the rest of the dashboard report to /metrics:
+	// savings, hit similarity and per-model totals
+	MetricsReport bool `json:"metrics_report"`
+
+	// HourlyHitRateTarget is the hit rate (0-1) each clock hour should reach;
+	// hours below it are posted to AlertWebhookURL (disabled when zero)
+	HourlyHitRateTarget float64 `json:"hourly_hit_rate_target"`
+	AlertWebhookURL     string  `json:"alert_webhook_url,omitempty"`
+
+	// OTelEndpoint is the OTLP/HTTP collector chat request traces are
+	// exported to (tracing is off when empty)
+	OTelEndpoint string `json:"otel_endpoint,omitempty"`
+
+	// loadErrs records values that failed to parse in LoadFromEnv
+	loadErrs []*ConfigError
 }
 
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		Port:              8080,
-		Host:              "0.0.0.0",
-		LogJSON:           false,
-		EmbeddingProvider: "ollama", // default to free local embeddings
-		EmbeddingModel:    "nomic-embed-text",
-		OpenAIAPIKey:      "",
-		OpenAIBaseURL:     "https://api.openai.com/v1",
-		OllamaBaseURL:     "http://localhost:11434",
-		SimilarityThreshold: 0.95,
-		CacheTTL:            time.Hour * 24,
-		MaxCacheSize:        10000,
-		MetricsEnabled:      true,
-		MetricsPort:         9090,
+		Port:                 8080,
+		Host:                 "0.0.0.0",
+		LogJSON:              false,
+		LogLevel:             "info",
+		EmbeddingProvider:    "ollama", // default to free local embeddings
+		EmbeddingModel:       "nomic-embed-text",
+		OpenAIAPIKey:         "",
+		OpenAIBaseURL:        "https://api.openai.com/v1",
+		OllamaBaseURL:        "http://localhost:11434",
+		GeminiBaseURL:        "https://generativelanguage.googleapis.com/v1beta",
+		AnthropicBaseURL:     "https://api.anthropic.com",
+		SimilarityThreshold:  0.95,
+		CompareSampleRate:    0.1,
+		CacheTTL:             time.Hour * 24,
+		MaxCacheSize:         10000,
+		CacheBackend:         "memory",
+		QdrantCollection:     "mimir",
+		PostgresTable:        "mimir_cache",
+		IndexType:            "linear",
+		CacheShards:          16,
+		SimilarityMetric:     "cosine",
+		EmbeddingPrecision:   "float64",
+		NormCheck:            "off",
+		NormCheckInterval:    time.Hour,
+		VerifyStep:           0.01,
+		VerifyMaxOffset:      0.03,
+		VerifyTTLExtension:   24 * time.Hour,
+		VerifyMaxTTL:         7 * 24 * time.Hour,
+		CacheMaxAge:          7 * 24 * time.Hour,
+		MetricsEnabled:       true,
+		MetricsPort:          9090,
+		CORSOrigins:          []string{"*"},
+		CORSMethods:          []string{"GET", "POST", "OPTIONS"},
+		CORSHeaders:          []string{"Content-Type", "Authorization", "X-Mimir-Context-Version", "X-Mimir-Embed-Model"},
+		DecompressRequests:   true,
+		MaxBodyBytes:         10 << 20,
+		CacheStreams:         true,
+		StreamPaceTokens:     1,
+		CoalesceMisses:       true,
+		ServerWriteTimeout:   2 * time.Minute,
+		UpstreamTimeout:      2 * time.Minute,
+		EmbedTimeout:         10 * time.Second,
+		ReadyTimeout:         2 * time.Second,
+		EvictionPolicy:       "lru",
+		DiversityCandidates:  32,
+		GhostTTL:             time.Minute,
+		CleanupInterval:      5 * time.Minute,
+		CleanupChunkSize:     1000,
+		SeenPromptsSize:      10000,
+		ErrorLogSize:         100,
+		SavingsRetentionDays: 90,
+		CacheKeyMode:         "full",
+		ImageKeyStrategy:     "ignore",
+		ToolCaching:          "strict",
+		PrefillCaching:       "strict",
+		LongPromptMode:       "skip",
+		MaxEmbedConcurrency:  4,
+		DefaultPriority:      "low",
+		EmbedRetryBackoff:    200 * time.Millisecond,
+		UpstreamRetryBackoff: 500 * time.Millisecond,
+		EmbedDedupe:          true,
+		EmbedBatchSize:       64,
+		WarmupLogEvery:       500,
+		WarmupConcurrency:    4,
 	}
 }
 
@@ -72,6 +472,65 @@ func LoadFromEnv() *Config {
 		cfg.LogJSON = true
 	}
 
+	if level := os.Getenv("MIMIR_LOG_LEVEL"); level != "" {
+		cfg.LogLevel = level
+	}
+
+	if writeTimeout := os.Getenv("MIMIR_SERVER_WRITE_TIMEOUT"); writeTimeout != "" {
+		if d, err := time.ParseDuration(writeTimeout); err == nil {
+			cfg.ServerWriteTimeout = d
+		}
+	}
+
+	if upstreamTimeout := os.Getenv("MIMIR_UPSTREAM_TIMEOUT"); upstreamTimeout != "" {
+		if d, err := time.ParseDuration(upstreamTimeout); err == nil {
+			cfg.UpstreamTimeout = d
+		}
+	}
+
+	if readyTimeout := os.Getenv("MIMIR_READY_TIMEOUT"); readyTimeout != "" {
+		if d, err := time.ParseDuration(readyTimeout); err == nil {
+			cfg.ReadyTimeout = d
+		}
+	}
+
+	if checkUpstream := os.Getenv("MIMIR_READY_CHECK_UPSTREAM"); checkUpstream == "true" {
+		cfg.ReadyCheckUpstream = true
+	}
+
+	if embedTimeout := os.Getenv("MIMIR_EMBED_TIMEOUT"); embedTimeout != "" {
+		if d, err := time.ParseDuration(embedTimeout); err == nil {
+			cfg.EmbedTimeout = d
+		}
+	}
+
+	if routeTimeouts := os.Getenv("MIMIR_ROUTE_TIMEOUTS"); routeTimeouts != "" {
+		timeouts, err := parseDurationMap(routeTimeouts)
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_ROUTE_TIMEOUTS", Message: err.Error()})
+		} else {
+			cfg.RouteTimeouts = timeouts
+		}
+	}
+
+	if decompress := os.Getenv("MIMIR_DECOMPRESS_REQUESTS"); decompress == "false" {
+		cfg.DecompressRequests = false
+	}
+
+	if maxBody := os.Getenv("MIMIR_MAX_BODY_BYTES"); maxBody != "" {
+		if n, err := strconv.ParseInt(maxBody, 10, 64); err == nil {
+			cfg.MaxBodyBytes = n
+		}
+	}
+
+	if streams := os.Getenv("MIMIR_CACHE_STREAMS"); streams == "false" {
+		cfg.CacheStreams = false
+	}
+
+	if coalesce := os.Getenv("MIMIR_COALESCE_MISSES"); coalesce == "false" {
+		cfg.CoalesceMisses = false
+	}
+
 	if provider := os.Getenv("MIMIR_EMBEDDING_PROVIDER"); provider != "" {
 		cfg.EmbeddingProvider = provider
 	}
@@ -80,6 +539,34 @@ func LoadFromEnv() *Config {
 		cfg.EmbeddingModel = model
 	}
 
+	if model := os.Getenv("MIMIR_COMPARE_EMBEDDING_MODEL"); model != "" {
+		cfg.CompareEmbeddingModel = model
+	}
+
+	if rate := os.Getenv("MIMIR_COMPARE_SAMPLE_RATE"); rate != "" {
+		if r, err := strconv.ParseFloat(rate, 64); err == nil {
+			cfg.CompareSampleRate = r
+		}
+	}
+
+	if threshold := os.Getenv("MIMIR_COMPARE_THRESHOLD"); threshold != "" {
+		if t, err := strconv.ParseFloat(threshold, 64); err == nil {
+			cfg.CompareThreshold = t
+		}
+	}
+
+	if extra := os.Getenv("MIMIR_EXTRA_EMBEDDING_MODELS"); extra != "" {
+		for _, model := range strings.Split(extra, ",") {
+			if model = strings.TrimSpace(model); model != "" {
+				cfg.ExtraEmbeddingModels = append(cfg.ExtraEmbeddingModels, model)
+			}
+		}
+	}
+
+	if header := os.Getenv("MIMIR_EMBED_MODEL_HEADER"); header == "true" {
+		cfg.EmbedModelHeader = true
+	}
+
 	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
 		cfg.OpenAIAPIKey = apiKey
 		// Auto-switch to OpenAI if API key is provided
@@ -95,10 +582,131 @@ func LoadFromEnv() *Config {
 		cfg.OpenAIBaseURL = baseURL
 	}
 
+	if org := os.Getenv("MIMIR_OPENAI_ORG"); org != "" {
+		cfg.OpenAIOrg = org
+	}
+
+	if project := os.Getenv("MIMIR_OPENAI_PROJECT"); project != "" {
+		cfg.OpenAIProject = project
+	}
+
+	if endpoint := os.Getenv("AZURE_OPENAI_ENDPOINT"); endpoint != "" {
+		cfg.AzureOpenAIEndpoint = endpoint
+	}
+
+	if apiKey := os.Getenv("AZURE_OPENAI_API_KEY"); apiKey != "" {
+		cfg.AzureOpenAIAPIKey = apiKey
+	}
+
+	if version := os.Getenv("AZURE_OPENAI_API_VERSION"); version != "" {
+		cfg.AzureOpenAIAPIVersion = version
+	}
+
+	if deployment := os.Getenv("MIMIR_AZURE_EMBEDDING_DEPLOYMENT"); deployment != "" {
+		cfg.AzureEmbeddingDeployment = deployment
+	}
+
+	// Azure serves OpenAI models, so the Ollama default model does not apply
+	if cfg.EmbeddingProvider == "azure" && os.Getenv("MIMIR_EMBEDDING_MODEL") == "" {
+		cfg.EmbeddingModel = "text-embedding-3-small"
+	}
+
+	if routes := os.Getenv("MIMIR_UPSTREAM_ROUTES"); routes != "" {
+		parsed, err := parseUpstreamRoutes(routes, os.Getenv("MIMIR_UPSTREAM_ROUTE_KEYS"))
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_UPSTREAM_ROUTES", Message: err.Error()})
+		} else {
+			cfg.UpstreamRoutes = parsed
+		}
+	}
+
+	if fallbackURL := os.Getenv("MIMIR_UPSTREAM_FALLBACK_URL"); fallbackURL != "" {
+		cfg.UpstreamFallbackURL = fallbackURL
+	}
+
+	if fallbackKey := os.Getenv("MIMIR_UPSTREAM_FALLBACK_API_KEY"); fallbackKey != "" {
+		cfg.UpstreamFallbackAPIKey = fallbackKey
+	}
+
+	if fallbackModel := os.Getenv("MIMIR_FALLBACK_MODEL"); fallbackModel != "" {
+		cfg.FallbackModel = fallbackModel
+	}
+
+	if retries := os.Getenv("MIMIR_UPSTREAM_MAX_RETRIES"); retries != "" {
+		if n, err := strconv.Atoi(retries); err == nil {
+			cfg.UpstreamMaxRetries = n
+		}
+	}
+
+	if backoff := os.Getenv("MIMIR_UPSTREAM_RETRY_BACKOFF"); backoff != "" {
+		if d, err := time.ParseDuration(backoff); err == nil {
+			cfg.UpstreamRetryBackoff = d
+		}
+	}
+
 	if ollamaURL := os.Getenv("OLLAMA_BASE_URL"); ollamaURL != "" {
 		cfg.OllamaBaseURL = ollamaURL
 	}
 
+	if teiURL := os.Getenv("HF_TEI_BASE_URL"); teiURL != "" {
+		cfg.HFTEIBaseURL = teiURL
+	}
+
+	if teiKey := os.Getenv("HF_TEI_API_KEY"); teiKey != "" {
+		cfg.HFTEIAPIKey = teiKey
+	}
+
+	if geminiKey := os.Getenv("GEMINI_API_KEY"); geminiKey != "" {
+		cfg.GeminiAPIKey = geminiKey
+	}
+
+	if geminiURL := os.Getenv("GEMINI_BASE_URL"); geminiURL != "" {
+		cfg.GeminiBaseURL = geminiURL
+	}
+
+	if dims := os.Getenv("MIMIR_EMBEDDING_DIMENSIONS"); dims != "" {
+		if n, err := strconv.Atoi(dims); err == nil {
+			cfg.EmbeddingDimensions = n
+		}
+	}
+
+	// A TEI server serves the model it was started with; without a name,
+	// label it rather than borrowing the Ollama default
+	if cfg.EmbeddingProvider == "hf-tei" && os.Getenv("MIMIR_EMBEDDING_MODEL") == "" {
+		cfg.EmbeddingModel = "tei"
+	}
+	if cfg.EmbeddingProvider == "gemini" && os.Getenv("MIMIR_EMBEDDING_MODEL") == "" {
+		cfg.EmbeddingModel = "text-embedding-004"
+	}
+
+	if apiKey := os.Getenv("ANTHROPIC_API_KEY"); apiKey != "" {
+		cfg.AnthropicAPIKey = apiKey
+	}
+
+	if baseURL := os.Getenv("ANTHROPIC_BASE_URL"); baseURL != "" {
+		cfg.AnthropicBaseURL = baseURL
+	}
+
+	if hosts := os.Getenv("MIMIR_ALLOWED_UPSTREAM_HOSTS"); hosts != "" {
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				cfg.AllowedUpstreamHosts = append(cfg.AllowedUpstreamHosts, strings.ToLower(host))
+			}
+		}
+	}
+
+	if strip := os.Getenv("MIMIR_STRIP_INNER_HEADERS"); strip != "" {
+		cfg.StripInnerHeaders = strip
+	}
+
+	if replay := os.Getenv("MIMIR_REPLAY_HEADERS"); replay != "" {
+		for _, name := range strings.Split(replay, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.ReplayHeaders = append(cfg.ReplayHeaders, name)
+			}
+		}
+	}
+
 	if threshold := os.Getenv("MIMIR_SIMILARITY_THRESHOLD"); threshold != "" {
 		if t, err := strconv.ParseFloat(threshold, 64); err == nil {
 			cfg.SimilarityThreshold = t
@@ -111,40 +719,1164 @@ func LoadFromEnv() *Config {
 		}
 	}
 
+	if maxTTL := os.Getenv("MIMIR_MAX_TTL"); maxTTL != "" {
+		if d, err := time.ParseDuration(maxTTL); err == nil {
+			cfg.MaxTTL = d
+		}
+	}
+
+	if sliding := os.Getenv("MIMIR_CACHE_SLIDING_TTL"); sliding == "true" {
+		cfg.CacheSlidingTTL = true
+	}
+
+	if maxAge := os.Getenv("MIMIR_CACHE_MAX_AGE"); maxAge != "" {
+		if d, err := time.ParseDuration(maxAge); err == nil {
+			cfg.CacheMaxAge = d
+		}
+	}
+
+	if ttl := os.Getenv("MIMIR_NEGATIVE_CACHE_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil {
+			cfg.NegativeCacheTTL = d
+		}
+	}
+
 	if maxSize := os.Getenv("MIMIR_MAX_CACHE_SIZE"); maxSize != "" {
 		if s, err := strconv.Atoi(maxSize); err == nil {
 			cfg.MaxCacheSize = s
 		}
 	}
 
-	if metricsEnabled := os.Getenv("MIMIR_METRICS_ENABLED"); metricsEnabled == "false" {
-		cfg.MetricsEnabled = false
+	if slow := os.Getenv("MIMIR_SLOW_REQUEST_MS"); slow != "" {
+		if ms, err := strconv.Atoi(slow); err == nil {
+			cfg.SlowRequestThreshold = time.Duration(ms) * time.Millisecond
+		}
 	}
 
-	if metricsPort := os.Getenv("MIMIR_METRICS_PORT"); metricsPort != "" {
-		if p, err := strconv.Atoi(metricsPort); err == nil {
-			cfg.MetricsPort = p
+	if pace := os.Getenv("MIMIR_STREAM_PACE_MS"); pace != "" {
+		if ms, err := strconv.Atoi(pace); err == nil {
+			cfg.StreamPace = time.Duration(ms) * time.Millisecond
 		}
 	}
 
-	return cfg
-}
+	if tokens := os.Getenv("MIMIR_STREAM_PACE_TOKENS"); tokens != "" {
+		if n, err := strconv.Atoi(tokens); err == nil {
+			cfg.StreamPaceTokens = n
+		}
+	}
 
-// Validate validates the configuration.
-func (c *Config) Validate() error {
-	if c.EmbeddingProvider != "openai" && c.EmbeddingProvider != "ollama" {
-		return &ConfigError{Field: "MIMIR_EMBEDDING_PROVIDER", Message: "must be 'openai' or 'ollama'"}
+	if blockRules := os.Getenv("MIMIR_BLOCK_RULES"); blockRules != "" {
+		parsed, err := parseBlockRules(blockRules)
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_BLOCK_RULES", Message: err.Error()})
+		} else {
+			cfg.BlockRules = parsed
+		}
 	}
-	if c.EmbeddingProvider == "openai" && c.OpenAIAPIKey == "" {
-		return &ConfigError{Field: "OPENAI_API_KEY", Message: "required when using OpenAI provider"}
+
+	if mode := os.Getenv("MIMIR_BLOCK_MODE"); mode == "log" {
+		cfg.BlockLogOnly = true
 	}
-	if c.SimilarityThreshold < 0 || c.SimilarityThreshold > 1 {
-		return &ConfigError{Field: "MIMIR_SIMILARITY_THRESHOLD", Message: "must be between 0 and 1"}
+
+	if token := os.Getenv("MIMIR_ADMIN_TOKEN"); token != "" {
+		cfg.AdminToken = token
 	}
-	if c.MaxCacheSize < 1 {
-		return &ConfigError{Field: "MIMIR_MAX_CACHE_SIZE", Message: "must be at least 1"}
+
+	if tokens := os.Getenv("MIMIR_AUTH_TOKEN"); tokens != "" {
+		for _, token := range strings.Split(tokens, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				cfg.AuthTokens = append(cfg.AuthTokens, token)
+			}
+		}
 	}
-	return nil
+
+	if origins := splitList(os.Getenv("MIMIR_CORS_ORIGINS")); len(origins) > 0 {
+		cfg.CORSOrigins = origins
+	}
+	if methods := splitList(os.Getenv("MIMIR_CORS_METHODS")); len(methods) > 0 {
+		cfg.CORSMethods = methods
+	}
+	if headers := splitList(os.Getenv("MIMIR_CORS_HEADERS")); len(headers) > 0 {
+		cfg.CORSHeaders = headers
+	}
+
+	if step := os.Getenv("MIMIR_VERIFY_STEP"); step != "" {
+		if f, err := strconv.ParseFloat(step, 64); err == nil {
+			cfg.VerifyStep = f
+		}
+	}
+
+	if maxOffset := os.Getenv("MIMIR_VERIFY_MAX_OFFSET"); maxOffset != "" {
+		if f, err := strconv.ParseFloat(maxOffset, 64); err == nil {
+			cfg.VerifyMaxOffset = f
+		}
+	}
+
+	if extension := os.Getenv("MIMIR_VERIFY_TTL_EXTENSION"); extension != "" {
+		if d, err := time.ParseDuration(extension); err == nil {
+			cfg.VerifyTTLExtension = d
+		}
+	}
+
+	if maxTTL := os.Getenv("MIMIR_VERIFY_MAX_TTL"); maxTTL != "" {
+		if d, err := time.ParseDuration(maxTTL); err == nil {
+			cfg.VerifyMaxTTL = d
+		}
+	}
+
+	if usage := os.Getenv("MIMIR_STREAM_INCLUDE_USAGE"); usage == "true" {
+		cfg.StreamIncludeUsage = true
+	}
+
+	if inject := os.Getenv("MIMIR_INJECT_CACHE_META"); inject == "true" {
+		cfg.InjectCacheMeta = true
+	}
+
+	if conditional := os.Getenv("MIMIR_CONDITIONAL_REQUESTS"); conditional == "true" {
+		cfg.ConditionalRequests = true
+	}
+
+	if msg := os.Getenv("MIMIR_FALLBACK_MESSAGE"); msg != "" {
+		cfg.FallbackMessage = msg
+	}
+
+	if mode := os.Getenv("MIMIR_TOOL_CACHING"); mode != "" {
+		cfg.ToolCaching = mode
+	}
+
+	if mode := os.Getenv("MIMIR_PREFILL_CACHING"); mode != "" {
+		cfg.PrefillCaching = mode
+	}
+
+	if temp := os.Getenv("MIMIR_MAX_CACHE_TEMPERATURE"); temp != "" {
+		if t, err := strconv.ParseFloat(temp, 64); err == nil {
+			cfg.MaxCacheTemperature = t
+		}
+	}
+
+	if key := os.Getenv("MIMIR_TEMPERATURE_KEY"); key == "true" {
+		cfg.TemperatureKey = true
+	}
+
+	if chars := os.Getenv("MIMIR_MAX_EMBED_CHARS"); chars != "" {
+		if n, err := strconv.Atoi(chars); err == nil {
+			cfg.MaxEmbedChars = n
+		}
+	}
+
+	if mode := os.Getenv("MIMIR_LONG_PROMPT_MODE"); mode != "" {
+		cfg.LongPromptMode = mode
+	}
+
+	if mode := os.Getenv("MIMIR_CACHE_KEY_MODE"); mode != "" {
+		cfg.CacheKeyMode = mode
+	}
+
+	if roles := os.Getenv("MIMIR_CACHE_KEY_ROLES"); roles != "" {
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				cfg.CacheKeyRoles = append(cfg.CacheKeyRoles, role)
+			}
+		}
+	}
+
+	if strip := os.Getenv("MIMIR_CACHE_KEY_STRIP"); strip != "" {
+		cfg.CacheKeyStrip = strip
+	}
+
+	if strategy := os.Getenv("MIMIR_IMAGE_KEY_STRATEGY"); strategy != "" {
+		cfg.ImageKeyStrategy = strategy
+	}
+
+	if fetch := os.Getenv("MIMIR_IMAGE_FETCH"); fetch == "true" {
+		cfg.FetchImages = true
+	}
+
+	if batch := os.Getenv("MIMIR_BATCH_SIMILARITY"); batch == "true" {
+		cfg.BatchSimilarity = true
+	}
+
+	if indexType := os.Getenv("MIMIR_INDEX_TYPE"); indexType != "" {
+		cfg.IndexType = indexType
+	}
+
+	if shards := os.Getenv("MIMIR_CACHE_SHARDS"); shards != "" {
+		if n, err := strconv.Atoi(shards); err == nil {
+			cfg.CacheShards = n
+		}
+	}
+
+	if metric := os.Getenv("MIMIR_SIMILARITY_METRIC"); metric != "" {
+		cfg.SimilarityMetric = metric
+	}
+
+	if check := os.Getenv("MIMIR_NORM_CHECK"); check != "" {
+		cfg.NormCheck = check
+	}
+
+	if interval := os.Getenv("MIMIR_NORM_CHECK_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			cfg.NormCheckInterval = d
+		}
+	}
+
+	if halfLife := os.Getenv("MIMIR_RECENCY_HALFLIFE"); halfLife != "" {
+		if d, err := time.ParseDuration(halfLife); err == nil {
+			cfg.RecencyHalfLife = d
+		}
+	}
+
+	if retain := os.Getenv("MIMIR_RETAIN_RAW_EMBEDDINGS"); retain == "true" {
+		cfg.RetainRawEmbeddings = true
+	}
+
+	if precision := os.Getenv("MIMIR_EMBEDDING_PRECISION"); precision != "" {
+		cfg.EmbeddingPrecision = precision
+	}
+
+	if seen := os.Getenv("MIMIR_SEEN_PROMPTS_SIZE"); seen != "" {
+		if n, err := strconv.Atoi(seen); err == nil {
+			cfg.SeenPromptsSize = n
+		}
+	}
+
+	if size := os.Getenv("MIMIR_ERROR_LOG_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.ErrorLogSize = n
+		}
+	}
+
+	if retention := os.Getenv("MIMIR_SAVINGS_RETENTION_DAYS"); retention != "" {
+		if n, err := strconv.Atoi(retention); err == nil {
+			cfg.SavingsRetentionDays = n
+		}
+	}
+
+	if pricing := os.Getenv("MIMIR_PRICING_JSON"); pricing != "" {
+		cfg.PricingJSON = pricing
+	}
+
+	if policy := os.Getenv("MIMIR_EVICTION_POLICY"); policy != "" {
+		cfg.EvictionPolicy = policy
+	}
+
+	if candidates := os.Getenv("MIMIR_DIVERSITY_CANDIDATES"); candidates != "" {
+		if n, err := strconv.Atoi(candidates); err == nil {
+			cfg.DiversityCandidates = n
+		}
+	}
+
+	if size := os.Getenv("MIMIR_GHOST_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.GhostSize = n
+		}
+	}
+
+	if ttl := os.Getenv("MIMIR_GHOST_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil {
+			cfg.GhostTTL = d
+		}
+	}
+
+	if interval := os.Getenv("MIMIR_CLEANUP_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			cfg.CleanupInterval = d
+		}
+	}
+
+	if size := os.Getenv("MIMIR_CLEANUP_CHUNK_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.CleanupChunkSize = n
+		}
+	}
+
+	if readOnly := os.Getenv("MIMIR_CACHE_READONLY"); readOnly == "true" {
+		cfg.CacheReadOnly = true
+	}
+
+	if compress := os.Getenv("MIMIR_COMPRESS_RESPONSES"); compress == "true" {
+		cfg.CompressResponses = true
+	}
+
+	if preload := os.Getenv("MIMIR_PRELOAD_PATH"); preload != "" {
+		cfg.PreloadPath = preload
+	}
+
+	if retries := os.Getenv("MIMIR_EMBED_RETRIES"); retries != "" {
+		if n, err := strconv.Atoi(retries); err == nil {
+			cfg.EmbedRetries = n
+		}
+	}
+
+	if backoff := os.Getenv("MIMIR_EMBED_RETRY_BACKOFF"); backoff != "" {
+		if d, err := time.ParseDuration(backoff); err == nil {
+			cfg.EmbedRetryBackoff = d
+		}
+	}
+
+	if dedupe := os.Getenv("MIMIR_EMBED_DEDUPE"); dedupe == "false" {
+		cfg.EmbedDedupe = false
+	}
+
+	if window := os.Getenv("MIMIR_EMBED_BATCH_WINDOW"); window != "" {
+		if d, err := time.ParseDuration(window); err == nil {
+			cfg.EmbedBatchWindow = d
+		}
+	}
+
+	if size := os.Getenv("MIMIR_EMBED_BATCH_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.EmbedBatchSize = n
+		}
+	}
+
+	if concurrency := os.Getenv("MIMIR_MAX_EMBED_CONCURRENCY"); concurrency != "" {
+		if n, err := strconv.Atoi(concurrency); err == nil {
+			cfg.MaxEmbedConcurrency = n
+		}
+	}
+
+	if concurrency := os.Getenv("MIMIR_MAX_UPSTREAM_CONCURRENCY"); concurrency != "" {
+		if n, err := strconv.Atoi(concurrency); err == nil {
+			cfg.MaxUpstreamConcurrency = n
+		}
+	}
+
+	if priority := os.Getenv("MIMIR_DEFAULT_PRIORITY"); priority != "" {
+		cfg.DefaultPriority = priority
+	}
+
+	if every := os.Getenv("MIMIR_WARMUP_LOG_EVERY"); every != "" {
+		if n, err := strconv.Atoi(every); err == nil {
+			cfg.WarmupLogEvery = n
+		}
+	}
+
+	if concurrency := os.Getenv("MIMIR_WARMUP_CONCURRENCY"); concurrency != "" {
+		if n, err := strconv.Atoi(concurrency); err == nil {
+			cfg.WarmupConcurrency = n
+		}
+	}
+
+	if cacheEmbeddings := os.Getenv("MIMIR_CACHE_EMBEDDINGS"); cacheEmbeddings == "true" {
+		cfg.CacheEmbeddings = true
+	}
+
+	if contextVersion := os.Getenv("MIMIR_DEFAULT_CONTEXT_VERSION"); contextVersion != "" {
+		cfg.DefaultContextVersion = contextVersion
+	}
+
+	if cacheRules := os.Getenv("MIMIR_CACHE_RULES"); cacheRules != "" {
+		parsed, err := rules.Parse(cacheRules)
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_CACHE_RULES", Message: err.Error()})
+		} else {
+			cfg.CacheRules = parsed
+		}
+	}
+
+	if noCache := os.Getenv("MIMIR_NO_CACHE_MODELS"); noCache != "" {
+		cfg.NoCacheModels = splitList(noCache)
+	}
+
+	if modelTTLs := os.Getenv("MIMIR_MODEL_TTLS"); modelTTLs != "" {
+		ttls, err := parseDurationMap(modelTTLs)
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_MODEL_TTLS", Message: err.Error()})
+		} else {
+			cfg.ModelTTLs = ttls
+		}
+	}
+
+	if modelThresholds := os.Getenv("MIMIR_MODEL_THRESHOLDS"); modelThresholds != "" {
+		thresholds, err := parseFloatMap(modelThresholds)
+		if err != nil {
+			cfg.loadErrs = append(cfg.loadErrs, &ConfigError{Field: "MIMIR_MODEL_THRESHOLDS", Message: err.Error()})
+		} else {
+			cfg.ModelThresholds = thresholds
+		}
+	}
+
+	if minTokens := os.Getenv("MIMIR_MIN_CACHE_RESPONSE_TOKENS"); minTokens != "" {
+		if n, err := strconv.Atoi(minTokens); err == nil {
+			cfg.MinCacheResponseTokens = n
+		}
+	}
+
+	if maxTokens := os.Getenv("MIMIR_MAX_CACHE_RESPONSE_TOKENS"); maxTokens != "" {
+		if n, err := strconv.Atoi(maxTokens); err == nil {
+			cfg.MaxCacheResponseTokens = n
+		}
+	}
+
+	if backend := os.Getenv("MIMIR_CACHE_BACKEND"); backend != "" {
+		cfg.CacheBackend = backend
+	}
+
+	if redisURL := os.Getenv("MIMIR_REDIS_URL"); redisURL != "" {
+		cfg.RedisURL = redisURL
+	}
+
+	if qdrantURL := os.Getenv("MIMIR_QDRANT_URL"); qdrantURL != "" {
+		cfg.QdrantURL = qdrantURL
+	}
+
+	if collection := os.Getenv("MIMIR_QDRANT_COLLECTION"); collection != "" {
+		cfg.QdrantCollection = collection
+	}
+
+	if apiKey := os.Getenv("MIMIR_QDRANT_API_KEY"); apiKey != "" {
+		cfg.QdrantAPIKey = apiKey
+	}
+
+	if dsn := os.Getenv("MIMIR_POSTGRES_DSN"); dsn != "" {
+		cfg.PostgresDSN = dsn
+	}
+
+	if table := os.Getenv("MIMIR_POSTGRES_TABLE"); table != "" {
+		cfg.PostgresTable = table
+	}
+
+	if persistPath := os.Getenv("MIMIR_CACHE_PERSIST_PATH"); persistPath != "" {
+		cfg.CachePersistPath = persistPath
+	}
+
+	if interval := os.Getenv("MIMIR_SNAPSHOT_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			cfg.SnapshotInterval = d
+		}
+	}
+
+	if metricsEnabled := os.Getenv("MIMIR_METRICS_ENABLED"); metricsEnabled == "false" {
+		cfg.MetricsEnabled = false
+	}
+
+	if report := os.Getenv("MIMIR_METRICS_REPORT"); report == "true" {
+		cfg.MetricsReport = true
+	}
+
+	if metricsPort := os.Getenv("MIMIR_METRICS_PORT"); metricsPort != "" {
+		if p, err := strconv.Atoi(metricsPort); err == nil {
+			cfg.MetricsPort = p
+		}
+	}
+
+	if target := os.Getenv("MIMIR_HOURLY_HIT_RATE_TARGET"); target != "" {
+		if t, err := strconv.ParseFloat(target, 64); err == nil {
+			cfg.HourlyHitRateTarget = t
+		}
+	}
+
+	if webhook := os.Getenv("MIMIR_ALERT_WEBHOOK_URL"); webhook != "" {
+		cfg.AlertWebhookURL = webhook
+	}
+
+	if endpoint := os.Getenv("MIMIR_OTEL_ENDPOINT"); endpoint != "" {
+		cfg.OTelEndpoint = endpoint
+	}
+
+	return cfg
+}
+
+// parseKeyValueList parses "key:value,key:value" into a map of raw values.
+// The value is split on the last colon so model tags like "llama3:8b" work.
+func parseKeyValueList(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idx := strings.LastIndex(pair, ":")
+		if idx <= 0 || idx == len(pair)-1 {
+			return nil, fmt.Errorf("invalid entry %q, expected key:value", pair)
+		}
+		result[strings.TrimSpace(pair[:idx])] = strings.TrimSpace(pair[idx+1:])
+	}
+	return result, nil
+}
+
+// parseDurationMap parses "key:duration,..." into a map of durations.
+func parseDurationMap(s string) (map[string]time.Duration, error) {
+	raw, err := parseKeyValueList(s)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]time.Duration, len(raw))
+	for model, v := range raw {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q for %s", v, model)
+		}
+		result[model] = d
+	}
+	return result, nil
+}
+
+// parseFloatMap parses "key:number,..." into a map of floats.
+func parseFloatMap(s string) (map[string]float64, error) {
+	raw, err := parseKeyValueList(s)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64, len(raw))
+	for model, v := range raw {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q for %s", v, model)
+		}
+		result[model] = f
+	}
+	return result, nil
+}
+
+// postgresTablePattern matches the table names the postgres backend
+// accepts, which are used in statements unquoted.
+var postgresTablePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// Block rule kinds.
+const (
+	BlockNoUserAgent = "no-user-agent"
+	BlockUserAgent   = "user-agent"
+	BlockPath        = "path"
+	BlockMaxBody     = "max-body"
+)
+
+// UpstreamRoute sends requests whose model matches Pattern to URL. Pattern
+// is a model name in which * matches any run of characters, so "gpt-*" is
+// a prefix match. APIKey, when set, replaces the client's key.
+type UpstreamRoute struct {
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+	APIKey  string `json:"api_key,omitempty"`
+}
+
+// parseUpstreamRoutes parses "pattern:url,..." routes, in order, with the
+// API keys in "pattern:key,..." keys. Patterns may contain colons, as in
+// "llama3:8b", so each route is split at the colon before the URL scheme.
+func parseUpstreamRoutes(routes, keys string) ([]UpstreamRoute, error) {
+	var parsed []UpstreamRoute
+	for _, item := range strings.Split(routes, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		scheme := strings.Index(item, "://")
+		if scheme < 0 {
+			return nil, fmt.Errorf("invalid route %q, expected pattern:url", item)
+		}
+		sep := strings.LastIndex(item[:scheme], ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("invalid route %q, expected pattern:url", item)
+		}
+		parsed = append(parsed, UpstreamRoute{
+			Pattern: strings.TrimSpace(item[:sep]),
+			URL:     strings.TrimSpace(item[sep+1:]),
+		})
+	}
+
+	if keys == "" {
+		return parsed, nil
+	}
+	byPattern, err := parseKeyValueList(keys)
+	if err != nil {
+		return nil, fmt.Errorf("MIMIR_UPSTREAM_ROUTE_KEYS: %w", err)
+	}
+	for pattern, key := range byPattern {
+		found := false
+		for i := range parsed {
+			if parsed[i].Pattern == pattern {
+				parsed[i].APIKey = key
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("MIMIR_UPSTREAM_ROUTE_KEYS: no route for %q", pattern)
+		}
+	}
+	return parsed, nil
+}
+
+// UpstreamFor returns the first route whose pattern matches model, or false
+// when requests for model go to OpenAIBaseURL.
+func (c *Config) UpstreamFor(model string) (UpstreamRoute, bool) {
+	if model == "" {
+		return UpstreamRoute{}, false
+	}
+	for _, route := range c.UpstreamRoutes {
+		if matchModel(route.Pattern, model) {
+			return route, true
+		}
+	}
+	return UpstreamRoute{}, false
+}
+
+// NoCache reports whether requests for model must bypass the cache, as
+// it matches one of NoCacheModels.
+func (c *Config) NoCache(model string) bool {
+	for _, pattern := range c.NoCacheModels {
+		if matchModel(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchModel reports whether model matches pattern, where * matches any
+// run of characters, including none.
+func matchModel(pattern, model string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == model
+	}
+	if !strings.HasPrefix(model, parts[0]) {
+		return false
+	}
+	model = model[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(model, part)
+		if i < 0 {
+			return false
+		}
+		model = model[i+len(part):]
+	}
+	return strings.HasSuffix(model, parts[len(parts)-1])
+}
+
+// BlockRule is a single front-door guardrail. Value is a substring for
+// user-agent, a path prefix for path and a byte limit for max-body.
+type BlockRule struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value,omitempty"`
+	Limit int64  `json:"limit,omitempty"`
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseBlockRules parses a comma-separated list of "kind" or "kind:value"
+// rules, e.g. "no-user-agent,path:/.env,max-body:1048576".
+func parseBlockRules(s string) ([]BlockRule, error) {
+	var rules []BlockRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, value, _ := strings.Cut(item, ":")
+		rule := BlockRule{Kind: kind, Value: value}
+		switch kind {
+		case BlockNoUserAgent:
+		case BlockUserAgent, BlockPath:
+			if value == "" {
+				return nil, fmt.Errorf("rule %q needs a value", item)
+			}
+		case BlockMaxBody:
+			limit, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || limit <= 0 {
+				return nil, fmt.Errorf("rule %q needs a positive byte limit", item)
+			}
+			rule.Limit = limit
+		default:
+			return nil, fmt.Errorf("unknown rule %q", item)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// TTLForModel returns the cache TTL for a model, falling back to CacheTTL,
+// capped at MaxTTL.
+func (c *Config) TTLForModel(model string) time.Duration {
+	if ttl, ok := c.ModelTTLs[model]; ok {
+		return c.ClampTTL(ttl)
+	}
+	return c.ClampTTL(c.CacheTTL)
+}
+
+// ClampTTL caps ttl at MaxTTL when a cap is set.
+func (c *Config) ClampTTL(ttl time.Duration) time.Duration {
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return ttl
+}
+
+// cosineMetric reports whether lookups use cosine similarity.
+func (c *Config) cosineMetric() bool {
+	return c.SimilarityMetric == "" || c.SimilarityMetric == "cosine"
+}
+
+// externalBackend reports whether entries live outside the process, in
+// Redis, Qdrant or Postgres, which only support cosine lookups on float64
+// vectors.
+func (c *Config) externalBackend() bool {
+	switch c.CacheBackend {
+	case "redis", "qdrant", "postgres":
+		return true
+	}
+	return false
+}
+
+// thresholdInRange reports whether t is a valid threshold for the metric:
+// a similarity between 0 and 1 for cosine, a non-negative distance for
+// euclidean, and any score for dot products.
+func (c *Config) thresholdInRange(t float64) bool {
+	switch c.SimilarityMetric {
+	case "dot":
+		return !math.IsNaN(t) && !math.IsInf(t, 0)
+	case "euclidean":
+		return t >= 0 && !math.IsInf(t, 0)
+	}
+	return t >= 0 && t <= 1
+}
+
+// CheckThreshold returns a *ConfigError when t is not a valid similarity
+// threshold for the metric, for thresholds set at runtime.
+func (c *Config) CheckThreshold(t float64) error {
+	if !c.thresholdInRange(t) {
+		return &ConfigError{Field: "threshold", Message: c.thresholdRange()}
+	}
+	return nil
+}
+
+// thresholdRange describes the valid thresholds for the metric.
+func (c *Config) thresholdRange() string {
+	switch c.SimilarityMetric {
+	case "dot":
+		return "must be a finite number"
+	case "euclidean":
+		return "must be a non-negative distance"
+	}
+	return "must be between 0 and 1"
+}
+
+// ThresholdForModel returns the similarity threshold for a model, falling
+// back to SimilarityThreshold.
+func (c *Config) ThresholdForModel(model string) float64 {
+	if threshold, ok := c.ModelThresholds[model]; ok {
+		return threshold
+	}
+	return c.SimilarityThreshold
+}
+
+// TimeoutForPath returns the upstream timeout for a request path, using the
+// longest matching prefix in RouteTimeouts and falling back to UpstreamTimeout.
+func (c *Config) TimeoutForPath(path string) time.Duration {
+	timeout := c.UpstreamTimeout
+	longest := -1
+	for prefix, d := range c.RouteTimeouts {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			timeout = d
+			longest = len(prefix)
+		}
+	}
+	return timeout
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if len(c.loadErrs) > 0 {
+		return c.loadErrs[0]
+	}
+	if c.LogLevel != "" {
+		if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+			return &ConfigError{Field: "MIMIR_LOG_LEVEL", Message: "must be 'debug', 'info', 'warn' or 'error'"}
+		}
+	}
+	switch c.EmbeddingProvider {
+	case "openai", "azure", "ollama", "hf-tei", "gemini":
+	default:
+		return &ConfigError{Field: "MIMIR_EMBEDDING_PROVIDER", Message: "must be 'openai', 'azure', 'ollama', 'hf-tei' or 'gemini'"}
+	}
+	if c.CompareEmbeddingModel != "" {
+		if c.CompareSampleRate <= 0 || c.CompareSampleRate > 1 {
+			return &ConfigError{Field: "MIMIR_COMPARE_SAMPLE_RATE", Message: "must be between 0 (exclusive) and 1"}
+		}
+		if c.CompareThreshold < 0 || c.CompareThreshold > 1 {
+			return &ConfigError{Field: "MIMIR_COMPARE_THRESHOLD", Message: "must be between 0 and 1"}
+		}
+	}
+	if c.EmbeddingDimensions < 0 {
+		return &ConfigError{Field: "MIMIR_EMBEDDING_DIMENSIONS", Message: "must not be negative"}
+	}
+	if c.EmbeddingProvider == "openai" && c.OpenAIAPIKey == "" {
+		return &ConfigError{Field: "OPENAI_API_KEY", Message: "required when using OpenAI provider"}
+	}
+	if c.EmbeddingProvider == "gemini" && c.GeminiAPIKey == "" {
+		return &ConfigError{Field: "GEMINI_API_KEY", Message: "required when using Gemini provider"}
+	}
+	if c.EmbeddingProvider == "hf-tei" && c.HFTEIBaseURL == "" {
+		return &ConfigError{Field: "HF_TEI_BASE_URL", Message: "required when using HF TEI provider"}
+	}
+	if c.EmbeddingProvider == "azure" {
+		if c.AzureOpenAIEndpoint == "" {
+			return &ConfigError{Field: "AZURE_OPENAI_ENDPOINT", Message: "required when using Azure provider"}
+		}
+		if c.AzureOpenAIAPIKey == "" {
+			return &ConfigError{Field: "AZURE_OPENAI_API_KEY", Message: "required when using Azure provider"}
+		}
+	}
+	upstreams := []struct{ field, url string }{
+		{"OPENAI_BASE_URL", c.OpenAIBaseURL},
+		{"MIMIR_UPSTREAM_FALLBACK_URL", c.UpstreamFallbackURL},
+	}
+	if c.EmbeddingProvider == "ollama" {
+		upstreams = append(upstreams, struct{ field, url string }{"OLLAMA_BASE_URL", c.OllamaBaseURL})
+	}
+	if c.EmbeddingProvider == "azure" {
+		upstreams = append(upstreams, struct{ field, url string }{"AZURE_OPENAI_ENDPOINT", c.AzureOpenAIEndpoint})
+	}
+	if c.EmbeddingProvider == "hf-tei" {
+		upstreams = append(upstreams, struct{ field, url string }{"HF_TEI_BASE_URL", c.HFTEIBaseURL})
+	}
+	if c.EmbeddingProvider == "gemini" {
+		upstreams = append(upstreams, struct{ field, url string }{"GEMINI_BASE_URL", c.GeminiBaseURL})
+	}
+	if c.AnthropicAPIKey != "" {
+		upstreams = append(upstreams, struct{ field, url string }{"ANTHROPIC_BASE_URL", c.AnthropicBaseURL})
+	}
+	for _, route := range c.UpstreamRoutes {
+		if route.Pattern == "" || route.URL == "" {
+			return &ConfigError{Field: "MIMIR_UPSTREAM_ROUTES", Message: "each route needs a model pattern and a URL"}
+		}
+		upstreams = append(upstreams, struct{ field, url string }{"MIMIR_UPSTREAM_ROUTES", route.URL})
+	}
+	for _, u := range upstreams {
+		if u.url == "" {
+			continue
+		}
+		if err := c.UpstreamAllowed(u.url); err != nil {
+			return &ConfigError{Field: u.field, Message: err.Error()}
+		}
+	}
+	switch c.SimilarityMetric {
+	case "", "cosine", "dot", "euclidean":
+	default:
+		return &ConfigError{Field: "MIMIR_SIMILARITY_METRIC", Message: "must be 'cosine', 'dot' or 'euclidean'"}
+	}
+	switch c.NormCheck {
+	case "", "off":
+	case "warn", "reject":
+		if c.NormCheckInterval <= 0 {
+			return &ConfigError{Field: "MIMIR_NORM_CHECK_INTERVAL", Message: "must be positive when the norm check is enabled"}
+		}
+	default:
+		return &ConfigError{Field: "MIMIR_NORM_CHECK", Message: "must be 'off', 'warn' or 'reject'"}
+	}
+	if !c.thresholdInRange(c.SimilarityThreshold) {
+		return &ConfigError{Field: "MIMIR_SIMILARITY_THRESHOLD", Message: c.thresholdRange()}
+	}
+	if c.HourlyHitRateTarget < 0 || c.HourlyHitRateTarget > 1 {
+		return &ConfigError{Field: "MIMIR_HOURLY_HIT_RATE_TARGET", Message: "must be between 0 and 1"}
+	}
+	if c.HourlyHitRateTarget > 0 {
+		if u, err := url.Parse(c.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ConfigError{Field: "MIMIR_ALERT_WEBHOOK_URL", Message: "must be an http(s) URL when MIMIR_HOURLY_HIT_RATE_TARGET is set"}
+		}
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return &ConfigError{Field: "MIMIR_CORS_ORIGINS", Message: fmt.Sprintf("%q is not \"*\" or an origin like https://app.example.com", origin)}
+		}
+	}
+	if c.OTelEndpoint != "" {
+		if u, err := url.Parse(c.OTelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ConfigError{Field: "MIMIR_OTEL_ENDPOINT", Message: "must be an http(s) URL"}
+		}
+	}
+	if c.MaxCacheSize < 1 {
+		return &ConfigError{Field: "MIMIR_MAX_CACHE_SIZE", Message: "must be at least 1"}
+	}
+	if c.UpstreamMaxRetries < 0 {
+		return &ConfigError{Field: "MIMIR_UPSTREAM_MAX_RETRIES", Message: "must not be negative"}
+	}
+	if c.UpstreamMaxRetries > 0 && c.UpstreamRetryBackoff <= 0 {
+		return &ConfigError{Field: "MIMIR_UPSTREAM_RETRY_BACKOFF", Message: "must be positive when retries are enabled"}
+	}
+	if c.ErrorLogSize < 0 {
+		return &ConfigError{Field: "MIMIR_ERROR_LOG_SIZE", Message: "must not be negative"}
+	}
+	if c.PricingJSON != "" {
+		if _, err := reports.ParsePricing(c.PricingJSON); err != nil {
+			return &ConfigError{Field: "MIMIR_PRICING_JSON", Message: err.Error()}
+		}
+	}
+	if c.SavingsRetentionDays < 0 {
+		return &ConfigError{Field: "MIMIR_SAVINGS_RETENTION_DAYS", Message: "must not be negative"}
+	}
+	if c.EmbedRetries < 0 {
+		return &ConfigError{Field: "MIMIR_EMBED_RETRIES", Message: "must not be negative"}
+	}
+	if c.EmbedRetries > 0 && c.EmbedRetryBackoff <= 0 {
+		return &ConfigError{Field: "MIMIR_EMBED_RETRY_BACKOFF", Message: "must be positive when retries are enabled"}
+	}
+	if c.EmbedBatchWindow < 0 {
+		return &ConfigError{Field: "MIMIR_EMBED_BATCH_WINDOW", Message: "must not be negative"}
+	}
+	if c.EmbedBatchWindow > 0 && c.EmbedBatchSize < 1 {
+		return &ConfigError{Field: "MIMIR_EMBED_BATCH_SIZE", Message: "must be positive when batching is enabled"}
+	}
+	if c.MaxEmbedConcurrency < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_EMBED_CONCURRENCY", Message: "must not be negative"}
+	}
+	if c.WarmupConcurrency < 0 {
+		return &ConfigError{Field: "MIMIR_WARMUP_CONCURRENCY", Message: "must not be negative"}
+	}
+	if c.MaxUpstreamConcurrency < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_UPSTREAM_CONCURRENCY", Message: "must not be negative"}
+	}
+	switch c.DefaultPriority {
+	case "", "high", "low":
+	default:
+		return &ConfigError{Field: "MIMIR_DEFAULT_PRIORITY", Message: "must be 'high' or 'low'"}
+	}
+	for _, name := range c.ReplayHeaders {
+		switch canonical := http.CanonicalHeaderKey(name); {
+		case strings.HasPrefix(canonical, "X-Mimir-"):
+			return &ConfigError{Field: "MIMIR_REPLAY_HEADERS", Message: fmt.Sprintf("%s is set by mimir itself", name)}
+		case canonical == "Content-Length" || canonical == "Content-Type" || canonical == "Content-Encoding" ||
+			canonical == "Transfer-Encoding" || canonical == "Connection":
+			return &ConfigError{Field: "MIMIR_REPLAY_HEADERS", Message: fmt.Sprintf("%s describes the body and cannot be replayed", name)}
+		}
+	}
+	switch c.CacheKeyMode {
+	case "", "full", "last-user":
+	default:
+		return &ConfigError{Field: "MIMIR_CACHE_KEY_MODE", Message: "must be 'full' or 'last-user'"}
+	}
+	for _, role := range c.CacheKeyRoles {
+		switch role {
+		case "system", "developer", "user", "assistant", "tool", "function":
+		default:
+			return &ConfigError{Field: "MIMIR_CACHE_KEY_ROLES", Message: fmt.Sprintf("unknown role %q", role)}
+		}
+	}
+	if _, err := regexp.Compile(c.CacheKeyStrip); err != nil {
+		return &ConfigError{Field: "MIMIR_CACHE_KEY_STRIP", Message: err.Error()}
+	}
+	switch c.ToolCaching {
+	case "", "strict", "skip":
+	default:
+		return &ConfigError{Field: "MIMIR_TOOL_CACHING", Message: "must be 'strict' or 'skip'"}
+	}
+	switch c.PrefillCaching {
+	case "", "strict", "skip":
+	default:
+		return &ConfigError{Field: "MIMIR_PREFILL_CACHING", Message: "must be 'strict' or 'skip'"}
+	}
+	if c.MaxCacheTemperature < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_CACHE_TEMPERATURE", Message: "must not be negative"}
+	}
+	if c.MaxEmbedChars < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_EMBED_CHARS", Message: "must not be negative"}
+	}
+	switch c.LongPromptMode {
+	case "", "skip", "truncate":
+	default:
+		return &ConfigError{Field: "MIMIR_LONG_PROMPT_MODE", Message: "must be 'skip' or 'truncate'"}
+	}
+	switch c.ImageKeyStrategy {
+	case "", "ignore", "url", "content":
+	default:
+		return &ConfigError{Field: "MIMIR_IMAGE_KEY_STRATEGY", Message: "must be 'ignore', 'url' or 'content'"}
+	}
+	if c.VerifyStep < 0 {
+		return &ConfigError{Field: "MIMIR_VERIFY_STEP", Message: "must not be negative"}
+	}
+	if c.VerifyMaxOffset < 0 || c.VerifyMaxOffset > 0.5 {
+		return &ConfigError{Field: "MIMIR_VERIFY_MAX_OFFSET", Message: "must be between 0 and 0.5"}
+	}
+	switch c.StripInnerHeaders {
+	case "", "preserve", "strip", "namespace":
+	default:
+		return &ConfigError{Field: "MIMIR_STRIP_INNER_HEADERS", Message: "must be 'preserve', 'strip' or 'namespace'"}
+	}
+	switch c.IndexType {
+	case "", "linear", "hnsw":
+	default:
+		return &ConfigError{Field: "MIMIR_INDEX_TYPE", Message: "must be 'linear' or 'hnsw'"}
+	}
+	if c.IndexType == "hnsw" && !c.cosineMetric() {
+		return &ConfigError{Field: "MIMIR_INDEX_TYPE", Message: "hnsw requires MIMIR_SIMILARITY_METRIC=cosine"}
+	}
+	if c.externalBackend() && !c.cosineMetric() {
+		return &ConfigError{Field: "MIMIR_SIMILARITY_METRIC", Message: "the " + c.CacheBackend + " backend only supports cosine"}
+	}
+	switch c.EmbeddingPrecision {
+	case "", "float64":
+	case "float32":
+		switch {
+		case c.externalBackend():
+			return &ConfigError{Field: "MIMIR_EMBEDDING_PRECISION", Message: "float32 is not supported by the " + c.CacheBackend + " backend"}
+		case !c.cosineMetric():
+			return &ConfigError{Field: "MIMIR_EMBEDDING_PRECISION", Message: "float32 requires MIMIR_SIMILARITY_METRIC=cosine"}
+		case c.IndexType == "hnsw":
+			return &ConfigError{Field: "MIMIR_EMBEDDING_PRECISION", Message: "float32 is not supported with MIMIR_INDEX_TYPE=hnsw"}
+		case c.RetainRawEmbeddings:
+			return &ConfigError{Field: "MIMIR_EMBEDDING_PRECISION", Message: "float32 cannot be combined with MIMIR_RETAIN_RAW_EMBEDDINGS"}
+		}
+	default:
+		return &ConfigError{Field: "MIMIR_EMBEDDING_PRECISION", Message: "must be 'float64' or 'float32'"}
+	}
+	if c.RecencyHalfLife < 0 {
+		return &ConfigError{Field: "MIMIR_RECENCY_HALFLIFE", Message: "must not be negative"}
+	}
+	if c.externalBackend() && c.RecencyHalfLife > 0 {
+		return &ConfigError{Field: "MIMIR_RECENCY_HALFLIFE", Message: "not supported by the " + c.CacheBackend + " backend"}
+	}
+	switch c.EvictionPolicy {
+	case "", "lru", "lfu", "diversity", "ttl-lru":
+	default:
+		return &ConfigError{Field: "MIMIR_EVICTION_POLICY", Message: "must be 'lru', 'lfu', 'diversity' or 'ttl-lru'"}
+	}
+	if c.StreamPace < 0 {
+		return &ConfigError{Field: "MIMIR_STREAM_PACE_MS", Message: "must not be negative"}
+	}
+	if c.StreamPace > 0 && c.StreamPaceTokens <= 0 {
+		return &ConfigError{Field: "MIMIR_STREAM_PACE_TOKENS", Message: "must be positive when pacing is enabled"}
+	}
+	if c.GhostSize < 0 {
+		return &ConfigError{Field: "MIMIR_GHOST_SIZE", Message: "must not be negative"}
+	}
+	if c.GhostSize > 0 && c.GhostTTL <= 0 {
+		return &ConfigError{Field: "MIMIR_GHOST_TTL", Message: "must be positive when ghosts are enabled"}
+	}
+	if c.GhostSize > 0 && c.externalBackend() {
+		return &ConfigError{Field: "MIMIR_GHOST_SIZE", Message: "not supported by the " + c.CacheBackend + " backend"}
+	}
+	if c.CleanupInterval < 0 {
+		return &ConfigError{Field: "MIMIR_CLEANUP_INTERVAL", Message: "must not be negative"}
+	}
+	if c.MaxBodyBytes < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_BODY_BYTES", Message: "must not be negative"}
+	}
+	if c.CacheShards < 0 {
+		return &ConfigError{Field: "MIMIR_CACHE_SHARDS", Message: "must not be negative"}
+	}
+	if c.CleanupChunkSize < 0 {
+		return &ConfigError{Field: "MIMIR_CLEANUP_CHUNK_SIZE", Message: "must not be negative"}
+	}
+	if c.ReadyTimeout < 0 {
+		return &ConfigError{Field: "MIMIR_READY_TIMEOUT", Message: "must not be negative"}
+	}
+	if c.EmbedTimeout < 0 {
+		return &ConfigError{Field: "MIMIR_EMBED_TIMEOUT", Message: "must not be negative"}
+	}
+	for prefix, d := range c.RouteTimeouts {
+		if d <= 0 {
+			return &ConfigError{Field: "MIMIR_ROUTE_TIMEOUTS", Message: "timeout for " + prefix + " must be positive"}
+		}
+	}
+	if c.MaxTTL < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_TTL", Message: "must not be negative"}
+	}
+	if c.MaxTTL > 0 && c.CacheTTL > c.MaxTTL {
+		return &ConfigError{Field: "MIMIR_CACHE_TTL", Message: "must not exceed MIMIR_MAX_TTL (" + c.MaxTTL.String() + ")"}
+	}
+	if c.CacheMaxAge < 0 {
+		return &ConfigError{Field: "MIMIR_CACHE_MAX_AGE", Message: "must not be negative"}
+	}
+	if c.NegativeCacheTTL < 0 {
+		return &ConfigError{Field: "MIMIR_NEGATIVE_CACHE_TTL", Message: "must not be negative"}
+	}
+	if c.CacheSlidingTTL && c.externalBackend() {
+		return &ConfigError{Field: "MIMIR_CACHE_SLIDING_TTL", Message: "not supported by the " + c.CacheBackend + " backend"}
+	}
+	for model, ttl := range c.ModelTTLs {
+		if ttl <= 0 {
+			return &ConfigError{Field: "MIMIR_MODEL_TTLS", Message: "TTL for model " + model + " must be positive"}
+		}
+	}
+	for model, threshold := range c.ModelThresholds {
+		if !c.thresholdInRange(threshold) {
+			return &ConfigError{Field: "MIMIR_MODEL_THRESHOLDS", Message: "threshold for model " + model + " " + c.thresholdRange()}
+		}
+	}
+	if c.MinCacheResponseTokens < 0 {
+		return &ConfigError{Field: "MIMIR_MIN_CACHE_RESPONSE_TOKENS", Message: "must not be negative"}
+	}
+	if c.MaxCacheResponseTokens < 0 {
+		return &ConfigError{Field: "MIMIR_MAX_CACHE_RESPONSE_TOKENS", Message: "must not be negative"}
+	}
+	if c.MaxCacheResponseTokens > 0 && c.MinCacheResponseTokens > c.MaxCacheResponseTokens {
+		return &ConfigError{Field: "MIMIR_MIN_CACHE_RESPONSE_TOKENS", Message: "must not exceed MIMIR_MAX_CACHE_RESPONSE_TOKENS"}
+	}
+	switch c.CacheBackend {
+	case "", "memory":
+	case "redis":
+		if c.RedisURL == "" {
+			return &ConfigError{Field: "MIMIR_REDIS_URL", Message: "is required when MIMIR_CACHE_BACKEND is 'redis'"}
+		}
+	case "qdrant":
+		if c.QdrantURL == "" {
+			return &ConfigError{Field: "MIMIR_QDRANT_URL", Message: "is required when MIMIR_CACHE_BACKEND is 'qdrant'"}
+		}
+		if c.QdrantCollection == "" {
+			return &ConfigError{Field: "MIMIR_QDRANT_COLLECTION", Message: "is required when MIMIR_CACHE_BACKEND is 'qdrant'"}
+		}
+	case "postgres":
+		if c.PostgresDSN == "" {
+			return &ConfigError{Field: "MIMIR_POSTGRES_DSN", Message: "is required when MIMIR_CACHE_BACKEND is 'postgres'"}
+		}
+		if !postgresTablePattern.MatchString(c.PostgresTable) {
+			return &ConfigError{Field: "MIMIR_POSTGRES_TABLE", Message: "must be a lowercase identifier of letters, digits and underscores"}
+		}
+	default:
+		return &ConfigError{Field: "MIMIR_CACHE_BACKEND", Message: "must be 'memory', 'redis', 'qdrant' or 'postgres'"}
+	}
+	if c.SnapshotInterval < 0 {
+		return &ConfigError{Field: "MIMIR_SNAPSHOT_INTERVAL", Message: "must not be negative"}
+	}
+	if c.SnapshotInterval > 0 && c.CachePersistPath == "" {
+		return &ConfigError{Field: "MIMIR_SNAPSHOT_INTERVAL", Message: "requires MIMIR_CACHE_PERSIST_PATH"}
+	}
+	return nil
+}
+
+// MetricsOnMainPort reports whether /metrics is served by the main server
+// rather than a dedicated listener on MetricsPort.
+func (c *Config) MetricsOnMainPort() bool {
+	return c.MetricsPort == 0 || c.MetricsPort == c.Port
+}
+
+// UpstreamAllowed reports an error unless rawURL is an absolute http(s) URL
+// whose host is permitted by AllowedUpstreamHosts. Entries match a hostname
+// exactly, a host:port pair exactly, or with a leading "*." any subdomain.
+func (c *Config) UpstreamAllowed(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL, got %q", rawURL)
+	}
+	if len(c.AllowedUpstreamHosts) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	hostPort := strings.ToLower(u.Host)
+	for _, allowed := range c.AllowedUpstreamHosts {
+		if allowed == host || allowed == hostPort {
+			return nil
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not in MIMIR_ALLOWED_UPSTREAM_HOSTS", u.Host)
 }
 
 // ConfigError represents a configuration error.
@@ -47,6 +47,18 @@ func TestLoadFromEnv(t *testing.T) {
 		"MIMIR_CACHE_TTL":            os.Getenv("MIMIR_CACHE_TTL"),
 		"MIMIR_MAX_CACHE_SIZE":       os.Getenv("MIMIR_MAX_CACHE_SIZE"),
 		"OPENAI_API_KEY":             os.Getenv("OPENAI_API_KEY"),
+		"MIMIR_CACHE_PERSIST_PATH":   os.Getenv("MIMIR_CACHE_PERSIST_PATH"),
+		"MIMIR_SNAPSHOT_INTERVAL":    os.Getenv("MIMIR_SNAPSHOT_INTERVAL"),
+		"MIMIR_MODEL_TTLS":           os.Getenv("MIMIR_MODEL_TTLS"),
+		"MIMIR_MODEL_THRESHOLDS":     os.Getenv("MIMIR_MODEL_THRESHOLDS"),
+		"MIMIR_MAX_TTL":              os.Getenv("MIMIR_MAX_TTL"),
+		"AZURE_OPENAI_ENDPOINT":      os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		"AZURE_OPENAI_API_KEY":       os.Getenv("AZURE_OPENAI_API_KEY"),
+		"HF_TEI_BASE_URL":            os.Getenv("HF_TEI_BASE_URL"),
+		"MIMIR_EMBEDDING_DIMENSIONS": os.Getenv("MIMIR_EMBEDDING_DIMENSIONS"),
+		"GEMINI_API_KEY":             os.Getenv("GEMINI_API_KEY"),
+		"MIMIR_AUTH_TOKEN":           os.Getenv("MIMIR_AUTH_TOKEN"),
+		"MIMIR_CORS_ORIGINS":         os.Getenv("MIMIR_CORS_ORIGINS"),
 	}
 
 	// Restore env after test
@@ -103,6 +115,124 @@ func TestLoadFromEnv(t *testing.T) {
 		}
 	})
 
+	t.Run("per-model TTLs", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_MODEL_TTLS", "gpt-4:72h, llama3:8b:30m")
+
+		cfg := LoadFromEnv()
+
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := cfg.TTLForModel("gpt-4"); got != 72*time.Hour {
+			t.Errorf("expected gpt-4 TTL=72h, got %v", got)
+		}
+		if got := cfg.TTLForModel("llama3:8b"); got != 30*time.Minute {
+			t.Errorf("expected llama3:8b TTL=30m, got %v", got)
+		}
+		if got := cfg.TTLForModel("unknown"); got != cfg.CacheTTL {
+			t.Errorf("expected fallback to CacheTTL, got %v", got)
+		}
+	})
+
+	t.Run("max TTL clamps per-model TTLs", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_MAX_TTL", "48h")
+		os.Setenv("MIMIR_MODEL_TTLS", "gpt-4:8760h")
+
+		cfg := LoadFromEnv()
+
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := cfg.TTLForModel("gpt-4"); got != 48*time.Hour {
+			t.Errorf("expected gpt-4 TTL clamped to 48h, got %v", got)
+		}
+		if got := cfg.TTLForModel("unknown"); got != 24*time.Hour {
+			t.Errorf("expected default TTL=24h under the cap, got %v", got)
+		}
+	})
+
+	t.Run("invalid per-model TTL fails validation", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_MODEL_TTLS", "gpt-4:forever")
+
+		cfg := LoadFromEnv()
+
+		err := cfg.Validate()
+		if err == nil {
+			t.Fatal("expected validation error for invalid TTL")
+		}
+		if cfgErr, ok := err.(*ConfigError); !ok || cfgErr.Field != "MIMIR_MODEL_TTLS" {
+			t.Errorf("expected MIMIR_MODEL_TTLS error, got %v", err)
+		}
+	})
+
+	t.Run("per-model thresholds", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_MODEL_THRESHOLDS", "gpt-4:0.92, codellama:13b:0.98")
+
+		cfg := LoadFromEnv()
+
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := cfg.ThresholdForModel("gpt-4"); got != 0.92 {
+			t.Errorf("expected gpt-4 threshold=0.92, got %v", got)
+		}
+		if got := cfg.ThresholdForModel("codellama:13b"); got != 0.98 {
+			t.Errorf("expected codellama:13b threshold=0.98, got %v", got)
+		}
+		if got := cfg.ThresholdForModel("unknown"); got != cfg.SimilarityThreshold {
+			t.Errorf("expected fallback to SimilarityThreshold, got %v", got)
+		}
+	})
+
+	t.Run("out of range per-model threshold fails validation", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_MODEL_THRESHOLDS", "gpt-4:92")
+
+		cfg := LoadFromEnv()
+
+		err := cfg.Validate()
+		if cfgErr, ok := err.(*ConfigError); !ok || cfgErr.Field != "MIMIR_MODEL_THRESHOLDS" {
+			t.Errorf("expected MIMIR_MODEL_THRESHOLDS error, got %v", err)
+		}
+	})
+
+	t.Run("snapshot settings", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_CACHE_PERSIST_PATH", "/data/cache.jsonl")
+		os.Setenv("MIMIR_SNAPSHOT_INTERVAL", "10m")
+
+		cfg := LoadFromEnv()
+
+		if cfg.CachePersistPath != "/data/cache.jsonl" {
+			t.Errorf("expected CachePersistPath=/data/cache.jsonl, got %s", cfg.CachePersistPath)
+		}
+		if cfg.SnapshotInterval != 10*time.Minute {
+			t.Errorf("expected SnapshotInterval=10m, got %v", cfg.SnapshotInterval)
+		}
+	})
+
 	t.Run("auto-switch to OpenAI when API key provided", func(t *testing.T) {
 		// Clear previous env
 		for k := range origEnv {
@@ -135,6 +265,108 @@ func TestLoadFromEnv(t *testing.T) {
 			t.Errorf("expected explicit provider to be respected, got %s", cfg.EmbeddingProvider)
 		}
 	})
+
+	t.Run("azure provider", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_EMBEDDING_PROVIDER", "azure")
+		os.Setenv("AZURE_OPENAI_ENDPOINT", "https://example.openai.azure.com")
+		os.Setenv("AZURE_OPENAI_API_KEY", "azure-key")
+
+		cfg := LoadFromEnv()
+
+		if cfg.EmbeddingModel != "text-embedding-3-small" {
+			t.Errorf("expected EmbeddingModel=text-embedding-3-small for azure, got %s", cfg.EmbeddingModel)
+		}
+		if cfg.AzureOpenAIEndpoint != "https://example.openai.azure.com" || cfg.AzureOpenAIAPIKey != "azure-key" {
+			t.Errorf("expected azure settings to be loaded, got %q and %q", cfg.AzureOpenAIEndpoint, cfg.AzureOpenAIAPIKey)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("hf-tei provider", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_EMBEDDING_PROVIDER", "hf-tei")
+		os.Setenv("HF_TEI_BASE_URL", "http://tei:8080")
+		os.Setenv("MIMIR_EMBEDDING_DIMENSIONS", "384")
+
+		cfg := LoadFromEnv()
+
+		if cfg.EmbeddingModel != "tei" {
+			t.Errorf("expected EmbeddingModel=tei for hf-tei, got %s", cfg.EmbeddingModel)
+		}
+		if cfg.HFTEIBaseURL != "http://tei:8080" || cfg.EmbeddingDimensions != 384 {
+			t.Errorf("expected TEI settings to be loaded, got %q and %d", cfg.HFTEIBaseURL, cfg.EmbeddingDimensions)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("gemini provider", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_EMBEDDING_PROVIDER", "gemini")
+
+		cfg := LoadFromEnv()
+
+		if cfg.EmbeddingModel != "text-embedding-004" {
+			t.Errorf("expected EmbeddingModel=text-embedding-004 for gemini, got %s", cfg.EmbeddingModel)
+		}
+		err := cfg.Validate()
+		if cfgErr, ok := err.(*ConfigError); !ok || cfgErr.Field != "GEMINI_API_KEY" {
+			t.Errorf("expected GEMINI_API_KEY to be required, got %v", err)
+		}
+
+		os.Setenv("GEMINI_API_KEY", "gemini-key")
+		if err := LoadFromEnv().Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("auth tokens", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		os.Setenv("MIMIR_AUTH_TOKEN", "old-token, new-token,")
+
+		cfg := LoadFromEnv()
+
+		if len(cfg.AuthTokens) != 2 || cfg.AuthTokens[0] != "old-token" || cfg.AuthTokens[1] != "new-token" {
+			t.Errorf("expected AuthTokens=[old-token new-token], got %v", cfg.AuthTokens)
+		}
+	})
+
+	t.Run("cors origins", func(t *testing.T) {
+		for k := range origEnv {
+			os.Unsetenv(k)
+		}
+
+		if cfg := LoadFromEnv(); len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "*" {
+			t.Errorf("expected CORSOrigins=[*] by default, got %v", cfg.CORSOrigins)
+		}
+
+		os.Setenv("MIMIR_CORS_ORIGINS", "https://app.example.com, http://localhost:3000")
+
+		cfg := LoadFromEnv()
+
+		if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[1] != "http://localhost:3000" {
+			t.Errorf("expected two CORS origins, got %v", cfg.CORSOrigins)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
 }
 
 func TestValidate(t *testing.T) {
@@ -214,35 +446,718 @@ func TestValidate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "MIMIR_MAX_CACHE_SIZE",
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := tt.cfg.Validate()
-			if tt.wantErr {
-				if err == nil {
-					t.Error("expected error, got nil")
-				} else if tt.errMsg != "" {
-					cfgErr, ok := err.(*ConfigError)
-					if !ok {
-						t.Errorf("expected *ConfigError, got %T", err)
-					} else if cfgErr.Field != tt.errMsg {
-						t.Errorf("expected error field %s, got %s", tt.errMsg, cfgErr.Field)
-					}
-				}
-			} else {
-				if err != nil {
-					t.Errorf("unexpected error: %v", err)
-				}
-			}
-		})
-	}
-}
-
-func TestConfigError(t *testing.T) {
-	err := &ConfigError{Field: "TEST_FIELD", Message: "test message"}
-	expected := "config error: TEST_FIELD test message"
-	if err.Error() != expected {
-		t.Errorf("expected %q, got %q", expected, err.Error())
+		{
+			name: "min response tokens above max",
+			cfg: &Config{
+				EmbeddingProvider:      "ollama",
+				SimilarityThreshold:    0.95,
+				MaxCacheSize:           1000,
+				MinCacheResponseTokens: 500,
+				MaxCacheResponseTokens: 100,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_MIN_CACHE_RESPONSE_TOKENS",
+		},
+		{
+			name: "snapshot interval without persist path",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				SnapshotInterval:    time.Minute,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_SNAPSHOT_INTERVAL",
+		},
+		{
+			name: "unknown image key strategy",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				ImageKeyStrategy:    "pixels",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_IMAGE_KEY_STRATEGY",
+		},
+		{
+			name: "unknown default priority",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				DefaultPriority:     "urgent",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_DEFAULT_PRIORITY",
+		},
+		{
+			name: "unknown norm check",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				NormCheck:           "strict",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_NORM_CHECK",
+		},
+		{
+			name: "ghosts without a window",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				GhostSize:           100,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_GHOST_TTL",
+		},
+		{
+			name: "unknown log level",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				LogLevel:            "verbose",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_LOG_LEVEL",
+		},
+		{
+			name: "unknown cache key role",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheKeyRoles:       []string{"users"},
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_KEY_ROLES",
+		},
+		{
+			name: "invalid cache key strip pattern",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheKeyStrip:       "req-[0-9",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_KEY_STRIP",
+		},
+		{
+			name: "unknown tool caching mode",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				ToolCaching:         "loose",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_TOOL_CACHING",
+		},
+		{
+			name: "base url outside allowlist",
+			cfg: &Config{
+				EmbeddingProvider:    "ollama",
+				SimilarityThreshold:  0.95,
+				MaxCacheSize:         1000,
+				OpenAIBaseURL:        "https://api.openai.com/v1",
+				OllamaBaseURL:        "http://169.254.169.254",
+				AllowedUpstreamHosts: []string{"api.openai.com"},
+			},
+			wantErr: true,
+			errMsg:  "OLLAMA_BASE_URL",
+		},
+		{
+			name: "anthropic base url outside allowlist",
+			cfg: &Config{
+				EmbeddingProvider:    "ollama",
+				SimilarityThreshold:  0.95,
+				MaxCacheSize:         1000,
+				OllamaBaseURL:        "http://api.openai.com",
+				AnthropicAPIKey:      "sk-ant-test",
+				AnthropicBaseURL:     "https://api.anthropic.com",
+				AllowedUpstreamHosts: []string{"api.openai.com"},
+			},
+			wantErr: true,
+			errMsg:  "ANTHROPIC_BASE_URL",
+		},
+		{
+			name: "relative base url",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				OpenAIBaseURL:       "api.openai.com/v1",
+			},
+			wantErr: true,
+			errMsg:  "OPENAI_BASE_URL",
+		},
+		{
+			name: "cache TTL above max TTL",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheTTL:            8760 * time.Hour,
+				MaxTTL:              720 * time.Hour,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_TTL",
+		},
+		{
+			name: "negative embed retries",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				EmbedRetries:        -1,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_EMBED_RETRIES",
+		},
+		{
+			name: "negative upstream retries",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				UpstreamMaxRetries:  -1,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_UPSTREAM_MAX_RETRIES",
+		},
+		{
+			name: "upstream retries without backoff",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				UpstreamMaxRetries:  2,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_UPSTREAM_RETRY_BACKOFF",
+		},
+		{
+			name: "hit rate target out of range",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				HourlyHitRateTarget: 40,
+				AlertWebhookURL:     "https://hooks.example.com/mimir",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_HOURLY_HIT_RATE_TARGET",
+		},
+		{
+			name: "hit rate target without webhook",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				HourlyHitRateTarget: 0.4,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_ALERT_WEBHOOK_URL",
+		},
+		{
+			name: "unknown inner header mode",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				StripInnerHeaders:   "drop",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_STRIP_INNER_HEADERS",
+		},
+		{
+			name: "unknown long prompt mode",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				MaxEmbedChars:       8000,
+				LongPromptMode:      "drop",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_LONG_PROMPT_MODE",
+		},
+		{
+			name: "invalid cache key mode",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheKeyMode:        "first-user",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_KEY_MODE",
+		},
+		{
+			name: "sliding ttl on redis",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheBackend:        "redis",
+				RedisURL:            "redis://localhost:6379",
+				CacheSlidingTTL:     true,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_SLIDING_TTL",
+		},
+		{
+			name: "negative negative cache ttl",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				NegativeCacheTTL:    -time.Minute,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_NEGATIVE_CACHE_TTL",
+		},
+		{
+			name: "embed batching without batch size",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				EmbedBatchWindow:    10 * time.Millisecond,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_EMBED_BATCH_SIZE",
+		},
+		{
+			name: "cors origin with a path",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CORSOrigins:         []string{"https://app.example.com/login"},
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CORS_ORIGINS",
+		},
+		{
+			name: "otel endpoint without scheme",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				OTelEndpoint:        "localhost:4318",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_OTEL_ENDPOINT",
+		},
+		{
+			name: "negative max body bytes",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				MaxBodyBytes:        -1,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_MAX_BODY_BYTES",
+		},
+		{
+			name: "negative max cache temperature",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				MaxCacheTemperature: -0.5,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_MAX_CACHE_TEMPERATURE",
+		},
+		{
+			name: "negative cache shards",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheShards:         -1,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_SHARDS",
+		},
+		{
+			name: "negative cleanup chunk size",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CleanupChunkSize:    -1,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CLEANUP_CHUNK_SIZE",
+		},
+		{
+			name: "negative model price",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				PricingJSON:         `{"gpt-4": {"input": -0.03, "output": 0.06}}`,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_PRICING_JSON",
+		},
+		{
+			name: "replayed body header",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				ReplayHeaders:       []string{"openai-model", "content-length"},
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_REPLAY_HEADERS",
+		},
+		{
+			name: "float32 precision with hnsw",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				IndexType:           "hnsw",
+				EmbeddingPrecision:  "float32",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_EMBEDDING_PRECISION",
+		},
+		{
+			name: "unknown index type",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				IndexType:           "ivf",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_INDEX_TYPE",
+		},
+		{
+			name: "unknown similarity metric",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				SimilarityMetric:    "manhattan",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_SIMILARITY_METRIC",
+		},
+		{
+			name: "euclidean distance threshold above 1",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 4.5,
+				MaxCacheSize:        1000,
+				SimilarityMetric:    "euclidean",
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative euclidean distance threshold",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: -1,
+				MaxCacheSize:        1000,
+				SimilarityMetric:    "euclidean",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_SIMILARITY_THRESHOLD",
+		},
+		{
+			name: "hnsw with dot metric",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				IndexType:           "hnsw",
+				SimilarityMetric:    "dot",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_INDEX_TYPE",
+		},
+		{
+			name: "negative recency half-life",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				RecencyHalfLife:     -time.Hour,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_RECENCY_HALFLIFE",
+		},
+		{
+			name: "azure without endpoint",
+			cfg: &Config{
+				EmbeddingProvider:   "azure",
+				AzureOpenAIAPIKey:   "azure-key",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+			},
+			wantErr: true,
+			errMsg:  "AZURE_OPENAI_ENDPOINT",
+		},
+		{
+			name: "hf-tei without url",
+			cfg: &Config{
+				EmbeddingProvider:   "hf-tei",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+			},
+			wantErr: true,
+			errMsg:  "HF_TEI_BASE_URL",
+		},
+		{
+			name: "redis backend without url",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheBackend:        "redis",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_REDIS_URL",
+		},
+		{
+			name: "qdrant backend without url",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheBackend:        "qdrant",
+				QdrantCollection:    "mimir",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_QDRANT_URL",
+		},
+		{
+			name: "postgres backend with quoted table",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheBackend:        "postgres",
+				PostgresDSN:         "postgres://mimir@localhost/mimir",
+				PostgresTable:       `"cache"; DROP TABLE users`,
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_POSTGRES_TABLE",
+		},
+		{
+			name: "qdrant backend with euclidean metric",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.5,
+				MaxCacheSize:        1000,
+				SimilarityMetric:    "euclidean",
+				CacheBackend:        "qdrant",
+				QdrantURL:           "http://localhost:6333",
+				QdrantCollection:    "mimir",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_SIMILARITY_METRIC",
+		},
+		{
+			name: "unknown cache backend",
+			cfg: &Config{
+				EmbeddingProvider:   "ollama",
+				SimilarityThreshold: 0.95,
+				MaxCacheSize:        1000,
+				CacheBackend:        "memcached",
+			},
+			wantErr: true,
+			errMsg:  "MIMIR_CACHE_BACKEND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				} else if tt.errMsg != "" {
+					cfgErr, ok := err.(*ConfigError)
+					if !ok {
+						t.Errorf("expected *ConfigError, got %T", err)
+					} else if cfgErr.Field != tt.errMsg {
+						t.Errorf("expected error field %s, got %s", tt.errMsg, cfgErr.Field)
+					}
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeoutForPath(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.UpstreamTimeout = time.Minute
+	cfg.RouteTimeouts = map[string]time.Duration{
+		"/v1/":           30 * time.Second,
+		"/v1/embeddings": 10 * time.Second,
+		"/v1/chat/":      5 * time.Minute,
+	}
+
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"/v1/embeddings", 10 * time.Second},
+		{"/v1/chat/completions", 5 * time.Minute},
+		{"/v1/models", 30 * time.Second},
+		{"/other", time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.TimeoutForPath(tt.path); got != tt.want {
+			t.Errorf("TimeoutForPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	err := &ConfigError{Field: "TEST_FIELD", Message: "test message"}
+	expected := "config error: TEST_FIELD test message"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestParseBlockRules(t *testing.T) {
+	rules, err := parseBlockRules("no-user-agent, path:/.env,user-agent:sqlmap,max-body:1024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []BlockRule{
+		{Kind: BlockNoUserAgent},
+		{Kind: BlockPath, Value: "/.env"},
+		{Kind: BlockUserAgent, Value: "sqlmap"},
+		{Kind: BlockMaxBody, Value: "1024", Limit: 1024},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("expected %d rules, got %d", len(want), len(rules))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d: expected %+v, got %+v", i, want[i], rules[i])
+		}
+	}
+
+	for _, bad := range []string{"path", "max-body:lots", "max-body:0", "teapot"} {
+		if _, err := parseBlockRules(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestUpstreamRoutes(t *testing.T) {
+	routes, err := parseUpstreamRoutes(
+		"gpt-*:https://api.openai.com, llama3:8b:http://ollama:11434,*-instruct:http://vllm:8000",
+		"*-instruct:vllm-key",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []UpstreamRoute{
+		{Pattern: "gpt-*", URL: "https://api.openai.com"},
+		{Pattern: "llama3:8b", URL: "http://ollama:11434"},
+		{Pattern: "*-instruct", URL: "http://vllm:8000", APIKey: "vllm-key"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("expected %d routes, got %+v", len(want), routes)
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("route %d: expected %+v, got %+v", i, want[i], routes[i])
+		}
+	}
+
+	cfg := &Config{UpstreamRoutes: routes}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o", "https://api.openai.com"},
+		{"llama3:8b", "http://ollama:11434"},
+		{"llama3:70b", ""},
+		{"mistral-7b-instruct", "http://vllm:8000"},
+		{"gpt-3.5-turbo-instruct", "https://api.openai.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		route, ok := cfg.UpstreamFor(tt.model)
+		if got := route.URL; got != tt.want || ok != (tt.want != "") {
+			t.Errorf("UpstreamFor(%q) = %q, %v; want %q", tt.model, got, ok, tt.want)
+		}
+	}
+
+	for _, bad := range [][2]string{{"gpt-4", ""}, {"http://vllm:8000", ""}, {"gpt-*:https://api.openai.com", "llama*:key"}} {
+		if _, err := parseUpstreamRoutes(bad[0], bad[1]); err == nil {
+			t.Errorf("expected error for routes %q with keys %q", bad[0], bad[1])
+		}
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	cfg := &Config{NoCacheModels: splitList("live-search, realtime-*")}
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"live-search", true},
+		{"live-search-2", false},
+		{"realtime-preview", true},
+		{"gpt-4o-realtime", false},
+		{"gpt-4o", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.NoCache(tt.model); got != tt.want {
+			t.Errorf("NoCache(%q) = %v; want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamAllowed(t *testing.T) {
+	cfg := &Config{AllowedUpstreamHosts: []string{"api.openai.com", "*.azure.com", "localhost:11434"}}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://api.openai.com/v1/chat/completions", true},
+		{"https://API.OpenAI.com/v1", true},
+		{"https://my-resource.openai.azure.com/openai", true},
+		{"http://localhost:11434/api/embeddings", true},
+		{"http://localhost:8080", false},
+		{"https://api.openai.com.evil.com/v1", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"ftp://api.openai.com", false},
+	}
+
+	for _, tt := range tests {
+		if err := cfg.UpstreamAllowed(tt.url); (err == nil) != tt.want {
+			t.Errorf("%s: expected allowed=%v, got error %v", tt.url, tt.want, err)
+		}
+	}
+
+	if err := (&Config{}).UpstreamAllowed("http://anything.internal"); err != nil {
+		t.Errorf("expected any host to be allowed without an allowlist, got %v", err)
 	}
 }
@@ -0,0 +1,51 @@
+package embedding
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AzureOpenAIConfig configures an embedder for an Azure OpenAI deployment.
+type AzureOpenAIConfig struct {
+	APIKey     string
+	Endpoint   string // resource endpoint, e.g. https://my-resource.openai.azure.com
+	Deployment string // deployment name; defaults to Model
+	APIVersion string // defaults to 2024-02-01
+	Model      string // model behind the deployment, for dimensions
+	Timeout    time.Duration
+}
+
+// NewAzureOpenAIEmbedder creates an OpenAI embedder that calls an Azure
+// OpenAI deployment. Azure addresses models by deployment in the URL and
+// authenticates with an api-key header; requests and responses are
+// otherwise the same as OpenAI's.
+func NewAzureOpenAIEmbedder(cfg *AzureOpenAIConfig) *OpenAIEmbedder {
+	if cfg.Model == "" {
+		cfg.Model = "text-embedding-3-small"
+	}
+	if cfg.Deployment == "" {
+		cfg.Deployment = cfg.Model
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = "2024-02-01"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	baseURL := strings.TrimSuffix(cfg.Endpoint, "/")
+	return &OpenAIEmbedder{
+		apiKey:     cfg.APIKey,
+		baseURL:    baseURL,
+		model:      cfg.Model,
+		dimensions: openAIDimensions(cfg.Model),
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+		url: baseURL + "/openai/deployments/" + url.PathEscape(cfg.Deployment) +
+			"/embeddings?api-version=" + url.QueryEscape(cfg.APIVersion),
+		azure: true,
+	}
+}
@@ -0,0 +1,62 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+func TestAzureOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/team-embed/embeddings" {
+			t.Errorf("expected the deployment's embeddings path, got %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2024-06-01" {
+			t.Errorf("expected api-version=2024-06-01, got %q", got)
+		}
+		if got := r.Header.Get("api-key"); got != "azure-key" {
+			t.Errorf("expected api-key=azure-key, got %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("expected no Authorization header, got %q", got)
+		}
+		json.NewEncoder(w).Encode(api.EmbeddingResponse{
+			Data: []api.EmbeddingData{{Embedding: []float64{0.1, 0.2}, Index: 0}},
+		})
+	}))
+	defer server.Close()
+
+	embedder := NewAzureOpenAIEmbedder(&AzureOpenAIConfig{
+		APIKey:     "azure-key",
+		Endpoint:   server.URL + "/",
+		Deployment: "team-embed",
+		APIVersion: "2024-06-01",
+		Model:      "text-embedding-3-large",
+	})
+
+	emb, err := embedder.Embed(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(emb) != 2 {
+		t.Errorf("expected 2 dimensions, got %d", len(emb))
+	}
+	if embedder.Dimensions() != 3072 {
+		t.Errorf("expected dimensions=3072 for the large model, got %d", embedder.Dimensions())
+	}
+	if embedder.Model() != "text-embedding-3-large" {
+		t.Errorf("expected model text-embedding-3-large, got %s", embedder.Model())
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		embedder := NewAzureOpenAIEmbedder(&AzureOpenAIConfig{Endpoint: "https://example.openai.azure.com"})
+		want := "https://example.openai.azure.com/openai/deployments/text-embedding-3-small/embeddings?api-version=2024-02-01"
+		if embedder.url != want {
+			t.Errorf("expected url %s, got %s", want, embedder.url)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchEmbedder collects Embed calls that arrive within a short window and
+// sends them upstream as one EmbedBatch call, fanning the vectors back out
+// to the waiting callers. Under concurrency this trades up to one window of
+// latency for far fewer round trips. A batch is sent early once it holds
+// maxBatch texts.
+//
+// Batches run on a background context so one caller giving up does not
+// fail the others; each caller still returns as soon as its own context is
+// done. When a batch call fails, its texts are embedded one by one, so a
+// single bad text only fails its own caller.
+type BatchEmbedder struct {
+	Embedder
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending []*batchCall
+	timer   *time.Timer
+}
+
+// batchCall is one Embed call waiting for its batch.
+type batchCall struct {
+	text string
+	emb  []float64
+	err  error
+	done chan struct{}
+}
+
+// NewBatchEmbedder wraps e to batch Embed calls arriving within window, up
+// to maxBatch texts per call.
+func NewBatchEmbedder(e Embedder, window time.Duration, maxBatch int) *BatchEmbedder {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	return &BatchEmbedder{Embedder: e, window: window, maxBatch: maxBatch}
+}
+
+// Embed queues text for the next batch and waits for its embedding.
+func (b *BatchEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	call := &batchCall{text: text, done: make(chan struct{})}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, call)
+	if len(b.pending) >= b.maxBatch {
+		batch := b.take()
+		b.mu.Unlock()
+		go b.send(batch)
+	} else {
+		if len(b.pending) == 1 {
+			b.timer = time.AfterFunc(b.window, b.flush)
+		}
+		b.mu.Unlock()
+	}
+
+	select {
+	case <-call.done:
+		return call.emb, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// take removes and returns the pending calls. b.mu must be held.
+func (b *BatchEmbedder) take() []*batchCall {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+// flush sends whatever is pending when the window closes.
+func (b *BatchEmbedder) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		b.send(batch)
+	}
+}
+
+// send embeds a batch and completes its calls.
+func (b *BatchEmbedder) send(batch []*batchCall) {
+	ctx := context.Background()
+	defer func() {
+		for _, call := range batch {
+			close(call.done)
+		}
+	}()
+
+	if len(batch) == 1 {
+		batch[0].emb, batch[0].err = b.Embedder.Embed(ctx, batch[0].text)
+		return
+	}
+
+	texts := make([]string, len(batch))
+	for i, call := range batch {
+		texts[i] = call.text
+	}
+	vectors, err := b.Embedder.EmbedBatch(ctx, texts)
+	if err == nil && len(vectors) != len(batch) {
+		err = fmt.Errorf("expected %d embeddings, got %d", len(batch), len(vectors))
+	}
+	if err == nil {
+		for i, call := range batch {
+			call.emb = vectors[i]
+		}
+		return
+	}
+	for _, call := range batch {
+		call.emb, call.err = b.Embedder.Embed(ctx, call.text)
+	}
+}
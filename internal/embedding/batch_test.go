@@ -0,0 +1,169 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingEmbedder embeds text as its length after a fixed round-trip
+// latency, counting calls and recording batch sizes.
+type countingEmbedder struct {
+	latency    time.Duration
+	embedCalls atomic.Int64
+	batchCalls atomic.Int64
+
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.embedCalls.Add(1)
+	time.Sleep(e.latency)
+	if strings.Contains(text, "bad") {
+		return nil, errors.New("cannot embed")
+	}
+	return []float64{float64(len(text))}, nil
+}
+
+func (e *countingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	e.batchCalls.Add(1)
+	e.mu.Lock()
+	e.sizes = append(e.sizes, len(texts))
+	e.mu.Unlock()
+	time.Sleep(e.latency)
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		if strings.Contains(text, "bad") {
+			return nil, errors.New("cannot embed")
+		}
+		vectors[i] = []float64{float64(len(text))}
+	}
+	return vectors, nil
+}
+
+func (e *countingEmbedder) Dimensions() int { return 1 }
+func (e *countingEmbedder) Model() string   { return "counting" }
+
+// embedConcurrently embeds texts from one goroutine each and returns the
+// vectors and errors in input order.
+func embedConcurrently(e Embedder, texts []string) ([][]float64, []error) {
+	vectors := make([][]float64, len(texts))
+	errs := make([]error, len(texts))
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			vectors[i], errs[i] = e.Embed(context.Background(), text)
+		}(i, text)
+	}
+	wg.Wait()
+	return vectors, errs
+}
+
+func TestBatchEmbedder(t *testing.T) {
+	t.Run("batches concurrent calls", func(t *testing.T) {
+		inner := &countingEmbedder{}
+		e := NewBatchEmbedder(inner, 50*time.Millisecond, 64)
+
+		texts := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff"}
+		vectors, errs := embedConcurrently(e, texts)
+		for i, text := range texts {
+			if errs[i] != nil {
+				t.Fatalf("unexpected error for %q: %v", text, errs[i])
+			}
+			if vectors[i][0] != float64(len(text)) {
+				t.Errorf("%q got the vector of another text: %v", text, vectors[i])
+			}
+		}
+		if calls := inner.batchCalls.Load(); calls != 1 {
+			t.Errorf("expected 1 batch call, got %d (sizes %v)", calls, inner.sizes)
+		}
+		if calls := inner.embedCalls.Load(); calls != 0 {
+			t.Errorf("expected no single calls, got %d", calls)
+		}
+	})
+
+	t.Run("sends full batches early", func(t *testing.T) {
+		inner := &countingEmbedder{}
+		e := NewBatchEmbedder(inner, time.Hour, 2)
+
+		_, errs := embedConcurrently(e, []string{"a", "b", "c", "d"})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if calls := inner.batchCalls.Load(); calls != 2 {
+			t.Errorf("expected 2 batch calls, got %d", calls)
+		}
+	})
+
+	t.Run("lone call is embedded alone", func(t *testing.T) {
+		inner := &countingEmbedder{}
+		e := NewBatchEmbedder(inner, time.Millisecond, 64)
+
+		if _, err := e.Embed(context.Background(), "hi"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if inner.embedCalls.Load() != 1 || inner.batchCalls.Load() != 0 {
+			t.Errorf("expected one single call, got %d single and %d batch", inner.embedCalls.Load(), inner.batchCalls.Load())
+		}
+	})
+
+	t.Run("failed batch falls back to single calls", func(t *testing.T) {
+		inner := &countingEmbedder{}
+		e := NewBatchEmbedder(inner, 50*time.Millisecond, 64)
+
+		_, errs := embedConcurrently(e, []string{"good", "bad", "fine"})
+		if errs[0] != nil || errs[2] != nil {
+			t.Errorf("expected good texts to embed, got %v", errs)
+		}
+		if errs[1] == nil {
+			t.Error("expected the bad text to fail")
+		}
+	})
+
+	t.Run("cancelled caller returns early", func(t *testing.T) {
+		inner := &countingEmbedder{}
+		e := NewBatchEmbedder(inner, time.Hour, 64)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := e.Embed(ctx, "hi"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	})
+}
+
+// BenchmarkBatchEmbedder compares concurrent lookups against an embedder
+// with a 2ms round trip, embedded one call each or batched.
+func BenchmarkBatchEmbedder(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		embed func(inner Embedder) Embedder
+	}{
+		{"direct", func(inner Embedder) Embedder { return inner }},
+		{"batched", func(inner Embedder) Embedder { return NewBatchEmbedder(inner, time.Millisecond, 64) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			inner := &countingEmbedder{latency: 2 * time.Millisecond}
+			e := bench.embed(inner)
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := e.Embed(context.Background(), "What is the capital of France?"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.ReportMetric(float64(inner.embedCalls.Load()+inner.batchCalls.Load())/float64(b.N), "calls/op")
+		})
+	}
+}
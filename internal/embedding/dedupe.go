@@ -0,0 +1,51 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+)
+
+// DedupeEmbedder embeds each distinct text of a batch once and fans the
+// vectors back out to every position the text appeared at. Warmup lists
+// often repeat prompts, and each repeat would otherwise cost an upstream
+// call or batch slot.
+type DedupeEmbedder struct {
+	Embedder
+}
+
+// NewDedupeEmbedder wraps e to deduplicate batch inputs.
+func NewDedupeEmbedder(e Embedder) *DedupeEmbedder {
+	return &DedupeEmbedder{Embedder: e}
+}
+
+// EmbedBatch generates embeddings for multiple texts, in input order.
+func (d *DedupeEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	unique := make([]string, 0, len(texts))
+	positions := make([]int, len(texts))
+	seen := make(map[string]int, len(texts))
+	for i, text := range texts {
+		j, ok := seen[text]
+		if !ok {
+			j = len(unique)
+			seen[text] = j
+			unique = append(unique, text)
+		}
+		positions[i] = j
+	}
+	if len(unique) == len(texts) {
+		return d.Embedder.EmbedBatch(ctx, texts)
+	}
+
+	vectors, err := d.Embedder.EmbedBatch(ctx, unique)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(unique) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(unique), len(vectors))
+	}
+	results := make([][]float64, len(texts))
+	for i, j := range positions {
+		results[i] = vectors[j]
+	}
+	return results, nil
+}
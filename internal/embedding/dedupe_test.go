@@ -0,0 +1,45 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDedupeEmbedder(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		// Encode the prompt's length so results can be told apart
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"embedding": []float64{float64(len(req.Prompt)), 1},
+		})
+	}))
+	defer server.Close()
+
+	e := NewDedupeEmbedder(NewOllamaEmbedder(&OllamaConfig{BaseURL: server.URL}))
+	texts := []string{"a", "bb", "a", "ccc", "bb", "a"}
+
+	vectors, err := e.EmbedBatch(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 upstream calls for 3 distinct texts, got %d", got)
+	}
+	if len(vectors) != len(texts) {
+		t.Fatalf("expected %d vectors, got %d", len(texts), len(vectors))
+	}
+	for i, text := range texts {
+		if vectors[i][0] != float64(len(text)) {
+			t.Errorf("vector %d: expected embedding of %q, got %v", i, text, vectors[i])
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// geminiMaxBatch is the most texts batchEmbedContents accepts per call.
+const geminiMaxBatch = 100
+
+// GeminiEmbedder generates embeddings using the Google Gemini API.
+type GeminiEmbedder struct {
+	apiKey     string
+	baseURL    string
+	model      string
+	dimensions int
+	reduced    bool // dimensions was configured, so outputDimensionality is sent
+	client     *http.Client
+}
+
+// GeminiConfig configures the Gemini embedder.
+type GeminiConfig struct {
+	APIKey     string
+	BaseURL    string
+	Model      string
+	Dimensions int // requested as outputDimensionality when set
+	Timeout    time.Duration
+}
+
+// geminiContent is a piece of content to embed.
+type geminiContent struct {
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+// geminiEmbedRequest is the body of :embedContent, and one element of
+// :batchEmbedContents.
+type geminiEmbedRequest struct {
+	Model                string        `json:"model"`
+	Content              geminiContent `json:"content"`
+	OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+}
+
+type geminiBatchRequest struct {
+	Requests []geminiEmbedRequest `json:"requests"`
+}
+
+type geminiEmbedding struct {
+	Values []float64 `json:"values"`
+}
+
+type geminiBatchResponse struct {
+	Embeddings []geminiEmbedding `json:"embeddings"`
+}
+
+// geminiError is the body of a failed Gemini request.
+type geminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// NewGeminiEmbedder creates a new Gemini embedder.
+func NewGeminiEmbedder(cfg *GeminiConfig) *GeminiEmbedder {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	if cfg.Model == "" {
+		cfg.Model = "text-embedding-004"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	dimensions := cfg.Dimensions
+	if dimensions == 0 {
+		dimensions = geminiDimensions(cfg.Model)
+	}
+	return &GeminiEmbedder{
+		apiKey:     cfg.APIKey,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		model:      strings.TrimPrefix(cfg.Model, "models/"),
+		dimensions: dimensions,
+		reduced:    cfg.Dimensions > 0,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+	}
+}
+
+// geminiDimensions returns the default embedding size of a Gemini model.
+func geminiDimensions(model string) int {
+	switch strings.TrimPrefix(model, "models/") {
+	case "gemini-embedding-001":
+		return 3072
+	}
+	return 768 // text-embedding-004 and embedding-001
+}
+
+// Embed generates an embedding for the given text.
+func (e *GeminiEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	var resp struct {
+		Embedding geminiEmbedding `json:"embedding"`
+	}
+	if err := e.post(ctx, "embedContent", e.request(text), &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embedding.Values) == 0 {
+		return nil, fmt.Errorf("empty embedding returned")
+	}
+	return resp.Embedding.Values, nil
+}
+
+// EmbedBatch generates embeddings for multiple texts with
+// batchEmbedContents, one call per geminiMaxBatch texts.
+func (e *GeminiEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	result := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += geminiMaxBatch {
+		end := start + geminiMaxBatch
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch := geminiBatchRequest{Requests: make([]geminiEmbedRequest, end-start)}
+		for i, text := range texts[start:end] {
+			batch.Requests[i] = e.request(text)
+		}
+		var resp geminiBatchResponse
+		if err := e.post(ctx, "batchEmbedContents", batch, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(resp.Embeddings))
+		}
+		for i, emb := range resp.Embeddings {
+			if len(emb.Values) == 0 {
+				return nil, fmt.Errorf("empty embedding returned for text %d", start+i)
+			}
+			result = append(result, emb.Values)
+		}
+	}
+	return result, nil
+}
+
+// request builds the embed request for text.
+func (e *GeminiEmbedder) request(text string) geminiEmbedRequest {
+	req := geminiEmbedRequest{
+		Model:   "models/" + e.model,
+		Content: geminiContent{Parts: []geminiPart{{Text: text}}},
+	}
+	if e.reduced {
+		req.OutputDimensionality = e.dimensions
+	}
+	return req
+}
+
+// post calls method on the model and decodes the response into out. The
+// API key travels in the query string, so it is kept out of errors.
+func (e *GeminiEmbedder) post(ctx context.Context, method string, body, out interface{}) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:%s?key=%s", e.baseURL, e.model, method, url.QueryEscape(e.apiKey))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp geminiError
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error.Message != "" {
+			return fmt.Errorf("Gemini API error (status %d): %s", resp.StatusCode, errResp.Error.Message)
+		}
+		return fmt.Errorf("Gemini API error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// Dimensions returns the dimensionality of the embeddings.
+func (e *GeminiEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+// Model returns the model name used for embeddings.
+func (e *GeminiEmbedder) Model() string {
+	return e.model
+}
@@ -0,0 +1,121 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGeminiEmbedder(t *testing.T) {
+	var batchCalls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("key"); got != "gemini-key" {
+			t.Errorf("expected the API key as a query param, got %q", got)
+		}
+		switch r.URL.Path {
+		case "/models/text-embedding-004:embedContent":
+			var req geminiEmbedRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			if req.Model != "models/text-embedding-004" || req.Content.Parts[0].Text != "test" {
+				t.Errorf("unexpected request: %+v", req)
+			}
+			fmt.Fprint(w, `{"embedding": {"values": [0.1, 0.2, 0.3]}}`)
+		case "/models/text-embedding-004:batchEmbedContents":
+			batchCalls.Add(1)
+			var req geminiBatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			resp := geminiBatchResponse{Embeddings: make([]geminiEmbedding, len(req.Requests))}
+			for i := range req.Requests {
+				resp.Embeddings[i] = geminiEmbedding{Values: []float64{float64(i), 0.5, 0.25}}
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	embedder := NewGeminiEmbedder(&GeminiConfig{APIKey: "gemini-key", BaseURL: server.URL + "/"})
+	if embedder.Model() != "text-embedding-004" || embedder.Dimensions() != 768 {
+		t.Errorf("expected text-embedding-004 with 768 dimensions, got %s with %d", embedder.Model(), embedder.Dimensions())
+	}
+
+	emb, err := embedder.Embed(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(emb) != 3 {
+		t.Errorf("expected 3 dimensions, got %d", len(emb))
+	}
+
+	embs, err := embedder.EmbedBatch(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if len(embs) != 3 || embs[2][0] != 2 {
+		t.Errorf("expected embeddings in input order, got %v", embs)
+	}
+	if batchCalls.Load() != 1 {
+		t.Errorf("expected one batch call, got %d", batchCalls.Load())
+	}
+
+	// Batches over the API limit are split
+	texts := make([]string, geminiMaxBatch+1)
+	if embs, err = embedder.EmbedBatch(context.Background(), texts); err != nil || len(embs) != len(texts) {
+		t.Fatalf("expected %d embeddings, got %d (%v)", len(texts), len(embs), err)
+	}
+	if embs[geminiMaxBatch][0] != 0 || batchCalls.Load() != 3 {
+		t.Errorf("expected two more batch calls, got %d", batchCalls.Load()-1)
+	}
+}
+
+func TestGeminiEmbedderDimensions(t *testing.T) {
+	tests := []struct {
+		model      string
+		configured int
+		want       int
+	}{
+		{model: "text-embedding-004", want: 768},
+		{model: "models/gemini-embedding-001", want: 3072},
+		{model: "gemini-embedding-001", configured: 256, want: 256},
+	}
+	for _, tt := range tests {
+		e := NewGeminiEmbedder(&GeminiConfig{Model: tt.model, Dimensions: tt.configured})
+		if e.Dimensions() != tt.want {
+			t.Errorf("%s: expected %d dimensions, got %d", tt.model, tt.want, e.Dimensions())
+		}
+		if req := e.request("x"); req.OutputDimensionality != tt.configured {
+			t.Errorf("%s: expected outputDimensionality=%d, got %d", tt.model, tt.configured, req.OutputDimensionality)
+		}
+	}
+}
+
+func TestGeminiEmbedderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"code": 400, "message": "API key not valid", "status": "INVALID_ARGUMENT"}}`)
+	}))
+	defer server.Close()
+
+	embedder := NewGeminiEmbedder(&GeminiConfig{APIKey: "secret-key", BaseURL: server.URL})
+	_, err := embedder.Embed(context.Background(), "test")
+	if err == nil || !strings.Contains(err.Error(), "API key not valid") {
+		t.Errorf("expected the Gemini error message, got %v", err)
+	}
+
+	// Transport errors must not leak the key from the query string
+	server.Close()
+	_, err = embedder.EmbedBatch(context.Background(), []string{"test"})
+	if err == nil || strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("expected an error without the API key, got %v", err)
+	}
+}
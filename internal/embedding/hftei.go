@@ -0,0 +1,145 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HFTEIEmbedder generates embeddings using a HuggingFace Text Embeddings
+// Inference server.
+type HFTEIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimensions atomic.Int64
+	client     *http.Client
+}
+
+// HFTEIConfig configures the HuggingFace TEI embedder.
+type HFTEIConfig struct {
+	BaseURL    string
+	APIKey     string // sent as a bearer token when the server requires one
+	Model      string // model the server was started with, for labeling
+	Dimensions int    // learned from the first embedding when zero
+	Timeout    time.Duration
+}
+
+// hfTEIRequest is the request body for the TEI /embed endpoint.
+type hfTEIRequest struct {
+	Inputs []string `json:"inputs"`
+}
+
+// hfTEIError is the body of a failed TEI request.
+type hfTEIError struct {
+	Error string `json:"error"`
+}
+
+// NewHFTEIEmbedder creates a new HuggingFace TEI embedder.
+func NewHFTEIEmbedder(cfg *HFTEIConfig) *HFTEIEmbedder {
+	if cfg.BaseURL == "" {
+		// text-embeddings-router's default port; 8080 is mimir's own
+		cfg.BaseURL = "http://localhost:3000"
+	}
+	if cfg.Model == "" {
+		cfg.Model = "tei"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	e := &HFTEIEmbedder{
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		apiKey:  cfg.APIKey,
+		model:   cfg.Model,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+	}
+	e.dimensions.Store(int64(cfg.Dimensions))
+	return e
+}
+
+// Embed generates an embedding for the given text.
+func (e *HFTEIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	embeddings, err := e.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// EmbedBatch generates embeddings for multiple texts in one request.
+func (e *HFTEIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	jsonBody, err := json.Marshal(hfTEIRequest{Inputs: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/embed", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed (is TEI running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp hfTEIError
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("TEI error (status %d): %s", resp.StatusCode, errResp.Error)
+		}
+		return nil, fmt.Errorf("TEI error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	// TEI answers with a bare array of vectors, in input order
+	var embeddings [][]float64
+	if err := json.Unmarshal(body, &embeddings); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	for i, emb := range embeddings {
+		if len(emb) == 0 {
+			return nil, fmt.Errorf("empty embedding returned for text %d", i)
+		}
+	}
+
+	e.dimensions.CompareAndSwap(0, int64(len(embeddings[0])))
+	return embeddings, nil
+}
+
+// Dimensions returns the dimensionality of the embeddings: the configured
+// value, or else the size of the first embedding returned (zero before).
+func (e *HFTEIEmbedder) Dimensions() int {
+	return int(e.dimensions.Load())
+}
+
+// Model returns the model name used for embeddings.
+func (e *HFTEIEmbedder) Model() string {
+	return e.model
+}
@@ -0,0 +1,83 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHFTEIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embed" {
+			t.Errorf("expected /embed, got %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer tei-key" {
+			t.Errorf("expected bearer token, got %q", got)
+		}
+		var req hfTEIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		result := make([][]float64, len(req.Inputs))
+		for i := range req.Inputs {
+			result[i] = []float64{float64(i), 0.5, 0.25}
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	embedder := NewHFTEIEmbedder(&HFTEIConfig{
+		BaseURL: server.URL + "/",
+		APIKey:  "tei-key",
+		Model:   "BAAI/bge-small-en-v1.5",
+	})
+	if embedder.Dimensions() != 0 {
+		t.Errorf("expected unknown dimensions before the first call, got %d", embedder.Dimensions())
+	}
+
+	emb, err := embedder.Embed(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(emb) != 3 {
+		t.Errorf("expected 3 dimensions, got %d", len(emb))
+	}
+	if embedder.Dimensions() != 3 {
+		t.Errorf("expected dimensions to be learned as 3, got %d", embedder.Dimensions())
+	}
+
+	embs, err := embedder.EmbedBatch(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if len(embs) != 2 || embs[1][0] != 1 {
+		t.Errorf("expected embeddings in input order, got %v", embs)
+	}
+
+	t.Run("configured dimensions", func(t *testing.T) {
+		embedder := NewHFTEIEmbedder(&HFTEIConfig{BaseURL: server.URL, Dimensions: 384})
+		if embedder.Dimensions() != 384 {
+			t.Errorf("expected dimensions=384, got %d", embedder.Dimensions())
+		}
+		if embedder.Model() != "tei" {
+			t.Errorf("expected default model tei, got %s", embedder.Model())
+		}
+	})
+}
+
+func TestHFTEIEmbedderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(hfTEIError{Error: "batch size 64 > maximum allowed batch size 32"})
+	}))
+	defer server.Close()
+
+	embedder := NewHFTEIEmbedder(&HFTEIConfig{BaseURL: server.URL})
+	_, err := embedder.Embed(context.Background(), "test")
+	if err == nil || !strings.Contains(err.Error(), "maximum allowed batch size") {
+		t.Errorf("expected the TEI error message, got %v", err)
+	}
+}
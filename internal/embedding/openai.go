@@ -14,19 +14,28 @@ import (
 
 // OpenAIEmbedder generates embeddings using the OpenAI API.
 type OpenAIEmbedder struct {
-	apiKey     string
-	baseURL    string
-	model      string
-	dimensions int
-	client     *http.Client
+	apiKey       string
+	organization string
+	project      string
+	baseURL      string
+	model        string
+	dimensions   int
+	client       *http.Client
+
+	// url is the embeddings endpoint; azure authenticates with an api-key
+	// header instead of a bearer token
+	url   string
+	azure bool
 }
 
 // OpenAIConfig configures the OpenAI embedder.
 type OpenAIConfig struct {
-	APIKey   string
-	BaseURL  string
-	Model    string
-	Timeout  time.Duration
+	APIKey       string
+	Organization string // sent as OpenAI-Organization when set
+	Project      string // sent as OpenAI-Project when set
+	BaseURL      string
+	Model        string
+	Timeout      time.Duration
 }
 
 // NewOpenAIEmbedder creates a new OpenAI embedder.
@@ -41,24 +50,29 @@ func NewOpenAIEmbedder(cfg *OpenAIConfig) *OpenAIEmbedder {
 		cfg.Timeout = 30 * time.Second
 	}
 
-	// Determine dimensions based on model
-	dimensions := 1536 // default for text-embedding-3-small
-	switch cfg.Model {
-	case "text-embedding-3-large":
-		dimensions = 3072
-	case "text-embedding-ada-002":
-		dimensions = 1536
-	}
-
 	return &OpenAIEmbedder{
-		apiKey:     cfg.APIKey,
-		baseURL:    cfg.BaseURL,
-		model:      cfg.Model,
-		dimensions: dimensions,
+		apiKey:       cfg.APIKey,
+		organization: cfg.Organization,
+		project:      cfg.Project,
+		baseURL:      cfg.BaseURL,
+		model:        cfg.Model,
+		dimensions:   openAIDimensions(cfg.Model),
 		client: &http.Client{
 			Timeout: cfg.Timeout,
 		},
+		url: cfg.BaseURL + "/embeddings",
+	}
+}
+
+// openAIDimensions returns the embedding size of an OpenAI model.
+func openAIDimensions(model string) int {
+	switch model {
+	case "text-embedding-3-large":
+		return 3072
+	case "text-embedding-ada-002":
+		return 1536
 	}
+	return 1536 // default for text-embedding-3-small
 }
 
 // Embed generates an embedding for the given text.
@@ -89,13 +103,23 @@ func (e *OpenAIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]fl
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/embeddings", bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	if e.azure {
+		req.Header.Set("api-key", e.apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	if e.organization != "" {
+		req.Header.Set("OpenAI-Organization", e.organization)
+	}
+	if e.project != "" {
+		req.Header.Set("OpenAI-Project", e.project)
+	}
 
 	resp, err := e.client.Do(req)
 	if err != nil {
@@ -188,6 +188,32 @@ func TestOpenAIEmbedderEmbed(t *testing.T) {
 	})
 }
 
+func TestOpenAIEmbedderOrgHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("OpenAI-Organization"); got != "org-123" {
+			t.Errorf("expected OpenAI-Organization=org-123, got %q", got)
+		}
+		if got := r.Header.Get("OpenAI-Project"); got != "proj-456" {
+			t.Errorf("expected OpenAI-Project=proj-456, got %q", got)
+		}
+		json.NewEncoder(w).Encode(api.EmbeddingResponse{
+			Data: []api.EmbeddingData{{Embedding: []float64{0.1}, Index: 0}},
+		})
+	}))
+	defer server.Close()
+
+	embedder := NewOpenAIEmbedder(&OpenAIConfig{
+		APIKey:       "test-key",
+		Organization: "org-123",
+		Project:      "proj-456",
+		BaseURL:      server.URL,
+	})
+
+	if _, err := embedder.Embed(context.Background(), "test"); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+}
+
 func TestOpenAIEmbedderEmbedBatch(t *testing.T) {
 	t.Run("successful batch", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
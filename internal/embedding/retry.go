@@ -0,0 +1,71 @@
+package embedding
+
+import (
+	"context"
+	"time"
+)
+
+// RetryEmbedder retries failed embedding calls with exponential backoff.
+// Backoff waits end as soon as the context is done, so a client that
+// disconnects does not keep the retry loop running.
+type RetryEmbedder struct {
+	Embedder
+	retries int
+	backoff time.Duration
+}
+
+// NewRetryEmbedder wraps e to retry each call up to retries times, waiting
+// backoff before the first retry and doubling the wait after each one.
+func NewRetryEmbedder(e Embedder, retries int, backoff time.Duration) *RetryEmbedder {
+	return &RetryEmbedder{Embedder: e, retries: retries, backoff: backoff}
+}
+
+// Embed generates an embedding for the given text, retrying on failure.
+func (r *RetryEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	var emb []float64
+	err := r.retry(ctx, func() error {
+		var err error
+		emb, err = r.Embedder.Embed(ctx, text)
+		return err
+	})
+	return emb, err
+}
+
+// EmbedBatch generates embeddings for multiple texts, retrying the whole
+// batch on failure.
+func (r *RetryEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	var embs [][]float64
+	err := r.retry(ctx, func() error {
+		var err error
+		embs, err = r.Embedder.EmbedBatch(ctx, texts)
+		return err
+	})
+	return embs, err
+}
+
+// retry calls fn until it succeeds, the retries are used up or ctx is
+// done. A cancelled context is reported as ctx.Err().
+func (r *RetryEmbedder) retry(ctx context.Context, fn func() error) error {
+	delay := r.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= r.retries {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,79 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyEmbedder fails its first failures calls.
+type flakyEmbedder struct {
+	failures int64
+	calls    atomic.Int64
+}
+
+func (e *flakyEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if e.calls.Add(1) <= e.failures {
+		return nil, errors.New("embedder unavailable")
+	}
+	return []float64{1, 0}, nil
+}
+
+func (e *flakyEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	emb, err := e.Embed(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return [][]float64{emb}, nil
+}
+
+func (e *flakyEmbedder) Dimensions() int { return 2 }
+func (e *flakyEmbedder) Model() string   { return "flaky" }
+
+func TestRetryEmbedder(t *testing.T) {
+	t.Run("succeeds after retries", func(t *testing.T) {
+		inner := &flakyEmbedder{failures: 2}
+		e := NewRetryEmbedder(inner, 3, time.Millisecond)
+
+		if _, err := e.Embed(context.Background(), "hi"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if inner.calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", inner.calls.Load())
+		}
+	})
+
+	t.Run("gives up after the last retry", func(t *testing.T) {
+		inner := &flakyEmbedder{failures: 10}
+		e := NewRetryEmbedder(inner, 2, time.Millisecond)
+
+		if _, err := e.EmbedBatch(context.Background(), []string{"hi"}); err == nil {
+			t.Fatal("expected an error")
+		}
+		if inner.calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", inner.calls.Load())
+		}
+	})
+
+	t.Run("cancellation aborts the backoff", func(t *testing.T) {
+		inner := &flakyEmbedder{failures: 10}
+		e := NewRetryEmbedder(inner, 5, time.Hour)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := e.Embed(ctx, "hi")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected a prompt return, took %v", elapsed)
+		}
+		if inner.calls.Load() != 1 {
+			t.Errorf("expected no attempts after cancellation, got %d", inner.calls.Load())
+		}
+	})
+}
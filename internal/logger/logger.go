@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,26 +37,89 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel parses "debug", "info", "warn" or "error", in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
 // Logger is a structured logger.
 type Logger struct {
 	mu       sync.Mutex
 	out      io.Writer
-	level    Level
+	level    atomic.Int32
 	jsonMode bool
+
+	// root is the logger a child made by With writes through, nil for a
+	// logger made by New; keyvals are prepended to each of its entries
+	root    *Logger
+	keyvals []interface{}
 }
 
-// New creates a new logger.
-func New(jsonMode bool) *Logger {
-	return &Logger{
+// New creates a new logger that drops messages below level.
+func New(jsonMode bool, level Level) *Logger {
+	l := &Logger{
 		out:      os.Stdout,
-		level:    LevelDebug,
 		jsonMode: jsonMode,
 	}
+	l.level.Store(int32(level))
+	return l
+}
+
+// With returns a child logger that adds keyvals to every entry it logs.
+// The child shares the output and level of l, so changing either on one
+// changes both.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	kv := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+	kv = append(append(kv, l.keyvals...), keyvals...)
+	return &Logger{root: l.base(), keyvals: kv}
+}
+
+// base returns the logger holding the output and level of l.
+func (l *Logger) base() *Logger {
+	if l.root != nil {
+		return l.root
+	}
+	return l
+}
+
+// SetLevel changes the minimum level logged. It is safe to call while
+// the logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	l.base().level.Store(int32(level))
+}
+
+// Level returns the minimum level logged.
+func (l *Logger) Level() Level {
+	return Level(l.base().level.Load())
+}
+
+// SetOutput sets the destination for log output.
+func (l *Logger) SetOutput(w io.Writer) {
+	l = l.base()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
 }
 
 // log writes a log entry.
 func (l *Logger) log(level Level, msg string, keyvals ...interface{}) {
-	if level < l.level {
+	if level < l.Level() {
+		return
+	}
+	if l.root != nil {
+		kv := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+		l.root.log(level, msg, append(append(kv, l.keyvals...), keyvals...)...)
 		return
 	}
 
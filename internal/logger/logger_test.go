@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Level
+		wantErr bool
+	}{
+		{in: "debug", want: LevelDebug},
+		{in: "INFO", want: LevelInfo},
+		{in: "warn", want: LevelWarn},
+		{in: "error", want: LevelError},
+		{in: "verbose", want: LevelInfo, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(false, LevelWarn)
+	l.SetOutput(&buf)
+
+	l.Debug("debug message")
+	l.Info("info message")
+	l.Warn("warn message")
+	l.Error("error message")
+	out := buf.String()
+	if strings.Contains(out, "debug message") || strings.Contains(out, "info message") {
+		t.Errorf("expected messages below WARN to be dropped, got %q", out)
+	}
+	if !strings.Contains(out, "warn message") || !strings.Contains(out, "error message") {
+		t.Errorf("expected WARN and ERROR messages, got %q", out)
+	}
+
+	buf.Reset()
+	l.SetLevel(LevelDebug)
+	l.Debug("debug message")
+	if !strings.Contains(buf.String(), "debug message") {
+		t.Errorf("expected SetLevel to enable DEBUG, got %q", buf.String())
+	}
+	if l.Level() != LevelDebug {
+		t.Errorf("expected level DEBUG, got %v", l.Level())
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(true, LevelInfo)
+	l.SetOutput(&buf)
+
+	child := l.With("request_id", "abc").With("model", "gpt-4")
+	child.Info("cache hit", "similarity", 0.97)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["request_id"] != "abc" || entry["model"] != "gpt-4" || entry["similarity"] != 0.97 || entry["msg"] != "cache hit" {
+		t.Errorf("expected the child's fields in the entry, got %v", entry)
+	}
+
+	buf.Reset()
+	l.Info("no fields")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("expected the parent to log without the child's fields, got %q", buf.String())
+	}
+
+	buf.Reset()
+	child.SetLevel(LevelWarn)
+	l.Info("dropped")
+	if buf.Len() != 0 || l.Level() != LevelWarn {
+		t.Errorf("expected the child to share the parent's level, got %q", buf.String())
+	}
+}
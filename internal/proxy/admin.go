@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// requireAdmin checks the X-Mimir-Admin-Token header against
+// MIMIR_ADMIN_TOKEN, writing an error and returning false when the request
+// is not authorized. Guarded endpoints are disabled when no token is set.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.cfg.AdminToken == "" {
+		h.writeError(w, "Admin endpoints are disabled; set MIMIR_ADMIN_TOKEN to enable them", http.StatusForbidden)
+		return false
+	}
+	token := r.Header.Get("X-Mimir-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+		h.writeError(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// requireAuth checks that the request carries one of the MIMIR_AUTH_TOKEN
+// bearer tokens, writing 403 when none are configured and 401 otherwise.
+// AuthMiddleware removes the header once it has checked it, so a request
+// it let through counts as carrying a valid token.
+func (h *Handler) requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.cfg.AuthTokens) == 0 {
+		h.writeError(w, "This endpoint is disabled; set MIMIR_AUTH_TOKEN to enable it", http.StatusForbidden)
+		return false
+	}
+	if passed, _ := r.Context().Value(authenticatedKey{}).(bool); passed {
+		return true
+	}
+	if !validToken(h.cfg.AuthTokens, r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mimir"`)
+		h.writeError(w, "Invalid auth token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// gcResult reports what a forced cleanup and garbage collection reclaimed.
+type gcResult struct {
+	EntriesRemoved  int    `json:"entries_removed"`
+	HeapAllocBefore uint64 `json:"heap_alloc_before"`
+	HeapAllocAfter  uint64 `json:"heap_alloc_after"`
+	HeapFreed       int64  `json:"heap_freed"`
+	DurationMs      int64  `json:"duration_ms"`
+}
+
+// handleGC purges expired entries, forces a garbage collection and reports
+// heap usage before and after. runtime.GC stops the world, so this is a
+// diagnostic endpoint rather than something to call routinely.
+func (h *Handler) handleGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	start := time.Now()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	removed := h.cache.Cleanup(r.Context())
+	runtime.GC()
+
+	runtime.ReadMemStats(&after)
+
+	result := gcResult{
+		EntriesRemoved:  removed,
+		HeapAllocBefore: before.HeapAlloc,
+		HeapAllocAfter:  after.HeapAlloc,
+		HeapFreed:       int64(before.HeapAlloc) - int64(after.HeapAlloc),
+		DurationMs:      time.Since(start).Milliseconds(),
+	}
+	h.log(r.Context()).Info("admin gc completed",
+		"entries_removed", result.EntriesRemoved,
+		"heap_freed", result.HeapFreed,
+		"duration_ms", result.DurationMs,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// verifier is implemented by caches that accept audit verdicts.
+type verifier interface {
+	Verify(ctx context.Context, embedding []float64, passed bool, opts cache.VerifyOptions) cache.VerifyResult
+}
+
+// verifyRequest reports whether the cached answer to Request was still
+// correct when audited against a fresh upstream response.
+type verifyRequest struct {
+	Request api.ChatCompletionRequest `json:"request"`
+	Passed  bool                      `json:"passed"`
+}
+
+// handleVerify applies an audit verdict to the entry cached for a request.
+// Entries that keep passing match more readily and live longer; entries that
+// fail are matched more strictly and eventually evicted. The request is
+// keyed exactly as /v1/chat/completions keys it, including the context
+// version and embedding model headers.
+func (h *Handler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	v, ok := h.cache.(verifier)
+	if !ok {
+		h.writeError(w, "Cache backend does not support verification", http.StatusNotImplemented)
+		return
+	}
+
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Request.Messages) == 0 {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	embedder := h.selectEmbedder(w, r)
+	ctx = cache.WithPartition(ctx, h.requestPartition(ctx, r, embedder, req.Request))
+
+	emb, err := h.embed(ctx, embedder, h.generateCacheKey(req.Request))
+	if err != nil {
+		h.writeError(w, "Failed to generate embedding", http.StatusBadGateway)
+		return
+	}
+
+	// Extensions never carry an entry past MIMIR_MAX_TTL
+	maxTTL := h.cfg.ClampTTL(h.cfg.VerifyMaxTTL)
+	if maxTTL == 0 {
+		maxTTL = h.cfg.MaxTTL
+	}
+	result := v.Verify(ctx, emb, req.Passed, cache.VerifyOptions{
+		Step:      h.cfg.VerifyStep,
+		MaxOffset: h.cfg.VerifyMaxOffset,
+		Extension: h.cfg.VerifyTTLExtension,
+		MaxTTL:    maxTTL,
+	})
+	h.log(ctx).Info("audit verdict applied",
+		"passed", req.Passed,
+		"found", result.Found,
+		"evicted", result.Evicted,
+		"threshold_offset", result.ThresholdOffset,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Found {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// invalidateRequest selects the entries to invalidate. Model and Prompt are
+// mutually exclusive; clearing the whole cache must be asked for with All so
+// an empty request never wipes it.
+type invalidateRequest struct {
+	Model  string `json:"model,omitempty"`
+	Prompt string `json:"prompt,omitempty"`
+	All    bool   `json:"all,omitempty"`
+}
+
+// invalidateResult reports how many entries an invalidation removed.
+type invalidateResult struct {
+	EntriesRemoved int `json:"entries_removed"`
+}
+
+// handleInvalidate serves DELETE /cache and POST /cache/invalidate. The
+// selection is read from a JSON body or, when there is none, from the
+// model, prompt and all query parameters.
+func (h *Handler) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	if (r.URL.Path == "/cache" && r.Method != http.MethodDelete) ||
+		(r.URL.Path == "/cache/invalidate" && r.Method != http.MethodPost) {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	req := invalidateRequest{
+		Model:  r.URL.Query().Get("model"),
+		Prompt: r.URL.Query().Get("prompt"),
+		All:    r.URL.Query().Get("all") == "true",
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	selectors := 0
+	for _, set := range []bool{req.Model != "", req.Prompt != "", req.All} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		h.writeError(w, "Set exactly one of model, prompt or all", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var removed int
+	var err error
+	switch {
+	case req.Model != "":
+		removed, err = h.cache.DeleteByModel(ctx, req.Model)
+	case req.Prompt != "":
+		removed, err = h.cache.DeleteByPrompt(ctx, req.Prompt)
+	default:
+		removed = h.cache.Size(ctx)
+		err = h.cache.Clear(ctx)
+	}
+	if err != nil {
+		h.log(ctx).Error("cache invalidation failed", "error", err)
+		h.writeError(w, "Cache invalidation failed", http.StatusInternalServerError)
+		return
+	}
+	h.log(ctx).Info("cache invalidated",
+		"model", req.Model,
+		"prompt", truncatePrompt(req.Prompt, 80),
+		"all", req.All,
+		"entries_removed", removed,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invalidateResult{EntriesRemoved: removed})
+}
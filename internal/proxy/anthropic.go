@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// anthropicVersion is sent as anthropic-version when the client sets none.
+const anthropicVersion = "2023-06-01"
+
+// anthropicPartition keeps Messages API entries apart from chat completions,
+// whose cached bodies have a different shape.
+const anthropicPartition = "@api:anthropic"
+
+// handleMessages serves the Anthropic Messages API at /v1/messages. Lookups
+// work like chat completions, but responses are cached and replayed
+// verbatim. Streaming requests are forwarded without caching.
+func (h *Handler) handleMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	startTime := time.Now()
+
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	decoded, err := h.decodeRequestBody(r, body)
+	if err != nil {
+		h.writeError(w, err.Error(), bodyErrorStatus(err, http.StatusUnsupportedMediaType))
+		return
+	}
+
+	var req api.AnthropicRequest
+	if err := json.Unmarshal(decoded, &req); err != nil {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if h.cfg.NoCache(req.Model) {
+		h.log(ctx).Debug("skipping cache for model", "model", req.Model)
+		w.Header().Set("X-Mimir-Cache", "SKIP")
+		h.forwardAnthropic(w, r, body)
+		return
+	}
+
+	if req.Stream {
+		h.log(ctx).Debug("skipping cache for streaming messages request")
+		h.forwardAnthropic(w, r, body)
+		return
+	}
+
+	cacheKey := anthropicCacheKey(req)
+	embedText, ok := h.embedInput(cacheKey)
+	if !ok {
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardAnthropic(w, r, body)
+		return
+	}
+	embedder := h.selectEmbedder(w, r)
+	ctx = cache.WithPartition(ctx, h.cachePartition(r, embedder)+anthropicPartition)
+
+	var timings phaseTimings
+	phaseStart := time.Now()
+	emb, err := h.embed(ctx, embedder, embedText)
+	timings.embed = time.Since(phaseStart)
+	if err != nil {
+		h.log(ctx).Warn("failed to generate embedding, forwarding request", "error", err)
+		phaseStart = time.Now()
+		upstreamErr := h.forwardAnthropic(w, r, body)
+		timings.upstream = time.Since(phaseStart)
+		if upstreamErr != nil {
+			h.recordFailure(r, reports.FailedRequest{
+				Model:      req.Model,
+				Prompt:     cacheKey,
+				EmbedError: err.Error(),
+			}, upstreamErr, startTime, timings)
+		}
+		return
+	}
+
+	phaseStart = time.Now()
+	entry, similarity, found := h.cache.Get(ctx, emb, h.thresholdFor(req.Model))
+	timings.lookup = time.Since(phaseStart)
+	if found && cache.HasRawResponse(entry) {
+		latencyMs := time.Since(startTime).Milliseconds()
+		h.log(ctx).Info("cache hit",
+			"api", "anthropic",
+			"similarity", fmt.Sprintf("%.4f", similarity),
+			"latency_ms", latencyMs,
+		)
+
+		body, err := cache.RawBody(entry)
+		if err != nil {
+			h.log(ctx).Error("failed to read cached response", "error", err)
+			h.writeError(w, "Failed to read cached response", http.StatusInternalServerError)
+			return
+		}
+
+		h.collector.RecordModelUsage(req.Model, true, similarity, latencyMs, entry.Response.Usage, cacheKey)
+		h.collector.AddLog("hit", fmt.Sprintf("[HIT] %.2f%% sim, %dms - %s", similarity*100, latencyMs, truncatePrompt(cacheKey, 80)))
+		expires := h.slideTTL(entry, h.cfg.TTLForModel(req.Model))
+
+		replayHeaders(w, entry)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Mimir-Cache", "HIT")
+		w.Header().Set("X-Mimir-Similarity", fmt.Sprintf("%.4f", similarity))
+		w.Header().Set("X-Mimir-Saved-USD", fmt.Sprintf("%.6f", h.collector.SavedUSD(req.Model, entry.Response.Usage)))
+		setAgeHeaders(w, entry, expires)
+		if h.cfg.EmbedModelHeader {
+			model := entry.EmbedModel
+			if model == "" {
+				model = embedder.Model()
+			}
+			w.Header().Set("X-Mimir-Embed-Model", model)
+		}
+		if !h.notModified(w, r, entry.ID) {
+			w.Write(body)
+		}
+		h.logSlowRequest(ctx, "HIT", time.Since(startTime), timings, cacheKey)
+		return
+	}
+
+	h.log(ctx).Debug("cache miss, forwarding to anthropic")
+
+	phaseStart = time.Now()
+	resp, respBody, err := h.sendUpstream(ctx, h.cfg.AnthropicBaseURL, "", r, body)
+	timings.upstream = time.Since(phaseStart)
+	if upstreamErr := upstreamFailure(resp, err); upstreamErr != nil {
+		h.recordFailure(r, reports.FailedRequest{Model: req.Model, Prompt: cacheKey}, upstreamErr, startTime, timings)
+	}
+	if err != nil {
+		h.log(ctx).Error("upstream request failed", "error", err)
+		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
+		return
+	}
+
+	h.copyUpstreamHeaders(w, resp.Header)
+	w.Header().Set("X-Mimir-Cache", "MISS")
+	if h.cfg.EmbedModelHeader {
+		w.Header().Set("X-Mimir-Embed-Model", embedder.Model())
+	}
+
+	if resp.StatusCode == http.StatusOK && !h.cfg.CacheReadOnly {
+		var msgResp api.AnthropicResponse
+		if err := json.Unmarshal(respBody, &msgResp); err == nil {
+			h.setEntryTag(w, h.storeMessage(ctx, req, msgResp, respBody, resp.Header, emb, embedder.Model()))
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+
+	latencyMs := time.Since(startTime).Milliseconds()
+	h.collector.RecordModelRequest(req.Model, false, 0, latencyMs, 0, cacheKey)
+	h.collector.AddLog("miss", fmt.Sprintf("[MISS] %dms - %s", latencyMs, truncatePrompt(cacheKey, 80)))
+
+	h.log(ctx).Info("upstream request completed",
+		"api", "anthropic",
+		"status", resp.StatusCode,
+		"latency_ms", latencyMs,
+	)
+	h.logSlowRequest(ctx, "MISS", time.Since(startTime), timings, cacheKey)
+}
+
+// storeMessage caches a Messages API response and returns the ID of the
+// stored entry. The body is kept verbatim in RawResponse; Request and
+// Response hold a chat-completion view of the exchange so stats, dumps and
+// the token band checks work unchanged. The ReplayHeaders in header, if
+// any, are stored with it.
+func (h *Handler) storeMessage(ctx context.Context, req api.AnthropicRequest, msgResp api.AnthropicResponse, raw []byte, header http.Header, emb []float64, embedModel string) string {
+	var text strings.Builder
+	for _, block := range msgResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	chatResp := api.ChatCompletionResponse{
+		ID:      msgResp.ID,
+		Object:  msgResp.Type,
+		Created: time.Now().Unix(),
+		Model:   msgResp.Model,
+		Choices: []api.Choice{{
+			Message:      api.Message{Role: "assistant", Content: text.String()},
+			FinishReason: msgResp.StopReason,
+		}},
+		Usage: api.Usage{
+			PromptTokens:     msgResp.Usage.InputTokens,
+			CompletionTokens: msgResp.Usage.OutputTokens,
+			TotalTokens:      msgResp.Usage.InputTokens + msgResp.Usage.OutputTokens,
+		},
+	}
+	if ok, reason := h.responseCacheable(chatResp); !ok {
+		h.log(ctx).Info("not caching response", "reason", reason)
+		return ""
+	}
+	if h.rejectNorm(emb) {
+		return ""
+	}
+
+	chatReq := api.ChatCompletionRequest{Model: req.Model}
+	if req.System != nil {
+		chatReq.Messages = append(chatReq.Messages, api.Message{Role: "system", Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		chatReq.Messages = append(chatReq.Messages, api.Message{Role: msg.Role, Content: msg.Content})
+	}
+
+	entry := &api.CacheEntry{
+		Request:     chatReq,
+		Response:    chatResp,
+		RawResponse: raw,
+		Embedding:   emb,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(h.cfg.TTLForModel(req.Model)),
+		LastHitAt:   time.Now(),
+		Partition:   cache.PartitionFromContext(ctx),
+		EmbedModel:  embedModel,
+		Headers:     h.snapshotHeaders(header),
+	}
+	if err := h.cache.Set(ctx, entry); err != nil {
+		h.log(ctx).Warn("failed to cache response", "error", err)
+		return ""
+	}
+	h.log(ctx).Debug("cached response", "model", msgResp.Model)
+	return entry.ID
+}
+
+// forwardAnthropic forwards a Messages API request without caching and
+// returns the upstream failure, if any.
+func (h *Handler) forwardAnthropic(w http.ResponseWriter, r *http.Request, body []byte) error {
+	resp, respBody, err := h.sendUpstream(r.Context(), h.cfg.AnthropicBaseURL, "", r, body)
+	if err != nil {
+		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
+		return upstreamFailure(resp, err)
+	}
+
+	h.copyUpstreamHeaders(w, resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+	return upstreamFailure(resp, nil)
+}
+
+// anthropicCacheKey creates a cache key from the system prompt and messages
+// of a Messages API request. The system prompt lives outside the messages
+// in this API, so it is written first as a system turn.
+func anthropicCacheKey(req api.AnthropicRequest) string {
+	var sb strings.Builder
+
+	if req.System != nil {
+		sb.WriteString("system: ")
+		writeContentText(&sb, req.System)
+		sb.WriteString("\n")
+	}
+	for _, msg := range req.Messages {
+		sb.WriteString(msg.Role)
+		sb.WriteString(": ")
+		writeContentText(&sb, msg.Content)
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/internal/config"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+func newFakeAnthropic(t *testing.T) *fakeUpstream {
+	u := &fakeUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.calls.Add(1)
+		u.lastBody, _ = io.ReadAll(r.Body)
+		u.lastReq = r
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.AnthropicResponse{
+			ID:         "msg_test",
+			Type:       "message",
+			Role:       "assistant",
+			Content:    []api.AnthropicContentBlock{{Type: "text", Text: "Paris"}},
+			Model:      "claude-3-5-sonnet",
+			StopReason: "end_turn",
+			Usage:      api.AnthropicUsage{InputTokens: 12, OutputTokens: 3},
+		})
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func messagesBody(t *testing.T, system, content string) []byte {
+	body, err := json.Marshal(api.AnthropicRequest{
+		Model:     "claude-3-5-sonnet",
+		System:    system,
+		Messages:  []api.AnthropicMessage{{Role: "user", Content: content}},
+		MaxTokens: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleMessages(t *testing.T) {
+	openai := newFakeUpstream(t)
+	anthropic := newFakeAnthropic(t)
+	h := newTestHandler(t, openai, func(cfg *config.Config) {
+		cfg.OpenAIAPIKey = "sk-openai"
+		cfg.AnthropicAPIKey = "sk-ant-test"
+		cfg.AnthropicBaseURL = anthropic.URL
+	})
+
+	send := func(system, content string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(messagesBody(t, system, content)))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("Be brief.", "What is the capital of France?")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected MISS, got %q", got)
+	}
+	if got := anthropic.lastReq.Header.Get("x-api-key"); got != "sk-ant-test" {
+		t.Errorf("expected configured x-api-key, got %q", got)
+	}
+	if got := anthropic.lastReq.Header.Get("anthropic-version"); got != anthropicVersion {
+		t.Errorf("expected default anthropic-version, got %q", got)
+	}
+	if got := anthropic.lastReq.Header.Get("Authorization"); got != "" {
+		t.Errorf("OpenAI key leaked to anthropic upstream: %q", got)
+	}
+	miss := rec.Body.String()
+
+	rec = send("Be brief.", "What is the capital of France?")
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Fatalf("expected HIT, got %q", got)
+	}
+	if rec.Body.String() != miss {
+		t.Errorf("expected verbatim cached body %q, got %q", miss, rec.Body.String())
+	}
+	if got := anthropic.calls.Load(); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+	if got := openai.calls.Load(); got != 0 {
+		t.Errorf("expected no OpenAI calls, got %d", got)
+	}
+
+	// A different system prompt is a different conversation
+	rec = send("Answer in French.", "What is the capital of France?")
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected MISS for a new system prompt, got %q", got)
+	}
+
+	report := h.Collector().GetReport()
+	if report.TotalHits != 1 || report.TotalMisses != 2 {
+		t.Errorf("expected 1 hit and 2 misses, got %d and %d", report.TotalHits, report.TotalMisses)
+	}
+	if saved := h.Collector().Savings(time.Now(), time.Hour, "1h").TokensSaved; saved != 15 {
+		t.Errorf("expected 15 tokens saved, got %d", saved)
+	}
+}
+
+func TestHandleMessagesDoesNotMatchChatCompletions(t *testing.T) {
+	openai := newFakeUpstream(t)
+	anthropic := newFakeAnthropic(t)
+	h := newTestHandler(t, openai, func(cfg *config.Config) {
+		cfg.AnthropicBaseURL = anthropic.URL
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Same conversation as the cached chat completion, but a different API
+	body, _ := json.Marshal(api.AnthropicRequest{
+		Model:    "gpt-4",
+		Messages: []api.AnthropicMessage{{Role: "user", Content: "What is the capital of France?"}},
+	})
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	req.Header.Set("x-api-key", "sk-ant-client")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected MISS, got %q", got)
+	}
+	if got := anthropic.lastReq.Header.Get("x-api-key"); got != "sk-ant-client" {
+		t.Errorf("expected client x-api-key, got %q", got)
+	}
+}
+
+func TestAnthropicCacheKey(t *testing.T) {
+	req := api.AnthropicRequest{
+		System: []interface{}{map[string]interface{}{"type": "text", "text": "Be brief."}},
+		Messages: []api.AnthropicMessage{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: []interface{}{map[string]interface{}{"type": "text", "text": "Hello"}}},
+		},
+	}
+	want := "system: Be brief.\nuser: Hi\nassistant: Hello\n"
+	if got := anthropicCacheKey(req); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
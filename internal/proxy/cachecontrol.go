@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// noCache reports whether r asks for a fresh upstream answer, with
+// X-Mimir-No-Cache or Cache-Control: no-cache. The answer is still cached,
+// replacing the stale one.
+func noCache(r *http.Request) bool {
+	if v := strings.TrimSpace(r.Header.Get("X-Mimir-No-Cache")); v != "" && v != "false" && v != "0" {
+		return true
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/embedding"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+const (
+	// compareIndexSize bounds each model's shadow index
+	compareIndexSize = 10000
+	// compareConcurrency bounds in-flight comparisons; samples beyond it are dropped
+	compareConcurrency = 4
+	compareTimeout     = 30 * time.Second
+)
+
+// embedderCompare mirrors sampled prompts through the default embedder and
+// a candidate, each with a shadow index of the prompts it has seen, to
+// measure the hit rate either model would achieve on the same traffic.
+// Shadow indexes only hold vectors and never serve responses.
+type embedderCompare struct {
+	candidate embedding.Embedder
+	indexes   [2]*cache.MemoryCache // default, candidate
+	slots     chan struct{}
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	sampled  int64
+	dropped  int64
+	errors   int64
+	hits     [2]int64
+	simSum   [2]float64
+	bothHit  int64
+	onlyHit  [2]int64
+	lastSeen time.Time
+}
+
+// ModelComparison is one model's side of an embedder comparison.
+type ModelComparison struct {
+	Model         string  `json:"model"`
+	Threshold     float64 `json:"threshold"`
+	Hits          int64   `json:"hits"`
+	HitRate       float64 `json:"hit_rate"`
+	AvgSimilarity float64 `json:"avg_nearest_similarity"`
+	OnlyHits      int64   `json:"only_hits"`
+}
+
+// EmbedderComparison reports would-be hit rates of the default and
+// candidate embedders on the same sampled requests.
+type EmbedderComparison struct {
+	SampleRate float64           `json:"sample_rate"`
+	Sampled    int64             `json:"sampled"`
+	Dropped    int64             `json:"dropped"`
+	Errors     int64             `json:"errors"`
+	BothHit    int64             `json:"both_hit"`
+	Models     []ModelComparison `json:"models"`
+	LastSample *time.Time        `json:"last_sample,omitempty"`
+}
+
+// SetCompareEmbedder starts comparing candidate against the default
+// embedder on a sample of chat completion traffic.
+func (h *Handler) SetCompareEmbedder(candidate embedding.Embedder) {
+	newIndex := func() *cache.MemoryCache {
+		return cache.NewMemoryCache(&cache.Options{
+			MaxSize:         compareIndexSize,
+			DefaultTTL:      h.cfg.CacheTTL,
+			CleanupInterval: 5 * time.Minute,
+			EvictionPolicy:  cache.EvictionLRU,
+		})
+	}
+	h.compare = &embedderCompare{
+		candidate: candidate,
+		indexes:   [2]*cache.MemoryCache{newIndex(), newIndex()},
+		slots:     make(chan struct{}, compareConcurrency),
+		ttl:       h.cfg.CacheTTL,
+	}
+}
+
+// compareThresholds returns the thresholds the default and candidate
+// embedders are judged by for model.
+func (h *Handler) compareThresholds(model string) [2]float64 {
+	candidate := h.cfg.CompareThreshold
+	if candidate == 0 {
+		candidate = h.similarityThreshold()
+	}
+	return [2]float64{h.thresholdFor(model), candidate}
+}
+
+// mirrorCompare samples a request for the embedder comparison. emb is the
+// default embedder's vector for key, reused rather than computed again.
+// The comparison runs in the background and never affects the response.
+func (h *Handler) mirrorCompare(partition, model, key string, emb []float64) {
+	c := h.compare
+	if c == nil || rand.Float64() >= h.cfg.CompareSampleRate {
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		c.mu.Lock()
+		c.dropped++
+		c.mu.Unlock()
+		return
+	}
+
+	go func() {
+		defer func() { <-c.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), compareTimeout)
+		defer cancel()
+		ctx = cache.WithPartition(ctx, partition)
+
+		candidateEmb, err := c.candidate.Embed(ctx, key)
+		if err != nil {
+			h.logger.Debug("embedder comparison failed", "model", c.candidate.Model(), "error", err)
+			c.mu.Lock()
+			c.errors++
+			c.mu.Unlock()
+			return
+		}
+
+		thresholds := h.compareThresholds(model)
+		var hit [2]bool
+		var sim [2]float64
+		for i, vec := range [2][]float64{emb, candidateEmb} {
+			hit[i], sim[i] = c.lookup(ctx, i, vec, thresholds[i])
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.sampled++
+		c.lastSeen = time.Now()
+		for i := range hit {
+			c.simSum[i] += sim[i]
+			if hit[i] {
+				c.hits[i]++
+				if !hit[1-i] {
+					c.onlyHit[i]++
+				}
+			}
+		}
+		if hit[0] && hit[1] {
+			c.bothHit++
+		}
+	}()
+}
+
+// lookup finds the nearest prompt to vec in shadow index i, reporting
+// whether it clears threshold and its similarity. Misses are added to the
+// index, as a real miss would be cached.
+func (c *embedderCompare) lookup(ctx context.Context, i int, vec []float64, threshold float64) (bool, float64) {
+	_, similarity, found := c.indexes[i].Get(ctx, vec, 0)
+	if found && similarity >= threshold {
+		return true, similarity
+	}
+	now := time.Now()
+	c.indexes[i].Set(ctx, &api.CacheEntry{
+		Embedding: vec,
+		Partition: cache.PartitionFromContext(ctx),
+		CreatedAt: now,
+		LastHitAt: now,
+		ExpiresAt: now.Add(c.ttl),
+	})
+	return false, similarity
+}
+
+// compareReport summarizes the comparison so far. Thresholds shown are
+// the defaults; per-model thresholds apply to the samples they match.
+func (h *Handler) compareReport() EmbedderComparison {
+	c := h.compare
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := EmbedderComparison{
+		SampleRate: h.cfg.CompareSampleRate,
+		Sampled:    c.sampled,
+		Dropped:    c.dropped,
+		Errors:     c.errors,
+		BothHit:    c.bothHit,
+	}
+	if !c.lastSeen.IsZero() {
+		last := c.lastSeen
+		report.LastSample = &last
+	}
+	thresholds := h.compareThresholds("")
+	for i, model := range []string{h.embedder.Model(), c.candidate.Model()} {
+		m := ModelComparison{Model: model, Threshold: thresholds[i], Hits: c.hits[i], OnlyHits: c.onlyHit[i]}
+		if c.sampled > 0 {
+			m.HitRate = float64(c.hits[i]) / float64(c.sampled)
+			m.AvgSimilarity = c.simSum[i] / float64(c.sampled)
+		}
+		report.Models = append(report.Models, m)
+	}
+	return report
+}
+
+// handleEmbedderCompare reports the would-be hit rates of the default and
+// candidate embedders.
+func (h *Handler) handleEmbedderCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.compare == nil {
+		h.writeError(w, "Embedder comparison is disabled; set MIMIR_COMPARE_EMBEDDING_MODEL to enable it", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.compareReport())
+}
@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/embedding"
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// completionsPartition keeps legacy completions entries apart from chat
+// completions, whose cached bodies have a different shape.
+const completionsPartition = "@api:completions"
+
+// handleCompletions serves the legacy completions API at /v1/completions.
+// The prompt is keyed as a single user message, so lookups work like chat
+// completions, and responses are cached and replayed verbatim. Streaming
+// requests are forwarded without caching, and requests whose prompt is
+// not a single string, or that use suffix or echo, bypass the cache.
+func (h *Handler) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	startTime := time.Now()
+
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	decoded, err := h.decodeRequestBody(r, body)
+	if err != nil {
+		h.writeError(w, err.Error(), bodyErrorStatus(err, http.StatusUnsupportedMediaType))
+		return
+	}
+
+	var req api.CompletionRequest
+	if err := json.Unmarshal(decoded, &req); err != nil {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if h.cfg.NoCache(req.Model) {
+		h.log(ctx).Debug("skipping cache for model", "model", req.Model)
+		w.Header().Set("X-Mimir-Cache", "SKIP")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	if !h.temperatureCacheable(req.Temperature) {
+		h.log(ctx).Debug("skipping cache for temperature", "temperature", *req.Temperature)
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	if req.Stream {
+		h.log(ctx).Debug("skipping cache for streaming completions request")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	prompt, ok := completionPrompt(req)
+	if !ok {
+		h.log(ctx).Debug("skipping cache for completions request without a single prompt")
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	ttl, store := h.requestTTL(r, req.Model)
+	if !store {
+		h.log(ctx).Debug("skipping cache due to X-Mimir-TTL")
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	chatReq := api.ChatCompletionRequest{
+		Model:       req.Model,
+		Messages:    []api.Message{{Role: "user", Content: prompt}},
+		Temperature: req.Temperature,
+		N:           req.N,
+		MaxTokens:   req.MaxTokens,
+	}
+	cacheKey := h.generateCacheKey(chatReq)
+	embedText, ok := h.embedInput(cacheKey)
+	if !ok {
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+	embedder := h.selectEmbedder(w, r)
+	partition := h.cachePartition(r, embedder) + completionsPartition
+	if key := h.temperatureKey(req.Temperature); key != "" {
+		partition += "@temp:" + key
+	}
+	ctx = cache.WithPartition(ctx, partition)
+
+	var timings phaseTimings
+	phaseStart := time.Now()
+	emb, err := h.embed(ctx, embedder, embedText)
+	timings.embed = time.Since(phaseStart)
+	if err != nil {
+		h.log(ctx).Warn("failed to generate embedding, forwarding request", "error", err)
+		phaseStart = time.Now()
+		upstreamErr := h.forwardRequest(w, r, body)
+		timings.upstream = time.Since(phaseStart)
+		if upstreamErr != nil {
+			h.recordFailure(r, reports.FailedRequest{
+				Model:      req.Model,
+				Prompt:     cacheKey,
+				EmbedError: err.Error(),
+			}, upstreamErr, startTime, timings)
+		}
+		return
+	}
+
+	var entry *api.CacheEntry
+	var similarity float64
+	var found bool
+	refresh := noCache(r)
+	phaseStart = time.Now()
+	if !refresh {
+		entry, similarity, found = h.cache.Get(ctx, emb, h.thresholdFor(req.Model))
+	}
+	timings.lookup = time.Since(phaseStart)
+	if found && cache.HasRawResponse(entry) {
+		latencyMs := time.Since(startTime).Milliseconds()
+		h.log(ctx).Info("cache hit",
+			"api", "completions",
+			"similarity", fmt.Sprintf("%.4f", similarity),
+			"latency_ms", latencyMs,
+		)
+
+		body, err := cache.RawBody(entry)
+		if err != nil {
+			h.log(ctx).Error("failed to read cached response", "error", err)
+			h.writeError(w, "Failed to read cached response", http.StatusInternalServerError)
+			return
+		}
+
+		h.collector.RecordModelUsage(req.Model, true, similarity, latencyMs, entry.Response.Usage, cacheKey)
+		h.collector.AddLog("hit", fmt.Sprintf("[HIT] %.2f%% sim, %dms - %s", similarity*100, latencyMs, truncatePrompt(cacheKey, 80)))
+		expires := h.slideTTL(entry, ttl)
+
+		replayHeaders(w, entry)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Mimir-Cache", "HIT")
+		w.Header().Set("X-Mimir-Similarity", fmt.Sprintf("%.4f", similarity))
+		w.Header().Set("X-Mimir-Saved-USD", fmt.Sprintf("%.6f", h.collector.SavedUSD(req.Model, entry.Response.Usage)))
+		setAgeHeaders(w, entry, expires)
+		if h.cfg.EmbedModelHeader {
+			model := entry.EmbedModel
+			if model == "" {
+				model = embedder.Model()
+			}
+			w.Header().Set("X-Mimir-Embed-Model", model)
+		}
+		if !h.notModified(w, r, entry.ID) {
+			w.Write(body)
+		}
+		h.logSlowRequest(ctx, "HIT", time.Since(startTime), timings, cacheKey)
+		return
+	}
+
+	h.log(ctx).Debug("cache miss, forwarding to upstream", "api", "completions", "refresh", refresh)
+	cacheStatus := "MISS"
+	if refresh {
+		cacheStatus = "BYPASS"
+	}
+
+	phaseStart = time.Now()
+	resp, respBody, err := h.doUpstreamRequest(ctx, r, body)
+	timings.upstream = time.Since(phaseStart)
+	if upstreamErr := upstreamFailure(resp, err); upstreamErr != nil {
+		h.recordFailure(r, reports.FailedRequest{Model: req.Model, Prompt: cacheKey}, upstreamErr, startTime, timings)
+	}
+	if err != nil {
+		h.log(ctx).Error("upstream request failed", "error", err)
+		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
+		return
+	}
+
+	h.copyUpstreamHeaders(w, resp.Header)
+	w.Header().Set("X-Mimir-Cache", cacheStatus)
+	if h.cfg.EmbedModelHeader {
+		w.Header().Set("X-Mimir-Embed-Model", embedder.Model())
+	}
+
+	if resp.StatusCode == http.StatusOK && !h.cfg.CacheReadOnly {
+		var complResp api.CompletionResponse
+		if err := json.Unmarshal(respBody, &complResp); err == nil {
+			h.setEntryTag(w, h.storeCompletion(ctx, chatReq, complResp, respBody, resp.Header, emb, embedder, ttl))
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+
+	latencyMs := time.Since(startTime).Milliseconds()
+	h.collector.RecordModelRequest(req.Model, false, 0, latencyMs, 0, cacheKey)
+	h.collector.AddLog("miss", fmt.Sprintf("[MISS] %dms - %s", latencyMs, truncatePrompt(cacheKey, 80)))
+
+	h.log(ctx).Info("upstream request completed",
+		"api", "completions",
+		"status", resp.StatusCode,
+		"latency_ms", latencyMs,
+	)
+	h.logSlowRequest(ctx, "MISS", time.Since(startTime), timings, cacheKey)
+}
+
+// completionPrompt returns the prompt of a legacy completions request when
+// it can be cached: a single string, or an array holding one string, with
+// no suffix to insert and no echo of the prompt.
+func completionPrompt(req api.CompletionRequest) (string, bool) {
+	if req.Suffix != "" || req.Echo {
+		return "", false
+	}
+	switch p := req.Prompt.(type) {
+	case string:
+		return p, true
+	case []interface{}:
+		if len(p) == 1 {
+			s, ok := p[0].(string)
+			return s, ok
+		}
+	}
+	return "", false
+}
+
+// storeCompletion caches a legacy completions response and returns the ID
+// of the stored entry. The body is kept verbatim in RawResponse; Response
+// holds a chat-completion view of it so stats, dumps and the token band
+// checks work unchanged. The ReplayHeaders in header, if any, are stored
+// with it.
+func (h *Handler) storeCompletion(ctx context.Context, chatReq api.ChatCompletionRequest, complResp api.CompletionResponse, raw []byte, header http.Header, emb []float64, embedder embedding.Embedder, ttl time.Duration) string {
+	chatResp := api.ChatCompletionResponse{
+		ID:      complResp.ID,
+		Object:  complResp.Object,
+		Created: complResp.Created,
+		Model:   complResp.Model,
+		Usage:   complResp.Usage,
+	}
+	for _, choice := range complResp.Choices {
+		chatResp.Choices = append(chatResp.Choices, api.Choice{
+			Index:        choice.Index,
+			Message:      api.Message{Role: "assistant", Content: choice.Text},
+			FinishReason: choice.FinishReason,
+		})
+	}
+	if ok, reason := h.responseCacheable(chatResp); !ok {
+		h.log(ctx).Info("not caching response", "reason", reason)
+		return ""
+	}
+	if h.rejectNorm(emb) {
+		return ""
+	}
+
+	entry := &api.CacheEntry{
+		Request:     chatReq,
+		Response:    chatResp,
+		RawResponse: raw,
+		Embedding:   emb,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(ttl),
+		LastHitAt:   time.Now(),
+		Partition:   cache.PartitionFromContext(ctx),
+		EmbedModel:  embedder.Model(),
+		Headers:     h.snapshotHeaders(header),
+	}
+	if err := h.cache.Set(ctx, entry); err != nil {
+		h.log(ctx).Warn("failed to cache response", "error", err)
+		return ""
+	}
+	h.log(ctx).Debug("cached response", "model", complResp.Model)
+	return entry.ID
+}
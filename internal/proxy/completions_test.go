@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+func newFakeCompletions(t *testing.T) *fakeUpstream {
+	u := &fakeUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.calls.Add(1)
+		u.lastBody, _ = io.ReadAll(r.Body)
+		u.lastReq = r
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.CompletionResponse{
+			ID:      "cmpl-test",
+			Object:  "text_completion",
+			Created: time.Now().Unix(),
+			Model:   "gpt-3.5-turbo-instruct",
+			Choices: []api.CompletionChoice{{Text: " Paris", FinishReason: "stop"}},
+			Usage:   api.Usage{PromptTokens: 8, CompletionTokens: 1, TotalTokens: 9},
+		})
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func TestHandleCompletions(t *testing.T) {
+	upstream := newFakeCompletions(t)
+	h := newTestHandler(t, upstream, nil)
+
+	send := func(req api.CompletionRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/completions", bytes.NewReader(body)))
+		return rec
+	}
+	prompt := "The capital of France is"
+
+	miss := send(api.CompletionRequest{Model: "gpt-3.5-turbo-instruct", Prompt: prompt})
+	if got := miss.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Fatalf("expected MISS, got %q", got)
+	}
+	if upstream.lastReq.URL.Path != "/v1/completions" {
+		t.Errorf("expected request forwarded to /v1/completions, got %s", upstream.lastReq.URL.Path)
+	}
+
+	// A single-element prompt array is the same prompt
+	hit := send(api.CompletionRequest{Model: "gpt-3.5-turbo-instruct", Prompt: []string{prompt}})
+	if got := hit.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Fatalf("expected HIT, got %q", got)
+	}
+	if !bytes.Equal(hit.Body.Bytes(), miss.Body.Bytes()) {
+		t.Errorf("expected the upstream body replayed verbatim, got %s", hit.Body.String())
+	}
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+
+	stats := h.collector.GetReport()
+	if stats.TotalHits != 1 || stats.TotalMisses != 1 {
+		t.Errorf("expected 1 hit and 1 miss recorded, got %d and %d", stats.TotalHits, stats.TotalMisses)
+	}
+
+	// Chat completions never see legacy entries
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, prompt))))
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected chat request to miss, got %q", got)
+	}
+
+	for name, req := range map[string]api.CompletionRequest{
+		"batched prompts": {Model: "gpt-3.5-turbo-instruct", Prompt: []string{prompt, "The capital of Spain is"}},
+		"suffix":          {Model: "gpt-3.5-turbo-instruct", Prompt: prompt, Suffix: "."},
+		"echo":            {Model: "gpt-3.5-turbo-instruct", Prompt: prompt, Echo: true},
+	} {
+		if got := send(req).Header().Get("X-Mimir-Cache"); got != "BYPASS" {
+			t.Errorf("%s: expected BYPASS, got %q", name, got)
+		}
+	}
+}
+
+func TestHandleCompletionsCompressed(t *testing.T) {
+	text := strings.Repeat(" Paris has been the capital of France since the tenth century.", 40)
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.CompletionResponse{
+			ID:      "cmpl-essay",
+			Object:  "text_completion",
+			Model:   "gpt-3.5-turbo-instruct",
+			Choices: []api.CompletionChoice{{Text: text, FinishReason: "stop"}},
+			Usage:   api.Usage{PromptTokens: 8, CompletionTokens: 500, TotalTokens: 508},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	h := newTestHandler(t, upstream, nil)
+	h.cache = cache.NewMemoryCache(&cache.Options{
+		MaxSize:           100,
+		DefaultTTL:        time.Hour,
+		CleanupInterval:   time.Hour,
+		CompressResponses: true,
+	})
+
+	body, _ := json.Marshal(api.CompletionRequest{Model: "gpt-3.5-turbo-instruct", Prompt: "The capital of France is"})
+	var recs [2]*httptest.ResponseRecorder
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		h.ServeHTTP(recs[i], httptest.NewRequest(http.MethodPost, "/v1/completions", bytes.NewReader(body)))
+	}
+
+	if got := recs[1].Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Fatalf("expected a hit on the compressed entry, got %q", got)
+	}
+	if !bytes.Equal(recs[1].Body.Bytes(), recs[0].Body.Bytes()) {
+		t.Errorf("expected the upstream body replayed verbatim, got %s", recs[1].Body.String())
+	}
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+	entries, _, err := h.cache.(*cache.MemoryCache).List(context.Background(), "", 0, 10)
+	if err != nil || len(entries) != 1 || !entries[0].CompressedRaw {
+		t.Error("expected the stored entry to be compressed")
+	}
+}
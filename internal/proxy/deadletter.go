@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aqstack/mimir/internal/reports"
+)
+
+// upstreamError is a failed upstream call: a transport error, relayed to
+// the client as 502, or a server error status relayed as is.
+type upstreamError struct {
+	status int
+	err    error
+}
+
+func (e *upstreamError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("upstream returned status %d", e.status)
+}
+
+// upstreamFailure returns the failure of an upstream call that ended with
+// resp and err, or nil when the upstream answered below 500.
+func upstreamFailure(resp *http.Response, err error) error {
+	if err != nil {
+		return &upstreamError{status: http.StatusBadGateway, err: err}
+	}
+	if resp.StatusCode >= 500 {
+		return &upstreamError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+// recordFailure adds a request that failed both cache and upstream to the
+// dead-letter log served at /reports/errors. The status defaults to the
+// one upstreamErr was relayed with.
+func (h *Handler) recordFailure(r *http.Request, f reports.FailedRequest, upstreamErr error, startTime time.Time, timings phaseTimings) {
+	f.Endpoint = r.URL.Path
+	f.Error = upstreamErr.Error()
+	var ue *upstreamError
+	if f.Status == 0 && errors.As(upstreamErr, &ue) {
+		f.Status = ue.status
+	}
+	f.LatencyMs = time.Since(startTime).Milliseconds()
+	f.EmbedMs = timings.embed.Milliseconds()
+	f.LookupMs = timings.lookup.Milliseconds()
+	f.UpstreamMs = timings.upstream.Milliseconds()
+	h.collector.RecordFailure(f)
+}
+
+// handleErrors serves the dead-letter log as JSON, oldest first. Records
+// hold prompts and upstream error bodies, so it requires the admin token.
+func (h *Handler) handleErrors(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.collector.Failures())
+}
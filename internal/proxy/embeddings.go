@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// handleEmbeddings serves /v1/embeddings, reusing cached vectors per input.
+// Only inputs missing from the cache are sent upstream, and the response is
+// reassembled in request order regardless of how the batch was ordered.
+func (h *Handler) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	// Decode compressed bodies for parsing; the original bytes are forwarded
+	// when nothing can be served from the cache
+	decoded, err := h.decodeRequestBody(r, body)
+	if err != nil {
+		h.writeError(w, err.Error(), bodyErrorStatus(err, http.StatusUnsupportedMediaType))
+		return
+	}
+
+	var req api.EmbeddingRequest
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(decoded, &req); err != nil {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(decoded, &fields); err != nil || fields == nil {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	inputs, ok := embeddingInputs(req.Input)
+	// Token-array inputs and base64 encoding are passed through untouched
+	if !ok || (req.EncodingFormat != "" && req.EncodingFormat != "float") {
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	dimensions := 0
+	if req.Dimensions != nil {
+		dimensions = *req.Dimensions
+	}
+
+	vectors := make([][]float64, len(inputs))
+	keys := make([]string, len(inputs))
+	var missing []string
+	missingIdx := make(map[string][]int)
+	for i, input := range inputs {
+		keys[i] = cache.EmbeddingKey(req.Model, dimensions, input)
+		if vec, found := h.embeddings.Get(keys[i]); found {
+			vectors[i] = vec
+			continue
+		}
+		if _, seen := missingIdx[input]; !seen {
+			missing = append(missing, input)
+		}
+		missingIdx[input] = append(missingIdx[input], i)
+	}
+
+	var usage api.EmbeddingUsage
+	model := req.Model
+	if len(missing) > 0 {
+		// Only input is replaced, so fields mimir does not know about reach
+		// the upstream unchanged. The new body is sent uncompressed.
+		fields["input"], _ = json.Marshal(missing)
+		upstreamBody, _ := json.Marshal(fields)
+		upstreamReq := r.Clone(r.Context())
+		upstreamReq.Header.Del("Content-Encoding")
+
+		resp, respBody, err := h.doUpstreamRequest(r.Context(), upstreamReq, upstreamBody)
+		if err != nil {
+			h.log(r.Context()).Error("upstream embeddings request failed", "error", err)
+			h.writeError(w, "Upstream request failed", http.StatusBadGateway)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			h.copyUpstreamHeaders(w, resp.Header)
+			w.WriteHeader(resp.StatusCode)
+			w.Write(respBody)
+			return
+		}
+
+		var embResp api.EmbeddingResponse
+		if err := json.Unmarshal(respBody, &embResp); err != nil || len(embResp.Data) != len(missing) {
+			h.log(r.Context()).Warn("unexpected upstream embeddings response", "error", err)
+			h.writeError(w, "Invalid upstream response", http.StatusBadGateway)
+			return
+		}
+
+		for _, d := range embResp.Data {
+			if d.Index < 0 || d.Index >= len(missing) {
+				continue
+			}
+			input := missing[d.Index]
+			for _, i := range missingIdx[input] {
+				vectors[i] = d.Embedding
+			}
+			if !h.cfg.CacheReadOnly {
+				h.embeddings.Set(keys[missingIdx[input][0]], d.Embedding)
+			}
+		}
+		usage = embResp.Usage
+		if embResp.Model != "" {
+			model = embResp.Model
+		}
+	}
+
+	result := api.EmbeddingResponse{
+		Object: "list",
+		Data:   make([]api.EmbeddingData, len(inputs)),
+		Model:  model,
+		Usage:  usage,
+	}
+	for i, vec := range vectors {
+		result.Data[i] = api.EmbeddingData{
+			Object:    "embedding",
+			Embedding: vec,
+			Index:     i,
+		}
+	}
+
+	switch {
+	case len(missing) == 0:
+		w.Header().Set("X-Mimir-Cache", "HIT")
+	case len(missing) < len(inputs):
+		w.Header().Set("X-Mimir-Cache", "PARTIAL")
+	default:
+		w.Header().Set("X-Mimir-Cache", "MISS")
+	}
+
+	h.log(r.Context()).Debug("embeddings request served",
+		"inputs", len(inputs),
+		"cached", len(inputs)-countIndexes(missingIdx),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// embeddingInputs normalizes an embeddings input to a list of strings.
+// It returns false for token-array inputs, which are not cached.
+func embeddingInputs(input interface{}) ([]string, bool) {
+	switch v := input.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		inputs := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			inputs[i] = s
+		}
+		return inputs, len(inputs) > 0
+	default:
+		return nil, false
+	}
+}
+
+func countIndexes(m map[string][]int) int {
+	n := 0
+	for _, idx := range m {
+		n += len(idx)
+	}
+	return n
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEntriesLimit = 50
+	maxEntriesLimit     = 1000
+	entryPromptLen      = 200
+)
+
+// entryInfo describes a cached entry for /cache/entries.
+type entryInfo struct {
+	ID         string    `json:"id"`
+	Model      string    `json:"model"`
+	Partition  string    `json:"partition,omitempty"`
+	Prompt     string    `json:"prompt"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastHitAt  time.Time `json:"last_hit_at"`
+	HitCount   int64     `json:"hit_count"`
+	Dimensions int       `json:"dimensions"`
+	Embedding  []float64 `json:"embedding,omitempty"`
+}
+
+// entriesPage is the response of /cache/entries.
+type entriesPage struct {
+	Entries []entryInfo `json:"entries"`
+	Total   int         `json:"total"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+}
+
+// handleCacheEntries lists cached entries, oldest first, filtered by
+// ?model= and paginated by ?offset= and ?limit=. Embeddings are left out
+// unless ?embedding=true. Entries hold prompts and responses, so it
+// requires the admin token.
+func (h *Handler) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	offset, limit := 0, defaultEntriesLimit
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.writeError(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.writeError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxEntriesLimit {
+		limit = maxEntriesLimit
+	}
+	withEmbedding := q.Get("embedding") == "true"
+
+	entries, total, err := h.cache.List(r.Context(), q.Get("model"), offset, limit)
+	if err != nil {
+		h.log(r.Context()).Warn("failed to list cache entries", "error", err)
+		h.writeError(w, "Failed to list cache entries", http.StatusBadGateway)
+		return
+	}
+
+	page := entriesPage{Entries: make([]entryInfo, 0, len(entries)), Total: total, Offset: offset, Limit: limit}
+	for _, e := range entries {
+		vec := e.RawEmbedding
+		if vec == nil {
+			vec = e.Embedding
+		}
+		info := entryInfo{
+			ID:         e.ID,
+			Model:      e.Request.Model,
+			Partition:  e.Partition,
+			Prompt:     truncatePrompt(h.generateCacheKey(e.Request), entryPromptLen),
+			CreatedAt:  e.CreatedAt,
+			ExpiresAt:  e.ExpiresAt,
+			LastHitAt:  e.LastHitAt,
+			HitCount:   e.HitCount,
+			Dimensions: len(vec),
+		}
+		if withEmbedding {
+			info.Embedding = vec
+		}
+		page.Entries = append(page.Entries, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/aqstack/mimir/internal/cache"
+)
+
+// maxEvalPairs caps the labeled pairs one evaluation embeds, since every
+// pair costs two embeddings.
+const maxEvalPairs = 1000
+
+// LabeledPair is a pair of queries labeled with whether they should share a cache entry.
+type LabeledPair struct {
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Match bool   `json:"match"`
+}
+
+// PairResult is the similarity computed for a labeled pair.
+type PairResult struct {
+	LabeledPair
+	Similarity float64 `json:"similarity"`
+}
+
+// ThresholdEval reports how well a similarity threshold separates labeled pairs.
+type ThresholdEval struct {
+	Model         string       `json:"model"`
+	Pairs         int          `json:"pairs"`
+	Threshold     float64      `json:"threshold"`
+	Precision     float64      `json:"precision"`
+	Recall        float64      `json:"recall"`
+	F1            float64      `json:"f1"`
+	BestThreshold float64      `json:"best_threshold"`
+	BestPrecision float64      `json:"best_precision"`
+	BestRecall    float64      `json:"best_recall"`
+	BestF1        float64      `json:"best_f1"`
+	Results       []PairResult `json:"results"`
+}
+
+// handleEvalThreshold embeds labeled query pairs and reports precision/recall
+// of the configured threshold, plus the threshold that maximizes F1. It
+// spends embedding calls, so it requires the admin token.
+func (h *Handler) handleEvalThreshold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	var pairs []LabeledPair
+	if err := json.NewDecoder(r.Body).Decode(&pairs); err != nil {
+		h.writeError(w, "Invalid request body", bodyErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+	if len(pairs) == 0 {
+		h.writeError(w, "At least one labeled pair is required", http.StatusBadRequest)
+		return
+	}
+	if len(pairs) > maxEvalPairs {
+		h.writeError(w, fmt.Sprintf("At most %d labeled pairs are accepted", maxEvalPairs), http.StatusBadRequest)
+		return
+	}
+
+	texts := make([]string, 0, len(pairs)*2)
+	for _, p := range pairs {
+		texts = append(texts, p.A, p.B)
+	}
+
+	done := h.collector.TrackEmbed()
+	embeddings, err := h.embedder.EmbedBatch(r.Context(), texts)
+	done()
+	if err != nil {
+		h.log(r.Context()).Error("threshold evaluation embedding failed", "error", err)
+		h.writeError(w, "Failed to embed pairs", http.StatusBadGateway)
+		return
+	}
+
+	results := make([]PairResult, len(pairs))
+	for i, p := range pairs {
+		results[i] = PairResult{
+			LabeledPair: p,
+			Similarity:  cache.CosineSimilarity(embeddings[2*i], embeddings[2*i+1]),
+		}
+	}
+
+	eval := evaluateThreshold(results, h.similarityThreshold())
+	eval.Model = h.embedder.Model()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eval)
+}
+
+// evaluateThreshold scores results at threshold and sweeps every observed
+// similarity as a candidate threshold to find the best F1.
+func evaluateThreshold(results []PairResult, threshold float64) *ThresholdEval {
+	eval := &ThresholdEval{
+		Pairs:     len(results),
+		Threshold: threshold,
+		Results:   results,
+	}
+	eval.Precision, eval.Recall, eval.F1 = scoreThreshold(results, threshold)
+
+	candidates := make([]float64, len(results))
+	for i, r := range results {
+		candidates[i] = r.Similarity
+	}
+	// Highest first so ties resolve to the stricter threshold
+	sort.Sort(sort.Reverse(sort.Float64Slice(candidates)))
+
+	eval.BestF1 = -1
+	for _, t := range candidates {
+		p, r, f1 := scoreThreshold(results, t)
+		if f1 > eval.BestF1 {
+			eval.BestThreshold, eval.BestPrecision, eval.BestRecall, eval.BestF1 = t, p, r, f1
+		}
+	}
+
+	return eval
+}
+
+// scoreThreshold computes precision, recall and F1 treating similarity >= t as a predicted match.
+func scoreThreshold(results []PairResult, t float64) (precision, recall, f1 float64) {
+	var tp, fp, fn int
+	for _, r := range results {
+		predicted := r.Similarity >= t
+		switch {
+		case predicted && r.Match:
+			tp++
+		case predicted && !r.Match:
+			fp++
+		case !predicted && r.Match:
+			fn++
+		}
+	}
+
+	if tp+fp > 0 {
+		precision = float64(tp) / float64(tp+fp)
+	}
+	if tp+fn > 0 {
+		recall = float64(tp) / float64(tp+fn)
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
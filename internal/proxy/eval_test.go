@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aqstack/mimir/internal/config"
+)
+
+// evalResults are labeled pairs with fixed similarities: three matches and
+// two non-matches, one of them scoring above a match.
+var evalResults = []PairResult{
+	{LabeledPair: LabeledPair{Match: true}, Similarity: 0.9},
+	{LabeledPair: LabeledPair{Match: true}, Similarity: 0.8},
+	{LabeledPair: LabeledPair{Match: false}, Similarity: 0.7},
+	{LabeledPair: LabeledPair{Match: true}, Similarity: 0.4},
+	{LabeledPair: LabeledPair{Match: false}, Similarity: 0.2},
+}
+
+func TestScoreThreshold(t *testing.T) {
+	tests := []struct {
+		threshold     float64
+		wantPrecision float64
+		wantRecall    float64
+		wantF1        float64
+	}{
+		{threshold: 0.95, wantPrecision: 0, wantRecall: 0, wantF1: 0},
+		{threshold: 0.75, wantPrecision: 1, wantRecall: 2.0 / 3, wantF1: 0.8},
+		{threshold: 0.5, wantPrecision: 2.0 / 3, wantRecall: 2.0 / 3, wantF1: 2.0 / 3},
+		{threshold: 0.3, wantPrecision: 0.75, wantRecall: 1, wantF1: 6.0 / 7},
+		{threshold: 0.1, wantPrecision: 0.6, wantRecall: 1, wantF1: 0.75},
+	}
+
+	for _, tt := range tests {
+		precision, recall, f1 := scoreThreshold(evalResults, tt.threshold)
+		if math.Abs(precision-tt.wantPrecision) > 1e-9 || math.Abs(recall-tt.wantRecall) > 1e-9 || math.Abs(f1-tt.wantF1) > 1e-9 {
+			t.Errorf("scoreThreshold(%v) = %v, %v, %v, want %v, %v, %v",
+				tt.threshold, precision, recall, f1, tt.wantPrecision, tt.wantRecall, tt.wantF1)
+		}
+	}
+}
+
+func TestEvaluateThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		results       []PairResult
+		wantThreshold float64
+		wantF1        float64
+	}{
+		{name: "mixed", results: evalResults, wantThreshold: 0.4, wantF1: 6.0 / 7},
+		{
+			// 0.9 and 0.4 both score an F1 of 2/3, so the stricter wins
+			name: "ties",
+			results: []PairResult{
+				{LabeledPair: LabeledPair{Match: true}, Similarity: 0.9},
+				{LabeledPair: LabeledPair{Match: false}, Similarity: 0.6},
+				{LabeledPair: LabeledPair{Match: false}, Similarity: 0.5},
+				{LabeledPair: LabeledPair{Match: true}, Similarity: 0.4},
+			},
+			wantThreshold: 0.9,
+			wantF1:        2.0 / 3,
+		},
+		{
+			name: "no matches",
+			results: []PairResult{
+				{LabeledPair: LabeledPair{Match: false}, Similarity: 0.9},
+			},
+			wantThreshold: 0.9,
+			wantF1:        0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := evaluateThreshold(tt.results, 0.75)
+			if eval.Pairs != len(tt.results) || eval.Threshold != 0.75 {
+				t.Errorf("expected %d pairs at 0.75, got %d at %v", len(tt.results), eval.Pairs, eval.Threshold)
+			}
+			if eval.BestThreshold != tt.wantThreshold || math.Abs(eval.BestF1-tt.wantF1) > 1e-9 {
+				t.Errorf("expected best threshold %v with F1 %v, got %v with F1 %v",
+					tt.wantThreshold, tt.wantF1, eval.BestThreshold, eval.BestF1)
+			}
+		})
+	}
+}
+
+func TestHandleEvalThreshold(t *testing.T) {
+	upstream := newFakeUpstream(t)
+
+	t.Run("disabled without token", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/eval/threshold", bytes.NewReader([]byte(`[]`))))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", rec.Code)
+		}
+	})
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	embedder := h.embedder.(*fakeEmbedder)
+	eval := func(token string, pairs []LabeledPair) (*httptest.ResponseRecorder, ThresholdEval) {
+		t.Helper()
+		body, _ := json.Marshal(pairs)
+		req := httptest.NewRequest(http.MethodPost, "/admin/eval/threshold", bytes.NewReader(body))
+		req.Header.Set("X-Mimir-Admin-Token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var result ThresholdEval
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec, result
+	}
+
+	// The fake embedder scores identical texts 1 and different texts 0
+	pairs := []LabeledPair{
+		{A: "capital of France", B: "capital of France", Match: true},
+		{A: "capital of France", B: "capital of Spain", Match: false},
+		{A: "capital of France", B: "France's capital city", Match: true},
+	}
+	if rec, _ := eval("wrong", pairs); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 with the wrong token, got %d", rec.Code)
+	}
+
+	rec, result := eval("secret", pairs)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result.Model != "fake-embed" || result.Pairs != 3 || len(result.Results) != 3 {
+		t.Errorf("unexpected evaluation: %+v", result)
+	}
+	if result.Precision != 1 || result.Recall != 0.5 {
+		t.Errorf("expected precision 1 and recall 0.5 at the configured threshold, got %v and %v", result.Precision, result.Recall)
+	}
+	if result.BestThreshold != 0 || math.Abs(result.BestF1-0.8) > 1e-9 {
+		t.Errorf("expected best threshold 0 with F1 0.8, got %v with F1 %v", result.BestThreshold, result.BestF1)
+	}
+
+	calls := embedder.calls.Load()
+	if rec, _ := eval("secret", make([]LabeledPair, maxEvalPairs+1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 above %d pairs, got %d", maxEvalPairs, rec.Code)
+	}
+	if rec, _ := eval("secret", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without pairs, got %d", rec.Code)
+	}
+	if got := embedder.calls.Load(); got != calls {
+		t.Errorf("expected rejected evaluations not to embed, got %d calls", got-calls)
+	}
+}
@@ -3,19 +3,27 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aqstack/mimir/internal/cache"
 	"github.com/aqstack/mimir/internal/config"
 	"github.com/aqstack/mimir/internal/embedding"
 	"github.com/aqstack/mimir/internal/logger"
 	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/internal/rules"
+	"github.com/aqstack/mimir/internal/tracing"
 	"github.com/aqstack/mimir/pkg/api"
 )
 
@@ -27,39 +35,159 @@ type Handler struct {
 	client    *http.Client
 	logger    *logger.Logger
 	collector *reports.Collector
+
+	// embeddings caches /v1/embeddings vectors per input (nil when disabled)
+	embeddings *cache.EmbeddingStore
+
+	// embedders are the models selectable with X-Mimir-Embed-Model, by name
+	embedders map[string]embedding.Embedder
+
+	// flights coalesces concurrent misses for the same prompt
+	flights flightGroup
+
+	// upstream bounds concurrent upstream requests (nil when unbounded)
+	upstream *upstreamGate
+
+	// keyStrip removes volatile text from cache keys (nil when unset)
+	keyStrip *regexp.Regexp
+
+	// compare mirrors sampled traffic through a candidate embedder (nil when off)
+	compare *embedderCompare
+
+	// tracer records spans for chat requests (nil when tracing is off)
+	tracer *tracing.Tracer
+
+	// lastEmbed is when the default embedder last succeeded, in Unix
+	// nanoseconds, sparing /health/ready a probe while traffic flows
+	lastEmbed atomic.Int64
+
+	// threshold holds the bits of the default similarity threshold, which
+	// /config/threshold can change while requests are served
+	threshold atomic.Uint64
 }
 
 // NewHandler creates a new proxy handler.
 func NewHandler(cfg *config.Config, c cache.Cache, e embedding.Embedder, log *logger.Logger) *Handler {
-	return &Handler{
+	h := &Handler{
 		cfg:      cfg,
 		cache:    c,
 		embedder: e,
-		client: &http.Client{
-			Timeout: 2 * time.Minute,
-		},
+		// Timeouts are applied per request from the route timeout map
+		client:    &http.Client{},
 		logger:    log,
 		collector: reports.NewCollector(),
+		embedders: map[string]embedding.Embedder{e.Model(): e},
 	}
+
+	h.setSimilarityThreshold(cfg.SimilarityThreshold)
+	h.flights.onWait = h.collector.TrackWaiting
+	if cfg.MaxUpstreamConcurrency > 0 {
+		h.upstream = newUpstreamGate(cfg.MaxUpstreamConcurrency)
+		h.upstream.onWait = h.collector.TrackWaiting
+	}
+	h.collector.SetSeenLimit(cfg.SeenPromptsSize)
+	h.collector.SetErrorLogLimit(cfg.ErrorLogSize)
+	if cfg.SavingsRetentionDays > 0 {
+		h.collector.SetSavingsRetention(cfg.SavingsRetentionDays)
+	}
+	if cfg.PricingJSON != "" {
+		// Validate has already rejected pricing that does not parse
+		pricing, _ := reports.ParsePricing(cfg.PricingJSON)
+		h.collector.SetPricing(pricing)
+	}
+
+	if c, ok := c.(cleanupReporter); ok {
+		c.OnCleanup(h.collector.RecordCleanup)
+	}
+
+	if cfg.CacheEmbeddings {
+		h.embeddings = cache.NewEmbeddingStore(cfg.MaxCacheSize, cfg.CacheTTL)
+	}
+	if cfg.CacheKeyStrip != "" {
+		// Validate has already rejected patterns that do not compile
+		h.keyStrip = regexp.MustCompile(cfg.CacheKeyStrip)
+	}
+
+	return h
+}
+
+// RegisterEmbedder makes an additional embedding model selectable per
+// request with the X-Mimir-Embed-Model header.
+func (h *Handler) RegisterEmbedder(e embedding.Embedder) {
+	h.embedders[e.Model()] = e
+}
+
+// SetTracer records a span for every chat completion request, with child
+// spans for its embedding, cache and upstream calls, through t.
+func (h *Handler) SetTracer(t *tracing.Tracer) {
+	h.tracer = t
+}
+
+// Collector returns the metrics collector behind the reports dashboard.
+func (h *Handler) Collector() *reports.Collector {
+	return h.collector
 }
 
 // ServeHTTP handles incoming requests.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Cache dumps are routinely larger than any API request
+	if h.cfg.MaxBodyBytes > 0 && r.URL.Path != "/cache/load" {
+		r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxBodyBytes)
+	}
+
 	switch {
 	case r.URL.Path == "/health":
 		h.handleHealth(w, r)
+	case r.URL.Path == "/readyz":
+		h.handleReady(w, r)
+	case r.URL.Path == "/health/ready":
+		h.handleHealthReady(w, r)
 	case r.URL.Path == "/stats":
 		h.handleStats(w, r)
+	case r.URL.Path == "/metrics" && h.cfg.MetricsEnabled && h.cfg.MetricsOnMainPort():
+		h.HandleMetrics(w, r)
+	case r.URL.Path == "/cache" || r.URL.Path == "/cache/invalidate":
+		h.handleInvalidate(w, r)
+	case r.URL.Path == "/cache/dump":
+		h.handleCacheDump(w, r)
+	case r.URL.Path == "/cache/load":
+		h.handleCacheLoad(w, r)
+	case r.URL.Path == "/cache/entries":
+		h.handleCacheEntries(w, r)
+	case r.URL.Path == "/cache/warmup":
+		h.handleWarmup(w, r)
 	case r.URL.Path == "/reports" || r.URL.Path == "/reports/":
 		h.handleDashboard(w, r)
 	case r.URL.Path == "/reports/data":
 		h.handleReportsData(w, r)
+	case r.URL.Path == "/reports/savings":
+		h.handleSavings(w, r)
 	case r.URL.Path == "/reports/logs":
 		h.handleLogs(w, r)
 	case r.URL.Path == "/reports/logs/clear":
 		h.handleClearLogs(w, r)
+	case r.URL.Path == "/reports/errors":
+		h.handleErrors(w, r)
+	case r.URL.Path == "/reports/embedder-compare":
+		h.handleEmbedderCompare(w, r)
+	case r.URL.Path == "/reports/generate-traffic":
+		h.handleGenerateTraffic(w, r)
+	case r.URL.Path == "/config/threshold":
+		h.handleThreshold(w, r)
+	case r.URL.Path == "/admin/eval/threshold":
+		h.handleEvalThreshold(w, r)
+	case r.URL.Path == "/admin/gc":
+		h.handleGC(w, r)
+	case r.URL.Path == "/admin/verify":
+		h.handleVerify(w, r)
 	case r.URL.Path == "/v1/chat/completions":
 		h.handleChatCompletions(w, r)
+	case r.URL.Path == "/v1/completions":
+		h.handleCompletions(w, r)
+	case r.URL.Path == "/v1/messages":
+		h.handleMessages(w, r)
+	case r.URL.Path == "/v1/embeddings" && h.embeddings != nil:
+		h.handleEmbeddings(w, r)
 	case strings.HasPrefix(r.URL.Path, "/v1/"):
 		// Pass through other OpenAI endpoints
 		h.handlePassthrough(w, r)
@@ -74,107 +202,411 @@ func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// handleReady reports whether the proxy can serve traffic. Unlike
+// /health it checks that the cache backend is reachable, naming the backend
+// and the error when it is not.
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := h.cache.Ping(r.Context()); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "unavailable",
+			"backend": h.cfg.CacheBackend,
+			"error":   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// HandleMetrics serves the reports collector's totals in the Prometheus
+// text format, on the main port or a dedicated metrics listener.
+func (h *Handler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.collector.WritePrometheus(w, h.cache.Size(r.Context())); err != nil {
+		h.log(r.Context()).Warn("failed to write metrics", "error", err)
+		return
+	}
+	if h.cfg.MetricsReport {
+		if err := h.collector.WriteReportMetrics(w); err != nil {
+			h.log(r.Context()).Warn("failed to write report metrics", "error", err)
+		}
+	}
+}
+
 // handleStats handles cache statistics requests.
 func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
 	stats := h.cache.Stats(r.Context())
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	json.NewEncoder(w).Encode(struct {
+		*api.CacheStats
+		reports.Saturation
+	}{stats, h.collector.Saturation()})
+}
+
+// cleanupReporter is implemented by caches that report their background
+// cleanup runs.
+type cleanupReporter interface {
+	OnCleanup(fn cache.CleanupFunc)
+}
+
+// snapshotter is implemented by caches that can export their entries.
+type snapshotter interface {
+	Snapshot(w io.Writer) (int, error)
+}
+
+// handleCacheDump streams every cache entry as JSON lines, including raw
+// embeddings when they are retained. Entries hold prompts and responses, so
+// it requires the admin token.
+func (h *Handler) handleCacheDump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	s, ok := h.cache.(snapshotter)
+	if !ok {
+		h.writeError(w, "Cache backend does not support dumps", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if _, err := s.Snapshot(w); err != nil {
+		h.log(r.Context()).Warn("failed to dump cache", "error", err)
+	}
 }
 
 // handleChatCompletions handles chat completion requests with caching.
 func (h *Handler) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx, span := h.tracer.StartRequest(r.Context(), r, "chat.completions")
+	defer span.End()
+	r = r.WithContext(ctx)
 	startTime := time.Now()
 
 	// Read request body
-	body, err := io.ReadAll(r.Body)
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	// Decode compressed bodies for parsing; the original bytes are forwarded
+	decoded, err := h.decodeRequestBody(r, body)
 	if err != nil {
-		h.writeError(w, "Failed to read request body", http.StatusBadRequest)
+		h.writeError(w, err.Error(), bodyErrorStatus(err, http.StatusUnsupportedMediaType))
 		return
 	}
-	r.Body.Close()
 
 	// Parse request
 	var req api.ChatCompletionRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := json.Unmarshal(decoded, &req); err != nil {
 		h.writeError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	span.SetAttr("model", req.Model)
 
-	// Skip caching for streaming requests
-	if req.Stream {
-		h.logger.Debug("skipping cache for streaming request")
+	// Skip caching for models that are never cached
+	if h.cfg.NoCache(req.Model) {
+		h.log(ctx).Debug("skipping cache for model", "model", req.Model)
+		w.Header().Set("X-Mimir-Cache", "SKIP")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	// Skip caching for requests sampling above the cacheable temperature
+	if !h.temperatureCacheable(req.Temperature) {
+		h.log(ctx).Debug("skipping cache for temperature", "temperature", *req.Temperature)
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	// Skip caching when a configured rule forbids it
+	if len(h.cfg.CacheRules) > 0 {
+		var doc interface{}
+		json.Unmarshal(decoded, &doc)
+		if ok, rule := rules.Cacheable(h.cfg.CacheRules, doc); !ok {
+			h.log(ctx).Debug("skipping cache due to rule", "rule", rule.String())
+			w.Header().Set("X-Mimir-Cache", "BYPASS")
+			h.forwardRequest(w, r, body)
+			return
+		}
+	}
+
+	// Skip caching when the client asks for the response not to be stored
+	ttl, store := h.requestTTL(r, req.Model)
+	if !store {
+		h.log(ctx).Debug("skipping cache due to X-Mimir-TTL")
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
 		h.forwardRequest(w, r, body)
 		return
 	}
 
-	// Generate cache key from messages
+	// Skip caching for tool requests when tool-aware caching is off
+	if h.cfg.ToolCaching == toolCachingSkip && usesTools(req) {
+		h.log(ctx).Debug("skipping cache for tool request")
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	// Skip caching for prefill requests when prefill caching is off
+	if h.cfg.PrefillCaching == prefillCachingSkip && hasPrefill(req) {
+		h.log(ctx).Debug("skipping cache for prefill request")
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+
+	// Skip caching for streaming requests unless stream caching is enabled
+	if req.Stream && !h.cfg.CacheStreams {
+		h.log(ctx).Debug("skipping cache for streaming request")
+		h.handleStream(w, r, req, body, decoded, nil)
+		return
+	}
+
+	// Generate cache key from messages, skipping the cache for keys too
+	// long to embed
 	cacheKey := h.generateCacheKey(req)
+	embedText, ok := h.embedInput(cacheKey)
+	if !ok {
+		w.Header().Set("X-Mimir-Cache", "BYPASS")
+		h.forwardRequest(w, r, body)
+		return
+	}
+	embedder := h.selectEmbedder(w, r)
+	partition := h.requestPartition(ctx, r, embedder, req)
+	ctx = cache.WithPartition(ctx, partition)
 
 	// Get embedding for cache lookup
-	emb, err := h.embedder.Embed(ctx, cacheKey)
+	var timings phaseTimings
+	phaseStart := time.Now()
+	embedCtx, embedSpan := tracing.Start(ctx, "embed")
+	emb, err := h.embed(embedCtx, embedder, embedText)
+	embedSpan.SetAttr("embed_model", embedder.Model())
+	embedSpan.SetError(err)
+	embedSpan.End()
+	timings.embed = time.Since(phaseStart)
 	if err != nil {
-		h.logger.Warn("failed to generate embedding, forwarding request", "error", err)
-		h.forwardRequest(w, r, body)
+		h.log(ctx).Warn("failed to generate embedding, forwarding request", "error", err)
+		phaseStart = time.Now()
+		var upstreamErr error
+		if req.Stream {
+			_, upstreamErr = h.handleStream(w, r, req, body, decoded, nil)
+		} else {
+			upstreamErr = h.forwardRequest(w, r, body)
+		}
+		timings.upstream = time.Since(phaseStart)
+		if upstreamErr != nil {
+			h.recordFailure(r, reports.FailedRequest{
+				Model:      req.Model,
+				Stream:     req.Stream,
+				Prompt:     cacheKey,
+				EmbedError: err.Error(),
+			}, upstreamErr, startTime, timings)
+		}
 		return
 	}
+	if embedder == h.embedder {
+		h.mirrorCompare(partition, req.Model, embedText, emb)
+	}
 
-	// Check cache
-	if entry, similarity, found := h.cache.Get(ctx, emb, h.cfg.SimilarityThreshold); found {
+	// Check cache, unless the client asked for a fresh answer
+	var entry *api.CacheEntry
+	var similarity float64
+	var found bool
+	refresh := noCache(r)
+	phaseStart = time.Now()
+	if !refresh {
+		getCtx, getSpan := tracing.Start(ctx, "cache.get")
+		entry, similarity, found = h.cache.Get(getCtx, emb, h.thresholdFor(req.Model))
+		getSpan.SetAttr("cache_hit", found)
+		if found {
+			getSpan.SetAttr("similarity", similarity)
+		}
+		getSpan.End()
+		// Cached errors only answer the identical request body, so they
+		// are looked up under their own exact key rather than by similarity
+		if found && entry.ErrorStatus != 0 {
+			found = false
+		}
+		if !found && h.cfg.NegativeCacheTTL > 0 {
+			if errEntry, errSimilarity, ok := h.lookupError(errorContext(ctx, decoded), emb, h.thresholdFor(req.Model)); ok {
+				timings.lookup = time.Since(phaseStart)
+				h.writeCachedError(ctx, w, req, errEntry, errSimilarity, cacheKey, startTime)
+				h.logSlowRequest(ctx, "HIT-ERROR", time.Since(startTime), timings, cacheKey)
+				return
+			}
+		}
+	}
+	timings.lookup = time.Since(phaseStart)
+	span.SetAttr("cache_hit", found)
+	if found {
+		span.SetAttr("similarity", similarity)
+	}
+	if found {
 		latencyMs := time.Since(startTime).Milliseconds()
-		h.logger.Info("cache hit",
+		h.log(ctx).Info("cache hit",
 			"similarity", fmt.Sprintf("%.4f", similarity),
 			"latency_ms", latencyMs,
 		)
 
-		// Record metrics - estimate tokens saved based on response
-		tokensSaved := entry.Response.Usage.TotalTokens
-		h.collector.RecordRequest(true, similarity, latencyMs, tokensSaved, cacheKey)
+		// Compressed entries are only parsed when the body has to be rewritten
+		response := entry.Response
+		var cached []byte
+		if entry.CompressedResponse != nil {
+			var err error
+			if h.cfg.InjectCacheMeta || req.Stream {
+				response, err = cache.DecodeResponse(entry)
+			} else {
+				cached, err = cache.ResponseBody(entry)
+			}
+			if err != nil {
+				h.log(ctx).Error("failed to read cached response", "error", err)
+				h.writeError(w, "Failed to read cached response", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Record metrics, pricing the tokens the cached response saved
+		h.collector.RecordModelUsage(req.Model, true, similarity, latencyMs, entry.Response.Usage, cacheKey)
 		h.collector.AddLog("hit", fmt.Sprintf("[HIT] %.2f%% sim, %dms - %s", similarity*100, latencyMs, truncatePrompt(cacheKey, 80)))
+		expires := h.slideTTL(entry, ttl)
 
 		// Return cached response with cache header
-		w.Header().Set("Content-Type", "application/json")
+		replayHeaders(w, entry)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
 		w.Header().Set("X-Mimir-Cache", "HIT")
 		w.Header().Set("X-Mimir-Similarity", fmt.Sprintf("%.4f", similarity))
-		json.NewEncoder(w).Encode(entry.Response)
+		w.Header().Set("X-Mimir-Saved-USD", fmt.Sprintf("%.6f", h.collector.SavedUSD(req.Model, entry.Response.Usage)))
+		setAgeHeaders(w, entry, expires)
+		if h.cfg.EmbedModelHeader {
+			model := entry.EmbedModel
+			if model == "" {
+				model = embedder.Model()
+			}
+			w.Header().Set("X-Mimir-Embed-Model", model)
+		}
+		if h.notModified(w, r, entry.ID) {
+			h.logSlowRequest(ctx, "HIT", time.Since(startTime), timings, cacheKey)
+			return
+		}
+		if req.Stream {
+			writeStreamReplay(r.Context(), w, response, req.StreamOptions != nil && req.StreamOptions.IncludeUsage, h.streamPace())
+		} else if h.cfg.InjectCacheMeta {
+			json.NewEncoder(w).Encode(responseWithMeta{
+				ChatCompletionResponse: response,
+				Meta: &cacheMeta{
+					Cache:      "HIT",
+					Similarity: similarity,
+					AgeSeconds: int64(time.Since(entry.CreatedAt).Seconds()),
+				},
+			})
+		} else if cached != nil {
+			w.Write(cached)
+		} else {
+			json.NewEncoder(w).Encode(entry.Response)
+		}
+		h.logSlowRequest(ctx, "HIT", time.Since(startTime), timings, cacheKey)
 		return
 	}
 
 	// Cache miss - forward to OpenAI
-	h.logger.Debug("cache miss, forwarding to upstream")
+	h.log(ctx).Debug("cache miss, forwarding to upstream", "refresh", refresh)
+	cacheStatus := "MISS"
+	if refresh {
+		cacheStatus = "BYPASS"
+	}
+
+	if req.Stream {
+		overrides := http.Header{}
+		overrides.Set("X-Mimir-Cache", cacheStatus)
+		if h.cfg.EmbedModelHeader {
+			overrides.Set("X-Mimir-Embed-Model", embedder.Model())
+		}
+		phaseStart = time.Now()
+		assembled, upstreamErr := h.handleStream(w, r, req, body, decoded, overrides)
+		timings.upstream = time.Since(phaseStart)
+		if upstreamErr != nil {
+			h.recordFailure(r, reports.FailedRequest{Model: req.Model, Stream: true, Prompt: cacheKey}, upstreamErr, startTime, timings)
+		}
+		if assembled != nil && !h.cfg.CacheReadOnly {
+			h.storeResponse(ctx, req, *assembled, nil, emb, embedder, ttl)
+		}
 
-	resp, respBody, err := h.doUpstreamRequest(ctx, r, body)
+		latencyMs := time.Since(startTime).Milliseconds()
+		h.collector.RecordModelRequest(req.Model, false, 0, latencyMs, 0, cacheKey)
+		h.collector.AddLog("miss", fmt.Sprintf("[MISS] %dms - %s", latencyMs, truncatePrompt(cacheKey, 80)))
+		h.log(ctx).Info("upstream stream completed", "latency_ms", latencyMs)
+		h.logSlowRequest(ctx, "MISS", time.Since(startTime), timings, cacheKey)
+		return
+	}
+
+	// Forward the request and cache a successful response, or a client
+	// error when negative caching is on (read-only replicas never write)
+	fetch := func(ctx context.Context) upstreamResult {
+		resp, respBody, err := h.doUpstreamRequest(ctx, r, body)
+		if h.cfg.UpstreamFallbackURL != "" && upstreamDown(resp, err) {
+			resp, respBody, err = h.doFallbackRequest(ctx, r, body, resp, err)
+		}
+		res := upstreamResult{resp: resp, body: respBody, err: err}
+		if err == nil && resp.StatusCode == http.StatusOK && !h.cfg.CacheReadOnly {
+			var chatResp api.ChatCompletionResponse
+			if err := json.Unmarshal(respBody, &chatResp); err == nil {
+				res.entryID = h.storeResponse(ctx, req, chatResp, resp.Header, emb, embedder, ttl)
+			}
+		} else if err == nil && h.cfg.NegativeCacheTTL > 0 && negativeCacheable(resp.StatusCode) && !h.cfg.CacheReadOnly {
+			h.storeError(errorContext(ctx, decoded), req, resp.StatusCode, resp.Header, respBody, emb, embedder)
+		}
+		return res
+	}
+
+	// Identical misses in flight at the same time share one upstream call
+	phaseStart = time.Now()
+	var res upstreamResult
+	var coalesced bool
+	if h.cfg.CoalesceMisses {
+		res, coalesced = h.flights.do(ctx, cache.PartitionFromContext(ctx)+"\x00"+cacheKey, fetch)
+	} else {
+		res = fetch(ctx)
+	}
+	resp, respBody, err := res.resp, res.body, res.err
+	timings.upstream = time.Since(phaseStart)
+	if upstreamErr := upstreamFailure(resp, err); upstreamErr != nil {
+		failure := reports.FailedRequest{Model: req.Model, Prompt: cacheKey}
+		if h.cfg.FallbackMessage != "" && upstreamDown(resp, err) {
+			failure.Status = http.StatusOK
+			failure.Fallback = true
+		}
+		h.recordFailure(r, failure, upstreamErr, startTime, timings)
+	}
+	if h.cfg.FallbackMessage != "" && upstreamDown(resp, err) {
+		h.log(ctx).Error("upstream unavailable, serving fallback response", "error", err)
+		h.writeFallbackResponse(w, req, cacheKey, startTime)
+		return
+	}
 	if err != nil {
-		h.logger.Error("upstream request failed", "error", err)
+		h.log(ctx).Error("upstream request failed", "error", err)
 		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
 		return
 	}
 
 	// Copy response headers
-	for k, v := range resp.Header {
-		w.Header()[k] = v
+	h.copyUpstreamHeaders(w, resp.Header)
+	w.Header().Set("X-Mimir-Cache", cacheStatus)
+	if h.cfg.EmbedModelHeader {
+		w.Header().Set("X-Mimir-Embed-Model", embedder.Model())
 	}
-	w.Header().Set("X-Mimir-Cache", "MISS")
-
-	// If successful, cache the response
-	if resp.StatusCode == http.StatusOK {
-		var chatResp api.ChatCompletionResponse
-		if err := json.Unmarshal(respBody, &chatResp); err == nil {
-			entry := &api.CacheEntry{
-				Request:   req,
-				Response:  chatResp,
-				Embedding: emb,
-				CreatedAt: time.Now(),
-				ExpiresAt: time.Now().Add(h.cfg.CacheTTL),
-				HitCount:  0,
-				LastHitAt: time.Now(),
-			}
-			if err := h.cache.Set(ctx, entry); err != nil {
-				h.logger.Warn("failed to cache response", "error", err)
-			} else {
-				h.logger.Debug("cached response", "model", chatResp.Model)
-			}
-		}
+
+	h.setEntryTag(w, res.entryID)
+	if coalesced {
+		w.Header().Set("X-Mimir-Coalesced", "true")
 	}
 
 	w.WriteHeader(resp.StatusCode)
@@ -183,84 +615,662 @@ func (h *Handler) handleChatCompletions(w http.ResponseWriter, r *http.Request)
 	latencyMs := time.Since(startTime).Milliseconds()
 
 	// Record cache miss metric
-	h.collector.RecordRequest(false, 0, latencyMs, 0, cacheKey)
+	h.collector.RecordModelRequest(req.Model, false, 0, latencyMs, 0, cacheKey)
 	h.collector.AddLog("miss", fmt.Sprintf("[MISS] %dms - %s", latencyMs, truncatePrompt(cacheKey, 80)))
 
-	h.logger.Info("upstream request completed",
+	h.log(ctx).Info("upstream request completed",
 		"status", resp.StatusCode,
 		"latency_ms", latencyMs,
 	)
+	h.logSlowRequest(ctx, "MISS", time.Since(startTime), timings, cacheKey)
+}
+
+// storeResponse caches a successful completion under emb for ttl, unless
+// the response is not cacheable, and returns the ID of the stored entry.
+// The ReplayHeaders in header, if any, are stored with it.
+func (h *Handler) storeResponse(ctx context.Context, req api.ChatCompletionRequest, chatResp api.ChatCompletionResponse, header http.Header, emb []float64, embedder embedding.Embedder, ttl time.Duration) string {
+	if ok, reason := h.responseCacheable(chatResp); !ok {
+		h.log(ctx).Info("not caching response", "reason", reason)
+		return ""
+	}
+	if h.rejectNorm(emb) {
+		return ""
+	}
+	entry := &api.CacheEntry{
+		Request:    req,
+		Response:   chatResp,
+		Embedding:  emb,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  time.Now().Add(ttl),
+		HitCount:   0,
+		LastHitAt:  time.Now(),
+		Partition:  cache.PartitionFromContext(ctx),
+		EmbedModel: embedder.Model(),
+		Headers:    h.snapshotHeaders(header),
+	}
+	ctx, span := tracing.Start(ctx, "cache.set")
+	defer span.End()
+	if err := h.cache.Set(ctx, entry); err != nil {
+		span.SetError(err)
+		var dimErr *cache.DimensionError
+		if errors.As(err, &dimErr) {
+			h.log(ctx).Warn("not caching response, embedding dimensions do not match the cache",
+				"error", err,
+				"embed_model", embedder.Model(),
+				"hint", "clear the cache with DELETE /cache?all=true after changing MIMIR_EMBEDDING_MODEL",
+			)
+			return ""
+		}
+		h.log(ctx).Warn("failed to cache response", "error", err)
+		return ""
+	}
+	h.log(ctx).Debug("cached response", "model", chatResp.Model)
+	return entry.ID
+}
+
+// rejectNorm reports whether emb must not be cached because it is not
+// unit-length while the dot metric, which assumes it is, is selected and
+// NormCheck is "reject".
+func (h *Handler) rejectNorm(emb []float64) bool {
+	if h.cfg.NormCheck != "reject" || h.cfg.SimilarityMetric != cache.MetricDot || cache.UnitLength(emb) {
+		return false
+	}
+	h.logger.Warn("not caching response, embedding is not unit-length",
+		"norm", fmt.Sprintf("%.4f", cache.VectorNorm(emb)),
+		"hint", "set MIMIR_SIMILARITY_METRIC=cosine or use a model with normalized output",
+	)
+	return true
+}
+
+// setEntryTag exposes the ID of the entry behind a response as its ETag
+// when conditional requests are enabled.
+func (h *Handler) setEntryTag(w http.ResponseWriter, id string) {
+	if !h.cfg.ConditionalRequests || id == "" {
+		return
+	}
+	w.Header().Set("ETag", `"`+id+`"`)
+	w.Header().Set("X-Mimir-Entry-ID", id)
+}
+
+// notModified tags a cache hit with its entry ID and, when the request's
+// If-None-Match names that entry, answers 304 Not Modified without a body.
+func (h *Handler) notModified(w http.ResponseWriter, r *http.Request, id string) bool {
+	if !h.cfg.ConditionalRequests || id == "" {
+		return false
+	}
+	h.setEntryTag(w, id)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || strings.Trim(tag, `"`) == id {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// cacheMeta describes a cached response for clients that cannot read headers.
+type cacheMeta struct {
+	Cache      string  `json:"cache"`
+	Similarity float64 `json:"similarity"`
+	AgeSeconds int64   `json:"age_seconds"`
+}
+
+// responseWithMeta is a cached completion with an added x_mimir object.
+type responseWithMeta struct {
+	api.ChatCompletionResponse
+	Meta *cacheMeta `json:"x_mimir"`
+}
+
+// phaseTimings breaks down where a chat completion request spent its time.
+type phaseTimings struct {
+	embed    time.Duration
+	lookup   time.Duration
+	upstream time.Duration
+}
+
+// logSlowRequest warns about requests slower than MIMIR_SLOW_REQUEST_MS.
+func (h *Handler) logSlowRequest(ctx context.Context, cacheStatus string, total time.Duration, timings phaseTimings, prompt string) {
+	if h.cfg.SlowRequestThreshold <= 0 || total < h.cfg.SlowRequestThreshold {
+		return
+	}
+	h.logger.Warn("slow request",
+		"cache", cacheStatus,
+		"latency_ms", total.Milliseconds(),
+		"embed_ms", timings.embed.Milliseconds(),
+		"lookup_ms", timings.lookup.Milliseconds(),
+		"upstream_ms", timings.upstream.Milliseconds(),
+		"prompt", truncatePrompt(prompt, 80),
+	)
+}
+
+// writeFallbackResponse answers with the configured canned message when the
+// upstream failed and nothing was cached. It is never cached itself.
+func (h *Handler) writeFallbackResponse(w http.ResponseWriter, req api.ChatCompletionRequest, cacheKey string, startTime time.Time) {
+	resp := api.ChatCompletionResponse{
+		ID:      fmt.Sprintf("chatcmpl-fallback-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []api.Choice{{
+			Index:        0,
+			Message:      api.Message{Role: "assistant", Content: h.cfg.FallbackMessage},
+			FinishReason: "stop",
+		}},
+	}
+
+	latencyMs := time.Since(startTime).Milliseconds()
+	h.collector.RecordModelRequest(req.Model, false, 0, latencyMs, 0, cacheKey)
+	h.collector.AddLog("miss", fmt.Sprintf("[FALLBACK] %dms - %s", latencyMs, truncatePrompt(cacheKey, 80)))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Mimir-Cache", "FALLBACK")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Cache key modes.
+const (
+	cacheKeyFull     = "full"
+	cacheKeyLastUser = "last-user"
+)
+
+// Long prompt modes.
+const (
+	longPromptSkip     = "skip"
+	longPromptTruncate = "truncate"
+)
+
+// embedInput returns the text to embed for cacheKey. Keys longer than
+// MaxEmbedChars are cut to their last MaxEmbedChars characters, where the
+// latest turn is, in truncate mode; otherwise it reports false and the
+// request should skip the cache rather than risk a slow or failing embed.
+func (h *Handler) embedInput(cacheKey string) (string, bool) {
+	limit := h.cfg.MaxEmbedChars
+	if limit <= 0 {
+		return cacheKey, true
+	}
+	n := utf8.RuneCountInString(cacheKey)
+	if n <= limit {
+		return cacheKey, true
+	}
+	if h.cfg.LongPromptMode != longPromptTruncate {
+		h.logger.Warn("prompt exceeds MIMIR_MAX_EMBED_CHARS, skipping cache", "chars", n, "max", limit)
+		return "", false
+	}
+
+	start := 0
+	for skip := n - limit; skip > 0; skip-- {
+		_, size := utf8.DecodeRuneInString(cacheKey[start:])
+		start += size
+	}
+	h.logger.Debug("truncating long prompt for embedding", "chars", n, "max", limit)
+	return cacheKey[start:], true
+}
+
+// log returns the handler's logger, carrying the request ID when ctx
+// belongs to a request LoggingMiddleware saw.
+func (h *Handler) log(ctx context.Context) *logger.Logger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return h.logger.With("request_id", id)
+	}
+	return h.logger
+}
+
+// embed embeds text with embedder, counting the call as in flight. The
+// call gives up after EmbedTimeout, so a slow embedder makes the request
+// fall back to the upstream instead of holding it.
+func (h *Handler) embed(ctx context.Context, embedder embedding.Embedder, text string) ([]float64, error) {
+	defer h.collector.TrackEmbed()()
+	if h.cfg.EmbedTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.EmbedTimeout)
+		defer cancel()
+	}
+	emb, err := embedder.Embed(ctx, text)
+	if err == nil && embedder == h.embedder {
+		h.lastEmbed.Store(time.Now().UnixNano())
+	}
+	return emb, err
+}
+
+// cachePartition returns the cache partition for a request. Clients bump
+// X-Mimir-Context-Version (e.g. a knowledge base hash) to stop matching
+// answers cached against an older context without clearing the cache.
+// Lookups with a non-default embedder get their own partition, since
+// vectors from different models are not comparable.
+func (h *Handler) cachePartition(r *http.Request, embedder embedding.Embedder) string {
+	partition := h.cfg.DefaultContextVersion
+	if version := r.Header.Get("X-Mimir-Context-Version"); version != "" {
+		partition = version
+	}
+	if embedder != h.embedder {
+		partition += "@embed:" + embedder.Model()
+	}
+	return partition
+}
+
+// requestPartition returns the cache partition for req: the partition from
+// cachePartition, scoped further by the request's images, tools, prefill
+// and, with MIMIR_TEMPERATURE_KEY, temperature.
+func (h *Handler) requestPartition(ctx context.Context, r *http.Request, embedder embedding.Embedder, req api.ChatCompletionRequest) string {
+	partition := h.cachePartition(r, embedder)
+	if key := h.imageKey(ctx, req); key != "" {
+		partition += "@img:" + key
+	}
+	if key := toolKey(req); key != "" {
+		partition += "@tools:" + key
+	}
+	if key := prefillKey(req); key != "" {
+		partition += "@prefill:" + key
+	}
+	if key := h.temperatureKey(req.Temperature); key != "" {
+		partition += "@temp:" + key
+	}
+	return partition
+}
+
+// selectEmbedder returns the embedder requested with X-Mimir-Embed-Model.
+// Unconfigured models fall back to the default embedder with a warning header.
+func (h *Handler) selectEmbedder(w http.ResponseWriter, r *http.Request) embedding.Embedder {
+	model := r.Header.Get("X-Mimir-Embed-Model")
+	if model == "" {
+		return h.embedder
+	}
+	if e, ok := h.embedders[model]; ok {
+		return e
+	}
+	h.log(r.Context()).Warn("unknown embedding model requested, using default", "model", model)
+	w.Header().Set("X-Mimir-Warning", fmt.Sprintf("embedding model %q is not configured, used %q", model, h.embedder.Model()))
+	return h.embedder
 }
 
-// generateCacheKey creates a cache key from the request messages.
+// responseCacheable reports whether a response falls within the configured
+// token band for caching, returning the reason when it does not.
+func (h *Handler) responseCacheable(resp api.ChatCompletionResponse) (bool, string) {
+	if h.cfg.MinCacheResponseTokens == 0 && h.cfg.MaxCacheResponseTokens == 0 {
+		return true, ""
+	}
+
+	tokens := resp.Usage.CompletionTokens
+	if tokens == 0 {
+		tokens = estimateResponseTokens(resp)
+	}
+
+	if h.cfg.MaxCacheResponseTokens > 0 && tokens > h.cfg.MaxCacheResponseTokens {
+		return false, fmt.Sprintf("response too large (%d tokens > %d)", tokens, h.cfg.MaxCacheResponseTokens)
+	}
+	if tokens < h.cfg.MinCacheResponseTokens {
+		return false, fmt.Sprintf("response too small (%d tokens < %d)", tokens, h.cfg.MinCacheResponseTokens)
+	}
+	return true, ""
+}
+
+// estimateResponseTokens approximates completion tokens at ~4 characters per token.
+func estimateResponseTokens(resp api.ChatCompletionResponse) int {
+	chars := 0
+	for _, choice := range resp.Choices {
+		if content, ok := choice.Message.Content.(string); ok {
+			chars += len(content)
+		}
+	}
+	return (chars + 3) / 4
+}
+
+// generateCacheKey creates a cache key from the request messages, keeping
+// only messages with a CacheKeyRoles role when any message has one, and
+// removing CacheKeyStrip matches. A trailing assistant prefill is always
+// kept.
 func (h *Handler) generateCacheKey(req api.ChatCompletionRequest) string {
 	var sb strings.Builder
 
-	for _, msg := range req.Messages {
+	messages := h.keyMessages(req.Messages)
+	for _, msg := range messages {
 		sb.WriteString(msg.Role)
 		sb.WriteString(": ")
+		writeContentText(&sb, msg.Content)
+		sb.WriteString("\n")
+	}
+
+	if h.keyStrip != nil {
+		return h.keyStrip.ReplaceAllString(sb.String(), "")
+	}
+	return sb.String()
+}
+
+// keyMessages returns the messages whose role is in CacheKeyRoles, plus a
+// trailing assistant prefill, which changes the answer whatever the roles.
+// When no message has one of the roles, every message is kept, so requests
+// without those roles do not all share one empty key. In the last-user key
+// mode only the final user message and any prefill are kept.
+func (h *Handler) keyMessages(messages []api.Message) []api.Message {
+	if h.cfg.CacheKeyMode == cacheKeyLastUser {
+		if last := lastUserTurn(messages); last != nil {
+			return last
+		}
+	}
+	if len(h.cfg.CacheKeyRoles) == 0 {
+		return messages
+	}
+	var kept []api.Message
+	matched := false
+	for i, msg := range messages {
+		keep := false
+		for _, role := range h.cfg.CacheKeyRoles {
+			if msg.Role == role {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			matched = true
+		} else {
+			keep = i == len(messages)-1 && msg.Role == "assistant"
+		}
+		if keep {
+			kept = append(kept, msg)
+		}
+	}
+	if !matched {
+		return messages
+	}
+	return kept
+}
+
+// lastUserTurn returns the final user message with the assistant prefill
+// following it, if any. It returns nil when the conversation goes on past
+// the last user message, with tool results or other turns the answer
+// depends on, or has no user message.
+func lastUserTurn(messages []api.Message) []api.Message {
+	end := len(messages)
+	if end > 0 && messages[end-1].Role == "assistant" {
+		end--
+	}
+	if end == 0 || messages[end-1].Role != "user" {
+		return nil
+	}
+	return messages[end-1:]
+}
 
-		switch content := msg.Content.(type) {
-		case string:
-			sb.WriteString(content)
-		case []interface{}:
-			// Handle multimodal content
-			for _, part := range content {
-				if p, ok := part.(map[string]interface{}); ok {
-					if text, ok := p["text"].(string); ok {
-						sb.WriteString(text)
-					}
+// writeContentText writes the text of a message content, either a string or
+// a list of multimodal parts, to sb.
+func writeContentText(sb *strings.Builder, content interface{}) {
+	switch content := content.(type) {
+	case string:
+		sb.WriteString(content)
+	case []interface{}:
+		// Handle multimodal content
+		for _, part := range content {
+			if p, ok := part.(map[string]interface{}); ok {
+				if text, ok := p["text"].(string); ok {
+					sb.WriteString(text)
 				}
 			}
 		}
-		sb.WriteString("\n")
+	}
+}
+
+// decodeRequestBody returns the request body with any gzip or deflate
+// Content-Encoding removed.
+func (h *Handler) decodeRequestBody(r *http.Request, body []byte) ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || !h.cfg.DecompressRequests {
+		return body, nil
 	}
 
-	return sb.String()
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s request body", encoding)
+	}
+	defer reader.Close()
+
+	// The decompressed body is held to the same limit, so a small
+	// compressed body cannot expand without bound
+	var limited io.Reader = reader
+	if h.cfg.MaxBodyBytes > 0 {
+		limited = io.LimitReader(reader, h.cfg.MaxBodyBytes+1)
+	}
+	decoded, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s request body", encoding)
+	}
+	if h.cfg.MaxBodyBytes > 0 && int64(len(decoded)) > h.cfg.MaxBodyBytes {
+		return nil, &http.MaxBytesError{Limit: h.cfg.MaxBodyBytes}
+	}
+	return decoded, nil
+}
+
+// readBody reads and closes the request body, writing an error response
+// and returning false when it cannot be read: 413 when it exceeds
+// MaxBodyBytes, 400 otherwise.
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		h.writeError(w, "Failed to read request body", bodyErrorStatus(err, http.StatusBadRequest))
+		return nil, false
+	}
+	return body, true
+}
+
+// bodyErrorStatus returns 413 for an error from a body over the size
+// limit, and fallback for any other error.
+func bodyErrorStatus(err error, fallback int) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
 }
 
-// forwardRequest forwards a request to the upstream without caching.
-func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, body []byte) {
+// forwardRequest forwards a request to the upstream without caching and
+// returns the upstream failure, if any.
+func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, body []byte) error {
 	resp, respBody, err := h.doUpstreamRequest(r.Context(), r, body)
 	if err != nil {
 		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
-		return
+		return upstreamFailure(resp, err)
 	}
 
-	for k, v := range resp.Header {
-		w.Header()[k] = v
-	}
+	h.copyUpstreamHeaders(w, resp.Header)
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
+	return upstreamFailure(resp, nil)
+}
+
+// copyUpstreamHeaders copies upstream response headers to w. X-Mimir-*
+// headers set by an inner mimir are kept, dropped or renamed to X-Mimir-L2-*
+// according to StripInnerHeaders.
+func (h *Handler) copyUpstreamHeaders(w http.ResponseWriter, header http.Header) {
+	for k, v := range header {
+		if strings.HasPrefix(k, "X-Mimir-") {
+			switch h.cfg.StripInnerHeaders {
+			case "strip":
+				continue
+			case "namespace":
+				k = "X-Mimir-L2-" + strings.TrimPrefix(k, "X-Mimir-")
+			}
+		}
+		w.Header()[k] = v
+	}
+}
+
+// snapshotHeaders returns the ReplayHeaders present in header, for storing
+// with an entry, or nil when there are none.
+func (h *Handler) snapshotHeaders(header http.Header) map[string]string {
+	var snapshot map[string]string
+	for _, name := range h.cfg.ReplayHeaders {
+		if v := header.Get(name); v != "" {
+			if snapshot == nil {
+				snapshot = make(map[string]string)
+			}
+			snapshot[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return snapshot
 }
 
-// doUpstreamRequest sends a request to the upstream OpenAI API.
+// replayHeaders sets the upstream headers stored with entry on w. It runs
+// before mimir sets its own headers on a hit, so those always win.
+func replayHeaders(w http.ResponseWriter, entry *api.CacheEntry) {
+	for k, v := range entry.Headers {
+		w.Header().Set(k, v)
+	}
+}
+
+// doUpstreamRequest sends a request to the upstream for its model, retrying
+// rate-limited responses.
 func (h *Handler) doUpstreamRequest(ctx context.Context, r *http.Request, body []byte) (*http.Response, []byte, error) {
-	upstreamURL := h.cfg.OpenAIBaseURL + r.URL.Path
+	baseURL, apiKey := h.upstreamFor(r, body)
+	return h.retryRateLimited(ctx, func() (*http.Response, []byte, error) {
+		return h.sendUpstream(ctx, baseURL, apiKey, r, body)
+	})
+}
+
+// upstreamFor returns the base URL and API key of the upstream serving the
+// model named in body: the first matching MIMIR_UPSTREAM_ROUTES route, or
+// the OpenAI API when none matches or the body names no model.
+func (h *Handler) upstreamFor(r *http.Request, body []byte) (string, string) {
+	if len(h.cfg.UpstreamRoutes) == 0 || len(body) == 0 {
+		return h.cfg.OpenAIBaseURL, ""
+	}
+	decoded, err := h.decodeRequestBody(r, body)
+	if err != nil {
+		return h.cfg.OpenAIBaseURL, ""
+	}
+	var fields struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(decoded, &fields)
+	if route, ok := h.cfg.UpstreamFor(fields.Model); ok {
+		return route.URL, route.APIKey
+	}
+	return h.cfg.OpenAIBaseURL, ""
+}
+
+// doFallbackRequest retries a failed chat request against the fallback upstream,
+// optionally swapping in the fallback model.
+func (h *Handler) doFallbackRequest(ctx context.Context, r *http.Request, body []byte, primaryResp *http.Response, primaryErr error) (*http.Response, []byte, error) {
+	reason := "error"
+	if primaryErr == nil {
+		reason = fmt.Sprintf("status %d", primaryResp.StatusCode)
+	}
+
+	if h.cfg.FallbackModel != "" {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err == nil {
+			fields["model"], _ = json.Marshal(h.cfg.FallbackModel)
+			if rewritten, err := json.Marshal(fields); err == nil {
+				body = rewritten
+			}
+		}
+	}
+
+	h.log(ctx).Warn("primary upstream failed, failing over",
+		"reason", reason,
+		"error", primaryErr,
+		"fallback_url", h.cfg.UpstreamFallbackURL,
+		"fallback_model", h.cfg.FallbackModel,
+	)
+	h.collector.RecordFailover()
+
+	resp, respBody, err := h.sendUpstream(ctx, h.cfg.UpstreamFallbackURL, h.cfg.UpstreamFallbackAPIKey, r, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fallback upstream failed: %w", err)
+	}
+	return resp, respBody, nil
+}
+
+// sendUpstream sends a request to baseURL, using apiKey when set and otherwise
+// the client's Authorization header. The configured OpenAI key stands in for
+// a missing client key only when baseURL is the OpenAI upstream. Anthropic
+// requests authenticate with x-api-key instead.
+func (h *Handler) sendUpstream(ctx context.Context, baseURL, apiKey string, r *http.Request, body []byte) (*http.Response, []byte, error) {
+	upstreamURL := baseURL + r.URL.Path
+	if err := h.cfg.UpstreamAllowed(upstreamURL); err != nil {
+		return nil, nil, fmt.Errorf("upstream rejected: %w", err)
+	}
+	if h.upstream != nil {
+		if err := h.upstream.acquire(ctx, h.highPriority(r)); err != nil {
+			return nil, nil, err
+		}
+		defer h.upstream.release()
+	}
+	defer h.collector.TrackUpstream()()
+
+	ctx, span := tracing.StartClient(ctx, "upstream.request")
+	defer span.End()
+	span.SetAttr("upstream", baseURL)
+
+	if timeout := h.cfg.TimeoutForPath(r.URL.Path); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	req, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Copy headers
+	// Copy headers, continuing the trace upstream when there is one
 	for k, v := range r.Header {
 		req.Header[k] = v
 	}
+	tracing.Inject(ctx, req.Header)
 
 	// Use configured API key if not provided in request
-	if req.Header.Get("Authorization") == "" {
+	if baseURL == h.cfg.AnthropicBaseURL {
+		if req.Header.Get("x-api-key") == "" && h.cfg.AnthropicAPIKey != "" {
+			req.Header.Set("x-api-key", h.cfg.AnthropicAPIKey)
+		}
+		if req.Header.Get("anthropic-version") == "" {
+			req.Header.Set("anthropic-version", anthropicVersion)
+		}
+	} else if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	} else if baseURL == h.cfg.OpenAIBaseURL && req.Header.Get("Authorization") == "" && h.cfg.OpenAIAPIKey != "" {
+		// The OpenAI key is never sent to routed or fallback upstreams
 		req.Header.Set("Authorization", "Bearer "+h.cfg.OpenAIAPIKey)
 	}
 
+	// Attribute primary OpenAI traffic to the configured org/project unless the client chose one
+	if baseURL == h.cfg.OpenAIBaseURL {
+		if h.cfg.OpenAIOrg != "" && req.Header.Get("OpenAI-Organization") == "" {
+			req.Header.Set("OpenAI-Organization", h.cfg.OpenAIOrg)
+		}
+		if h.cfg.OpenAIProject != "" && req.Header.Get("OpenAI-Project") == "" {
+			req.Header.Set("OpenAI-Project", h.cfg.OpenAIProject)
+		}
+	}
+
 	resp, err := h.client.Do(req)
 	if err != nil {
+		span.SetError(err)
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttr("status", resp.StatusCode)
 
-	respBody, err := io.ReadAll(resp.Body)
+	// Upstream bodies are held to the request limit too
+	var limited io.Reader = resp.Body
+	if h.cfg.MaxBodyBytes > 0 {
+		limited = io.LimitReader(resp.Body, h.cfg.MaxBodyBytes+1)
+	}
+	respBody, err := io.ReadAll(limited)
+	if err == nil && h.cfg.MaxBodyBytes > 0 && int64(len(respBody)) > h.cfg.MaxBodyBytes {
+		err = fmt.Errorf("upstream response exceeds %d bytes", h.cfg.MaxBodyBytes)
+	}
 	if err != nil {
+		span.SetError(err)
 		return nil, nil, err
 	}
 
@@ -269,8 +1279,10 @@ func (h *Handler) doUpstreamRequest(ctx context.Context, r *http.Request, body [
 
 // handlePassthrough passes requests directly to upstream.
 func (h *Handler) handlePassthrough(w http.ResponseWriter, r *http.Request) {
-	body, _ := io.ReadAll(r.Body)
-	r.Body.Close()
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
 	h.forwardRequest(w, r, body)
 }
 
@@ -295,10 +1307,27 @@ func (h *Handler) handleDashboard(w http.ResponseWriter, r *http.Request) {
 // handleReportsData serves the performance report data as JSON.
 func (h *Handler) handleReportsData(w http.ResponseWriter, r *http.Request) {
 	report := h.collector.GetReport()
+	report.Cache = h.cache.Stats(r.Context())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
 }
 
+// handleSavings serves the savings report for ?period= (default 30d),
+// broken down by day and by model.
+func (h *Handler) handleSavings(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("period")
+	if label == "" {
+		label = "30d"
+	}
+	period, err := reports.ParsePeriod(label)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.collector.Savings(time.Now(), period, label))
+}
+
 // handleLogs serves the recent logs as JSON.
 func (h *Handler) handleLogs(w http.ResponseWriter, r *http.Request) {
 	logs := h.collector.GetLogs()
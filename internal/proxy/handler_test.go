@@ -0,0 +1,2797 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/config"
+	"github.com/aqstack/mimir/internal/logger"
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/internal/rules"
+	"github.com/aqstack/mimir/internal/tracing"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// fakeEmbedder maps each distinct text to its own axis so identical
+// texts match exactly and different texts never match.
+type fakeEmbedder struct {
+	mu    sync.Mutex
+	model string
+	dims  int
+	calls atomic.Int64
+	axes  map[string]int
+	fail  string  // texts containing fail return an error
+	scale float64 // length of returned vectors, 1 when zero
+}
+
+func newFakeEmbedder() *fakeEmbedder {
+	return &fakeEmbedder{model: "fake-embed", dims: 16, axes: make(map[string]int)}
+}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.calls.Add(1)
+	if e.fail != "" && strings.Contains(text, e.fail) {
+		return nil, fmt.Errorf("cannot embed %q", text)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	axis, ok := e.axes[text]
+	if !ok {
+		axis = len(e.axes) % e.dims
+		e.axes[text] = axis
+	}
+	v := make([]float64, e.dims)
+	v[axis] = 1
+	if e.scale != 0 {
+		v[axis] = e.scale
+	}
+	return v, nil
+}
+
+func (e *fakeEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	result := make([][]float64, len(texts))
+	for i, text := range texts {
+		var err error
+		if result[i], err = e.Embed(ctx, text); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (e *fakeEmbedder) Dimensions() int { return e.dims }
+func (e *fakeEmbedder) Model() string   { return e.model }
+
+// fakeUpstream is a chat completions server that records the requests it receives.
+type fakeUpstream struct {
+	*httptest.Server
+	calls    atomic.Int64
+	lastBody []byte
+	lastReq  *http.Request
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	u := &fakeUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.calls.Add(1)
+		u.lastBody, _ = io.ReadAll(r.Body)
+		u.lastReq = r
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Openai-Model", "gpt-4-0613")
+		w.Header().Set("Openai-Processing-Ms", "42")
+		json.NewEncoder(w).Encode(api.ChatCompletionResponse{
+			ID:      "chatcmpl-test",
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   "gpt-4",
+			Choices: []api.Choice{{
+				Message:      api.Message{Role: "assistant", Content: "Paris"},
+				FinishReason: "stop",
+			}},
+			Usage: api.Usage{PromptTokens: 10, CompletionTokens: 1, TotalTokens: 11},
+		})
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func newTestHandler(t *testing.T, upstream *fakeUpstream, configure func(*config.Config)) *Handler {
+	cfg := config.DefaultConfig()
+	cfg.OpenAIBaseURL = upstream.URL
+	if configure != nil {
+		configure(cfg)
+	}
+
+	c := cache.NewMemoryCache(&cache.Options{
+		MaxSize:         100,
+		DefaultTTL:      time.Hour,
+		CleanupInterval: time.Hour,
+	})
+
+	log := logger.New(false, logger.LevelDebug)
+	log.SetOutput(io.Discard)
+
+	return NewHandler(cfg, c, newFakeEmbedder(), log)
+}
+
+func chatBody(t *testing.T, content string) []byte {
+	body, err := json.Marshal(api.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []api.Message{{Role: "user", Content: content}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleEmbeddings(t *testing.T) {
+	var lastBody []byte
+	var lastEncoding string
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		lastBody, _ = io.ReadAll(r.Body)
+		lastEncoding = r.Header.Get("Content-Encoding")
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.Unmarshal(lastBody, &req)
+		resp := api.EmbeddingResponse{Object: "list", Model: "text-embedding-3-small"}
+		for i, input := range req.Input {
+			resp.Data = append(resp.Data, api.EmbeddingData{Object: "embedding", Embedding: []float64{float64(len(input))}, Index: i})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(upstream.Close)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.CacheEmbeddings = true
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", bytes.NewReader(gzipBytes(t, []byte(body))))
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(`{"model":"text-embedding-3-small","input":"a"}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Mimir-Cache") != "MISS" {
+		t.Fatalf("expected a MISS for a compressed request, got %d %q: %s", rec.Code, rec.Header().Get("X-Mimir-Cache"), rec.Body.String())
+	}
+
+	// Only the uncached input goes upstream, with unknown fields kept
+	rec = send(`{"model":"text-embedding-3-small","input":["a","bb"],"provider_hint":{"region":"eu"}}`)
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "PARTIAL" {
+		t.Errorf("expected PARTIAL, got %q", got)
+	}
+	var forwarded map[string]json.RawMessage
+	if err := json.Unmarshal(lastBody, &forwarded); err != nil {
+		t.Fatalf("expected an uncompressed JSON body upstream: %v", err)
+	}
+	if lastEncoding != "" {
+		t.Errorf("expected no Content-Encoding on the rewritten body, got %q", lastEncoding)
+	}
+	if got := string(forwarded["input"]); got != `["bb"]` {
+		t.Errorf("expected only the missing input, got %s", got)
+	}
+	if got := string(forwarded["provider_hint"]); got != `{"region":"eu"}` {
+		t.Errorf("expected the unknown field forwarded unchanged, got %s", got)
+	}
+
+	var resp api.EmbeddingResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Data) != 2 || resp.Data[0].Embedding[0] != 1 || resp.Data[1].Embedding[0] != 2 {
+		t.Errorf("expected both vectors in request order, got %+v", resp.Data)
+	}
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestHandleEmbeddingsReadOnlyCache(t *testing.T) {
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.EmbeddingResponse{
+			Object: "list",
+			Model:  "text-embedding-3-small",
+			Data:   []api.EmbeddingData{{Object: "embedding", Embedding: []float64{1}}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.CacheEmbeddings = true
+		cfg.CacheReadOnly = true
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model":"text-embedding-3-small","input":"a"}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Mimir-Cache"); rec.Code != http.StatusOK || got != "MISS" {
+			t.Errorf("expected misses not to be stored, got %d %q", rec.Code, got)
+		}
+	}
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestHandleChatCompletionsBodyLimit(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.MaxBodyBytes = 1024
+	})
+
+	send := func(path string, body []byte, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	large := chatBody(t, strings.Repeat("x", 2048))
+	if rec := send("/v1/chat/completions", large, ""); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a large body, got %d", rec.Code)
+	}
+	if rec := send("/v1/models", large, ""); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a large passthrough body, got %d", rec.Code)
+	}
+
+	// A small compressed body may not expand past the limit either
+	compressed := gzipBytes(t, large)
+	if len(compressed) > 1024 {
+		t.Fatalf("expected the compressed body to fit the limit, got %d bytes", len(compressed))
+	}
+	if rec := send("/v1/chat/completions", compressed, "gzip"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a large decompressed body, got %d", rec.Code)
+	}
+	if upstream.calls.Load() != 0 {
+		t.Errorf("expected no upstream calls, got %d", upstream.calls.Load())
+	}
+
+	// The fake upstream's response is well under 1024 bytes
+	if rec := send("/v1/chat/completions", chatBody(t, "hi"), ""); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+
+	h.cfg.MaxBodyBytes = 64
+	if rec := send("/v1/chat/completions", chatBody(t, "hello"), ""); rec.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502 for a large upstream response, got %d", rec.Code)
+	}
+}
+
+func TestHandleChatCompletionsGzipBody(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+
+	compressed := gzipBytes(t, chatBody(t, "What is the capital of France?"))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(compressed))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected first request to MISS, got %q", got)
+	}
+
+	// Upstream receives the original compressed body
+	if upstream.lastReq.Header.Get("Content-Encoding") != "gzip" {
+		t.Error("expected Content-Encoding to be forwarded upstream")
+	}
+	if !bytes.Equal(upstream.lastBody, compressed) {
+		t.Error("expected the original compressed body to be forwarded upstream")
+	}
+
+	rec = send()
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Errorf("expected second request to HIT, got %q", got)
+	}
+	if upstream.calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", upstream.calls.Load())
+	}
+	// 10 prompt and 1 completion tokens at gpt-4 prices
+	if got := rec.Header().Get("X-Mimir-Saved-USD"); got != "0.000360" {
+		t.Errorf("expected X-Mimir-Saved-USD 0.000360, got %q", got)
+	}
+}
+
+func TestHandleChatCompletionsUnsupportedEncoding(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+	req.Header.Set("Content-Encoding", "br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", rec.Code)
+	}
+}
+
+func TestHandleChatCompletionsEmbedModelOverride(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+
+	alt := newFakeEmbedder()
+	alt.model = "alt-embed"
+	h.RegisterEmbedder(alt)
+
+	send := func(model string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		if model != "" {
+			req.Header.Set("X-Mimir-Embed-Model", model)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if got := send("").Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected default lookup to MISS, got %q", got)
+	}
+
+	// The alternate model looks up its own partition
+	rec := send("alt-embed")
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected first alt-embed lookup to MISS, got %q", got)
+	}
+	if alt.calls.Load() != 1 {
+		t.Errorf("expected alt embedder to be called once, got %d", alt.calls.Load())
+	}
+	if got := send("alt-embed").Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Errorf("expected second alt-embed lookup to HIT, got %q", got)
+	}
+
+	// Unknown models fall back to the default embedder with a warning
+	rec = send("missing-embed")
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Errorf("expected fallback to default embedder to HIT, got %q", got)
+	}
+	if rec.Header().Get("X-Mimir-Warning") == "" {
+		t.Error("expected a warning header for an unknown embedding model")
+	}
+}
+
+func TestHandleChatCompletionsFallbackMessage(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.FallbackMessage = "Sorry, try again later."
+	})
+	// Point at a closed server so the upstream is unreachable
+	upstream.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "FALLBACK" {
+		t.Errorf("expected X-Mimir-Cache=FALLBACK, got %q", got)
+	}
+	var resp api.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Sorry, try again later." {
+		t.Errorf("unexpected fallback response: %+v", resp)
+	}
+	if h.cache.Size(context.Background()) != 0 {
+		t.Error("expected fallback response not to be cached")
+	}
+}
+
+func TestHandleChatCompletionsSlowRequestLog(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.SlowRequestThreshold = time.Nanosecond
+	})
+	var logs bytes.Buffer
+	h.logger.SetOutput(&logs)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	for _, want := range []string{"slow request", "embed_ms", "lookup_ms", "upstream_ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected slow request log to contain %q, got %s", want, out)
+		}
+	}
+}
+
+func TestHandleCacheDump(t *testing.T) {
+	upstream := newFakeUpstream(t)
+
+	t.Run("disabled without token", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/dump", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", rec.Code)
+		}
+	})
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/dump", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without the admin token, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "chatcmpl-test") {
+		t.Errorf("expected no entries without the admin token, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/cache/dump", nil)
+	req.Header.Set("X-Mimir-Admin-Token", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var entry api.CacheEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line per entry: %v", err)
+	}
+	if entry.Response.ID != "chatcmpl-test" {
+		t.Errorf("unexpected dumped entry: %+v", entry.Response)
+	}
+}
+
+func TestHandleCacheLoad(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	source := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	source.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+	dump := httptest.NewRecorder()
+	dumpReq := httptest.NewRequest(http.MethodGet, "/cache/dump", nil)
+	dumpReq.Header.Set("X-Mimir-Admin-Token", "secret")
+	source.ServeHTTP(dump, dumpReq)
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/load", bytes.NewReader(dump.Body.Bytes())))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without the admin token, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/cache/load", bytes.NewReader(dump.Body.Bytes()))
+	req.Header.Set("X-Mimir-Admin-Token", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result loadResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Loaded != 1 {
+		t.Fatalf("expected 1 entry loaded, got %+v (%v)", result, err)
+	}
+
+	// The loaded entry serves a hit without calling upstream
+	calls := upstream.calls.Load()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+	if rec.Header().Get("X-Mimir-Cache") != "HIT" || upstream.calls.Load() != calls {
+		t.Errorf("expected a hit from the loaded entry, got %q", rec.Header().Get("X-Mimir-Cache"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/cache/load", strings.NewReader("not json\n"))
+	req.Header.Set("X-Mimir-Admin-Token", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid entries, got %d", rec.Code)
+	}
+}
+
+func TestHandleCacheEntries(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+
+	for _, content := range []string{"first", "second", "third"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(2 * time.Millisecond) // distinct CreatedAt for a stable order
+	}
+
+	list := func(query string) (int, entriesPage) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/cache/entries"+query, nil)
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		h.ServeHTTP(rec, req)
+		var page entriesPage
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		return rec.Code, page
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/entries?embedding=true", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "first") {
+		t.Fatalf("expected status 401 and no entries without the admin token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	code, page := list("?limit=2&offset=1")
+	if code != http.StatusOK || page.Total != 3 || len(page.Entries) != 2 {
+		t.Fatalf("expected 2 of 3 entries, got status %d and %+v", code, page)
+	}
+	e := page.Entries[0]
+	if e.Model != "gpt-4" || !strings.Contains(e.Prompt, "second") || e.Dimensions != 16 || e.Embedding != nil {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if !e.ExpiresAt.After(e.CreatedAt) {
+		t.Errorf("expected expiry after creation, got %v and %v", e.CreatedAt, e.ExpiresAt)
+	}
+
+	if _, page = list("?embedding=true&limit=1"); len(page.Entries) != 1 || len(page.Entries[0].Embedding) != 16 {
+		t.Errorf("expected the embedding with ?embedding=true, got %+v", page.Entries)
+	}
+	if _, page = list("?model=gpt-3.5-turbo"); page.Total != 0 || len(page.Entries) != 0 {
+		t.Errorf("expected no entries for another model, got %+v", page)
+	}
+	if code, _ = list("?limit=-1"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative limit, got %d", code)
+	}
+}
+
+func TestHandleEmbedderCompare(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.CompareSampleRate = 1
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reports/embedder-compare", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a candidate, got %d", rec.Code)
+	}
+
+	// The candidate embeds both prompts alike, so it also hits on beta
+	candidate := newFakeEmbedder()
+	candidate.model = "candidate-embed"
+	key := func(content string) string {
+		return h.generateCacheKey(api.ChatCompletionRequest{Messages: []api.Message{{Role: "user", Content: content}}})
+	}
+	candidate.axes[key("alpha")] = 0
+	candidate.axes[key("beta")] = 0
+	h.SetCompareEmbedder(candidate)
+
+	for i, content := range []string{"alpha", "alpha", "beta"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		deadline := time.Now().Add(2 * time.Second)
+		for h.compareReport().Sampled < int64(i+1) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reports/embedder-compare", nil))
+	var report EmbedderComparison
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Sampled != 3 || report.BothHit != 1 || len(report.Models) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	def, cand := report.Models[0], report.Models[1]
+	if def.Model != "fake-embed" || def.Hits != 1 || def.OnlyHits != 0 {
+		t.Errorf("expected the default embedder to hit once, got %+v", def)
+	}
+	if cand.Model != "candidate-embed" || cand.Hits != 2 || cand.OnlyHits != 1 {
+		t.Errorf("expected the candidate to hit twice, got %+v", cand)
+	}
+	if upstream.calls.Load() != 2 {
+		t.Errorf("expected the comparison not to change serving, got %d upstream calls", upstream.calls.Load())
+	}
+}
+
+func imageChatBody(t *testing.T, text, url string) []byte {
+	body, err := json.Marshal(api.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []api.Message{{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": text},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleChatCompletionsImageKeyStrategy(t *testing.T) {
+	tests := []struct {
+		strategy   string
+		secondHits bool
+	}{
+		{strategy: "ignore", secondHits: true},
+		{strategy: "url", secondHits: false},
+		{strategy: "content", secondHits: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.ImageKeyStrategy = tt.strategy
+			})
+
+			send := func(url string) string {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(imageChatBody(t, "What is in this image?", url)))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Header().Get("X-Mimir-Cache")
+			}
+
+			send("data:image/png;base64,AAAA")
+			if got := send("data:image/png;base64,AAAA"); got != "HIT" {
+				t.Errorf("expected identical image to HIT, got %q", got)
+			}
+			if got := send("data:image/png;base64,BBBB"); (got == "HIT") != tt.secondHits {
+				t.Errorf("different image: got %q, expected hit=%v", got, tt.secondHits)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsToolCaching(t *testing.T) {
+	toolBody := func(choice string) []byte {
+		body, err := json.Marshal(map[string]interface{}{
+			"model":    "gpt-4o-mini",
+			"messages": []api.Message{{Role: "user", Content: "What is the weather in Paris?"}},
+			"tools": []map[string]interface{}{{
+				"type":     "function",
+				"function": map[string]interface{}{"name": "get_weather"},
+			}},
+			"tool_choice": choice,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	tests := []struct {
+		mode        string
+		wantSame    string
+		wantChanged string
+	}{
+		{mode: "strict", wantSame: "HIT", wantChanged: "MISS"},
+		{mode: "skip", wantSame: "BYPASS", wantChanged: "BYPASS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.ToolCaching = tt.mode
+			})
+
+			send := func(choice string) string {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(toolBody(choice)))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Header().Get("X-Mimir-Cache")
+			}
+
+			send("auto")
+			if got := send("auto"); got != tt.wantSame {
+				t.Errorf("same tool_choice: expected %s, got %q", tt.wantSame, got)
+			}
+			if got := send("none"); got != tt.wantChanged {
+				t.Errorf("different tool_choice: expected %s, got %q", tt.wantChanged, got)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsPrefill(t *testing.T) {
+	prefillRequest := func(prefill string) api.ChatCompletionRequest {
+		messages := []api.Message{{Role: "user", Content: "Name a French city."}}
+		if prefill != "" {
+			messages = append(messages, api.Message{Role: "assistant", Content: prefill})
+		}
+		return api.ChatCompletionRequest{Model: "gpt-4", Messages: messages}
+	}
+
+	tests := []struct {
+		mode        string
+		wantSame    string
+		wantChanged string
+	}{
+		{mode: "strict", wantSame: "HIT", wantChanged: "MISS"},
+		{mode: "skip", wantSame: "BYPASS", wantChanged: "BYPASS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.PrefillCaching = tt.mode
+			})
+			// Embed every variant identically, so only the partition keeps them apart
+			embedder := h.embedder.(*fakeEmbedder)
+			for _, prefill := range []string{"", "Lyon is", "Nice is"} {
+				embedder.axes[h.generateCacheKey(prefillRequest(prefill))] = 0
+			}
+
+			send := func(prefill string) string {
+				body, err := json.Marshal(prefillRequest(prefill))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Header().Get("X-Mimir-Cache")
+			}
+
+			// A full answer is cached first; the prefill request must not get it
+			send("")
+			if got := send("Lyon is"); got != tt.wantChanged {
+				t.Errorf("prefill after a full answer: expected %s, got %q", tt.wantChanged, got)
+			}
+			if got := send("Lyon is"); got != tt.wantSame {
+				t.Errorf("same prefill: expected %s, got %q", tt.wantSame, got)
+			}
+			if got := send("Nice is"); got != tt.wantChanged {
+				t.Errorf("different prefill: expected %s, got %q", tt.wantChanged, got)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsReplayHeaders(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.ReplayHeaders = []string{"openai-model"}
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	send()
+	rec := send()
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Fatalf("expected HIT, got %q", got)
+	}
+	if got := rec.Header().Get("Openai-Model"); got != "gpt-4-0613" {
+		t.Errorf("expected allowlisted header replayed, got %q", got)
+	}
+	if got := rec.Header().Get("Openai-Processing-Ms"); got != "" {
+		t.Errorf("expected header outside the allowlist dropped, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", got)
+	}
+}
+
+func TestHandleChatCompletionsLongPrompt(t *testing.T) {
+	question := "What is the capital of France? Answer in one word."
+	long := strings.Repeat("lorem ipsum ", 20) + question
+	other := strings.Repeat("dolor sit ", 24) + question
+
+	tests := []struct {
+		mode      string
+		wantFirst string
+		wantOther string
+		wantEmbed int64
+	}{
+		{mode: "skip", wantFirst: "BYPASS", wantOther: "BYPASS", wantEmbed: 0},
+		{mode: "truncate", wantFirst: "MISS", wantOther: "HIT", wantEmbed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.MaxEmbedChars = 40
+				cfg.LongPromptMode = tt.mode
+			})
+
+			send := func(content string) string {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d", rec.Code)
+				}
+				return rec.Header().Get("X-Mimir-Cache")
+			}
+
+			if got := send(long); got != tt.wantFirst {
+				t.Errorf("expected %s, got %q", tt.wantFirst, got)
+			}
+			// Truncation keeps the end of the key, which both prompts share
+			if got := send(other); got != tt.wantOther {
+				t.Errorf("prompt with the same ending: expected %s, got %q", tt.wantOther, got)
+			}
+			if calls := h.embedder.(*fakeEmbedder).calls.Load(); calls != tt.wantEmbed {
+				t.Errorf("expected %d embed calls, got %d", tt.wantEmbed, calls)
+			}
+			if got := send("Short prompt"); got != "MISS" {
+				t.Errorf("short prompt: expected MISS, got %q", got)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsEmbedModelHeader(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.EmbedModelHeader = true
+	})
+	alt := newFakeEmbedder()
+	alt.model = "alt-embed"
+	h.RegisterEmbedder(alt)
+
+	send := func(model string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		req.Header.Set("X-Mimir-Embed-Model", model)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if got := send("alt-embed").Header().Get("X-Mimir-Embed-Model"); got != "alt-embed" {
+		t.Errorf("expected miss to report alt-embed, got %q", got)
+	}
+	rec := send("alt-embed")
+	if rec.Header().Get("X-Mimir-Cache") != "HIT" {
+		t.Fatalf("expected HIT, got %q", rec.Header().Get("X-Mimir-Cache"))
+	}
+	if got := rec.Header().Get("X-Mimir-Embed-Model"); got != "alt-embed" {
+		t.Errorf("expected hit to report the stored entry's model, got %q", got)
+	}
+}
+
+func TestHandleChatCompletionsCacheRules(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		parsed, err := rules.Parse(`[{"path": "$.messages[0].role", "op": "==", "value": "system", "action": "require"}]`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.CacheRules = parsed
+	})
+
+	send := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Mimir-Cache")
+	}
+
+	// The request has no system message, so it is never cached
+	if got := send(); got != "BYPASS" {
+		t.Errorf("expected BYPASS, got %q", got)
+	}
+	send()
+	if upstream.calls.Load() != 2 {
+		t.Errorf("expected both requests to reach upstream, got %d", upstream.calls.Load())
+	}
+	if h.cache.Size(context.Background()) != 0 {
+		t.Error("expected nothing to be cached")
+	}
+}
+
+func TestHandleChatCompletionsNoCacheModels(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.NoCacheModels = []string{"live-search", "gpt-*"}
+	})
+	embedder := newFakeEmbedder()
+	h.embedder = embedder
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+		if got := rec.Header().Get("X-Mimir-Cache"); got != "SKIP" {
+			t.Errorf("expected SKIP, got %q", got)
+		}
+	}
+	if upstream.calls.Load() != 2 {
+		t.Errorf("expected both requests to reach upstream, got %d", upstream.calls.Load())
+	}
+	if embedder.calls.Load() != 0 || h.cache.Size(context.Background()) != 0 {
+		t.Errorf("expected no embedding or store, got %d embeddings", embedder.calls.Load())
+	}
+}
+
+func TestHandleChatCompletionsTemperature(t *testing.T) {
+	body := func(t *testing.T, temperature *float64) []byte {
+		data, err := json.Marshal(api.ChatCompletionRequest{
+			Model:       "gpt-4",
+			Messages:    []api.Message{{Role: "user", Content: "name a color"}},
+			Temperature: temperature,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	temp := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		max    float64
+		key    bool
+		first  *float64
+		second *float64
+		want   []string
+	}{
+		{name: "unset cached", first: nil, second: nil, want: []string{"MISS", "HIT"}},
+		{name: "zero cached", first: temp(0), second: temp(0), want: []string{"MISS", "HIT"}},
+		{name: "above max bypassed", first: temp(0.7), second: temp(0.7), want: []string{"BYPASS", "BYPASS"}},
+		{name: "within raised max", max: 1, first: temp(0.7), second: temp(0.7), want: []string{"MISS", "HIT"}},
+		{name: "unkeyed temperatures share", max: 1, first: temp(0.2), second: temp(0.7), want: []string{"MISS", "HIT"}},
+		{name: "keyed temperatures differ", max: 1, key: true, first: temp(0.2), second: temp(0.7), want: []string{"MISS", "MISS"}},
+		{name: "keyed same temperature", max: 1, key: true, first: temp(0.7), second: temp(0.7), want: []string{"MISS", "HIT"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.MaxCacheTemperature = tt.max
+				cfg.TemperatureKey = tt.key
+			})
+			for i, temperature := range []*float64{tt.first, tt.second} {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body(t, temperature))))
+				if got := rec.Header().Get("X-Mimir-Cache"); got != tt.want[i] {
+					t.Errorf("request %d: expected %s, got %q", i+1, tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleThreshold(t *testing.T) {
+	upstream := newFakeUpstream(t)
+
+	t.Run("disabled without auth tokens", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/threshold", strings.NewReader(`{"threshold": 0.1}`)))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", rec.Code)
+		}
+	})
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AuthTokens = []string{"secret"}
+		cfg.AdminToken = "admin"
+	})
+
+	post := func(handler http.Handler, header, body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/config/threshold", strings.NewReader(body))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, header := range []string{"", "Bearer wrong", "Bearer admin"} {
+		if code := post(h, header, `{"threshold": 0.1}`); code != http.StatusUnauthorized {
+			t.Errorf("header %q: expected 401, got %d", header, code)
+		}
+	}
+	if got := h.similarityThreshold(); got != h.cfg.SimilarityThreshold {
+		t.Fatalf("expected an unauthorized POST to leave the threshold alone, got %v", got)
+	}
+
+	// AuthMiddleware strips the header it checked, but its pass still counts
+	if code := post(AuthMiddleware(h.cfg.AuthTokens)(h), "Bearer secret", `{"threshold": 0.5}`); code != http.StatusOK {
+		t.Errorf("expected 200 behind AuthMiddleware, got %d", code)
+	}
+	h.setSimilarityThreshold(h.cfg.SimilarityThreshold)
+
+	threshold := func(method, body string) (int, float64) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/config/threshold", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		h.ServeHTTP(rec, req)
+		var resp struct {
+			Threshold float64 `json:"threshold"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Threshold
+	}
+	send := func(content string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content))))
+		return rec.Header().Get("X-Mimir-Cache")
+	}
+
+	if code, got := threshold(http.MethodGet, ""); code != http.StatusOK || got != h.cfg.SimilarityThreshold {
+		t.Fatalf("expected the configured threshold, got %d %v", code, got)
+	}
+	for _, body := range []string{`{"threshold": 1.5}`, `{"threshold": -0.1}`, `{}`, `not json`} {
+		if code, _ := threshold(http.MethodPost, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+	if code, _ := threshold(http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", code)
+	}
+
+	// An entry spanning the axes of three prompts is 0.58 similar to each
+	key := func(content string) []float64 {
+		emb, _ := h.embedder.Embed(context.Background(), h.generateCacheKey(api.ChatCompletionRequest{
+			Messages: []api.Message{{Role: "user", Content: content}},
+		}))
+		return emb
+	}
+	emb := make([]float64, len(key("hi")))
+	for _, content := range []string{"hi", "hello", "hey"} {
+		for i, v := range key(content) {
+			emb[i] += v
+		}
+	}
+	h.cache.Set(context.Background(), &api.CacheEntry{
+		Request:   api.ChatCompletionRequest{Model: "gpt-4"},
+		Embedding: emb,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	if got := send("hello"); got != "MISS" {
+		t.Fatalf("expected MISS at the configured threshold, got %q", got)
+	}
+	if code, got := threshold(http.MethodPost, `{"threshold": 0.5}`); code != http.StatusOK || got != 0.5 {
+		t.Fatalf("expected the threshold changed to 0.5, got %d %v", code, got)
+	}
+	if got := send("hey"); got != "HIT" {
+		t.Errorf("expected HIT at the lowered threshold, got %q", got)
+	}
+	if h.cfg.SimilarityThreshold != 0.95 {
+		t.Errorf("expected the configured threshold left alone, got %v", h.cfg.SimilarityThreshold)
+	}
+}
+
+func TestHandleGenerateTraffic(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	body := `{"type": "identical", "count": 2}`
+
+	t.Run("disabled without token", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reports/generate-traffic", strings.NewReader(body)))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", rec.Code)
+		}
+	})
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reports/generate-traffic", strings.NewReader(body))
+		req.Header.Set("X-Mimir-Admin-Token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("guess"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with the wrong token, got %d", rec.Code)
+	}
+	if calls := upstream.calls.Load(); calls != 0 {
+		t.Fatalf("expected no upstream calls without the admin token, got %d", calls)
+	}
+
+	rec := send("secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "data: ") {
+		t.Errorf("expected progress events, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected one upstream call for two identical prompts, got %d", calls)
+	}
+}
+
+func TestHandleGC(t *testing.T) {
+	upstream := newFakeUpstream(t)
+
+	t.Run("disabled without token", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/gc", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", rec.Code)
+		}
+	})
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/gc", nil)
+		req.Header.Set("X-Mimir-Admin-Token", "guess")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("purges expired entries", func(t *testing.T) {
+		h.cache.Set(context.Background(), &api.CacheEntry{
+			Embedding: []float64{1, 0},
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/gc", nil)
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+
+		var result gcResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.EntriesRemoved != 1 {
+			t.Errorf("expected 1 entry removed, got %d", result.EntriesRemoved)
+		}
+		if result.HeapAllocBefore == 0 || result.HeapAllocAfter == 0 {
+			t.Error("expected heap sizes to be reported")
+		}
+	})
+}
+
+func TestHandleChatCompletionsInjectCacheMeta(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.InjectCacheMeta = true
+	})
+
+	send := func() map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	if _, ok := send()["x_mimir"]; ok {
+		t.Error("expected no cache metadata on a miss")
+	}
+
+	body := send()
+	meta, ok := body["x_mimir"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected x_mimir object on a hit, got %v", body)
+	}
+	if meta["cache"] != "HIT" || meta["similarity"] != 1.0 {
+		t.Errorf("unexpected cache metadata: %v", meta)
+	}
+	if _, ok := meta["age_seconds"]; !ok {
+		t.Error("expected age_seconds in cache metadata")
+	}
+	if body["id"] != "chatcmpl-test" {
+		t.Errorf("expected the original response fields to be kept, got %v", body)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	for _, provider := range []string{"ollama", "openai"} {
+		t.Run(provider, func(t *testing.T) {
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.EmbeddingProvider = provider
+				cfg.MaxEmbedConcurrency = 3
+			})
+			h.embedder.(*fakeEmbedder).fail = "bad"
+
+			var lines bytes.Buffer
+			enc := json.NewEncoder(&lines)
+			for i := 0; i < 10; i++ {
+				enc.Encode(api.CacheEntry{
+					Request:  api.ChatCompletionRequest{Model: "gpt-4", Messages: []api.Message{{Role: "user", Content: fmt.Sprintf("question %d", i)}}},
+					Response: api.ChatCompletionResponse{ID: fmt.Sprintf("resp-%d", i)},
+				})
+			}
+			enc.Encode(api.CacheEntry{
+				Request: api.ChatCompletionRequest{Model: "gpt-4", Messages: []api.Message{{Role: "user", Content: "bad question"}}},
+			})
+			// Already embedded entries are stored without calling the embedder
+			enc.Encode(api.CacheEntry{
+				Request:   api.ChatCompletionRequest{Model: "gpt-4", Messages: []api.Message{{Role: "user", Content: "pre-embedded"}}},
+				Embedding: []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0.6, 0.8},
+			})
+
+			result, err := h.Preload(context.Background(), &lines)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Loaded != 11 || result.Embedded != 10 || result.Failed != 1 {
+				t.Errorf("unexpected result: %+v", result)
+			}
+			if size := h.cache.Size(context.Background()); size != 11 {
+				t.Errorf("expected 11 cached entries, got %d", size)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsSlidingTTL(t *testing.T) {
+	for _, sliding := range []bool{false, true} {
+		upstream := newFakeUpstream(t)
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.CacheTTL = time.Minute
+			cfg.CacheSlidingTTL = sliding
+			cfg.CacheMaxAge = time.Hour
+		})
+
+		send := func() http.Header {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec.Header()
+		}
+
+		// An entry stored 50 seconds ago, with 10 seconds left
+		emb, _ := h.embedder.Embed(context.Background(), h.generateCacheKey(api.ChatCompletionRequest{
+			Messages: []api.Message{{Role: "user", Content: "hi"}},
+		}))
+		before := time.Now().Add(10 * time.Second)
+		h.cache.Set(context.Background(), &api.CacheEntry{
+			Request:   api.ChatCompletionRequest{Model: "gpt-4"},
+			Embedding: emb,
+			CreatedAt: time.Now().Add(-50 * time.Second),
+			ExpiresAt: before,
+		})
+
+		header := send()
+		if got := header.Get("X-Mimir-Cache"); got != "HIT" {
+			t.Fatalf("expected HIT, got %q", got)
+		}
+		entries, _, _ := h.cache.List(context.Background(), "", 0, 0)
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry, got %d", len(entries))
+		}
+		entry := entries[0]
+		extended := entry.ExpiresAt.After(before.Add(30 * time.Second))
+		if extended != sliding {
+			t.Errorf("sliding=%v: expected extended=%v, expiry moved from %v to %v", sliding, sliding, before, entry.ExpiresAt)
+		}
+
+		// The headers report the age at response time and the expiry after sliding
+		if got := header.Get("X-Mimir-Cache-Age"); got != "50" && got != "51" {
+			t.Errorf("expected X-Mimir-Cache-Age of 50 seconds, got %q", got)
+		}
+		if got, want := header.Get("X-Mimir-Cache-Expires"), entry.ExpiresAt.UTC().Format(time.RFC3339); got != want {
+			t.Errorf("sliding=%v: expected X-Mimir-Cache-Expires %q, got %q", sliding, want, got)
+		}
+	}
+}
+
+func TestHandleChatCompletionsNegativeCache(t *testing.T) {
+	statuses := map[string]int{
+		"policy violation": http.StatusBadRequest,
+		"slow down":        http.StatusTooManyRequests,
+		"bad key":          http.StatusUnauthorized,
+		"server error":     http.StatusInternalServerError,
+	}
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		var req api.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statuses[req.Messages[0].Content.(string)])
+		json.NewEncoder(w).Encode(api.ErrorResponse{Error: api.APIError{Message: "rejected", Type: "invalid_request_error"}})
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		prompt    string
+		ttl       time.Duration
+		wantCache string
+		wantCalls int64
+	}{
+		{prompt: "policy violation", ttl: time.Minute, wantCache: "HIT-ERROR", wantCalls: 1},
+		{prompt: "policy violation", ttl: 0, wantCache: "MISS", wantCalls: 2},
+		{prompt: "slow down", ttl: time.Minute, wantCache: "MISS", wantCalls: 2},
+		{prompt: "bad key", ttl: time.Minute, wantCache: "MISS", wantCalls: 2},
+		{prompt: "server error", ttl: time.Minute, wantCache: "MISS", wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%v", tt.prompt, tt.ttl), func(t *testing.T) {
+			upstream.calls.Store(0)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.NegativeCacheTTL = tt.ttl
+			})
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, tt.prompt))))
+			}
+			if rec.Code != statuses[tt.prompt] {
+				t.Errorf("expected status %d, got %d", statuses[tt.prompt], rec.Code)
+			}
+			if got := rec.Header().Get("X-Mimir-Cache"); got != tt.wantCache {
+				t.Errorf("expected %s, got %q", tt.wantCache, got)
+			}
+			if calls := upstream.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("expected %d upstream calls, got %d", tt.wantCalls, calls)
+			}
+			if !strings.Contains(rec.Body.String(), "rejected") {
+				t.Errorf("expected the upstream error body, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsNegativeCacheExactRequest(t *testing.T) {
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		var req api.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.MaxTokens != nil && *req.MaxTokens < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(api.ErrorResponse{Error: api.APIError{Message: "max_tokens must be positive", Type: "invalid_request_error"}})
+			return
+		}
+		json.NewEncoder(w).Encode(api.ChatCompletionResponse{
+			ID:      "chatcmpl-1",
+			Object:  "chat.completion",
+			Model:   req.Model,
+			Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: "Paris"}, FinishReason: "stop"}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.NegativeCacheTTL = time.Minute
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		return rec
+	}
+	invalid := `{"model":"gpt-4","max_tokens":-1,"messages":[{"role":"user","content":"capital of France?"}]}`
+	valid := `{"model":"gpt-4","max_tokens":50,"messages":[{"role":"user","content":"capital of France?"}]}`
+
+	if rec := send(invalid); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 from upstream, got %d", rec.Code)
+	}
+	if rec := send(invalid); rec.Header().Get("X-Mimir-Cache") != "HIT-ERROR" {
+		t.Fatalf("expected the identical request to replay the error, got %q", rec.Header().Get("X-Mimir-Cache"))
+	}
+
+	rec := send(valid)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for a valid request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected MISS, got %q", got)
+	}
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("expected the valid request to reach upstream, got %d calls", calls)
+	}
+
+	// The error lookup must not count each request's miss a second time
+	if stats := h.cache.Stats(context.Background()); stats.TotalMisses != 3 || stats.TotalHits != 0 {
+		t.Errorf("expected 3 misses and no hits, got %d and %d", stats.TotalMisses, stats.TotalHits)
+	}
+}
+
+// hangingEmbedder blocks every Embed call until its context is done.
+type hangingEmbedder struct {
+	*fakeEmbedder
+}
+
+func (e hangingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHandleChatCompletionsEmbedTimeout(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.EmbedTimeout = 20 * time.Millisecond
+	})
+	h.embedder = hangingEmbedder{newFakeEmbedder()}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the request to be forwarded, got %d", rec.Code)
+	}
+	if upstream.calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", upstream.calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the embed timeout to cut the lookup short, took %v", elapsed)
+	}
+}
+
+func TestHandleChatCompletionsUpstreamRoutes(t *testing.T) {
+	openai := newFakeUpstream(t)
+	vllm := newFakeUpstream(t)
+	ollama := newFakeUpstream(t)
+	h := newTestHandler(t, openai, func(cfg *config.Config) {
+		cfg.OpenAIAPIKey = "sk-openai"
+		cfg.UpstreamRoutes = []config.UpstreamRoute{
+			{Pattern: "llama*", URL: vllm.URL, APIKey: "vllm-key"},
+			{Pattern: "mistral*", URL: ollama.URL},
+		}
+	})
+
+	send := func(model, auth string) {
+		body, _ := json.Marshal(api.ChatCompletionRequest{
+			Model:    model,
+			Messages: []api.Message{{Role: "user", Content: "hi from " + model + auth}},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", model, rec.Code)
+		}
+	}
+
+	send("llama-3-8b", "")
+	if vllm.calls.Load() != 1 || openai.calls.Load() != 0 {
+		t.Fatalf("expected the llama request to go to the routed upstream, got %d routed and %d default calls", vllm.calls.Load(), openai.calls.Load())
+	}
+	if auth := vllm.lastReq.Header.Get("Authorization"); auth != "Bearer vllm-key" {
+		t.Errorf("expected the route's API key, got %q", auth)
+	}
+
+	// A route without a key never receives OPENAI_API_KEY
+	send("mistral-7b", "")
+	if ollama.calls.Load() != 1 {
+		t.Fatalf("expected the mistral request to go to its route, got %d calls", ollama.calls.Load())
+	}
+	if auth := ollama.lastReq.Header.Get("Authorization"); auth != "" {
+		t.Errorf("expected no Authorization header on a keyless route, got %q", auth)
+	}
+	send("mistral-7b", "Bearer client-key")
+	if auth := ollama.lastReq.Header.Get("Authorization"); auth != "Bearer client-key" {
+		t.Errorf("expected the client's key on a keyless route, got %q", auth)
+	}
+
+	send("gpt-4", "")
+	if openai.calls.Load() != 1 || vllm.calls.Load() != 1 {
+		t.Errorf("expected the gpt request to go to the default upstream, got %d routed and %d default calls", vllm.calls.Load(), openai.calls.Load())
+	}
+	if auth := openai.lastReq.Header.Get("Authorization"); auth != "Bearer sk-openai" {
+		t.Errorf("expected OPENAI_API_KEY on the default upstream, got %q", auth)
+	}
+}
+
+func TestHandleWarmup(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+		cfg.WarmupConcurrency = 3
+		cfg.MaxEmbedChars = 40
+	})
+
+	warmup := func(prompts ...string) (*httptest.ResponseRecorder, warmupResult) {
+		var reqs []api.ChatCompletionRequest
+		for _, prompt := range prompts {
+			reqs = append(reqs, api.ChatCompletionRequest{Model: "gpt-4", Messages: []api.Message{{Role: "user", Content: prompt}}})
+		}
+		body, _ := json.Marshal(reqs)
+		req := httptest.NewRequest(http.MethodPost, "/cache/warmup", bytes.NewReader(body))
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var result warmupResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec, result
+	}
+
+	rec, result := warmup("question 1", "question 2", "question 3", strings.Repeat("too long ", 10))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result.Seeded != 3 || result.Present != 0 || result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if calls := upstream.calls.Load(); calls != 3 {
+		t.Errorf("expected 3 upstream calls, got %d", calls)
+	}
+
+	// Seeded prompts are served from the cache
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "question 2")))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Errorf("expected a seeded prompt to hit, got %q", got)
+	}
+
+	_, result = warmup("question 1", "question 4")
+	if result.Seeded != 1 || result.Present != 1 {
+		t.Errorf("expected 1 seeded and 1 present, got %+v", result)
+	}
+	if calls := upstream.calls.Load(); calls != 4 {
+		t.Errorf("expected only the new prompt to go upstream, got %d calls", calls)
+	}
+
+	t.Run("invalid request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/cache/warmup", strings.NewReader(`[{"model": "gpt-4"}]`))
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a request without messages, got %d", rec.Code)
+		}
+	})
+
+	t.Run("requires admin token", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/warmup", strings.NewReader("[]")))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", rec.Code)
+		}
+	})
+}
+
+func TestHandleChatCompletionsReadOnlyCache(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.CacheReadOnly = true
+	})
+
+	send := func(content string) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Mimir-Cache")
+	}
+
+	send("hi")
+	if got := send("hi"); got != "MISS" {
+		t.Errorf("expected misses not to be stored, got %q", got)
+	}
+	if h.cache.Size(context.Background()) != 0 {
+		t.Error("expected read-only cache to stay empty")
+	}
+
+	// Entries written elsewhere (e.g. by the primary) are still served
+	emb, _ := h.embedder.Embed(context.Background(), h.generateCacheKey(api.ChatCompletionRequest{
+		Messages: []api.Message{{Role: "user", Content: "replicated"}},
+	}))
+	h.cache.Set(context.Background(), &api.CacheEntry{Embedding: emb, ExpiresAt: time.Now().Add(time.Hour)})
+	if got := send("replicated"); got != "HIT" {
+		t.Errorf("expected read-only cache to serve hits, got %q", got)
+	}
+}
+
+func TestHandleChatCompletionsCompressedHit(t *testing.T) {
+	answer := strings.Repeat("A long essay-style answer about the capital of France. ", 50)
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		json.NewEncoder(w).Encode(api.ChatCompletionResponse{
+			ID:      "chatcmpl-essay",
+			Object:  "chat.completion",
+			Model:   "gpt-4",
+			Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: answer}, FinishReason: "stop"}},
+			Usage:   api.Usage{PromptTokens: 10, CompletionTokens: 500, TotalTokens: 510},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	for _, inject := range []bool{false, true} {
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.InjectCacheMeta = inject
+		})
+		h.cache = cache.NewMemoryCache(&cache.Options{
+			MaxSize:           100,
+			DefaultTTL:        time.Hour,
+			CleanupInterval:   time.Hour,
+			CompressResponses: true,
+		})
+
+		var resp api.ChatCompletionResponse
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "essay"))))
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 && rec.Header().Get("X-Mimir-Cache") != "HIT" {
+				t.Fatalf("inject=%v: expected a hit, got %q", inject, rec.Header().Get("X-Mimir-Cache"))
+			}
+		}
+		if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != answer {
+			t.Errorf("inject=%v: expected the full answer from a compressed entry, got %+v", inject, resp.Choices)
+		}
+	}
+}
+
+func TestHandleChatCompletionsUpstreamAllowlist(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AllowedUpstreamHosts = []string{"api.openai.com"}
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 for a host outside the allowlist, got %d", rec.Code)
+	}
+	if upstream.calls.Load() != 0 {
+		t.Error("expected the upstream not to be contacted")
+	}
+}
+
+func TestHandleVerify(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+
+	verify := func(content string, passed bool) (*httptest.ResponseRecorder, cache.VerifyResult) {
+		body, _ := json.Marshal(verifyRequest{
+			Request: api.ChatCompletionRequest{Model: "gpt-4", Messages: []api.Message{{Role: "user", Content: content}}},
+			Passed:  passed,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/admin/verify", bytes.NewReader(body))
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var result cache.VerifyResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec, result
+	}
+
+	if rec, _ := verify("never asked", true); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an uncached request, got %d", rec.Code)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "capital of France?"))))
+
+	rec, result := verify("capital of France?", true)
+	if rec.Code != http.StatusOK || !result.Found || result.ThresholdOffset >= 0 {
+		t.Errorf("expected the entry to be boosted, got %d %+v", rec.Code, result)
+	}
+
+	for i := 0; i < 10 && !result.Evicted; i++ {
+		_, result = verify("capital of France?", false)
+	}
+	if !result.Evicted {
+		t.Error("expected repeated failures to evict the entry")
+	}
+}
+
+// unreachableCache is a cache whose backing store cannot be reached.
+type unreachableCache struct {
+	cache.Cache
+}
+
+func (unreachableCache) Ping(ctx context.Context) error {
+	return fmt.Errorf("redis dial: connection refused")
+}
+
+func TestHandleReady(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the memory cache, got %d", rec.Code)
+	}
+
+	h.cfg.CacheBackend = "redis"
+	h.cache = unreachableCache{h.cache}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for an unreachable backend, got %d", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["backend"] != "redis" || !strings.Contains(body["error"], "connection refused") {
+		t.Errorf("expected the backend and error in the response, got %v", body)
+	}
+}
+
+func TestHandleHealthReady(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.ReadyCheckUpstream = true
+	})
+	embedder := newFakeEmbedder()
+	embedder.fail = readyProbeText
+	h.embedder = embedder
+
+	get := func() (int, map[string]string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		var body struct {
+			Status string            `json:"status"`
+			Checks map[string]string `json:"checks"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body.Checks
+	}
+
+	code, checks := get()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the embedder fails, got %d", code)
+	}
+	if !strings.Contains(checks["embedder"], "cannot embed") || checks["cache"] != "ok" || checks["upstream"] != "ok" {
+		t.Errorf("expected only the embedder to fail, got %v", checks)
+	}
+	if upstream.lastReq == nil || upstream.lastReq.Method != http.MethodGet || upstream.lastReq.URL.Path != "/v1/models" {
+		t.Errorf("expected the upstream probed with GET /v1/models, got %+v", upstream.lastReq)
+	}
+
+	// A recent successful lookup stands in for the probe
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi"))))
+	calls := embedder.calls.Load()
+	if code, checks := get(); code != http.StatusOK || checks["embedder"] != "ok" {
+		t.Fatalf("expected ready after a successful lookup, got %d %v", code, checks)
+	}
+	if embedder.calls.Load() != calls {
+		t.Error("expected no probe embedding after a recent success")
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	h.cfg.OpenAIBaseURL = down.URL
+	if code, checks := get(); code != http.StatusServiceUnavailable || !strings.Contains(checks["upstream"], "502") {
+		t.Errorf("expected 503 naming the upstream status, got %d %v", code, checks)
+	}
+}
+
+func TestHandleChatCompletionsInnerHeaders(t *testing.T) {
+	// The upstream is another mimir that served a hit
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Mimir-Cache", "HIT")
+		w.Header().Set("X-Mimir-Similarity", "0.9800")
+		json.NewEncoder(w).Encode(api.ChatCompletionResponse{
+			ID:      "chatcmpl-inner",
+			Model:   "gpt-4",
+			Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: "Paris"}, FinishReason: "stop"}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		mode           string
+		wantSimilarity string
+		wantL2Cache    string
+	}{
+		{"preserve", "0.9800", ""},
+		{"strip", "", ""},
+		{"namespace", "", "HIT"},
+	}
+	for _, tt := range tests {
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.StripInnerHeaders = tt.mode
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "capital of France"))))
+
+		if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+			t.Errorf("%s: expected the outer layer's MISS, got %q", tt.mode, got)
+		}
+		if got := rec.Header().Get("X-Mimir-Similarity"); got != tt.wantSimilarity {
+			t.Errorf("%s: X-Mimir-Similarity = %q, want %q", tt.mode, got, tt.wantSimilarity)
+		}
+		if got := rec.Header().Get("X-Mimir-L2-Cache"); got != tt.wantL2Cache {
+			t.Errorf("%s: X-Mimir-L2-Cache = %q, want %q", tt.mode, got, tt.wantL2Cache)
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.MetricsPort = 0
+	})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "capital of France"))))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"mimir_cache_hits_total 1\n", "mimir_cache_misses_total 1\n", "mimir_cache_entries 1\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in metrics:\n%s", want, body)
+		}
+	}
+
+	// With a dedicated metrics port, the main port does not serve them
+	h.cfg.MetricsPort = 9090
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 on the main port, got %d", rec.Code)
+	}
+}
+
+// thresholdRecorder records the threshold of the last lookup.
+type thresholdRecorder struct {
+	cache.Cache
+	threshold float64
+}
+
+func (c *thresholdRecorder) Get(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	c.threshold = threshold
+	return c.Cache.Get(ctx, embedding, threshold)
+}
+
+func TestHandleChatCompletionsModelThreshold(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.ModelThresholds = map[string]float64{"codellama": 0.98}
+	})
+	recorder := &thresholdRecorder{Cache: h.cache}
+	h.cache = recorder
+
+	for _, tt := range []struct {
+		model string
+		want  float64
+	}{
+		{"codellama", 0.98},
+		{"gpt-4", h.cfg.SimilarityThreshold},
+	} {
+		body, _ := json.Marshal(api.ChatCompletionRequest{
+			Model:    tt.model,
+			Messages: []api.Message{{Role: "user", Content: "write a sort function"}},
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+		if recorder.threshold != tt.want {
+			t.Errorf("%s: expected threshold %v, got %v", tt.model, tt.want, recorder.threshold)
+		}
+	}
+}
+
+func TestHandleSavings(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "capital of France"))))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reports/savings?period=7d", nil))
+	var report reports.SavingsReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Period != "7d" || report.Requests != 2 || report.Hits != 1 || report.TokensSaved != 11 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.ByModel) != 1 || report.ByModel[0].Model != "gpt-4" {
+		t.Errorf("expected a gpt-4 breakdown, got %+v", report.ByModel)
+	}
+	if report.Coverage.Complete {
+		t.Error("expected partial coverage for a fresh process")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reports/savings?period=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad period, got %d", rec.Code)
+	}
+}
+
+func TestHandleInvalidate(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+
+	invalidate := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Mimir-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	removed := func(rec *httptest.ResponseRecorder) int {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var result invalidateResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.EntriesRemoved
+	}
+
+	for _, content := range []string{"What is the capital of France?", "Summarize the refund policy", "Tell me a joke"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if rec := invalidate(http.MethodPost, "/cache/invalidate", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an empty selection to be rejected, got %d", rec.Code)
+	}
+	if rec := invalidate(http.MethodPost, "/cache", `{"all": true}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST /cache to be rejected, got %d", rec.Code)
+	}
+	if n := removed(invalidate(http.MethodPost, "/cache/invalidate", `{"prompt": "refund"}`)); n != 1 {
+		t.Errorf("expected 1 entry removed by prompt, got %d", n)
+	}
+	if n := removed(invalidate(http.MethodDelete, "/cache?model=gpt-3.5-turbo", "")); n != 0 {
+		t.Errorf("expected no entries for another model, got %d", n)
+	}
+	if n := removed(invalidate(http.MethodDelete, "/cache?all=true", "")); n != 2 {
+		t.Errorf("expected the remaining 2 entries cleared, got %d", n)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "Tell me a joke")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+		t.Errorf("expected MISS after invalidation, got %q", got)
+	}
+}
+
+func TestHandleChatCompletionsConditionalRequests(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.ConditionalRequests = true
+	})
+
+	send := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("")
+	etag := rec.Header().Get("ETag")
+	id := rec.Header().Get("X-Mimir-Entry-ID")
+	if id == "" || etag != `"`+id+`"` {
+		t.Fatalf("expected the miss to carry the stored entry's ETag, got ETag %q and ID %q", etag, id)
+	}
+
+	rec = send(etag)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected an empty body, got %q", rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Mimir-Cache"); got != "HIT" {
+		t.Errorf("expected HIT, got %q", got)
+	}
+
+	rec = send(`"stale", W/"other"`)
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("expected the full response for a stale tag, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("expected ETag %q on the hit, got %q", etag, got)
+	}
+
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		h := newTestHandler(t, upstream, nil)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("ETag"); got != "" {
+			t.Errorf("expected no ETag, got %q", got)
+		}
+	})
+}
+
+func TestHandleChatCompletionsCoalesceMisses(t *testing.T) {
+	const clients = 10
+
+	for _, status := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			// The upstream holds every request until all clients are waiting
+			upstream := newFakeUpstream(t)
+			release := make(chan struct{})
+			var calls atomic.Int64
+			gate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				<-release
+				if status != http.StatusOK {
+					http.Error(w, "overloaded", status)
+					return
+				}
+				upstream.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer gate.Close()
+
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.OpenAIBaseURL = gate.URL
+			})
+
+			var wg sync.WaitGroup
+			recs := make([]*httptest.ResponseRecorder, clients)
+			for i := range recs {
+				recs[i] = httptest.NewRecorder()
+				wg.Add(1)
+				go func(rec *httptest.ResponseRecorder) {
+					defer wg.Done()
+					req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+					h.ServeHTTP(rec, req)
+				}(recs[i])
+			}
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := calls.Load(); got != 1 {
+				t.Errorf("expected 1 upstream call, got %d", got)
+			}
+			coalesced := 0
+			for _, rec := range recs {
+				if rec.Code != status {
+					t.Errorf("expected status %d, got %d", status, rec.Code)
+				}
+				if rec.Header().Get("X-Mimir-Coalesced") == "true" {
+					coalesced++
+				}
+			}
+			if coalesced != clients-1 {
+				t.Errorf("expected %d coalesced responses, got %d", clients-1, coalesced)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		upstream := newFakeUpstream(t)
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.CoalesceMisses = false
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Mimir-Coalesced"); got != "" {
+			t.Errorf("expected no X-Mimir-Coalesced header, got %q", got)
+		}
+	})
+}
+
+func TestFlightGroupSharesErrors(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstreamErr := fmt.Errorf("connection refused")
+
+	leader := make(chan upstreamResult)
+	go func() {
+		res, _ := g.do(context.Background(), "key", func(context.Context) upstreamResult {
+			close(started)
+			<-release
+			return upstreamResult{err: upstreamErr}
+		})
+		leader <- res
+	}()
+	<-started
+
+	// A waiter whose context ends gives up without the leader's result
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, shared := g.do(ctx, "key", nil); !shared || res.err != context.Canceled {
+		t.Errorf("expected a cancelled waiter to get context.Canceled, got %v (shared %v)", res.err, shared)
+	}
+
+	waiter := make(chan upstreamResult)
+	go func() {
+		res, shared := g.do(context.Background(), "key", func(context.Context) upstreamResult {
+			t.Error("waiter ran its own call")
+			return upstreamResult{}
+		})
+		if !shared {
+			t.Error("expected the waiter's result to be shared")
+		}
+		waiter <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if res := <-leader; res.err != upstreamErr {
+		t.Errorf("expected the leader to get %v, got %v", upstreamErr, res.err)
+	}
+	if res := <-waiter; res.err != upstreamErr {
+		t.Errorf("expected the waiter to get %v, got %v", upstreamErr, res.err)
+	}
+}
+
+func TestFlightGroupLeaderCancelled(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	callErr := make(chan error, 1)
+
+	type ctxKey struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "leader"))
+	leader := make(chan upstreamResult)
+	go func() {
+		res, _ := g.do(ctx, "key", func(ctx context.Context) upstreamResult {
+			if ctx.Value(ctxKey{}) != "leader" {
+				t.Error("expected the call to keep the leader's context values")
+			}
+			close(started)
+			<-release
+			callErr <- ctx.Err()
+			return upstreamResult{entryID: "shared"}
+		})
+		leader <- res
+	}()
+	<-started
+
+	waiter := make(chan upstreamResult)
+	go func() {
+		res, _ := g.do(context.Background(), "key", nil)
+		waiter <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The leader's client goes away while the waiter still needs the result
+	cancel()
+	if res := <-leader; res.err != context.Canceled {
+		t.Errorf("expected the cancelled leader to get context.Canceled, got %v", res.err)
+	}
+	close(release)
+
+	if res := <-waiter; res.err != nil || res.entryID != "shared" {
+		t.Errorf("expected the waiter to get the call's result, got %+v", res)
+	}
+	if err := <-callErr; err != nil {
+		t.Errorf("expected the call's context to outlive the leader's, got %v", err)
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.AdminToken = "secret"
+	})
+	upstream.Close()
+
+	send := func(content string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, content)))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("What is the capital of France?")
+	h.embedder.(*fakeEmbedder).fail = "bad"
+	send("a bad prompt")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reports/errors", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "capital of France") {
+		t.Fatalf("expected status 401 and no records without the admin token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/reports/errors", nil)
+	req.Header.Set("X-Mimir-Admin-Token", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var failures []reports.FailedRequest
+	if err := json.Unmarshal(rec.Body.Bytes(), &failures); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failed requests, got %+v", failures)
+	}
+
+	miss := failures[0]
+	if miss.Endpoint != "/v1/chat/completions" || miss.Model != "gpt-4" || miss.Status != http.StatusBadGateway {
+		t.Errorf("unexpected failure record: %+v", miss)
+	}
+	if miss.Error == "" || miss.EmbedError != "" || !strings.Contains(miss.Prompt, "capital of France") {
+		t.Errorf("expected an upstream error for the prompt, got %+v", miss)
+	}
+	if failures[1].EmbedError == "" {
+		t.Errorf("expected the embedding error to be recorded, got %+v", failures[1])
+	}
+}
+
+func TestHandleStatsSaturation(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	release := make(chan struct{})
+	gate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		upstream.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer gate.Close()
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.OpenAIBaseURL = gate.URL
+	})
+
+	stats := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	body := stats()
+	if body["upstream_in_flight"] != 1.0 || body["requests_waiting"] != 2.0 || body["embeds_in_flight"] != 0.0 {
+		t.Errorf("expected 1 upstream call and 2 waiting requests, got %v", body)
+	}
+	if _, ok := body["total_entries"]; !ok {
+		t.Errorf("expected cache stats alongside saturation, got %v", body)
+	}
+
+	close(release)
+	wg.Wait()
+	body = stats()
+	if body["upstream_in_flight"] != 0.0 || body["requests_waiting"] != 0.0 {
+		t.Errorf("expected no work in progress, got %v", body)
+	}
+}
+
+func TestHandleChatCompletionsRateLimited(t *testing.T) {
+	t.Run("relayed", func(t *testing.T) {
+		upstream := newFakeUpstream(t)
+		limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.Header().Set("X-Ratelimit-Remaining-Requests", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		}))
+		defer limited.Close()
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.OpenAIBaseURL = limited.URL
+			cfg.FallbackMessage = "Sorry, try again later."
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "7" {
+			t.Errorf("expected Retry-After=7, got %q", got)
+		}
+		if got := rec.Header().Get("X-Ratelimit-Remaining-Requests"); got != "0" {
+			t.Errorf("expected rate-limit headers to be relayed, got %q", got)
+		}
+		if got := h.collector.GetReport().TotalRateLimited; got != 1 {
+			t.Errorf("expected TotalRateLimited=1, got %d", got)
+		}
+	})
+
+	t.Run("plain 503 fails over", func(t *testing.T) {
+		fallback := newFakeUpstream(t)
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer down.Close()
+		h := newTestHandler(t, fallback, func(cfg *config.Config) {
+			cfg.OpenAIBaseURL = down.URL
+			cfg.UpstreamFallbackURL = fallback.URL
+			cfg.UpstreamMaxRetries = 2
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK || fallback.calls.Load() != 1 {
+			t.Fatalf("expected the fallback upstream to answer, got %d with %d fallback calls", rec.Code, fallback.calls.Load())
+		}
+		if got := h.collector.GetReport().TotalRateLimited; got != 0 {
+			t.Errorf("expected a plain 503 not to count as rate limited, got %d", got)
+		}
+	})
+
+	t.Run("503 with Retry-After relayed", func(t *testing.T) {
+		fallback := newFakeUpstream(t)
+		busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer busy.Close()
+		h := newTestHandler(t, fallback, func(cfg *config.Config) {
+			cfg.OpenAIBaseURL = busy.URL
+			cfg.UpstreamFallbackURL = fallback.URL
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "7" {
+			t.Errorf("expected the 503 relayed with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if calls := fallback.calls.Load(); calls != 0 {
+			t.Errorf("expected no failover, got %d fallback calls", calls)
+		}
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		upstream := newFakeUpstream(t)
+		var calls atomic.Int64
+		flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			}
+			upstream.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer flaky.Close()
+		h := newTestHandler(t, upstream, func(cfg *config.Config) {
+			cfg.OpenAIBaseURL = flaky.URL
+			cfg.UpstreamMaxRetries = 2
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 after retrying, got %d", rec.Code)
+		}
+		if calls.Load() != 2 {
+			t.Errorf("expected 2 upstream calls, got %d", calls.Load())
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		after   string
+		attempt int
+		want    time.Duration
+		wantOK  bool
+	}{
+		{name: "backoff", attempt: 0, want: time.Second, wantOK: true},
+		{name: "backoff doubles", attempt: 2, want: 4 * time.Second, wantOK: true},
+		{name: "retry-after seconds", after: "3", want: 3 * time.Second, wantOK: true},
+		{name: "retry-after too long", after: "120", want: 2 * time.Minute, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.after != "" {
+				header.Set("Retry-After", tt.after)
+			}
+			got, ok := retryDelay(header, time.Second, tt.attempt)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryDelay() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsTTLHeader(t *testing.T) {
+	tests := []struct {
+		header  string
+		wantTTL time.Duration // zero when nothing is cached
+	}{
+		{header: "5m", wantTTL: 5 * time.Minute},
+		{header: "0", wantTTL: 0},
+		{header: "no-store", wantTTL: 0},
+		{header: "soon", wantTTL: config.DefaultConfig().CacheTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+			req.Header.Set("X-Mimir-TTL", tt.header)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if tt.wantTTL == 0 {
+				if got := rec.Header().Get("X-Mimir-Cache"); got != "BYPASS" {
+					t.Errorf("expected BYPASS, got %q", got)
+				}
+				if h.cache.Size(context.Background()) != 0 {
+					t.Error("expected nothing to be cached")
+				}
+				return
+			}
+
+			entries, _, err := h.cache.List(context.Background(), "", 0, 0)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("expected one cached entry, got %d: %v", len(entries), err)
+			}
+			entry := entries[0]
+			if got := entry.ExpiresAt.Sub(entry.CreatedAt); got < tt.wantTTL-time.Second || got > tt.wantTTL+time.Second {
+				t.Errorf("expected TTL %v, got %v", tt.wantTTL, got)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsNoCache(t *testing.T) {
+	tests := []struct {
+		name, header, value string
+	}{
+		{name: "mimir header", header: "X-Mimir-No-Cache", value: "true"},
+		{name: "cache-control", header: "Cache-Control", value: "max-age=0, no-cache"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, nil)
+
+			send := func(fresh bool) string {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+				if fresh {
+					req.Header.Set(tt.header, tt.value)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Header().Get("X-Mimir-Cache")
+			}
+
+			send(false)
+			if got := send(true); got != "BYPASS" {
+				t.Errorf("expected BYPASS, got %q", got)
+			}
+			if upstream.calls.Load() != 2 {
+				t.Errorf("expected the refresh to reach upstream, got %d calls", upstream.calls.Load())
+			}
+			if got := send(false); got != "HIT" {
+				t.Errorf("expected the refreshed answer to be cached, got %q", got)
+			}
+			if h.cache.Size(context.Background()) != 1 {
+				t.Errorf("expected the refresh to replace the entry, got %d entries", h.cache.Size(context.Background()))
+			}
+		})
+	}
+}
+
+func TestUpstreamGatePriority(t *testing.T) {
+	g := newUpstreamGate(1)
+	ctx := context.Background()
+	if err := g.acquire(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := func(high, low int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			g.mu.Lock()
+			h, l := len(g.high), len(g.low)
+			g.mu.Unlock()
+			if h == high && l == low {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d high and %d low waiters, got %d and %d", high, low, h, l)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A low-priority request queues first, then a high-priority one
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		g.acquire(ctx, false)
+		order <- "low"
+		g.release()
+	}()
+	queued(0, 1)
+	go func() {
+		defer wg.Done()
+		g.acquire(ctx, true)
+		order <- "high"
+		g.release()
+	}()
+	queued(1, 1)
+
+	// A waiter that gives up leaves the queue without taking a slot
+	cancelled, cancel := context.WithCancel(ctx)
+	errc := make(chan error, 1)
+	go func() { errc <- g.acquire(cancelled, true) }()
+	queued(2, 1)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	g.release()
+	if first, second := <-order, <-order; first != "high" || second != "low" {
+		t.Errorf("expected high before low, got %s then %s", first, second)
+	}
+	wg.Wait()
+	if g.free != 1 {
+		t.Errorf("expected the slot to be free again, got %d", g.free)
+	}
+}
+
+func TestHandleChatCompletionsNormCheck(t *testing.T) {
+	for _, check := range []string{"warn", "reject"} {
+		t.Run(check, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			h := newTestHandler(t, upstream, func(cfg *config.Config) {
+				cfg.SimilarityMetric = "dot"
+				cfg.NormCheck = check
+			})
+			h.embedder.(*fakeEmbedder).scale = 3
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "hi")))
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			want := 1
+			if check == "reject" {
+				want = 0
+			}
+			if got := h.cache.Size(context.Background()); got != want {
+				t.Errorf("expected %d cached entries, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateCacheKey(t *testing.T) {
+	req := api.ChatCompletionRequest{Messages: []api.Message{
+		{Role: "system", Content: "Now: 2024-05-01T10:00:00Z. Request req-81f2."},
+		{Role: "user", Content: "What is the capital of France?"},
+	}}
+
+	tests := []struct {
+		name  string
+		mode  string
+		roles []string
+		strip string
+		req   api.ChatCompletionRequest
+		want  string
+	}{
+		{
+			name: "all roles by default",
+			req:  req,
+			want: "system: Now: 2024-05-01T10:00:00Z. Request req-81f2.\nuser: What is the capital of France?\n",
+		},
+		{
+			name:  "selected roles",
+			roles: []string{"user"},
+			req:   req,
+			want:  "user: What is the capital of France?\n",
+		},
+		{
+			name:  "no message with a selected role",
+			roles: []string{"assistant"},
+			req:   api.ChatCompletionRequest{Messages: req.Messages[1:]},
+			want:  "user: What is the capital of France?\n",
+		},
+		{
+			name:  "volatile text stripped",
+			strip: `\d{4}-\d{2}-\d{2}T\S+|req-[0-9a-f]+`,
+			req:   req,
+			want:  "system: Now:  Request .\nuser: What is the capital of France?\n",
+		},
+		{
+			name:  "trailing prefill kept",
+			roles: []string{"user"},
+			req: api.ChatCompletionRequest{Messages: []api.Message{
+				req.Messages[1],
+				{Role: "assistant", Content: "The capital is"},
+			}},
+			want: "user: What is the capital of France?\nassistant: The capital is\n",
+		},
+		{
+			name: "last user message",
+			mode: "last-user",
+			req: api.ChatCompletionRequest{Messages: []api.Message{
+				req.Messages[0],
+				{Role: "user", Content: "Hi, I'm planning a trip."},
+				{Role: "assistant", Content: "Where to?"},
+				req.Messages[1],
+			}},
+			want: "user: What is the capital of France?\n",
+		},
+		{
+			name: "last user message with prefill",
+			mode: "last-user",
+			req: api.ChatCompletionRequest{Messages: []api.Message{
+				req.Messages[0],
+				req.Messages[1],
+				{Role: "assistant", Content: "The capital is"},
+			}},
+			want: "user: What is the capital of France?\nassistant: The capital is\n",
+		},
+		{
+			name: "conversation past the last user message",
+			mode: "last-user",
+			req: api.ChatCompletionRequest{Messages: []api.Message{
+				req.Messages[1],
+				{Role: "assistant", Content: "Let me look that up."},
+				{Role: "tool", Content: "Paris"},
+			}},
+			want: "user: What is the capital of France?\nassistant: Let me look that up.\ntool: Paris\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, newFakeUpstream(t), func(cfg *config.Config) {
+				cfg.CacheKeyMode = tt.mode
+				cfg.CacheKeyRoles = tt.roles
+				cfg.CacheKeyStrip = tt.strip
+			})
+			if got := h.generateCacheKey(tt.req); got != tt.want {
+				t.Errorf("generateCacheKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletionsTracing(t *testing.T) {
+	var mu sync.Mutex
+	var names []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var export struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&export)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					names = append(names, s.Name)
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+
+	upstream := newFakeUpstream(t)
+	h := newTestHandler(t, upstream, nil)
+	tracer := tracing.New(collector.URL, "test", h.logger)
+	h.SetTracer(tracer)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(chatBody(t, "What is the capital of France?")))
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	traceparent := upstream.lastReq.Header.Get("traceparent")
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(traceparent, "00f067aa0ba902b7") {
+		t.Errorf("expected the upstream call to continue the trace from its own span, got %q", traceparent)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	got := strings.Join(names, ",")
+	want := "embed,cache.get,upstream.request,cache.set,chat.completions,embed,cache.get,chat.completions"
+	if got != want {
+		t.Errorf("expected spans %s, got %s", want, got)
+	}
+}
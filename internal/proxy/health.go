@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyEmbedFresh is how recently a lookup must have embedded successfully
+// for /health/ready to trust the embedder without a probe of its own.
+const readyEmbedFresh = 30 * time.Second
+
+// readyProbeText is embedded to check the embedder.
+const readyProbeText = "mimir readiness check"
+
+// handleHealthReady reports whether the proxy can serve traffic end to
+// end: the cache backend answers a ping, the default embedder produces an
+// embedding and, with ReadyCheckUpstream, the upstream answers a request
+// for its model list. Checks run concurrently within ReadyTimeout; any
+// failure returns 503 with the reason for each failed check.
+func (h *Handler) handleHealthReady(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.cfg.ReadyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.ReadyTimeout)
+		defer cancel()
+	}
+
+	checks := map[string]func(context.Context) error{
+		"cache":    h.cache.Ping,
+		"embedder": h.checkEmbedder,
+	}
+	if h.cfg.ReadyCheckUpstream {
+		checks["upstream"] = h.checkUpstream
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(checks))
+	ready := true
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[name] = err.Error()
+				ready = false
+				return
+			}
+			results[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	status := "ready"
+	if !ready {
+		status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{status, results})
+}
+
+// checkEmbedder embeds a fixed probe with the default embedder, unless a
+// lookup embedded successfully within readyEmbedFresh.
+func (h *Handler) checkEmbedder(ctx context.Context) error {
+	if last := h.lastEmbed.Load(); last != 0 && time.Since(time.Unix(0, last)) < readyEmbedFresh {
+		return nil
+	}
+	if _, err := h.embed(ctx, h.embedder, readyProbeText); err != nil {
+		return fmt.Errorf("%s: %w", h.embedder.Model(), err)
+	}
+	return nil
+}
+
+// checkUpstream requests the upstream's model list. Any response short of
+// a server error counts as reachable, since the probe may lack a valid key.
+func (h *Handler) checkUpstream(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.cfg.OpenAIBaseURL+"/v1/models", nil)
+	if err != nil {
+		return err
+	}
+	if h.cfg.OpenAIAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.cfg.OpenAIAPIKey)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	return nil
+}
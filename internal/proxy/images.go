@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// Image key strategies for multimodal requests.
+const (
+	imageKeyIgnore  = "ignore"
+	imageKeyURL     = "url"
+	imageKeyContent = "content"
+)
+
+// maxImageFetchBytes bounds how much of a remote image is read for hashing.
+const maxImageFetchBytes = 20 << 20
+
+// imageKey returns a digest of the images in a request according to the
+// configured strategy, or "" when images do not contribute to the key.
+// The digest is folded into the cache partition rather than the embedded
+// text, so the same text with different images can never match.
+func (h *Handler) imageKey(ctx context.Context, req api.ChatCompletionRequest) string {
+	if h.cfg.ImageKeyStrategy == "" || h.cfg.ImageKeyStrategy == imageKeyIgnore {
+		return ""
+	}
+
+	digest := sha256.New()
+	images := 0
+	for _, msg := range req.Messages {
+		parts, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, part := range parts {
+			url := imageURL(part)
+			if url == "" {
+				continue
+			}
+			images++
+			digest.Write([]byte(h.imageIdentity(ctx, url)))
+			digest.Write([]byte{0})
+		}
+	}
+
+	if images == 0 {
+		return ""
+	}
+	return hex.EncodeToString(digest.Sum(nil))[:16]
+}
+
+// imageIdentity returns the string an image is hashed by. Data URLs carry
+// their content inline; remote URLs are hashed by URL unless fetching is
+// enabled, in which case their content is hashed.
+func (h *Handler) imageIdentity(ctx context.Context, url string) string {
+	if h.cfg.ImageKeyStrategy != imageKeyContent || strings.HasPrefix(url, "data:") || !h.cfg.FetchImages {
+		return url
+	}
+
+	sum, err := h.fetchImageDigest(ctx, url)
+	if err != nil {
+		h.log(ctx).Warn("failed to fetch image for cache key, hashing URL", "error", err)
+		return url
+	}
+	return "sha256:" + sum
+}
+
+// fetchImageDigest downloads a remote image and returns its content hash.
+func (h *Handler) fetchImageDigest(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.LimitReader(resp.Body, maxImageFetchBytes)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// imageURL extracts the URL from an image_url content part.
+func imageURL(part interface{}) string {
+	p, ok := part.(map[string]interface{})
+	if !ok || p["type"] != "image_url" {
+		return ""
+	}
+	switch v := p["image_url"].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		url, _ := v["url"].(string)
+		return url
+	}
+	return ""
+}
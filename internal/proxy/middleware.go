@@ -1,17 +1,65 @@
 package proxy
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/aqstack/mimir/internal/config"
 	"github.com/aqstack/mimir/internal/logger"
 )
 
-// LoggingMiddleware logs incoming requests.
+// requestIDHeader carries the ID correlating the log lines of a request.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds a client-supplied request ID.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID LoggingMiddleware assigned to the request
+// carrying ctx, or "" outside one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the client's X-Request-ID if it is a usable ID, or a
+// new random one. IDs are limited to printable ASCII without spaces so a
+// client cannot forge log fields.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLen {
+		valid := true
+		for i := 0; i < len(id); i++ {
+			if id[i] <= ' ' || id[i] > '~' {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// LoggingMiddleware assigns each request an ID, taken from X-Request-ID
+// when the client sent one, echoes it in the response and stores it in
+// the request context for the handler's log lines. Once the request is
+// served it logs one line with the ID, method, path, status and duration.
 func LoggingMiddleware(log *logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
+			id := requestID(r)
+			w.Header().Set(requestIDHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
 
 			// Wrap response writer to capture status code
 			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
@@ -19,6 +67,7 @@ func LoggingMiddleware(log *logger.Logger) func(http.Handler) http.Handler {
 			next.ServeHTTP(wrapped, r)
 
 			log.Info("request",
+				"request_id", id,
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", wrapped.statusCode,
@@ -29,20 +78,162 @@ func LoggingMiddleware(log *logger.Logger) func(http.Handler) http.Handler {
 	}
 }
 
-// CORSMiddleware adds CORS headers.
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// CORSMiddleware adds CORS headers for browser clients. With "*" among
+// origins any origin is allowed; otherwise only a request whose Origin is
+// listed gets headers, echoing its origin, and others get none. OPTIONS
+// requests are answered here as preflights, with 403 for an origin that
+// is not allowed.
+func CORSMiddleware(origins, methods, headers []string) func(http.Handler) http.Handler {
+	wildcard := false
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			wildcard = true
+		}
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			ok := wildcard || (origin != "" && allowed[strings.ToLower(origin)])
+			if !wildcard {
+				// Responses differ by origin, so caches must not share them
+				w.Header().Add("Vary", "Origin")
+			}
+			if ok {
+				if wildcard {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			}
+
+			if r.Method == http.MethodOptions {
+				if origin != "" && !ok {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BlockMiddleware rejects requests matching any of the block rules with 403.
+// With logOnly set, matches are logged and the request is let through.
+// This is a lightweight guardrail, not a web application firewall.
+func BlockMiddleware(rules []config.BlockRule, logOnly bool, log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(rules) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rule, ok := matchBlockRule(rules, r); ok {
+				log.Warn("request matched block rule",
+					"rule", rule.Kind,
+					"method", r.Method,
+					"path", r.URL.Path,
+					"user_agent", r.UserAgent(),
+					"remote_addr", r.RemoteAddr,
+					"blocked", !logOnly,
+				)
+				if !logOnly {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			// Enforce body limits on requests without a declared length too
+			if !logOnly {
+				for _, rule := range rules {
+					if rule.Kind == config.BlockMaxBody {
+						r.Body = http.MaxBytesReader(w, r.Body, rule.Limit)
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchBlockRule returns the first rule a request violates.
+func matchBlockRule(rules []config.BlockRule, r *http.Request) (config.BlockRule, bool) {
+	for _, rule := range rules {
+		switch rule.Kind {
+		case config.BlockNoUserAgent:
+			if r.UserAgent() == "" {
+				return rule, true
+			}
+		case config.BlockUserAgent:
+			if strings.Contains(strings.ToLower(r.UserAgent()), strings.ToLower(rule.Value)) {
+				return rule, true
+			}
+		case config.BlockPath:
+			if strings.HasPrefix(r.URL.Path, rule.Value) {
+				return rule, true
+			}
+		case config.BlockMaxBody:
+			if r.ContentLength > rule.Limit {
+				return rule, true
+			}
+		}
+	}
+	return config.BlockRule{}, false
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// AuthMiddleware rejects requests without an "Authorization: Bearer"
+// header matching one of tokens with 401. Several tokens may be valid at
+// once so they can be rotated. Probes on /health, /health/ready and /readyz
+// stay open.
+// The header is removed once checked, so the proxy token never reaches the
+// upstream, which is called with the configured API key instead; the
+// request's context records that it passed, for requireAuth.
+func AuthMiddleware(tokens []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(tokens) == 0 {
+			return next
 		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" || r.URL.Path == "/readyz" || r.URL.Path == "/health/ready" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validToken(tokens, r.Header.Get("Authorization")) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mimir"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatedKey{}, true)))
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+type authenticatedKey struct{}
+
+// validToken reports whether an Authorization header carries one of
+// tokens. Every token is compared, in constant time, so the time taken
+// doesn't reveal which one nearly matched.
+func validToken(tokens []string, header string) bool {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return false
+	}
+	presented := []byte(header[len(prefix):])
+	valid := 0
+	for _, token := range tokens {
+		valid |= subtle.ConstantTimeCompare(presented, []byte(token))
+	}
+	return valid == 1
 }
 
 // RecoveryMiddleware recovers from panics.
@@ -71,3 +262,10 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Flush implements http.Flusher so streaming responses work through the middleware.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
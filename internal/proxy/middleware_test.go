@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aqstack/mimir/internal/config"
+	"github.com/aqstack/mimir/internal/logger"
+)
+
+func TestBlockMiddleware(t *testing.T) {
+	rules := []config.BlockRule{
+		{Kind: config.BlockNoUserAgent},
+		{Kind: config.BlockUserAgent, Value: "sqlmap"},
+		{Kind: config.BlockPath, Value: "/.env"},
+		{Kind: config.BlockMaxBody, Limit: 16},
+	}
+	log := logger.New(false, logger.LevelDebug)
+	log.SetOutput(io.Discard)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		path      string
+		userAgent string
+		body      string
+		want      int
+	}{
+		{name: "allowed", path: "/v1/chat/completions", userAgent: "curl/8.0", want: http.StatusOK},
+		{name: "missing user agent", path: "/v1/chat/completions", want: http.StatusForbidden},
+		{name: "blocked user agent", path: "/stats", userAgent: "sqlmap/1.7", want: http.StatusForbidden},
+		{name: "path probe", path: "/.env", userAgent: "curl/8.0", want: http.StatusForbidden},
+		{name: "body too large", path: "/v1/chat/completions", userAgent: "curl/8.0", body: strings.Repeat("x", 32), want: http.StatusForbidden},
+	}
+
+	h := BlockMiddleware(rules, false, log)(ok)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Del("User-Agent")
+			if tt.userAgent != "" {
+				req.Header.Set("User-Agent", tt.userAgent)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	t.Run("undeclared body length", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", io.NopCloser(bytes.NewReader(make([]byte, 32))))
+		req.ContentLength = -1
+		req.Header.Set("User-Agent", "curl/8.0")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected body read to fail past the limit, got %d", rec.Code)
+		}
+	})
+
+	t.Run("log only", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/.env", nil)
+		rec := httptest.NewRecorder()
+		BlockMiddleware(rules, true, log)(ok).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected log-only mode to let the request through, got %d", rec.Code)
+		}
+	})
+}
+
+func TestLoggingMiddlewareRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log := logger.New(true, logger.LevelInfo)
+	log.SetOutput(&buf)
+
+	var seen string
+	h := LoggingMiddleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "generated", header: "", keep: false},
+		{name: "propagated", header: "trace-1234", keep: true},
+		{name: "unprintable replaced", header: "bad id\tstatus=200", keep: false},
+		{name: "too long replaced", header: strings.Repeat("a", maxRequestIDLen+1), keep: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			id := rec.Header().Get("X-Request-ID")
+			if id == "" || id != seen {
+				t.Fatalf("expected the response ID %q to match the context ID %q", id, seen)
+			}
+			if (id == tt.header) != tt.keep {
+				t.Errorf("got request ID %q for header %q", id, tt.header)
+			}
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["request_id"] != id || entry["method"] != "GET" || entry["path"] != "/stats" || entry["status"] != float64(http.StatusTeapot) {
+				t.Errorf("unexpected log line %v", entry)
+			}
+			if _, ok := entry["duration_ms"]; !ok {
+				t.Errorf("expected a duration in the log line, got %v", entry)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	var forwarded string
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("Authorization")
+	})
+	h := AuthMiddleware([]string{"old-token", "new-token"})(ok)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{name: "current token", path: "/v1/chat/completions", header: "Bearer new-token", want: http.StatusOK},
+		{name: "rotated out token still valid", path: "/stats", header: "Bearer old-token", want: http.StatusOK},
+		{name: "lowercase scheme", path: "/stats", header: "bearer new-token", want: http.StatusOK},
+		{name: "missing header", path: "/v1/chat/completions", want: http.StatusUnauthorized},
+		{name: "wrong token", path: "/reports", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "not a bearer token", path: "/stats", header: "Basic new-token", want: http.StatusUnauthorized},
+		{name: "health open", path: "/health", want: http.StatusOK},
+		{name: "readiness open", path: "/readyz", want: http.StatusOK},
+		{name: "health ready open", path: "/health/ready", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+			if forwarded != "" {
+				t.Errorf("expected proxy token removed before forwarding, got %q", forwarded)
+			}
+		})
+	}
+
+	t.Run("disabled without tokens", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		AuthMiddleware(nil)(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected requests let through when auth is off, got %d", rec.Code)
+		}
+	})
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	methods := []string{"GET", "POST", "OPTIONS"}
+	headers := []string{"Content-Type", "Authorization"}
+
+	tests := []struct {
+		name        string
+		origins     []string
+		method      string
+		origin      string
+		wantStatus  int
+		wantAllowed string
+	}{
+		{name: "wildcard", origins: []string{"*"}, method: http.MethodPost, origin: "https://evil.example.com", wantStatus: http.StatusOK, wantAllowed: "*"},
+		{name: "listed origin", origins: []string{"https://app.example.com/"}, method: http.MethodPost, origin: "https://app.example.com", wantStatus: http.StatusOK, wantAllowed: "https://app.example.com"},
+		{name: "unlisted origin", origins: []string{"https://app.example.com"}, method: http.MethodPost, origin: "https://evil.example.com", wantStatus: http.StatusOK},
+		{name: "no origin", origins: []string{"https://app.example.com"}, method: http.MethodPost, wantStatus: http.StatusOK},
+		{name: "preflight from listed origin", origins: []string{"https://app.example.com"}, method: http.MethodOptions, origin: "https://APP.example.com", wantStatus: http.StatusOK, wantAllowed: "https://APP.example.com"},
+		{name: "preflight from unlisted origin", origins: []string{"https://app.example.com"}, method: http.MethodOptions, origin: "https://evil.example.com", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CORSMiddleware(tt.origins, methods, headers)(ok)
+			req := httptest.NewRequest(tt.method, "/v1/chat/completions", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowed {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.wantAllowed, got)
+			}
+			wantMethods := ""
+			if tt.wantAllowed != "" {
+				wantMethods = "GET, POST, OPTIONS"
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != wantMethods {
+				t.Errorf("expected Access-Control-Allow-Methods %q, got %q", wantMethods, got)
+			}
+		})
+	}
+}
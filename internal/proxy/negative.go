@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/embedding"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// negativeCacheable reports whether an upstream status is a client error
+// the same request would get again, such as a content policy rejection.
+// Authentication, permission and rate limit errors depend on the caller or
+// the moment rather than the prompt, so they are never cached, and neither
+// are server errors.
+func negativeCacheable(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// errorContext narrows the partition carried by ctx to a hash of the
+// decoded request body. Cached errors are stored and looked up under it, so
+// a request that differs only in a parameter the embedding ignores never
+// replays another request's error.
+func errorContext(ctx context.Context, decoded []byte) context.Context {
+	sum := sha256.Sum256(decoded)
+	return cache.WithPartition(ctx, cache.PartitionFromContext(ctx)+"@error:"+hex.EncodeToString(sum[:16]))
+}
+
+// peeker is implemented by caches that can look up an entry without
+// counting a hit or miss.
+type peeker interface {
+	Peek(ctx context.Context, embedding []float64, threshold float64) (*api.CacheEntry, float64, bool)
+}
+
+// lookupError finds a cached error under emb in the partition carried by
+// ctx, which callers narrow with errorContext. The request's own lookup has
+// already counted it as a miss, so caches that can are searched without
+// counting another.
+func (h *Handler) lookupError(ctx context.Context, emb []float64, threshold float64) (*api.CacheEntry, float64, bool) {
+	var entry *api.CacheEntry
+	var similarity float64
+	var found bool
+	if p, ok := h.cache.(peeker); ok {
+		entry, similarity, found = p.Peek(ctx, emb, threshold)
+	} else {
+		entry, similarity, found = h.cache.Get(ctx, emb, threshold)
+	}
+	if !found || entry.ErrorStatus == 0 {
+		return nil, 0, false
+	}
+	return entry, similarity, true
+}
+
+// storeError caches an upstream client error for MIMIR_NEGATIVE_CACHE_TTL
+// under emb in the partition carried by ctx, which callers narrow with
+// errorContext. Bodies that are not JSON are not cached.
+func (h *Handler) storeError(ctx context.Context, req api.ChatCompletionRequest, status int, header http.Header, body []byte, emb []float64, embedder embedding.Embedder) {
+	if !json.Valid(body) || h.rejectNorm(emb) {
+		return
+	}
+	now := time.Now()
+	entry := &api.CacheEntry{
+		Request:     req,
+		RawResponse: body,
+		ErrorStatus: status,
+		Embedding:   emb,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(h.cfg.ClampTTL(h.cfg.NegativeCacheTTL)),
+		LastHitAt:   now,
+		Partition:   cache.PartitionFromContext(ctx),
+		EmbedModel:  embedder.Model(),
+		Headers:     h.snapshotHeaders(header),
+	}
+	if err := h.cache.Set(ctx, entry); err != nil {
+		h.log(ctx).Warn("failed to cache error response", "error", err)
+		return
+	}
+	h.log(ctx).Debug("cached error response", "status", status)
+}
+
+// writeCachedError replays a cached upstream client error, marked
+// X-Mimir-Cache: HIT-ERROR.
+func (h *Handler) writeCachedError(ctx context.Context, w http.ResponseWriter, req api.ChatCompletionRequest, entry *api.CacheEntry, similarity float64, cacheKey string, startTime time.Time) {
+	body, err := cache.RawBody(entry)
+	if err != nil {
+		h.log(ctx).Error("failed to read cached error", "error", err)
+		h.writeError(w, "Failed to read cached response", http.StatusInternalServerError)
+		return
+	}
+
+	latencyMs := time.Since(startTime).Milliseconds()
+	h.log(ctx).Info("cache hit on cached error",
+		"status", entry.ErrorStatus,
+		"similarity", fmt.Sprintf("%.4f", similarity),
+		"latency_ms", latencyMs,
+	)
+	h.collector.RecordModelRequest(req.Model, true, similarity, latencyMs, 0, cacheKey)
+	h.collector.AddLog("hit", fmt.Sprintf("[HIT-ERROR] %d, %dms - %s", entry.ErrorStatus, latencyMs, truncatePrompt(cacheKey, 80)))
+
+	replayHeaders(w, entry)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Mimir-Cache", "HIT-ERROR")
+	w.Header().Set("X-Mimir-Similarity", fmt.Sprintf("%.4f", similarity))
+	setAgeHeaders(w, entry, entry.ExpiresAt)
+	w.WriteHeader(entry.ErrorStatus)
+	w.Write(body)
+}
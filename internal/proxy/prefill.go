@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// Prefill caching modes.
+const (
+	prefillCachingStrict = "strict"
+	prefillCachingSkip   = "skip"
+)
+
+// hasPrefill reports whether a request ends with an assistant message,
+// which clients send for the model to continue rather than answer.
+func hasPrefill(req api.ChatCompletionRequest) bool {
+	n := len(req.Messages)
+	return n > 0 && req.Messages[n-1].Role == "assistant"
+}
+
+// prefillKey returns a digest of a request's trailing assistant message, or
+// "" when it has none. It is folded into the cache partition, so a prefill
+// request only matches answers that continued the same prefill, and never
+// a full answer to the same conversation.
+func prefillKey(req api.ChatCompletionRequest) string {
+	if !hasPrefill(req) {
+		return ""
+	}
+	var sb strings.Builder
+	writeContentText(&sb, req.Messages[len(req.Messages)-1].Content)
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// openAIBatchSize is how many preload entries a worker embeds per OpenAI call.
+const openAIBatchSize = 64
+
+// teiBatchSize is how many preload entries a worker embeds per TEI call,
+// the server's default --max-client-batch-size.
+const teiBatchSize = 32
+
+// geminiBatchSize is how many preload entries a worker embeds per Gemini
+// call, the most batchEmbedContents accepts.
+const geminiBatchSize = 100
+
+// PreloadResult summarizes a preload run.
+type PreloadResult struct {
+	Loaded   int           `json:"loaded"`
+	Embedded int           `json:"embedded"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Preload seeds the cache from JSON lines of cache entries, the format
+// written by /cache/dump. Entries that carry an embedding are stored as-is;
+// the rest are embedded by MIMIR_MAX_EMBED_CONCURRENCY workers. Entries
+// that fail to embed are logged and skipped.
+func (h *Handler) Preload(ctx context.Context, r io.Reader) (*PreloadResult, error) {
+	start := time.Now()
+	result := &PreloadResult{}
+	defer func() { result.Duration = time.Since(start) }()
+
+	var pending []*api.CacheEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry api.CacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		if entry.Partition == "" {
+			entry.Partition = h.cfg.DefaultContextVersion
+		}
+		if entry.ExpiresAt.IsZero() {
+			entry.ExpiresAt = time.Now().Add(h.cfg.TTLForModel(entry.Request.Model))
+		} else if ttl := time.Until(entry.ExpiresAt); h.cfg.ClampTTL(ttl) < ttl {
+			h.logger.Warn("preloaded entry expiry exceeds max TTL, clamping", "line", line, "expires_at", entry.ExpiresAt)
+			entry.ExpiresAt = time.Now().Add(h.cfg.MaxTTL)
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+			entry.LastHitAt = entry.CreatedAt
+		}
+
+		if len(entry.Embedding) > 0 {
+			h.storePreloaded(ctx, &entry)
+			result.Loaded++
+			continue
+		}
+		pending = append(pending, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	embedded, failed := h.embedConcurrently(ctx, pending)
+	result.Embedded = embedded
+	result.Loaded += embedded
+	result.Failed = failed
+	return result, nil
+}
+
+// embedConcurrently embeds and stores entries using a bounded worker pool.
+// OpenAI, Azure, TEI and Gemini workers embed in batches; Ollama has no batch API, so its workers
+// embed one entry at a time and rely on concurrency instead.
+func (h *Handler) embedConcurrently(ctx context.Context, entries []*api.CacheEntry) (int, int) {
+	batchSize := 1
+	switch h.cfg.EmbeddingProvider {
+	case "openai", "azure":
+		batchSize = openAIBatchSize
+	case "hf-tei":
+		batchSize = teiBatchSize
+	case "gemini":
+		batchSize = geminiBatchSize
+	}
+	workers := h.cfg.MaxEmbedConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	batches := make(chan []*api.CacheEntry)
+	var embedded, failed, processed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				ok := h.embedBatch(ctx, batch)
+				embedded.Add(int64(ok))
+				failed.Add(int64(len(batch) - ok))
+
+				done := processed.Add(int64(len(batch)))
+				every := int64(h.cfg.WarmupLogEvery)
+				if every > 0 && done/every != (done-int64(len(batch)))/every {
+					h.logger.Info("preload progress", "embedded", done, "total", len(entries))
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < len(entries); i += batchSize {
+		end := i + batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		select {
+		case batches <- entries[i:end]:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	return int(embedded.Load()), int(failed.Load())
+}
+
+// embedBatch embeds and stores a batch, returning how many succeeded. When
+// a batch call fails, entries are retried one by one to isolate the bad ones.
+func (h *Handler) embedBatch(ctx context.Context, batch []*api.CacheEntry) int {
+	texts := make([]string, len(batch))
+	for i, entry := range batch {
+		texts[i] = h.generateCacheKey(entry.Request)
+	}
+
+	if len(batch) > 1 {
+		done := h.collector.TrackEmbed()
+		vectors, err := h.embedder.EmbedBatch(ctx, texts)
+		done()
+		if err == nil && len(vectors) == len(batch) {
+			for i, entry := range batch {
+				entry.Embedding = vectors[i]
+				h.storePreloaded(ctx, entry)
+			}
+			return len(batch)
+		}
+	}
+
+	ok := 0
+	for i, entry := range batch {
+		emb, err := h.embed(ctx, h.embedder, texts[i])
+		if err != nil {
+			h.logger.Warn("skipping preload entry that failed to embed",
+				"prompt", truncatePrompt(texts[i], 80),
+				"error", err,
+			)
+			continue
+		}
+		entry.Embedding = emb
+		h.storePreloaded(ctx, entry)
+		ok++
+	}
+	return ok
+}
+
+func (h *Handler) storePreloaded(ctx context.Context, entry *api.CacheEntry) {
+	if entry.EmbedModel == "" {
+		entry.EmbedModel = h.embedder.Model()
+	}
+	if err := h.cache.Set(ctx, entry); err != nil {
+		h.logger.Warn("failed to store preload entry", "error", err)
+	}
+}
+
+// loadResult reports what a /cache/load call stored.
+type loadResult struct {
+	Loaded     int   `json:"loaded"`
+	Embedded   int   `json:"embedded"`
+	Failed     int   `json:"failed"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// handleCacheLoad stores JSON lines of cache entries posted in the
+// /cache/dump format, as MIMIR_PRELOAD_PATH does at startup, so a dump of
+// one instance can be loaded into another while it runs.
+func (h *Handler) handleCacheLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.cfg.CacheReadOnly {
+		h.writeError(w, "Cache is read-only", http.StatusConflict)
+		return
+	}
+
+	result, err := h.Preload(r.Context(), r.Body)
+	if err != nil {
+		h.writeError(w, fmt.Sprintf("Invalid cache entries after %d loaded: %v", result.Loaded, err), http.StatusBadRequest)
+		return
+	}
+	h.logger.Info("loaded cache entries",
+		"loaded", result.Loaded,
+		"embedded", result.Embedded,
+		"failed", result.Failed,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loadResult{
+		Loaded:     result.Loaded,
+		Embedded:   result.Embedded,
+		Failed:     result.Failed,
+		DurationMs: result.Duration.Milliseconds(),
+	})
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Request priorities, chosen per request with X-Mimir-Priority.
+const (
+	priorityHigh = "high"
+	priorityLow  = "low"
+)
+
+// upstreamGate bounds concurrent upstream requests. When every slot is
+// taken, requests queue by priority: a freed slot goes to the oldest
+// high-priority waiter, and to low-priority waiters only when no
+// high-priority one is queued.
+type upstreamGate struct {
+	mu   sync.Mutex
+	free int
+	high []chan struct{}
+	low  []chan struct{}
+
+	// onWait, when set, is called as a request starts queueing and returns
+	// a function called when it stops
+	onWait func() (done func())
+}
+
+func newUpstreamGate(slots int) *upstreamGate {
+	return &upstreamGate{free: slots}
+}
+
+// acquire takes a slot, queueing at the given priority while none is free.
+// A request whose context ends leaves the queue and gets the context's
+// error.
+func (g *upstreamGate) acquire(ctx context.Context, high bool) error {
+	g.mu.Lock()
+	if g.free > 0 {
+		g.free--
+		g.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if high {
+		g.high = append(g.high, ready)
+	} else {
+		g.low = append(g.low, ready)
+	}
+	g.mu.Unlock()
+
+	if g.onWait != nil {
+		defer g.onWait()()
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		queued := g.remove(ready)
+		g.mu.Unlock()
+		if !queued {
+			// The slot was handed over as the context ended
+			g.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, handing it to the next waiter if any.
+func (g *upstreamGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case len(g.high) > 0:
+		close(g.high[0])
+		g.high = g.high[1:]
+	case len(g.low) > 0:
+		close(g.low[0])
+		g.low = g.low[1:]
+	default:
+		g.free++
+	}
+}
+
+// remove drops ready from the queues and reports whether it was queued.
+// Caller must hold the lock.
+func (g *upstreamGate) remove(ready chan struct{}) bool {
+	for _, queue := range []*[]chan struct{}{&g.high, &g.low} {
+		for i, ch := range *queue {
+			if ch == ready {
+				*queue = append((*queue)[:i], (*queue)[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// highPriority reports whether r is queued ahead of low-priority requests,
+// from its X-Mimir-Priority header or else DefaultPriority.
+func (h *Handler) highPriority(r *http.Request) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("X-Mimir-Priority"))) {
+	case priorityHigh:
+		return true
+	case priorityLow:
+		return false
+	}
+	return h.cfg.DefaultPriority == priorityHigh
+}
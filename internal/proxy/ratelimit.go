@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryWait caps how long a request waits before retrying. Longer
+// Retry-After values are relayed to the client instead.
+const maxRetryWait = 30 * time.Second
+
+// rateLimited reports whether the upstream rejected a request as
+// throttled: a 429, or a 503 that says when to retry. A plain 503 means
+// the upstream is down and is left to failover.
+func rateLimited(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// upstreamDown reports whether an upstream call failed in a way that
+// warrants failover or the canned fallback reply. Rate-limited responses
+// are relayed instead, so clients see the real status and Retry-After.
+func upstreamDown(resp *http.Response, err error) bool {
+	return err != nil || (resp.StatusCode >= 500 && !rateLimited(resp))
+}
+
+// retryDelay returns how long to wait before retry attempt n (from zero):
+// the Retry-After header when present, and otherwise backoff doubled after
+// each attempt. It returns false when the wait would exceed maxRetryWait.
+func retryDelay(header http.Header, backoff time.Duration, n int) (time.Duration, bool) {
+	delay := backoff << n
+	if after := header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil {
+			delay = time.Duration(secs) * time.Second
+		} else if at, err := http.ParseTime(after); err == nil {
+			delay = time.Until(at)
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, delay <= maxRetryWait
+}
+
+// retryRateLimited sends a request with send, retrying up to
+// UpstreamMaxRetries times while the upstream is rateLimited. The last
+// response is returned as is, so its status and Retry-After reach the client.
+func (h *Handler) retryRateLimited(ctx context.Context, send func() (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, respBody, err := send()
+		if err != nil || !rateLimited(resp) {
+			return resp, respBody, err
+		}
+
+		h.log(ctx).Warn("upstream rate limited",
+			"status", resp.StatusCode,
+			"retry_after", resp.Header.Get("Retry-After"),
+			"attempt", attempt+1,
+		)
+		h.collector.RecordRateLimited()
+		h.collector.AddLog("warn", fmt.Sprintf("[RATE LIMITED] upstream returned %d", resp.StatusCode))
+
+		delay, ok := retryDelay(resp.Header, h.cfg.UpstreamRetryBackoff, attempt)
+		if attempt >= h.cfg.UpstreamMaxRetries || !ok {
+			return resp, respBody, nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, respBody, nil
+		case <-timer.C:
+		}
+	}
+}
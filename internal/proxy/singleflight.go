@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamResult is the outcome of forwarding a cache miss: the upstream
+// response and, when it was cached, the ID of the stored entry.
+type upstreamResult struct {
+	resp    *http.Response
+	body    []byte
+	entryID string
+	err     error
+}
+
+// flightCall is an upstream request in progress; done is closed once res
+// is set.
+type flightCall struct {
+	done chan struct{}
+	res  upstreamResult
+}
+
+// flightGroup coalesces concurrent cache misses for the same prompt into
+// one upstream request. The zero value is ready to use.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+
+	// onWait, when set, is called as a request starts waiting on another's
+	// call and returns a function called when it stops
+	onWait func() (done func())
+}
+
+// do runs fn unless a call for key is already in flight, in which case it
+// waits for that call and returns its result, errors included. shared
+// reports whether the result came from another request. fn runs on a
+// context detached from ctx, keeping its values but not its cancellation,
+// since requests waiting on the call depend on it finishing. Any caller,
+// the one that started the call included, whose context ends stops waiting
+// and gets the context's error while the call carries on for the others.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) upstreamResult) (res upstreamResult, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		if g.onWait != nil {
+			defer g.onWait()()
+		}
+		return c.wait(ctx), true
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// fn stores the response before the call is released, so requests
+	// arriving after this point hit the cache instead
+	go func() {
+		defer func() {
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+		c.res = fn(detachedContext{ctx})
+	}()
+	return c.wait(ctx), false
+}
+
+// wait returns the call's result once it is done, or the error of ctx if
+// that ends first.
+func (c *flightCall) wait(ctx context.Context) upstreamResult {
+	select {
+	case <-c.done:
+		return c.res
+	case <-ctx.Done():
+		return upstreamResult{err: ctx.Err()}
+	}
+}
+
+// detachedContext carries the values of its parent, such as the request ID
+// and cache partition, without its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
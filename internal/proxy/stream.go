@@ -0,0 +1,340 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// handleStream forwards a streaming chat completion and returns it
+// reassembled into a regular completion when the upstream succeeded, or nil
+// along with the upstream failure.
+// With MIMIR_STREAM_INCLUDE_USAGE set, usage is requested from the upstream
+// even when the client did not ask for it, so streamed completions can be
+// accounted for; the extra usage chunk is removed before the client sees it.
+// Headers in overrides replace those copied from the upstream response.
+func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, req api.ChatCompletionRequest, body, decoded []byte, overrides http.Header) (*api.ChatCompletionResponse, error) {
+	clientWantsUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+
+	upstreamBody := body
+	injected := false
+	// Compressed bodies are forwarded untouched, so they cannot be rewritten
+	if h.cfg.StreamIncludeUsage && !clientWantsUsage && bytes.Equal(body, decoded) {
+		if rewritten, err := withIncludeUsage(body); err == nil {
+			upstreamBody = rewritten
+			injected = true
+		}
+	}
+
+	resp, respBody, err := h.doUpstreamRequest(r.Context(), r, upstreamBody)
+	if err != nil {
+		h.writeError(w, "Upstream request failed", http.StatusBadGateway)
+		return nil, upstreamFailure(resp, err)
+	}
+
+	var assembled *api.ChatCompletionResponse
+	if resp.StatusCode == http.StatusOK {
+		if assembled, err = assembleStream(respBody); err == nil && assembled.Usage.TotalTokens > 0 {
+			h.log(r.Context()).Debug("streamed completion usage",
+				"prompt_tokens", assembled.Usage.PromptTokens,
+				"completion_tokens", assembled.Usage.CompletionTokens,
+				"total_tokens", assembled.Usage.TotalTokens,
+			)
+		}
+		if injected {
+			respBody = stripUsageChunks(respBody)
+		}
+	}
+
+	h.copyUpstreamHeaders(w, resp.Header)
+	for k, v := range overrides {
+		w.Header()[k] = v
+	}
+	if injected {
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+	return assembled, upstreamFailure(resp, nil)
+}
+
+// streamPace slows down a synthetic stream: delay between content chunks
+// of words words each. The zero value sends every word at once.
+type streamPace struct {
+	delay time.Duration
+	words int
+}
+
+// streamPace returns the pacing configured by MIMIR_STREAM_PACE_MS and
+// MIMIR_STREAM_PACE_TOKENS.
+func (h *Handler) streamPace() streamPace {
+	return streamPace{delay: h.cfg.StreamPace, words: h.cfg.StreamPaceTokens}
+}
+
+// writeStreamReplay writes a cached completion as a synthetic SSE stream:
+// per choice a role chunk, the content in chunks of pace.words words (one
+// when unset), a chunk per tool call and a finish chunk, then a usage chunk when the client asked
+// for one, and [DONE]. With pace.delay set, content chunks are spaced out
+// like a live stream; the replay stops without [DONE] once ctx is done,
+// which happens when the client goes away.
+func writeStreamReplay(ctx context.Context, w http.ResponseWriter, resp api.ChatCompletionResponse, includeUsage bool, pace streamPace) {
+	flusher, _ := w.(http.Flusher)
+	send := func(choices []api.ChunkChoice, usage *api.Usage) {
+		data, _ := json.Marshal(api.ChatCompletionChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: choices,
+			Usage:   usage,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	words := pace.words
+	if words < 1 {
+		words = 1
+	}
+	wait := func() bool {
+		if pace.delay <= 0 {
+			return true
+		}
+		timer := time.NewTimer(pace.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	sent := false
+	for _, c := range resp.Choices {
+		send([]api.ChunkChoice{{Index: c.Index, Delta: api.ChunkDelta{Role: c.Message.Role}}}, nil)
+		content, _ := c.Message.Content.(string)
+		var chunk strings.Builder
+		n := 0
+		flush := func() bool {
+			if chunk.Len() == 0 {
+				return true
+			}
+			if sent && !wait() {
+				return false
+			}
+			send([]api.ChunkChoice{{Index: c.Index, Delta: api.ChunkDelta{Content: chunk.String()}}}, nil)
+			chunk.Reset()
+			n = 0
+			sent = true
+			return true
+		}
+		for _, word := range strings.SplitAfter(content, " ") {
+			if word == "" {
+				continue
+			}
+			chunk.WriteString(word)
+			if n++; n == words && !flush() {
+				return
+			}
+		}
+		if !flush() {
+			return
+		}
+		for i, call := range c.Message.ToolCalls {
+			send([]api.ChunkChoice{{Index: c.Index, Delta: api.ChunkDelta{ToolCalls: []api.ChunkToolCall{{
+				Index:    i,
+				ID:       call.ID,
+				Type:     call.Type,
+				Function: call.Function,
+			}}}}}, nil)
+		}
+		var finish *string
+		if c.FinishReason != "" {
+			reason := c.FinishReason
+			finish = &reason
+		}
+		send([]api.ChunkChoice{{Index: c.Index, FinishReason: finish}}, nil)
+	}
+	if includeUsage {
+		usage := resp.Usage
+		send([]api.ChunkChoice{}, &usage)
+	}
+	io.WriteString(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// withIncludeUsage sets stream_options.include_usage on a raw request body,
+// preserving every other field.
+func withIncludeUsage(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	var opts map[string]json.RawMessage
+	if raw, ok := fields["stream_options"]; ok {
+		json.Unmarshal(raw, &opts)
+	}
+	if opts == nil {
+		opts = make(map[string]json.RawMessage)
+	}
+	opts["include_usage"] = json.RawMessage("true")
+	fields["stream_options"], _ = json.Marshal(opts)
+	return json.Marshal(fields)
+}
+
+// streamEvents calls fn with the payload of each "data:" line of an SSE body.
+func streamEvents(body []byte, fn func(data string)) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			fn(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		}
+	}
+}
+
+// assembleStream reassembles a buffered SSE chat completion stream into a
+// regular completion, including tool calls merged from their deltas and
+// usage from the final chunk when present. A stream that was cut short,
+// ending without [DONE], carrying an error event or leaving a choice
+// without a finish reason, is an error, so it is never cached.
+func assembleStream(body []byte) (*api.ChatCompletionResponse, error) {
+	resp := &api.ChatCompletionResponse{Object: "chat.completion"}
+	contents := make(map[int]*strings.Builder)
+	choices := make(map[int]*api.Choice)
+	toolCalls := make(map[int]map[int]*api.ToolCall)
+	chunks := 0
+	done, failed := false, false
+
+	streamEvents(body, func(data string) {
+		if data == "[DONE]" {
+			done = true
+			return
+		}
+		var chunk struct {
+			api.ChatCompletionChunk
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return
+		}
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			failed = true
+			return
+		}
+		chunks++
+		if resp.ID == "" {
+			resp.ID = chunk.ID
+			resp.Created = chunk.Created
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			choice, ok := choices[c.Index]
+			if !ok {
+				choice = &api.Choice{Index: c.Index, Message: api.Message{Role: "assistant"}}
+				choices[c.Index] = choice
+				contents[c.Index] = &strings.Builder{}
+			}
+			if c.Delta.Role != "" {
+				choice.Message.Role = c.Delta.Role
+			}
+			contents[c.Index].WriteString(c.Delta.Content)
+			for _, d := range c.Delta.ToolCalls {
+				calls := toolCalls[c.Index]
+				if calls == nil {
+					calls = make(map[int]*api.ToolCall)
+					toolCalls[c.Index] = calls
+				}
+				call, ok := calls[d.Index]
+				if !ok {
+					call = &api.ToolCall{}
+					calls[d.Index] = call
+				}
+				if d.ID != "" {
+					call.ID = d.ID
+				}
+				if d.Type != "" {
+					call.Type = d.Type
+				}
+				if d.Function.Name != "" {
+					call.Function.Name = d.Function.Name
+				}
+				call.Function.Arguments += d.Function.Arguments
+			}
+			if c.FinishReason != nil {
+				choice.FinishReason = *c.FinishReason
+			}
+		}
+	})
+
+	switch {
+	case failed:
+		return nil, errors.New("stream carried an error event")
+	case chunks == 0:
+		return nil, errors.New("no stream chunks found")
+	case !done:
+		return nil, errors.New("stream ended without [DONE]")
+	}
+
+	indexes := make([]int, 0, len(choices))
+	for i := range choices {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		choice := choices[i]
+		if choice.FinishReason == "" {
+			return nil, fmt.Errorf("stream ended without a finish reason for choice %d", i)
+		}
+		calls := make([]int, 0, len(toolCalls[i]))
+		for j := range toolCalls[i] {
+			calls = append(calls, j)
+		}
+		sort.Ints(calls)
+		for _, j := range calls {
+			choice.Message.ToolCalls = append(choice.Message.ToolCalls, *toolCalls[i][j])
+		}
+		// Like a regular completion, a tool call answer has null content
+		if content := contents[i].String(); content != "" || len(calls) == 0 {
+			choice.Message.Content = content
+		}
+		resp.Choices = append(resp.Choices, *choice)
+	}
+
+	return resp, nil
+}
+
+// stripUsageChunks removes usage-only chunks (no choices, usage set) from an
+// SSE body, leaving every other event untouched.
+func stripUsageChunks(body []byte) []byte {
+	var out bytes.Buffer
+	for _, event := range bytes.SplitAfter(body, []byte("\n\n")) {
+		trimmed := bytes.TrimSpace(event)
+		if bytes.HasPrefix(trimmed, []byte("data:")) {
+			var chunk api.ChatCompletionChunk
+			if err := json.Unmarshal(bytes.TrimSpace(trimmed[len("data:"):]), &chunk); err == nil && len(chunk.Choices) == 0 && chunk.Usage != nil {
+				continue
+			}
+		}
+		out.Write(event)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aqstack/mimir/internal/config"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+const testStream = `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"content":"Par"},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"content":"is"},"finish_reason":"stop"}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14}}
+
+data: [DONE]
+
+`
+
+func TestAssembleStream(t *testing.T) {
+	resp, err := assembleStream([]byte(testStream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ID != "chatcmpl-1" || resp.Model != "gpt-4" {
+		t.Errorf("unexpected metadata: %+v", resp)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Paris" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected choices: %+v", resp.Choices)
+	}
+	if resp.Usage.TotalTokens != 14 || resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 2 {
+		t.Errorf("expected usage from the final chunk, got %+v", resp.Usage)
+	}
+
+	if _, err := assembleStream([]byte("data: [DONE]\n\n")); err == nil {
+		t.Error("expected error for a stream without chunks")
+	}
+}
+
+const testToolStream = `data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`
+
+func TestAssembleStreamToolCalls(t *testing.T) {
+	resp, err := assembleStream([]byte(testToolStream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := resp.Choices[0].Message
+	if msg.Content != nil || len(msg.ToolCalls) != 1 || resp.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("expected one tool call and no content, got %+v", resp.Choices[0])
+	}
+	call := msg.ToolCalls[0]
+	if call.ID != "call_1" || call.Type != "function" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call %+v", call)
+	}
+
+	// The replay carries the tool call, so it reassembles to the same answer
+	rec := httptest.NewRecorder()
+	writeStreamReplay(context.Background(), rec, *resp, false, streamPace{})
+	replayed, err := assembleStream(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replayed.Choices[0].Message.ToolCalls) != 1 || replayed.Choices[0].Message.ToolCalls[0] != call {
+		t.Errorf("expected the tool call replayed, got %+v", replayed.Choices[0].Message)
+	}
+}
+
+func TestAssembleStreamTruncated(t *testing.T) {
+	complete := strings.TrimSuffix(testStream, "data: [DONE]\n\n")
+	tests := map[string]string{
+		"no done":          complete,
+		"no finish reason": strings.Replace(testStream, `"finish_reason":"stop"`, `"finish_reason":null`, 1),
+		"error event":      complete + "data: {\"error\":{\"message\":\"overloaded\"}}\n\ndata: [DONE]\n\n",
+	}
+	for name, stream := range tests {
+		if resp, err := assembleStream([]byte(stream)); err == nil {
+			t.Errorf("%s: expected an error, got %+v", name, resp)
+		}
+	}
+}
+
+func TestHandleStreamTruncatedNotCached(t *testing.T) {
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, strings.TrimSuffix(testStream, "data: [DONE]\n\n"))
+	}))
+	t.Cleanup(upstream.Close)
+	h := newTestHandler(t, upstream, nil)
+
+	body, _ := json.Marshal(api.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []api.Message{{Role: "user", Content: "capital of France?"}},
+		Stream:   true,
+	})
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+		if got := rec.Header().Get("X-Mimir-Cache"); got != "MISS" {
+			t.Errorf("request %d: expected MISS, got %q", i+1, got)
+		}
+	}
+	if upstream.calls.Load() != 2 || h.cache.Size(context.Background()) != 0 {
+		t.Errorf("expected the truncated stream not to be cached, got %d calls", upstream.calls.Load())
+	}
+}
+
+func TestStripUsageChunks(t *testing.T) {
+	stripped := string(stripUsageChunks([]byte(testStream)))
+	if strings.Contains(stripped, `"usage"`) {
+		t.Error("expected the usage chunk to be removed")
+	}
+	if !strings.Contains(stripped, `"content":"is"`) || !strings.HasSuffix(stripped, "data: [DONE]\n\n") {
+		t.Errorf("expected other events to be kept, got %q", stripped)
+	}
+}
+
+func TestHandleStreamIncludeUsage(t *testing.T) {
+	var upstreamReq map[string]interface{}
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamReq = nil
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, testStream)
+	}))
+	t.Cleanup(upstream.Close)
+
+	h := newTestHandler(t, upstream, func(cfg *config.Config) {
+		cfg.StreamIncludeUsage = true
+		cfg.CacheStreams = false
+	})
+
+	send := func(opts *api.StreamOptions) string {
+		body, _ := json.Marshal(api.ChatCompletionRequest{
+			Model:         "gpt-4",
+			Messages:      []api.Message{{Role: "user", Content: "capital of France?"}},
+			Stream:        true,
+			StreamOptions: opts,
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+		return rec.Body.String()
+	}
+
+	// Usage is requested upstream but hidden from a client that did not ask
+	out := send(nil)
+	opts, _ := upstreamReq["stream_options"].(map[string]interface{})
+	if opts["include_usage"] != true {
+		t.Errorf("expected include_usage to be requested upstream, got %v", upstreamReq["stream_options"])
+	}
+	if strings.Contains(out, `"usage"`) {
+		t.Error("expected the injected usage chunk to be stripped")
+	}
+
+	// Clients that asked for usage still get it
+	out = send(&api.StreamOptions{IncludeUsage: true})
+	if !strings.Contains(out, `"usage"`) {
+		t.Error("expected the usage chunk to be passed through")
+	}
+}
+
+func TestHandleStreamCache(t *testing.T) {
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, testStream)
+	}))
+	t.Cleanup(upstream.Close)
+	h := newTestHandler(t, upstream, nil)
+
+	send := func(stream bool, opts *api.StreamOptions) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(api.ChatCompletionRequest{
+			Model:         "gpt-4",
+			Messages:      []api.Message{{Role: "user", Content: "capital of France?"}},
+			Stream:        stream,
+			StreamOptions: opts,
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := send(true, nil); rec.Header().Get("X-Mimir-Cache") != "MISS" {
+		t.Fatalf("expected a miss, got %q", rec.Header().Get("X-Mimir-Cache"))
+	}
+
+	// The hit is replayed as a stream that reassembles to the original
+	rec := send(true, &api.StreamOptions{IncludeUsage: true})
+	if rec.Header().Get("X-Mimir-Cache") != "HIT" {
+		t.Fatalf("expected a hit, got %q", rec.Header().Get("X-Mimir-Cache"))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", ct)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+		t.Error("expected the replay to end with [DONE]")
+	}
+	replayed, err := assembleStream(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replayed.Choices[0].Message.Content != "Paris" || replayed.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected replayed choices: %+v", replayed.Choices)
+	}
+	if replayed.Usage.TotalTokens != 14 {
+		t.Errorf("expected usage captured from the final chunk, got %+v", replayed.Usage)
+	}
+
+	// Usage is only replayed to clients that asked for it
+	if rec := send(true, nil); strings.Contains(rec.Body.String(), `"usage"`) {
+		t.Error("expected no usage chunk without include_usage")
+	}
+
+	// Non-streaming clients get the cached answer as JSON
+	rec = send(false, nil)
+	var resp api.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Choices[0].Message.Content != "Paris" {
+		t.Errorf("expected a JSON hit, got %q", rec.Body.String())
+	}
+	if upstream.calls.Load() != 1 {
+		t.Errorf("expected one upstream call, got %d", upstream.calls.Load())
+	}
+}
+
+func TestWriteStreamReplay(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeStreamReplay(context.Background(), rec, api.ChatCompletionResponse{
+		ID:      "chatcmpl-cached",
+		Model:   "gpt-4",
+		Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: "The capital is Paris."}, FinishReason: "stop"}},
+	}, false, streamPace{})
+
+	var chunks int
+	streamEvents(rec.Body.Bytes(), func(data string) { chunks++ })
+	// role, four words, finish and [DONE]
+	if chunks != 7 {
+		t.Errorf("expected 7 events, got %d: %q", chunks, rec.Body.String())
+	}
+}
+
+func TestWriteStreamReplayPaced(t *testing.T) {
+	resp := api.ChatCompletionResponse{
+		ID:      "chatcmpl-cached",
+		Model:   "gpt-4",
+		Choices: []api.Choice{{Message: api.Message{Role: "assistant", Content: "one two three four five"}, FinishReason: "stop"}},
+	}
+
+	t.Run("groups words and waits between chunks", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		writeStreamReplay(context.Background(), rec, resp, false, streamPace{delay: 20 * time.Millisecond, words: 2})
+		elapsed := time.Since(start)
+
+		var content []string
+		streamEvents(rec.Body.Bytes(), func(data string) {
+			var chunk api.ChatCompletionChunk
+			if json.Unmarshal([]byte(data), &chunk) == nil && len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+				content = append(content, chunk.Choices[0].Delta.Content)
+			}
+		})
+		if strings.Join(content, "|") != "one two |three four |five" {
+			t.Errorf("expected three chunks of two words, got %q", content)
+		}
+		// Two pauses, between the three content chunks
+		if elapsed < 40*time.Millisecond {
+			t.Errorf("expected the replay to take at least 40ms, took %v", elapsed)
+		}
+		if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+			t.Errorf("expected the stream to finish, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("stops when the client goes away", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(30*time.Millisecond, cancel)
+		start := time.Now()
+		writeStreamReplay(ctx, rec, resp, false, streamPace{delay: time.Hour, words: 1})
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected pacing to stop on disconnect, took %v", elapsed)
+		}
+		if strings.Contains(rec.Body.String(), "[DONE]") || strings.Contains(rec.Body.String(), "two") {
+			t.Errorf("expected the replay to stop after the first word, got %q", rec.Body.String())
+		}
+	})
+}
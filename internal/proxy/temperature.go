@@ -0,0 +1,20 @@
+package proxy
+
+import "strconv"
+
+// temperatureCacheable reports whether a request sampling at temperature
+// may be cached. A request that sets no temperature always may; one above
+// MIMIR_MAX_CACHE_TEMPERATURE asks for varied answers, so it never is.
+func (h *Handler) temperatureCacheable(temperature *float64) bool {
+	return temperature == nil || *temperature <= h.cfg.MaxCacheTemperature
+}
+
+// temperatureKey returns the request's temperature for the cache partition
+// when MIMIR_TEMPERATURE_KEY is set, or "" when it is not or the request
+// sets none.
+func (h *Handler) temperatureKey(temperature *float64) string {
+	if !h.cfg.TemperatureKey || temperature == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*temperature, 'g', -1, 64)
+}
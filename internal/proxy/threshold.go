@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+)
+
+// similarityThreshold returns the default similarity threshold in effect,
+// which starts as MIMIR_SIMILARITY_THRESHOLD and may be changed at runtime
+// through /config/threshold.
+func (h *Handler) similarityThreshold() float64 {
+	return math.Float64frombits(h.threshold.Load())
+}
+
+// setSimilarityThreshold changes the default similarity threshold.
+func (h *Handler) setSimilarityThreshold(t float64) {
+	h.threshold.Store(math.Float64bits(t))
+}
+
+// thresholdFor returns the similarity threshold for model: its
+// MIMIR_MODEL_THRESHOLDS override, or the default threshold in effect.
+func (h *Handler) thresholdFor(model string) float64 {
+	if threshold, ok := h.cfg.ModelThresholds[model]; ok {
+		return threshold
+	}
+	return h.similarityThreshold()
+}
+
+// thresholdState is the body of /config/threshold requests and responses.
+type thresholdState struct {
+	Threshold  *float64 `json:"threshold"`
+	Configured float64  `json:"configured"`
+}
+
+// handleThreshold reports the default similarity threshold on GET and
+// changes it on POST, which requires an auth token, so it can be tuned
+// without a restart. The change lasts until the process restarts;
+// per-model overrides are unaffected.
+func (h *Handler) handleThreshold(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !h.requireAuth(w, r) {
+			return
+		}
+		var req thresholdState
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Threshold == nil {
+			h.writeError(w, "Invalid request body", bodyErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		if err := h.cfg.CheckThreshold(*req.Threshold); err != nil {
+			h.writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := h.similarityThreshold()
+		h.setSimilarityThreshold(*req.Threshold)
+		h.log(r.Context()).Info("similarity threshold changed", "from", previous, "to", *req.Threshold)
+	default:
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	threshold := h.similarityThreshold()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thresholdState{Threshold: &threshold, Configured: h.cfg.SimilarityThreshold})
+}
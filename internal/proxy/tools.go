@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// Tool caching modes.
+const (
+	toolCachingStrict = "strict"
+	toolCachingSkip   = "skip"
+)
+
+// usesTools reports whether a request offers tools or functions or
+// constrains how they are called.
+func usesTools(req api.ChatCompletionRequest) bool {
+	return len(req.Tools) > 0 || req.ToolChoice != nil || req.ParallelToolCalls != nil ||
+		len(req.Functions) > 0 || req.FunctionCall != nil
+}
+
+// toolKey returns a digest of a request's tool definitions, tool_choice and
+// parallel_tool_calls (and their legacy function equivalents), or "" when
+// it uses none. Like imageKey, it is folded into the cache partition, so a
+// request only matches answers generated with identical tool settings.
+func toolKey(req api.ChatCompletionRequest) string {
+	if !usesTools(req) {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		Tools             []api.Tool     `json:"tools,omitempty"`
+		ToolChoice        interface{}    `json:"tool_choice,omitempty"`
+		ParallelToolCalls *bool          `json:"parallel_tool_calls,omitempty"`
+		Functions         []api.Function `json:"functions,omitempty"`
+		FunctionCall      interface{}    `json:"function_call,omitempty"`
+	}{req.Tools, req.ToolChoice, req.ParallelToolCalls, req.Functions, req.FunctionCall})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
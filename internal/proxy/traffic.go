@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aqstack/mimir/internal/reports"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+const maxTrafficCount = 1000
+
+// trafficRequest configures a server-side traffic generation run.
+type trafficRequest struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Delay int    `json:"delay"` // milliseconds between requests
+	Model string `json:"model"`
+}
+
+// trafficEvent is streamed to the client after each generated request.
+type trafficEvent struct {
+	Index     int    `json:"index"`
+	Count     int    `json:"count"`
+	Prompt    string `json:"prompt"`
+	Cache     string `json:"cache"`
+	Status    int    `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// trafficSummary is the final event of a traffic generation run.
+type trafficSummary struct {
+	Done    bool    `json:"done"`
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// handleGenerateTraffic runs a preset prompt set against the chat completion
+// handler in-process, streaming progress back as server-sent events. Misses
+// are paid upstream calls, so it requires the admin token.
+func (h *Handler) handleGenerateTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	var tr trafficRequest
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	prompts, ok := reports.TrafficPresets[tr.Type]
+	if !ok {
+		h.writeError(w, fmt.Sprintf("Unknown traffic type %q", tr.Type), http.StatusBadRequest)
+		return
+	}
+	if tr.Count < 1 || tr.Count > maxTrafficCount {
+		h.writeError(w, fmt.Sprintf("count must be between 1 and %d", maxTrafficCount), http.StatusBadRequest)
+		return
+	}
+	if tr.Delay < 0 {
+		tr.Delay = 0
+	}
+	if tr.Model == "" {
+		tr.Model = "gpt-3.5-turbo"
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.writeError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	hits, misses := 0, 0
+
+	for i := 0; i < tr.Count; i++ {
+		prompt := prompts[i%len(prompts)]
+
+		body, _ := json.Marshal(api.ChatCompletionRequest{
+			Model:    tr.Model,
+			Messages: []api.Message{{Role: "user", Content: prompt}},
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		start := time.Now()
+		cw := newCaptureWriter()
+		h.handleChatCompletions(cw, req)
+
+		cacheStatus := cw.Header().Get("X-Mimir-Cache")
+		if cacheStatus == "HIT" {
+			hits++
+		} else {
+			misses++
+			if cacheStatus == "" {
+				cacheStatus = "ERROR"
+			}
+		}
+
+		writeSSE(w, trafficEvent{
+			Index:     i + 1,
+			Count:     tr.Count,
+			Prompt:    prompt,
+			Cache:     cacheStatus,
+			Status:    cw.status,
+			LatencyMs: time.Since(start).Milliseconds(),
+		})
+		flusher.Flush()
+
+		if tr.Delay > 0 && i < tr.Count-1 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(tr.Delay) * time.Millisecond):
+			}
+		}
+	}
+
+	writeSSE(w, trafficSummary{
+		Done:    true,
+		Hits:    hits,
+		Misses:  misses,
+		HitRate: float64(hits) / float64(tr.Count),
+	})
+	flusher.Flush()
+}
+
+// writeSSE writes v as a single server-sent event data line.
+func writeSSE(w http.ResponseWriter, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// captureWriter is an in-memory http.ResponseWriter for in-process requests.
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newCaptureWriter() *captureWriter {
+	return &captureWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (c *captureWriter) Header() http.Header         { return c.header }
+func (c *captureWriter) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *captureWriter) WriteHeader(code int)        { c.status = code }
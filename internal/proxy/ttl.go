@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// requestTTL returns how long a response to r may stay cached: the
+// X-Mimir-TTL header when it holds a Go duration, capped at MaxTTL, and
+// otherwise the TTL configured for model. It returns false when the header
+// is "0" or "no-store", so the response must not be cached at all. Invalid
+// values are logged and ignored.
+func (h *Handler) requestTTL(r *http.Request, model string) (time.Duration, bool) {
+	value := strings.TrimSpace(r.Header.Get("X-Mimir-TTL"))
+	if value == "" {
+		return h.cfg.TTLForModel(model), true
+	}
+	if strings.EqualFold(value, "no-store") {
+		return 0, false
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		h.log(r.Context()).Warn("ignoring invalid X-Mimir-TTL header", "value", value)
+		return h.cfg.TTLForModel(model), true
+	}
+	if ttl == 0 {
+		return 0, false
+	}
+	return h.cfg.ClampTTL(ttl), true
+}
+
+// toucher is implemented by caches that can slide an entry's expiry.
+type toucher interface {
+	Touch(entry *api.CacheEntry, ttl, maxAge time.Duration) time.Time
+}
+
+// slideTTL extends a hit entry's expiry to ttl from now when sliding TTLs
+// are enabled, so popular answers outlive their TTL, but never past
+// MIMIR_CACHE_MAX_AGE after they were stored. It returns the entry's
+// expiry, slid or not.
+func (h *Handler) slideTTL(entry *api.CacheEntry, ttl time.Duration) time.Time {
+	if !h.cfg.CacheSlidingTTL {
+		return entry.ExpiresAt
+	}
+	if t, ok := h.cache.(toucher); ok {
+		return t.Touch(entry, ttl, h.cfg.CacheMaxAge)
+	}
+	return entry.ExpiresAt
+}
+
+// setAgeHeaders reports how old a served entry is, in whole seconds since
+// it was stored, and when it expires. Both are taken as the response is
+// written, so a hit on an old entry reports its age now, not when stored.
+func setAgeHeaders(w http.ResponseWriter, entry *api.CacheEntry, expires time.Time) {
+	age := time.Since(entry.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	w.Header().Set("X-Mimir-Cache-Age", strconv.FormatInt(int64(age.Seconds()), 10))
+	if !expires.IsZero() {
+		w.Header().Set("X-Mimir-Cache-Expires", expires.UTC().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aqstack/mimir/internal/cache"
+	"github.com/aqstack/mimir/internal/embedding"
+	"github.com/aqstack/mimir/internal/rules"
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// maxWarmupRequests caps the prompts accepted by a single warmup call.
+const maxWarmupRequests = 1000
+
+// warmupResult reports what a warmup run did with its prompts.
+type warmupResult struct {
+	Seeded     int   `json:"seeded"`
+	Present    int   `json:"present"`
+	Skipped    int   `json:"skipped"`
+	Failed     int   `json:"failed"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// warmupOutcome is what happened to one warmup prompt.
+type warmupOutcome int
+
+const (
+	warmupSeeded warmupOutcome = iota
+	warmupPresent
+	warmupSkipped
+	warmupFailed
+)
+
+// handleWarmup seeds the cache from a JSON array of chat completion
+// requests. Each is keyed exactly as /v1/chat/completions keys it; prompts
+// that already match an entry are left alone, and the rest are sent
+// upstream at low priority by MIMIR_WARMUP_CONCURRENCY workers and their
+// responses stored. Prompts the cache would bypass are skipped.
+func (h *Handler) handleWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.cfg.CacheReadOnly {
+		h.writeError(w, "Cache is read-only", http.StatusConflict)
+		return
+	}
+
+	var reqs []api.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil || len(reqs) == 0 {
+		h.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(reqs) > maxWarmupRequests {
+		h.writeError(w, fmt.Sprintf("At most %d requests per warmup", maxWarmupRequests), http.StatusBadRequest)
+		return
+	}
+	for i, req := range reqs {
+		if req.Model == "" || len(req.Messages) == 0 {
+			h.writeError(w, fmt.Sprintf("Request %d needs a model and messages", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := time.Now()
+	embedder := h.selectEmbedder(w, r)
+	workers := h.cfg.WarmupConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var counts [warmupFailed + 1]atomic.Int64
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				counts[h.warmOne(r.Context(), r, embedder, reqs[i])].Add(1)
+			}
+		}()
+	}
+	for i := range reqs {
+		select {
+		case indexes <- i:
+		case <-r.Context().Done():
+		}
+		if r.Context().Err() != nil {
+			break
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	result := warmupResult{
+		Seeded:     int(counts[warmupSeeded].Load()),
+		Present:    int(counts[warmupPresent].Load()),
+		Skipped:    int(counts[warmupSkipped].Load()),
+		Failed:     int(counts[warmupFailed].Load()),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	h.logger.Info("cache warmup completed",
+		"seeded", result.Seeded,
+		"present", result.Present,
+		"skipped", result.Skipped,
+		"failed", result.Failed,
+		"duration_ms", result.DurationMs,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// warmOne embeds req and, unless an entry already matches it, fetches and
+// stores its response. The upstream call authenticates with the warmup
+// request's Authorization header or the configured key.
+func (h *Handler) warmOne(ctx context.Context, r *http.Request, embedder embedding.Embedder, req api.ChatCompletionRequest) warmupOutcome {
+	// Cached responses are replayed as streams when needed, so always
+	// fetch a complete one
+	req.Stream = false
+	req.StreamOptions = nil
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return warmupFailed
+	}
+	if len(h.cfg.CacheRules) > 0 {
+		var doc interface{}
+		json.Unmarshal(body, &doc)
+		if ok, _ := rules.Cacheable(h.cfg.CacheRules, doc); !ok {
+			return warmupSkipped
+		}
+	}
+	ttl, store := h.requestTTL(r, req.Model)
+	if !store ||
+		(h.cfg.ToolCaching == toolCachingSkip && usesTools(req)) ||
+		(h.cfg.PrefillCaching == prefillCachingSkip && hasPrefill(req)) {
+		return warmupSkipped
+	}
+	cacheKey := h.generateCacheKey(req)
+	embedText, ok := h.embedInput(cacheKey)
+	if !ok {
+		return warmupSkipped
+	}
+
+	ctx = cache.WithPartition(ctx, h.requestPartition(ctx, r, embedder, req))
+	emb, err := h.embed(ctx, embedder, embedText)
+	if err != nil {
+		h.logger.Warn("failed to embed warmup prompt", "prompt", truncatePrompt(cacheKey, 80), "error", err)
+		return warmupFailed
+	}
+	if _, _, found := h.cache.Get(ctx, emb, h.thresholdFor(req.Model)); found {
+		return warmupPresent
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return warmupFailed
+	}
+	upstreamReq.Header.Set("Content-Type", "application/json")
+	upstreamReq.Header.Set("X-Mimir-Priority", priorityLow)
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		upstreamReq.Header.Set("Authorization", auth)
+	}
+	resp, respBody, err := h.doUpstreamRequest(ctx, upstreamReq, body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	if err != nil {
+		h.logger.Warn("failed to fetch warmup response", "prompt", truncatePrompt(cacheKey, 80), "error", err)
+		return warmupFailed
+	}
+
+	var chatResp api.ChatCompletionResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return warmupFailed
+	}
+	if h.storeResponse(ctx, req, chatResp, resp.Header, emb, embedder, ttl) == "" {
+		return warmupSkipped
+	}
+	return warmupSeeded
+}
@@ -0,0 +1,102 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HourlyAlert is the webhook payload sent for an hour whose hit rate fell
+// below the target.
+type HourlyAlert struct {
+	Alert    string    `json:"alert"`
+	Hour     time.Time `json:"hour"`
+	HitRate  float64   `json:"hit_rate"`
+	Target   float64   `json:"target"`
+	Requests int64     `json:"requests"`
+}
+
+// HitRateAlerter compares completed hours against a hit-rate target and
+// posts an HourlyAlert to a webhook for each hour that falls short. Each
+// hour is alerted at most once; failed deliveries are retried on the next
+// check.
+type HitRateAlerter struct {
+	collector  *Collector
+	target     float64
+	webhookURL string
+	client     *http.Client
+	alerted    map[time.Time]bool
+}
+
+// NewHitRateAlerter creates an alerter for target, a fraction of requests
+// served from cache.
+func NewHitRateAlerter(c *Collector, target float64, webhookURL string) *HitRateAlerter {
+	return &HitRateAlerter{
+		collector:  c,
+		target:     target,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		alerted:    make(map[time.Time]bool),
+	}
+}
+
+// Check alerts on every completed hour below target that has not been
+// alerted yet and returns the alerts delivered. It is not safe for
+// concurrent use.
+func (a *HitRateAlerter) Check(ctx context.Context, now time.Time) ([]HourlyAlert, error) {
+	hours := a.collector.CompletedHours(now)
+
+	// Forget hours that have dropped out of the collector's history
+	if len(hours) > 0 {
+		for hour := range a.alerted {
+			if hour.Before(hours[0].Hour) {
+				delete(a.alerted, hour)
+			}
+		}
+	}
+
+	var sent []HourlyAlert
+	for _, h := range hours {
+		if h.HitRate >= a.target || a.alerted[h.Hour] {
+			continue
+		}
+		alert := HourlyAlert{
+			Alert:    "hourly_hit_rate",
+			Hour:     h.Hour,
+			HitRate:  h.HitRate,
+			Target:   a.target,
+			Requests: h.Requests,
+		}
+		if err := a.post(ctx, alert); err != nil {
+			return sent, err
+		}
+		a.alerted[h.Hour] = true
+		sent = append(sent, alert)
+	}
+	return sent, nil
+}
+
+func (a *HitRateAlerter) post(ctx context.Context, alert HourlyAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordWindow stands in for a minute of traffic starting at start.
+func recordWindow(c *Collector, start time.Time, hits, misses int64) {
+	c.windowStart = start
+	c.windowHits = hits
+	c.windowMisses = misses
+}
+
+func TestCompletedHours(t *testing.T) {
+	c := NewCollector()
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	recordWindow(c, hour, 1, 3)
+	if hours := c.CompletedHours(hour.Add(30 * time.Minute)); len(hours) != 0 {
+		t.Fatalf("expected the open hour to be excluded, got %+v", hours)
+	}
+
+	recordWindow(c, hour.Add(30*time.Minute), 1, 0)
+	hours := c.CompletedHours(hour.Add(65 * time.Minute))
+	if len(hours) != 1 {
+		t.Fatalf("expected one completed hour, got %+v", hours)
+	}
+	if !hours[0].Hour.Equal(hour) || hours[0].Requests != 5 || hours[0].HitRate != 0.4 {
+		t.Errorf("unexpected hour: %+v", hours[0])
+	}
+}
+
+func TestHitRateAlerter(t *testing.T) {
+	var alerts []HourlyAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert HourlyAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts = append(alerts, alert)
+	}))
+	defer server.Close()
+
+	c := NewCollector()
+	a := NewHitRateAlerter(c, 0.5, server.URL)
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// 10:00 meets the target, 11:00 misses it
+	recordWindow(c, hour, 6, 4)
+	c.CompletedHours(hour.Add(time.Hour))
+	recordWindow(c, hour.Add(time.Hour), 2, 8)
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Check(ctx, hour.Add(2*time.Hour+time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected one debounced alert, got %d", len(alerts))
+	}
+	if !alerts[0].Hour.Equal(hour.Add(time.Hour)) || alerts[0].HitRate != 0.2 || alerts[0].Target != 0.5 {
+		t.Errorf("unexpected alert: %+v", alerts[0])
+	}
+}
+
+func TestHitRateAlerterRetriesFailedDelivery(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c := NewCollector()
+	a := NewHitRateAlerter(c, 0.5, server.URL)
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	recordWindow(c, hour, 0, 10)
+
+	now := hour.Add(time.Hour + time.Minute)
+	if _, err := a.Check(context.Background(), now); err == nil {
+		t.Fatal("expected an error from a failing webhook")
+	}
+	status = http.StatusOK
+	if sent, err := a.Check(context.Background(), now); err != nil || len(sent) != 1 {
+		t.Errorf("expected the alert to be retried, got %v, %v", sent, err)
+	}
+}
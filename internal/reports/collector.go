@@ -3,8 +3,12 @@ package reports
 
 import (
 	"fmt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/aqstack/mimir/pkg/api"
 )
 
 // DataPoint represents a single metric data point.
@@ -43,6 +47,10 @@ type Collector struct {
 	logs    []LogEntry
 	maxLogs int
 
+	// Dead-letter log of requests that failed both cache and upstream
+	failures    []FailedRequest
+	maxFailures int
+
 	// Aggregated time-series data (per minute)
 	hitRateHistory    []DataPoint
 	latencyHistory    []DataPoint
@@ -56,13 +64,53 @@ type Collector struct {
 	windowLatency int64
 	windowSavings float64
 
+	// Minute windows folded into clock hours, for hourly hit-rate targets
+	hourStart  time.Time
+	hourHits   int64
+	hourMisses int64
+	hourly     []HourlyHitRate
+
 	// Lifetime stats
-	totalRequests  int64
-	totalHits      int64
-	totalMisses    int64
-	totalLatencyMs int64
-	totalSavings   float64
-	startTime      time.Time
+	totalRequests    int64
+	totalHits        int64
+	totalMisses      int64
+	totalLatencyMs   int64
+	totalSavings     float64
+	totalFailovers   int64
+	totalRateLimited int64
+	startTime        time.Time
+
+	// Background cache cleanup runs
+	cleanupRuns  int64
+	totalExpired int64
+	lastCleanup  time.Duration
+
+	// Lifetime totals exported as Prometheus metrics
+	totalTokensSaved int64
+	latencyCounts    []int64 // per latencyBuckets bound, plus +Inf
+	similarityCounts []int64 // cache hits per similarityBuckets bound, plus +Inf
+	similaritySum    float64
+	models           map[string]*UsageTotals
+
+	// pricing turns tokens saved into dollars
+	pricing Pricing
+
+	// Work in progress, updated without the lock
+	embedsInFlight   atomic.Int64
+	upstreamInFlight atomic.Int64
+	waiting          atomic.Int64
+
+	// Per-day, per-model totals for the savings report
+	daily         map[string]map[string]*UsageTotals
+	retentionDays int
+
+	// Bounded set of prompt hashes used to tell first-seen misses, which
+	// are unavoidable while the cache warms, from genuine misses
+	seen            map[uint64]struct{}
+	seenOrder       []uint64
+	seenIdx         int
+	maxSeen         int
+	firstSeenMisses int64
 }
 
 // NewCollector creates a new metrics collector.
@@ -73,17 +121,65 @@ func NewCollector() *Collector {
 		maxRequests:       1000,
 		logs:              make([]LogEntry, 0, 100),
 		maxLogs:           100,
-		hitRateHistory:    make([]DataPoint, 0, 60),   // 1 hour at 1-min resolution
+		maxFailures:       100,
+		hitRateHistory:    make([]DataPoint, 0, 60), // 1 hour at 1-min resolution
 		latencyHistory:    make([]DataPoint, 0, 60),
 		savingsHistory:    make([]DataPoint, 0, 60),
 		throughputHistory: make([]DataPoint, 0, 60),
 		windowStart:       now,
 		startTime:         now,
+		seen:              make(map[uint64]struct{}),
+		maxSeen:           10000,
+		latencyCounts:     make([]int64, len(latencyBuckets)+1),
+		similarityCounts:  make([]int64, len(similarityBuckets)+1),
+		models:            make(map[string]*UsageTotals),
+		daily:             make(map[string]map[string]*UsageTotals),
+		retentionDays:     90,
+		pricing:           DefaultPricing(),
 	}
 }
 
+// SetPricing sets the model prices savings are computed with.
+func (c *Collector) SetPricing(p Pricing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pricing = p
+}
+
+// SavedUSD returns what serving usage of model from the cache saved, in USD.
+func (c *Collector) SavedUSD(model string, usage api.Usage) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pricing.Cost(model, usage)
+}
+
+// SetSeenLimit bounds how many distinct prompts are remembered for the
+// steady-state hit rate. Zero disables tracking.
+func (c *Collector) SetSeenLimit(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSeen = n
+	c.seen = make(map[uint64]struct{})
+	c.seenOrder = nil
+	c.seenIdx = 0
+}
+
 // RecordRequest records metrics for a single request.
 func (c *Collector) RecordRequest(cacheHit bool, similarity float64, latencyMs int64, tokensSaved int, prompt string) {
+	c.RecordModelRequest("", cacheHit, similarity, latencyMs, tokensSaved, prompt)
+}
+
+// RecordModelRequest records metrics for a single request to model, which
+// the savings report breaks totals down by.
+func (c *Collector) RecordModelRequest(model string, cacheHit bool, similarity float64, latencyMs int64, tokensSaved int, prompt string) {
+	c.RecordModelUsage(model, cacheHit, similarity, latencyMs, api.Usage{TotalTokens: tokensSaved}, prompt)
+}
+
+// RecordModelUsage is RecordModelRequest with the usage a hit saved broken
+// down into prompt and completion tokens, so savings use the model's input
+// and output prices.
+func (c *Collector) RecordModelUsage(model string, cacheHit bool, similarity float64, latencyMs int64, saved api.Usage, prompt string) {
+	tokensSaved := saved.TotalTokens
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -94,6 +190,10 @@ func (c *Collector) RecordRequest(cacheHit bool, similarity float64, latencyMs i
 		c.rotateWindow(now)
 	}
 
+	if firstSeen := c.markSeen(prompt); firstSeen && !cacheHit {
+		c.firstSeenMisses++
+	}
+
 	// Truncate prompt for storage
 	if len(prompt) > 100 {
 		prompt = prompt[:97] + "..."
@@ -127,13 +227,71 @@ func (c *Collector) RecordRequest(cacheHit bool, similarity float64, latencyMs i
 	c.windowLatency += latencyMs
 	c.totalLatencyMs += latencyMs
 	c.totalRequests++
+	c.latencyCounts[latencyBucket(latencyMs)]++
+	if cacheHit {
+		c.totalTokensSaved += int64(tokensSaved)
+		c.similarityCounts[similarityBucket(similarity)]++
+		c.similaritySum += similarity
+	}
 
-	// Estimate cost savings ($0.002 per 1K tokens for GPT-4)
+	// Price the saved tokens at the model's rates
+	var savings float64
 	if cacheHit && tokensSaved > 0 {
-		savings := float64(tokensSaved) * 0.000002
+		savings = c.pricing.Cost(model, saved)
 		c.windowSavings += savings
 		c.totalSavings += savings
 	}
+
+	c.recordDaily(now, model, cacheHit, tokensSaved, savings)
+	c.recordModel(model, cacheHit, tokensSaved, savings)
+}
+
+// markSeen records prompt in the seen-set and reports whether it is new.
+// Caller must hold the lock.
+func (c *Collector) markSeen(prompt string) bool {
+	if c.maxSeen <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(prompt))
+	key := h.Sum64()
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+
+	if len(c.seenOrder) < c.maxSeen {
+		c.seenOrder = append(c.seenOrder, key)
+	} else {
+		delete(c.seen, c.seenOrder[c.seenIdx])
+		c.seenOrder[c.seenIdx] = key
+		c.seenIdx = (c.seenIdx + 1) % c.maxSeen
+	}
+	c.seen[key] = struct{}{}
+	return true
+}
+
+// RecordFailover records a request served by the fallback upstream.
+func (c *Collector) RecordFailover() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalFailovers++
+}
+
+// RecordRateLimited records an upstream response of 429 or 503.
+func (c *Collector) RecordRateLimited() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalRateLimited++
+}
+
+// RecordCleanup records a background cache cleanup run that removed
+// removed expired entries in took.
+func (c *Collector) RecordCleanup(removed int, took time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanupRuns++
+	c.totalExpired += int64(removed)
+	c.lastCleanup = took
 }
 
 // rotateWindow aggregates current window and starts a new one.
@@ -162,6 +320,8 @@ func (c *Collector) rotateWindow(now time.Time) {
 			Timestamp: c.windowStart,
 			Value:     float64(total),
 		}, 60)
+
+		c.addToHour(c.windowStart, c.windowHits, c.windowMisses)
 	}
 
 	// Reset window
@@ -172,6 +332,59 @@ func (c *Collector) rotateWindow(now time.Time) {
 	c.windowSavings = 0
 }
 
+// HourlyHitRate is the hit rate of one completed clock hour.
+type HourlyHitRate struct {
+	Hour     time.Time `json:"hour"`
+	Requests int64     `json:"requests"`
+	// HitRate is the fraction of requests served from cache
+	HitRate float64 `json:"hit_rate"`
+}
+
+// addToHour folds a finished minute window into its clock hour, closing
+// the open hour when the window belongs to a later one. Caller must hold the lock.
+func (c *Collector) addToHour(start time.Time, hits, misses int64) {
+	if hour := start.Truncate(time.Hour); hour.After(c.hourStart) {
+		c.closeHour()
+		c.hourStart = hour
+	}
+	c.hourHits += hits
+	c.hourMisses += misses
+}
+
+// closeHour records the open hour, if it saw any traffic, keeping a day of
+// history. Caller must hold the lock.
+func (c *Collector) closeHour() {
+	if total := c.hourHits + c.hourMisses; total > 0 {
+		if len(c.hourly) >= 24 {
+			c.hourly = c.hourly[1:]
+		}
+		c.hourly = append(c.hourly, HourlyHitRate{
+			Hour:     c.hourStart,
+			Requests: total,
+			HitRate:  float64(c.hourHits) / float64(total),
+		})
+	}
+	c.hourHits = 0
+	c.hourMisses = 0
+}
+
+// CompletedHours returns the hit rate of each completed clock hour of the
+// last day, oldest first. An hour is complete once now is past it and the
+// minute window still collecting traffic belongs to a later hour.
+func (c *Collector) CompletedHours(now time.Time) []HourlyHitRate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.windowStart) >= time.Minute {
+		c.rotateWindow(now)
+	}
+	if hour := c.windowStart.Truncate(time.Hour); hour.After(c.hourStart) {
+		c.closeHour()
+		c.hourStart = hour
+	}
+	return append([]HourlyHitRate(nil), c.hourly...)
+}
+
 func appendWithLimit(slice []DataPoint, point DataPoint, limit int) []DataPoint {
 	if len(slice) >= limit {
 		copy(slice, slice[1:])
@@ -184,14 +397,25 @@ func appendWithLimit(slice []DataPoint, point DataPoint, limit int) []DataPoint
 // Report represents the full performance report.
 type Report struct {
 	// Summary stats
-	Uptime         string  `json:"uptime"`
-	TotalRequests  int64   `json:"total_requests"`
-	TotalHits      int64   `json:"total_hits"`
-	TotalMisses    int64   `json:"total_misses"`
-	HitRate        float64 `json:"hit_rate"`
-	AvgLatencyMs   float64 `json:"avg_latency_ms"`
-	TotalSavingsUSD float64 `json:"total_savings_usd"`
-	RequestsPerMin float64 `json:"requests_per_min"`
+	Uptime           string  `json:"uptime"`
+	TotalRequests    int64   `json:"total_requests"`
+	TotalHits        int64   `json:"total_hits"`
+	TotalMisses      int64   `json:"total_misses"`
+	HitRate          float64 `json:"hit_rate"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	TotalSavingsUSD  float64 `json:"total_savings_usd"`
+	RequestsPerMin   float64 `json:"requests_per_min"`
+	TotalFailovers   int64   `json:"total_failovers"`
+	TotalRateLimited int64   `json:"total_rate_limited"`
+
+	// Entries removed by background cache cleanup, and its runs
+	TotalExpired  int64 `json:"total_expired"`
+	CleanupRuns   int64 `json:"cleanup_runs"`
+	LastCleanupMs int64 `json:"last_cleanup_ms"`
+
+	// Hit rate excluding misses on first-seen prompts
+	SteadyStateHitRate float64 `json:"steady_state_hit_rate"`
+	FirstSeenMisses    int64   `json:"first_seen_misses"`
 
 	// Time series for charts
 	HitRateHistory    []DataPoint `json:"hit_rate_history"`
@@ -203,8 +427,11 @@ type Report struct {
 	RecentRequests []RequestMetric `json:"recent_requests"`
 
 	// Distribution data
-	LatencyDistribution  []BucketCount `json:"latency_distribution"`
+	LatencyDistribution    []BucketCount `json:"latency_distribution"`
 	SimilarityDistribution []BucketCount `json:"similarity_distribution"`
+
+	// Cache backend stats, filled in by the caller
+	Cache *api.CacheStats `json:"cache,omitempty"`
 }
 
 // BucketCount represents a histogram bucket.
@@ -221,11 +448,14 @@ func (c *Collector) GetReport() *Report {
 	now := time.Now()
 	uptime := now.Sub(c.startTime)
 
-	var hitRate, avgLatency, reqPerMin float64
+	var hitRate, steadyHitRate, avgLatency, reqPerMin float64
 	if c.totalRequests > 0 {
 		hitRate = float64(c.totalHits) / float64(c.totalRequests) * 100
 		avgLatency = float64(c.totalLatencyMs) / float64(c.totalRequests)
 	}
+	if steady := c.totalRequests - c.firstSeenMisses; steady > 0 {
+		steadyHitRate = float64(c.totalHits) / float64(steady) * 100
+	}
 	if uptime.Minutes() > 0 {
 		reqPerMin = float64(c.totalRequests) / uptime.Minutes()
 	}
@@ -241,31 +471,38 @@ func (c *Collector) GetReport() *Report {
 	similarityDist := c.calculateSimilarityDistribution()
 
 	return &Report{
-		Uptime:               formatDuration(uptime),
-		TotalRequests:        c.totalRequests,
-		TotalHits:            c.totalHits,
-		TotalMisses:          c.totalMisses,
-		HitRate:              hitRate,
-		AvgLatencyMs:         avgLatency,
-		TotalSavingsUSD:      c.totalSavings,
-		RequestsPerMin:       reqPerMin,
-		HitRateHistory:       c.hitRateHistory,
-		LatencyHistory:       c.latencyHistory,
-		SavingsHistory:       c.savingsHistory,
-		ThroughputHistory:    c.throughputHistory,
-		RecentRequests:       recentRequests,
-		LatencyDistribution:  latencyDist,
+		Uptime:                 formatDuration(uptime),
+		TotalRequests:          c.totalRequests,
+		TotalHits:              c.totalHits,
+		TotalMisses:            c.totalMisses,
+		HitRate:                hitRate,
+		AvgLatencyMs:           avgLatency,
+		TotalSavingsUSD:        c.totalSavings,
+		RequestsPerMin:         reqPerMin,
+		TotalFailovers:         c.totalFailovers,
+		TotalRateLimited:       c.totalRateLimited,
+		TotalExpired:           c.totalExpired,
+		CleanupRuns:            c.cleanupRuns,
+		LastCleanupMs:          c.lastCleanup.Milliseconds(),
+		SteadyStateHitRate:     steadyHitRate,
+		FirstSeenMisses:        c.firstSeenMisses,
+		HitRateHistory:         c.hitRateHistory,
+		LatencyHistory:         c.latencyHistory,
+		SavingsHistory:         c.savingsHistory,
+		ThroughputHistory:      c.throughputHistory,
+		RecentRequests:         recentRequests,
+		LatencyDistribution:    latencyDist,
 		SimilarityDistribution: similarityDist,
 	}
 }
 
 func (c *Collector) calculateLatencyDistribution() []BucketCount {
 	buckets := map[string]int{
-		"0-10ms":   0,
-		"10-50ms":  0,
-		"50-100ms": 0,
+		"0-10ms":    0,
+		"10-50ms":   0,
+		"50-100ms":  0,
 		"100-500ms": 0,
-		"500ms+":   0,
+		"500ms+":    0,
 	}
 
 	for _, req := range c.requests {
@@ -375,4 +612,3 @@ func (c *Collector) ClearLogs() {
 	defer c.mu.Unlock()
 	c.logs = make([]LogEntry, 0, c.maxLogs)
 }
-
@@ -106,12 +106,12 @@ func TestSimilarityDistribution(t *testing.T) {
 	c := NewCollector()
 
 	// Record cache hits with different similarities
-	c.RecordRequest(true, 1.0, 5, 100, "p1")   // 0.99-1.0
-	c.RecordRequest(true, 0.98, 5, 100, "p2")  // 0.97-0.99
-	c.RecordRequest(true, 0.96, 5, 100, "p3")  // 0.95-0.97
-	c.RecordRequest(true, 0.92, 5, 100, "p4")  // 0.90-0.95
-	c.RecordRequest(true, 0.85, 5, 100, "p5")  // <0.90
-	c.RecordRequest(false, 0, 100, 0, "p6")    // miss - should not be counted
+	c.RecordRequest(true, 1.0, 5, 100, "p1")  // 0.99-1.0
+	c.RecordRequest(true, 0.98, 5, 100, "p2") // 0.97-0.99
+	c.RecordRequest(true, 0.96, 5, 100, "p3") // 0.95-0.97
+	c.RecordRequest(true, 0.92, 5, 100, "p4") // 0.90-0.95
+	c.RecordRequest(true, 0.85, 5, 100, "p5") // <0.90
+	c.RecordRequest(false, 0, 100, 0, "p6")   // miss - should not be counted
 
 	report := c.GetReport()
 
@@ -209,3 +209,84 @@ func TestDashboardHTML(t *testing.T) {
 		t.Error("expected HTML to fetch from /reports/data")
 	}
 }
+
+func TestDashboardHTMLIncludesTrafficPresets(t *testing.T) {
+	html := DashboardHTML()
+	if strings.Contains(html, "__TRAFFIC_PRESETS__") {
+		t.Fatal("expected traffic presets placeholder to be replaced")
+	}
+	if !strings.Contains(html, TrafficPresets["identical"][0]) {
+		t.Error("expected dashboard to embed the traffic preset prompts")
+	}
+}
+
+func TestRecordFailover(t *testing.T) {
+	c := NewCollector()
+	c.RecordFailover()
+	c.RecordFailover()
+
+	if report := c.GetReport(); report.TotalFailovers != 2 {
+		t.Errorf("expected TotalFailovers=2, got %d", report.TotalFailovers)
+	}
+}
+
+func TestRecordRateLimited(t *testing.T) {
+	c := NewCollector()
+	c.RecordRateLimited()
+
+	if report := c.GetReport(); report.TotalRateLimited != 1 {
+		t.Errorf("expected TotalRateLimited=1, got %d", report.TotalRateLimited)
+	}
+}
+
+func TestRecordCleanup(t *testing.T) {
+	c := NewCollector()
+	c.RecordCleanup(3, 20*time.Millisecond)
+	c.RecordCleanup(0, 5*time.Millisecond)
+
+	report := c.GetReport()
+	if report.TotalExpired != 3 || report.CleanupRuns != 2 {
+		t.Errorf("expected 3 expired over 2 runs, got %d over %d", report.TotalExpired, report.CleanupRuns)
+	}
+	if report.LastCleanupMs != 5 {
+		t.Errorf("expected LastCleanupMs=5, got %d", report.LastCleanupMs)
+	}
+}
+
+func TestSteadyStateHitRate(t *testing.T) {
+	c := NewCollector()
+
+	// First occurrences are warmup misses; the repeat miss is genuine
+	c.RecordRequest(false, 0, 100, 0, "prompt1")
+	c.RecordRequest(false, 0, 100, 0, "prompt2")
+	c.RecordRequest(true, 0.99, 5, 500, "prompt1")
+	c.RecordRequest(false, 0, 100, 0, "prompt2")
+
+	report := c.GetReport()
+	if report.FirstSeenMisses != 2 {
+		t.Errorf("expected FirstSeenMisses=2, got %d", report.FirstSeenMisses)
+	}
+	if report.HitRate != 25 {
+		t.Errorf("expected HitRate=25, got %f", report.HitRate)
+	}
+	if report.SteadyStateHitRate != 50 {
+		t.Errorf("expected SteadyStateHitRate=50, got %f", report.SteadyStateHitRate)
+	}
+}
+
+func TestSeenLimit(t *testing.T) {
+	c := NewCollector()
+	c.SetSeenLimit(1)
+
+	c.RecordRequest(false, 0, 100, 0, "prompt1")
+	c.RecordRequest(false, 0, 100, 0, "prompt2")
+	// prompt1 was pushed out of the seen-set, so it counts as first-seen again
+	c.RecordRequest(false, 0, 100, 0, "prompt1")
+
+	if c.firstSeenMisses != 3 {
+		t.Errorf("expected firstSeenMisses=3, got %d", c.firstSeenMisses)
+	}
+	if len(c.seen) != 1 {
+		t.Errorf("expected seen-set bounded to 1, got %d", len(c.seen))
+	}
+}
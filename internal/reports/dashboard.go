@@ -1,8 +1,17 @@
 package reports
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // DashboardHTML returns the HTML for the performance dashboard.
 func DashboardHTML() string {
-	return `<!DOCTYPE html>
+	presets, _ := json.Marshal(TrafficPresets)
+	return strings.Replace(dashboardHTML, "__TRAFFIC_PRESETS__", string(presets), 1)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
 <html lang="en">
 <head>
     <meta charset="UTF-8">
@@ -167,6 +176,7 @@ func DashboardHTML() string {
             color: #e2e8f0;
             font-size: 0.8rem;
         }
+        .threshold-control input[type=range] { width: 180px; padding: 0; }
         .traffic-presets { display: flex; gap: 0.5rem; flex-wrap: wrap; }
         .traffic-presets button { padding: 0.5rem 1rem; font-size: 0.75rem; border-radius: 0.375rem; }
         .progress-bar {
@@ -226,6 +236,10 @@ func DashboardHTML() string {
                 <div class="stat-label">Hit Rate</div>
                 <div class="stat-value green" id="hitRate">--%</div>
             </div>
+            <div class="stat-card" title="Hit rate excluding misses on prompts seen for the first time, which are unavoidable while the cache warms up.">
+                <div class="stat-label">Steady-State Hit Rate</div>
+                <div class="stat-value green" id="steadyHitRate">--%</div>
+            </div>
             <div class="stat-card">
                 <div class="stat-label">Total Requests</div>
                 <div class="stat-value blue" id="totalRequests">--</div>
@@ -246,6 +260,10 @@ func DashboardHTML() string {
                 <div class="stat-label">Requests/min</div>
                 <div class="stat-value" id="reqPerMin">--</div>
             </div>
+            <div class="stat-card" title="Share of evicted or expired entries that were never hit. High churn means the cache is too small or the threshold too strict.">
+                <div class="stat-label">Cache Churn</div>
+                <div class="stat-value" id="churnRate">--%</div>
+            </div>
         </div>
 
         <div class="charts-grid">
@@ -261,6 +279,9 @@ func DashboardHTML() string {
                         </select>
                         <button id="sendBtn" onclick="sendTestPrompt()">Send</button>
                     </div>
+                    <div class="traffic-options threshold-control">
+                        <label title="Default similarity threshold, applied to new lookups at once and kept until restart">Threshold: <input type="range" id="thresholdSlider" min="0" max="1" step="0.01" oninput="document.getElementById('thresholdValue').textContent = parseFloat(this.value).toFixed(2)" onchange="setThreshold(this.value)"> <span id="thresholdValue">--</span></label>
+                    </div>
                     <div id="testResult" class="test-result"></div>
                 </div>
             </div>
@@ -270,6 +291,7 @@ func DashboardHTML() string {
                     <div class="traffic-options">
                         <label>Requests: <input type="number" id="trafficCount" value="10" min="1" max="100"></label>
                         <label>Delay (ms): <input type="number" id="trafficDelay" value="100" min="0" max="5000"></label>
+                        <label title="Run the load from the mimir server instead of this browser tab"><input type="checkbox" id="trafficServer"> Server-side</label>
                     </div>
                     <div class="traffic-presets">
                         <button onclick="generateTraffic('identical')" title="Same query repeated - 100% cache hits expected">Identical</button>
@@ -373,11 +395,15 @@ func DashboardHTML() string {
 
                 // Update stats
                 document.getElementById('hitRate').textContent = data.hit_rate.toFixed(1) + '%';
+                document.getElementById('steadyHitRate').textContent = data.steady_state_hit_rate.toFixed(1) + '%';
                 document.getElementById('totalRequests').textContent = data.total_requests.toLocaleString();
                 document.getElementById('avgLatency').textContent = data.avg_latency_ms.toFixed(1) + 'ms';
                 document.getElementById('cacheHits').textContent = data.total_hits.toLocaleString();
                 document.getElementById('cacheMisses').textContent = data.total_misses.toLocaleString();
                 document.getElementById('reqPerMin').textContent = data.requests_per_min.toFixed(1);
+                if (data.cache) {
+                    document.getElementById('churnRate').textContent = (data.cache.churn_rate * 100).toFixed(1) + '%';
+                }
 
                 // Update hit rate chart
                 if (data.hit_rate_history && data.hit_rate_history.length > 0) {
@@ -476,77 +502,7 @@ ${content}` + "`" + `;
         }
 
         // Traffic generator
-        const trafficPrompts = {
-            identical: ['Explain the difference between SQL and NoSQL databases'],
-            similar: [
-                // Database questions - should have high semantic similarity
-                'Explain the difference between SQL and NoSQL databases',
-                'What are the key differences between SQL and NoSQL?',
-                'Compare SQL databases to NoSQL databases',
-                'SQL vs NoSQL - what is the difference?',
-                'How do relational databases differ from NoSQL databases?',
-                // Python questions - should have high semantic similarity
-                'How do I read a file in Python?',
-                'What is the Python code to read a file?',
-                'Show me how to open and read a file in Python',
-                'Python file reading example',
-                // API questions
-                'What is a REST API?',
-                'Explain REST APIs',
-                'What does REST API mean?',
-                'How do REST APIs work?'
-            ],
-            random: [
-                'Explain the difference between TCP and UDP protocols',
-                'What is the time complexity of quicksort?',
-                'How does garbage collection work in Java?',
-                'Explain the CAP theorem in distributed systems',
-                'What is the difference between process and thread?',
-                'How does HTTPS encryption work?',
-                'Explain microservices architecture',
-                'What is Docker and how does containerization work?',
-                'Explain the concept of eventual consistency',
-                'What is a load balancer and how does it work?',
-                'Describe the differences between REST and GraphQL',
-                'How does DNS resolution work?',
-                'What is the purpose of an index in a database?',
-                'Explain OAuth 2.0 authentication flow',
-                'What is the difference between horizontal and vertical scaling?',
-                'How do WebSockets differ from HTTP?',
-                'Explain the concept of database sharding',
-                'What is a reverse proxy?',
-                'How does Redis caching work?',
-                'Explain the publish-subscribe pattern'
-            ],
-            coding: [
-                'Write a function to reverse a string in Python',
-                'How do I reverse a string in Python?',
-                'Python code to reverse a string',
-                'Show me string reversal in Python',
-                'Implement a function to check if a number is prime',
-                'Write code to check for prime numbers',
-                'How to determine if a number is prime?',
-                'Prime number checking algorithm',
-                'How do I sort a list in Python?',
-                'Python list sorting methods',
-                'Sort a list in ascending order Python',
-                'What is the best way to sort lists in Python?'
-            ],
-            devops: [
-                'How do I create a Kubernetes deployment?',
-                'Kubernetes deployment YAML example',
-                'Create a deployment in K8s',
-                'Write a Kubernetes deployment manifest',
-                'How to set up a CI/CD pipeline?',
-                'Explain CI/CD pipeline setup',
-                'What are the steps to create a CI/CD pipeline?',
-                'CI/CD best practices',
-                'How do I write a Dockerfile?',
-                'Dockerfile example for a Python app',
-                'Create a Docker image for Python application',
-                'Best practices for writing Dockerfiles'
-            ]
-        };
+        const trafficPrompts = __TRAFFIC_PRESETS__;
 
         let trafficRunning = false;
 
@@ -564,29 +520,35 @@ ${content}` + "`" + `;
             const prompts = trafficPrompts[type];
             let hits = 0, misses = 0;
 
-            for (let i = 0; i < count; i++) {
-                const prompt = prompts[i % prompts.length];
-                status.textContent = ` + "`" + `Sending ${i + 1}/${count}: "${prompt}"` + "`" + `;
-                progress.style.width = ((i + 1) / count * 100) + '%';
-
-                try {
-                    const resp = await fetch('/v1/chat/completions', {
-                        method: 'POST',
-                        headers: { 'Content-Type': 'application/json' },
-                        body: JSON.stringify({
-                            model: document.getElementById('testModel').value,
-                            messages: [{ role: 'user', content: prompt }]
-                        })
-                    });
-                    const cacheStatus = resp.headers.get('X-Mimir-Cache');
-                    if (cacheStatus === 'HIT') hits++; else misses++;
-                    await resp.json();
-                } catch (e) {
-                    misses++;
-                }
-
-                if (delay > 0 && i < count - 1) {
-                    await new Promise(r => setTimeout(r, delay));
+            if (document.getElementById('trafficServer').checked) {
+                const result = await generateServerTraffic(type, count, delay, status, progress);
+                hits = result.hits;
+                misses = result.misses;
+            } else {
+                for (let i = 0; i < count; i++) {
+                    const prompt = prompts[i % prompts.length];
+                    status.textContent = ` + "`" + `Sending ${i + 1}/${count}: "${prompt}"` + "`" + `;
+                    progress.style.width = ((i + 1) / count * 100) + '%';
+
+                    try {
+                        const resp = await fetch('/v1/chat/completions', {
+                            method: 'POST',
+                            headers: { 'Content-Type': 'application/json' },
+                            body: JSON.stringify({
+                                model: document.getElementById('testModel').value,
+                                messages: [{ role: 'user', content: prompt }]
+                            })
+                        });
+                        const cacheStatus = resp.headers.get('X-Mimir-Cache');
+                        if (cacheStatus === 'HIT') hits++; else misses++;
+                        await resp.json();
+                    } catch (e) {
+                        misses++;
+                    }
+
+                    if (delay > 0 && i < count - 1) {
+                        await new Promise(r => setTimeout(r, delay));
+                    }
                 }
             }
 
@@ -597,6 +559,123 @@ ${content}` + "`" + `;
             fetchData();
         }
 
+        // Server-side traffic: POST the job and read progress from the SSE stream
+        async function generateServerTraffic(type, count, delay, status, progress) {
+            let hits = 0, misses = 0;
+            const token = adminToken();
+            if (!token) {
+                status.textContent = 'Server-side traffic needs the admin token';
+                return { hits, misses };
+            }
+            try {
+                const resp = await fetch('/reports/generate-traffic', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', 'X-Mimir-Admin-Token': token },
+                    body: JSON.stringify({
+                        type: type,
+                        count: count,
+                        delay: delay,
+                        model: document.getElementById('testModel').value
+                    })
+                });
+                if (resp.status === 401) sessionStorage.removeItem('mimirAdminToken');
+                if (!resp.ok) {
+                    status.textContent = 'Error: ' + (await resp.text());
+                    return { hits, misses };
+                }
+
+                const reader = resp.body.getReader();
+                const decoder = new TextDecoder();
+                let buffer = '';
+                while (true) {
+                    const { value, done } = await reader.read();
+                    if (done) break;
+                    buffer += decoder.decode(value, { stream: true });
+
+                    let idx;
+                    while ((idx = buffer.indexOf('\n\n')) >= 0) {
+                        const event = buffer.slice(0, idx);
+                        buffer = buffer.slice(idx + 2);
+                        const line = event.split('\n').find(l => l.startsWith('data: '));
+                        if (!line) continue;
+
+                        const data = JSON.parse(line.slice(6));
+                        if (data.done) {
+                            hits = data.hits;
+                            misses = data.misses;
+                        } else {
+                            if (data.cache === 'HIT') hits++; else misses++;
+                            status.textContent = ` + "`" + `Server sent ${data.index}/${data.count}: "${data.prompt}" [${data.cache}]` + "`" + `;
+                            progress.style.width = (data.index / data.count * 100) + '%';
+                        }
+                    }
+                }
+            } catch (e) {
+                status.textContent = 'Error: ' + e.message;
+            }
+            return { hits, misses };
+        }
+
+        // Similarity threshold, changed live through /config/threshold
+        async function fetchThreshold() {
+            try {
+                const resp = await fetch('/config/threshold');
+                const data = await resp.json();
+                document.getElementById('thresholdSlider').value = data.threshold;
+                document.getElementById('thresholdValue').textContent = data.threshold.toFixed(2);
+            } catch (e) {
+                console.error('Failed to fetch threshold:', e);
+            }
+        }
+
+        // Changing the threshold needs a MIMIR_AUTH_TOKEN bearer token,
+        // asked for once and kept for the browser session
+        function authToken() {
+            let token = sessionStorage.getItem('mimirAuthToken');
+            if (!token) {
+                token = prompt('Auth token (MIMIR_AUTH_TOKEN):') || '';
+                if (token) sessionStorage.setItem('mimirAuthToken', token);
+            }
+            return token;
+        }
+
+        // Admin endpoints need MIMIR_ADMIN_TOKEN, kept the same way
+        function adminToken() {
+            let token = sessionStorage.getItem('mimirAdminToken');
+            if (!token) {
+                token = prompt('Admin token (MIMIR_ADMIN_TOKEN):') || '';
+                if (token) sessionStorage.setItem('mimirAdminToken', token);
+            }
+            return token;
+        }
+
+        async function setThreshold(value) {
+            const result = document.getElementById('testResult');
+            const token = authToken();
+            if (!token) {
+                fetchThreshold();
+                return;
+            }
+            try {
+                const resp = await fetch('/config/threshold', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token },
+                    body: JSON.stringify({ threshold: parseFloat(value) })
+                });
+                if (resp.status === 401) sessionStorage.removeItem('mimirAuthToken');
+                if (!resp.ok) {
+                    result.className = 'test-result error';
+                    result.textContent = 'Error: ' + (await resp.text());
+                }
+            } catch (e) {
+                result.className = 'test-result error';
+                result.textContent = 'Error: ' + e.message;
+            }
+            fetchThreshold();
+        }
+
+        fetchThreshold();
+
         // Allow Ctrl+Enter to send
         document.getElementById('testPrompt').addEventListener('keydown', (e) => {
             if (e.ctrlKey && e.key === 'Enter') sendTestPrompt();
@@ -631,4 +710,3 @@ ${content}` + "`" + `;
     </script>
 </body>
 </html>`
-}
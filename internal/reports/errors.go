@@ -0,0 +1,63 @@
+package reports
+
+import "time"
+
+// FailedRequest is a dead-letter record of a request that could not be
+// answered from the cache and then failed upstream as well.
+type FailedRequest struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Endpoint   string    `json:"endpoint"`
+	Model      string    `json:"model,omitempty"`
+	Stream     bool      `json:"stream,omitempty"`
+	Prompt     string    `json:"prompt,omitempty"`
+	EmbedError string    `json:"embed_error,omitempty"` // why the cache was skipped, if it was
+	Error      string    `json:"error"`
+	Status     int       `json:"status"`             // status returned to the client
+	Fallback   bool      `json:"fallback,omitempty"` // a canned fallback answer was served
+	LatencyMs  int64     `json:"latency_ms"`
+	EmbedMs    int64     `json:"embed_ms"`
+	LookupMs   int64     `json:"lookup_ms"`
+	UpstreamMs int64     `json:"upstream_ms"`
+}
+
+// SetErrorLogLimit bounds how many failed requests are kept for
+// /reports/errors, dropping the oldest first. Zero disables the log.
+func (c *Collector) SetErrorLogLimit(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxFailures = n
+	if len(c.failures) > n {
+		c.failures = append([]FailedRequest(nil), c.failures[len(c.failures)-n:]...)
+	}
+}
+
+// RecordFailure adds a failed request to the dead-letter log.
+func (c *Collector) RecordFailure(f FailedRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxFailures <= 0 {
+		return
+	}
+	if f.Timestamp.IsZero() {
+		f.Timestamp = time.Now()
+	}
+	if len(f.Prompt) > 100 {
+		f.Prompt = f.Prompt[:97] + "..."
+	}
+
+	if len(c.failures) >= c.maxFailures {
+		c.failures = c.failures[1:]
+	}
+	c.failures = append(c.failures, f)
+}
+
+// Failures returns the dead-letter log, oldest first.
+func (c *Collector) Failures() []FailedRequest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]FailedRequest, len(c.failures))
+	copy(result, c.failures)
+	return result
+}
@@ -0,0 +1,37 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordFailure(t *testing.T) {
+	c := NewCollector()
+	c.SetErrorLogLimit(2)
+
+	c.RecordFailure(FailedRequest{Endpoint: "/v1/chat/completions", Error: "first", Status: 502})
+	c.RecordFailure(FailedRequest{Endpoint: "/v1/chat/completions", Error: "second", Status: 503, Prompt: strings.Repeat("x", 200)})
+	c.RecordFailure(FailedRequest{Endpoint: "/v1/messages", Error: "third", Status: 502})
+
+	failures := c.Failures()
+	if len(failures) != 2 || failures[0].Error != "second" || failures[1].Error != "third" {
+		t.Fatalf("expected the two newest failures, oldest first, got %+v", failures)
+	}
+	if len(failures[0].Prompt) != 100 {
+		t.Errorf("expected the prompt truncated to 100 bytes, got %d", len(failures[0].Prompt))
+	}
+	if failures[1].Timestamp.IsZero() {
+		t.Error("expected a timestamp to be set")
+	}
+
+	// Shrinking the limit keeps the newest entries; zero disables the log
+	c.SetErrorLogLimit(1)
+	if failures := c.Failures(); len(failures) != 1 || failures[0].Error != "third" {
+		t.Errorf("expected only the newest failure, got %+v", failures)
+	}
+	c.SetErrorLogLimit(0)
+	c.RecordFailure(FailedRequest{Error: "ignored"})
+	if failures := c.Failures(); len(failures) != 0 {
+		t.Errorf("expected a disabled log to be empty, got %+v", failures)
+	}
+}
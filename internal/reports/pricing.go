@@ -0,0 +1,101 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+// ModelPrice is what a model charges per 1K tokens, in USD.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Pricing maps model names to their prices. A model without an entry of
+// its own is priced by the longest entry it extends with a "-" suffix, so
+// "gpt-4o-2024-08-06" uses "gpt-4o", and otherwise by the "default" entry.
+type Pricing map[string]ModelPrice
+
+// defaultModelPrice prices models missing from the table. It matches the
+// flat per-token rate savings were estimated at before per-model pricing.
+var defaultModelPrice = ModelPrice{Input: 0.002, Output: 0.002}
+
+// DefaultPricing returns list prices of common OpenAI and Anthropic models.
+func DefaultPricing() Pricing {
+	return Pricing{
+		"gpt-4":                  {Input: 0.03, Output: 0.06},
+		"gpt-4-32k":              {Input: 0.06, Output: 0.12},
+		"gpt-4-turbo":            {Input: 0.01, Output: 0.03},
+		"gpt-4o":                 {Input: 0.0025, Output: 0.01},
+		"gpt-4o-mini":            {Input: 0.00015, Output: 0.0006},
+		"gpt-4.1":                {Input: 0.002, Output: 0.008},
+		"gpt-4.1-mini":           {Input: 0.0004, Output: 0.0016},
+		"gpt-4.1-nano":           {Input: 0.0001, Output: 0.0004},
+		"gpt-3.5-turbo":          {Input: 0.0005, Output: 0.0015},
+		"gpt-3.5-turbo-instruct": {Input: 0.0015, Output: 0.002},
+		"o1":                     {Input: 0.015, Output: 0.06},
+		"o1-mini":                {Input: 0.0011, Output: 0.0044},
+		"o3-mini":                {Input: 0.0011, Output: 0.0044},
+		"claude-3-opus":          {Input: 0.015, Output: 0.075},
+		"claude-3-sonnet":        {Input: 0.003, Output: 0.015},
+		"claude-3-haiku":         {Input: 0.00025, Output: 0.00125},
+		"claude-3-5-sonnet":      {Input: 0.003, Output: 0.015},
+		"claude-3-5-haiku":       {Input: 0.0008, Output: 0.004},
+		"default":                defaultModelPrice,
+	}
+}
+
+// ParsePricing returns the default pricing overlaid with the JSON object
+// s, which maps model names to {"input": ..., "output": ...} prices per 1K
+// tokens. An empty s returns the defaults.
+func ParsePricing(s string) (Pricing, error) {
+	pricing := DefaultPricing()
+	if strings.TrimSpace(s) == "" {
+		return pricing, nil
+	}
+	var overrides map[string]ModelPrice
+	if err := json.Unmarshal([]byte(s), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid pricing JSON: %w", err)
+	}
+	for model, price := range overrides {
+		if price.Input < 0 || price.Output < 0 {
+			return nil, fmt.Errorf("negative price for %q", model)
+		}
+		pricing[model] = price
+	}
+	return pricing, nil
+}
+
+// Lookup returns the price of model.
+func (p Pricing) Lookup(model string) ModelPrice {
+	if price, ok := p[model]; ok {
+		return price
+	}
+	best := ""
+	for name := range p {
+		if len(name) > len(best) && strings.HasPrefix(model, name+"-") {
+			best = name
+		}
+	}
+	if best != "" {
+		return p[best]
+	}
+	if price, ok := p["default"]; ok {
+		return price
+	}
+	return defaultModelPrice
+}
+
+// Cost returns what usage of model costs in USD. Tokens not broken down
+// into prompt and completion are priced as input.
+func (p Pricing) Cost(model string, usage api.Usage) float64 {
+	price := p.Lookup(model)
+	input := usage.PromptTokens
+	if rest := usage.TotalTokens - usage.PromptTokens - usage.CompletionTokens; rest > 0 {
+		input += rest
+	}
+	return (float64(input)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1000
+}
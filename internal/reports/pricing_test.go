@@ -0,0 +1,78 @@
+package reports
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aqstack/mimir/pkg/api"
+)
+
+func TestPricingLookup(t *testing.T) {
+	p := DefaultPricing()
+	tests := []struct {
+		model string
+		want  ModelPrice
+	}{
+		{"gpt-4", ModelPrice{Input: 0.03, Output: 0.06}},
+		{"gpt-4-0613", ModelPrice{Input: 0.03, Output: 0.06}},
+		{"gpt-4o-2024-08-06", ModelPrice{Input: 0.0025, Output: 0.01}},
+		{"gpt-4o-mini-2024-07-18", ModelPrice{Input: 0.00015, Output: 0.0006}},
+		{"llama3", defaultModelPrice},
+		{"", defaultModelPrice},
+	}
+	for _, tt := range tests {
+		if got := p.Lookup(tt.model); got != tt.want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	p := DefaultPricing()
+	got := p.Cost("gpt-4", api.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000})
+	if math.Abs(got-0.09) > 1e-9 {
+		t.Errorf("expected $0.09, got %f", got)
+	}
+	// Tokens without a breakdown are priced as input
+	got = p.Cost("gpt-4", api.Usage{TotalTokens: 500})
+	if math.Abs(got-0.015) > 1e-9 {
+		t.Errorf("expected $0.015, got %f", got)
+	}
+}
+
+func TestParsePricing(t *testing.T) {
+	p, err := ParsePricing(`{"gpt-4": {"input": 0.01, "output": 0.02}, "llama3": {"input": 0, "output": 0}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := p.Lookup("gpt-4-0613"); got != (ModelPrice{Input: 0.01, Output: 0.02}) {
+		t.Errorf("expected override to apply to gpt-4 snapshots, got %+v", got)
+	}
+	if got := p.Lookup("llama3"); got != (ModelPrice{}) {
+		t.Errorf("expected llama3 to be free, got %+v", got)
+	}
+	if got := p.Lookup("gpt-4o"); got != DefaultPricing()["gpt-4o"] {
+		t.Errorf("expected defaults to be kept, got %+v", got)
+	}
+
+	for _, s := range []string{`not json`, `{"gpt-4": {"input": -1}}`} {
+		if _, err := ParsePricing(s); err == nil {
+			t.Errorf("expected error for %s", s)
+		}
+	}
+}
+
+func TestCollectorModelPricing(t *testing.T) {
+	c := NewCollector()
+	c.RecordModelUsage("gpt-4", true, 0.99, 5, api.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000}, "p1")
+	c.RecordModelUsage("gpt-4", false, 0, 500, api.Usage{}, "p2")
+
+	if got := c.GetReport().TotalSavingsUSD; math.Abs(got-0.09) > 1e-9 {
+		t.Errorf("expected $0.09 saved, got %f", got)
+	}
+
+	c.SetPricing(Pricing{"default": {Input: 1, Output: 1}})
+	if got := c.SavedUSD("gpt-4", api.Usage{TotalTokens: 1000}); got != 1 {
+		t.Errorf("expected custom pricing to apply, got %f", got)
+	}
+}
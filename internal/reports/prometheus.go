@@ -0,0 +1,161 @@
+package reports
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// latencyBuckets are the request latency histogram bounds in milliseconds.
+// They include the dashboard's latency distribution bounds.
+var latencyBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// latencyBucket returns the index of the first bucket holding latencyMs,
+// len(latencyBuckets) for +Inf.
+func latencyBucket(latencyMs int64) int {
+	for i, bound := range latencyBuckets {
+		if latencyMs <= bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// similarityBuckets are the cache hit similarity histogram bounds, the
+// dashboard's similarity distribution bounds.
+var similarityBuckets = []float64{0.90, 0.95, 0.97, 0.99}
+
+// similarityBucket returns the index of the first bucket holding
+// similarity, len(similarityBuckets) for +Inf.
+func similarityBucket(similarity float64) int {
+	for i, bound := range similarityBuckets {
+		if similarity <= bound {
+			return i
+		}
+	}
+	return len(similarityBuckets)
+}
+
+// WritePrometheus writes the collector's lifetime totals in the Prometheus
+// text exposition format. entries is the current cache size, which the
+// collector does not track itself.
+func (c *Collector) WritePrometheus(w io.Writer, entries int) error {
+	c.mu.RLock()
+	hits, misses, requests := c.totalHits, c.totalMisses, c.totalRequests
+	tokensSaved, latencyMs := c.totalTokensSaved, c.totalLatencyMs
+	counts := append([]int64(nil), c.latencyCounts...)
+	cleanupRuns, expired, lastCleanup := c.cleanupRuns, c.totalExpired, c.lastCleanup
+	c.mu.RUnlock()
+	saturation := c.Saturation()
+
+	var hitRate float64
+	if requests > 0 {
+		hitRate = float64(hits) / float64(requests)
+	}
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+	metric("mimir_cache_hits_total", "counter", "Requests served from the cache.", float64(hits))
+	metric("mimir_cache_misses_total", "counter", "Requests forwarded upstream after a cache miss.", float64(misses))
+	metric("mimir_cache_entries", "gauge", "Entries currently in the cache.", float64(entries))
+	metric("mimir_cache_hit_rate", "gauge", "Fraction of requests served from the cache since startup.", hitRate)
+	metric("mimir_cache_expired_total", "counter", "Expired entries removed by background cache cleanup.", float64(expired))
+	metric("mimir_cache_cleanup_runs_total", "counter", "Background cache cleanup runs.", float64(cleanupRuns))
+	metric("mimir_cache_cleanup_last_duration_seconds", "gauge", "Duration of the last background cache cleanup run.", lastCleanup.Seconds())
+	metric("mimir_tokens_saved_total", "counter", "Tokens not spent upstream thanks to cache hits.", float64(tokensSaved))
+	metric("mimir_embeds_in_flight", "gauge", "Embedding calls in progress.", float64(saturation.EmbedsInFlight))
+	metric("mimir_upstream_in_flight", "gauge", "Upstream requests in progress.", float64(saturation.UpstreamInFlight))
+	metric("mimir_requests_waiting", "gauge", "Requests waiting on an identical request's upstream call or an upstream slot.", float64(saturation.RequestsWaiting))
+
+	const latency = "mimir_request_latency_seconds"
+	fmt.Fprintf(bw, "# HELP %s Chat completion request latency.\n# TYPE %s histogram\n", latency, latency)
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", latency, formatFloat(float64(bound)/1000), cumulative)
+	}
+	cumulative += counts[len(latencyBuckets)]
+	fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", latency, cumulative)
+	fmt.Fprintf(bw, "%s_sum %s\n", latency, formatFloat(float64(latencyMs)/1000))
+	fmt.Fprintf(bw, "%s_count %d\n", latency, cumulative)
+
+	return bw.Flush()
+}
+
+// WriteReportMetrics writes the rest of the dashboard report in the
+// Prometheus text exposition format: savings, failover and rate-limit
+// totals, the similarity of cache hits as a histogram, and per-model
+// totals labeled by model.
+func (c *Collector) WriteReportMetrics(w io.Writer) error {
+	c.mu.RLock()
+	savings, failovers, rateLimited := c.totalSavings, c.totalFailovers, c.totalRateLimited
+	hits, requests, firstSeen := c.totalHits, c.totalRequests, c.firstSeenMisses
+	counts := append([]int64(nil), c.similarityCounts...)
+	similaritySum := c.similaritySum
+	models := make([]ModelSavings, 0, len(c.models))
+	for model, totals := range c.models {
+		models = append(models, ModelSavings{Model: model, UsageTotals: *totals})
+	}
+	c.mu.RUnlock()
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+
+	var steadyHitRate float64
+	if steady := requests - firstSeen; steady > 0 {
+		steadyHitRate = float64(hits) / float64(steady)
+	}
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+	metric("mimir_savings_usd_total", "counter", "Estimated dollars saved by cache hits.", savings)
+	metric("mimir_failovers_total", "counter", "Requests served by the fallback upstream.", float64(failovers))
+	metric("mimir_upstream_rate_limited_total", "counter", "Upstream responses with status 429 or 503.", float64(rateLimited))
+	metric("mimir_first_seen_misses_total", "counter", "Cache misses on prompts seen for the first time.", float64(firstSeen))
+	metric("mimir_steady_state_hit_rate", "gauge", "Fraction of requests served from the cache, excluding first-seen misses.", steadyHitRate)
+
+	const similarity = "mimir_hit_similarity"
+	fmt.Fprintf(bw, "# HELP %s Similarity of cache hits to their prompts.\n# TYPE %s histogram\n", similarity, similarity)
+	var cumulative int64
+	for i, bound := range similarityBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", similarity, formatFloat(bound), cumulative)
+	}
+	cumulative += counts[len(similarityBuckets)]
+	fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", similarity, cumulative)
+	fmt.Fprintf(bw, "%s_sum %s\n", similarity, formatFloat(similaritySum))
+	fmt.Fprintf(bw, "%s_count %d\n", similarity, cumulative)
+
+	fmt.Fprint(bw, "# HELP mimir_model_requests_total Requests by model and cache result.\n# TYPE mimir_model_requests_total counter\n")
+	for _, m := range models {
+		label := escapeLabel(m.Model)
+		fmt.Fprintf(bw, "mimir_model_requests_total{model=\"%s\",result=\"hit\"} %d\n", label, m.Hits)
+		fmt.Fprintf(bw, "mimir_model_requests_total{model=\"%s\",result=\"miss\"} %d\n", label, m.Requests-m.Hits)
+	}
+	fmt.Fprint(bw, "# HELP mimir_model_tokens_saved_total Tokens saved by cache hits, by model.\n# TYPE mimir_model_tokens_saved_total counter\n")
+	for _, m := range models {
+		fmt.Fprintf(bw, "mimir_model_tokens_saved_total{model=\"%s\"} %d\n", escapeLabel(m.Model), m.TokensSaved)
+	}
+	fmt.Fprint(bw, "# HELP mimir_model_savings_usd_total Estimated dollars saved by cache hits, by model.\n# TYPE mimir_model_savings_usd_total counter\n")
+	for _, m := range models {
+		fmt.Fprintf(bw, "mimir_model_savings_usd_total{model=\"%s\"} %s\n", escapeLabel(m.Model), formatFloat(m.SavingsUSD))
+	}
+
+	return bw.Flush()
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,79 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	c := NewCollector()
+	c.RecordRequest(true, 0.99, 4, 500, "prompt1")
+	c.RecordRequest(true, 0.97, 30, 100, "prompt2")
+	c.RecordRequest(false, 0, 700, 0, "prompt3")
+	c.RecordRequest(false, 0, 20000, 0, "prompt4")
+	c.RecordCleanup(7, 250*time.Millisecond)
+
+	var sb strings.Builder
+	if err := c.WritePrometheus(&sb, 42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := sb.String()
+
+	for _, want := range []string{
+		"# TYPE mimir_cache_hits_total counter\nmimir_cache_hits_total 2\n",
+		"mimir_cache_misses_total 2\n",
+		"mimir_cache_entries 42\n",
+		"mimir_cache_hit_rate 0.5\n",
+		"mimir_tokens_saved_total 600\n",
+		"# TYPE mimir_cache_expired_total counter\nmimir_cache_expired_total 7\n",
+		"mimir_cache_cleanup_runs_total 1\n",
+		"mimir_cache_cleanup_last_duration_seconds 0.25\n",
+		"# TYPE mimir_request_latency_seconds histogram\n",
+		`mimir_request_latency_seconds_bucket{le="0.005"} 1` + "\n",
+		`mimir_request_latency_seconds_bucket{le="0.05"} 2` + "\n",
+		`mimir_request_latency_seconds_bucket{le="1"} 3` + "\n",
+		`mimir_request_latency_seconds_bucket{le="10"} 3` + "\n",
+		`mimir_request_latency_seconds_bucket{le="+Inf"} 4` + "\n",
+		"mimir_request_latency_seconds_sum 20.734\n",
+		"mimir_request_latency_seconds_count 4\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteReportMetrics(t *testing.T) {
+	c := NewCollector()
+	c.RecordModelRequest("gpt-4", true, 0.99, 4, 500, "prompt1")
+	c.RecordModelRequest("gpt-4", true, 0.96, 4, 0, "prompt2")
+	c.RecordModelRequest("gpt-4", false, 0, 700, 0, "prompt3")
+	c.RecordModelRequest(`my"model`, false, 0, 20, 0, "prompt4")
+	c.RecordFailover()
+
+	var sb strings.Builder
+	if err := c.WriteReportMetrics(&sb); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := sb.String()
+
+	for _, want := range []string{
+		"mimir_savings_usd_total 0.015\n",
+		"mimir_failovers_total 1\n",
+		"# TYPE mimir_hit_similarity histogram\n",
+		`mimir_hit_similarity_bucket{le="0.95"} 0` + "\n",
+		`mimir_hit_similarity_bucket{le="0.97"} 1` + "\n",
+		`mimir_hit_similarity_bucket{le="0.99"} 2` + "\n",
+		`mimir_hit_similarity_bucket{le="+Inf"} 2` + "\n",
+		"mimir_hit_similarity_count 2\n",
+		`mimir_model_requests_total{model="gpt-4",result="hit"} 2` + "\n",
+		`mimir_model_requests_total{model="gpt-4",result="miss"} 1` + "\n",
+		`mimir_model_requests_total{model="my\"model",result="miss"} 1` + "\n",
+		`mimir_model_tokens_saved_total{model="gpt-4"} 500` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package reports
+
+// Saturation is a snapshot of work in progress, for scaling on load
+// rather than CPU.
+type Saturation struct {
+	EmbedsInFlight   int64 `json:"embeds_in_flight"`
+	UpstreamInFlight int64 `json:"upstream_in_flight"`
+	RequestsWaiting  int64 `json:"requests_waiting"`
+}
+
+// TrackEmbed counts an embedding call as in flight until the returned
+// function is called.
+func (c *Collector) TrackEmbed() (done func()) {
+	c.embedsInFlight.Add(1)
+	return func() { c.embedsInFlight.Add(-1) }
+}
+
+// TrackUpstream counts an upstream request as in flight until the returned
+// function is called.
+func (c *Collector) TrackUpstream() (done func()) {
+	c.upstreamInFlight.Add(1)
+	return func() { c.upstreamInFlight.Add(-1) }
+}
+
+// TrackWaiting counts a request as waiting on another request's work until
+// the returned function is called.
+func (c *Collector) TrackWaiting() (done func()) {
+	c.waiting.Add(1)
+	return func() { c.waiting.Add(-1) }
+}
+
+// Saturation returns the current in-flight and waiting counts.
+func (c *Collector) Saturation() Saturation {
+	return Saturation{
+		EmbedsInFlight:   c.embedsInFlight.Load(),
+		UpstreamInFlight: c.upstreamInFlight.Load(),
+		RequestsWaiting:  c.waiting.Load(),
+	}
+}
@@ -0,0 +1,39 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSaturation(t *testing.T) {
+	c := NewCollector()
+	doneEmbed := c.TrackEmbed()
+	doneUpstream := c.TrackUpstream()
+	c.TrackUpstream()
+	doneWaiting := c.TrackWaiting()
+
+	if got := c.Saturation(); got != (Saturation{EmbedsInFlight: 1, UpstreamInFlight: 2, RequestsWaiting: 1}) {
+		t.Errorf("unexpected saturation: %+v", got)
+	}
+
+	var sb strings.Builder
+	if err := c.WritePrometheus(&sb, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE mimir_embeds_in_flight gauge\nmimir_embeds_in_flight 1\n",
+		"mimir_upstream_in_flight 2\n",
+		"mimir_requests_waiting 1\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, sb.String())
+		}
+	}
+
+	doneEmbed()
+	doneUpstream()
+	doneWaiting()
+	if got := c.Saturation(); got != (Saturation{UpstreamInFlight: 1}) {
+		t.Errorf("expected finished work to be released, got %+v", got)
+	}
+}
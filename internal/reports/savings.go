@@ -0,0 +1,197 @@
+package reports
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dayFormat keys the daily savings buckets, in UTC.
+const dayFormat = "2006-01-02"
+
+// UsageTotals sums requests and savings over a day, a model or a period.
+type UsageTotals struct {
+	Requests    int64   `json:"requests"`
+	Hits        int64   `json:"hits"`
+	TokensSaved int64   `json:"tokens_saved"`
+	SavingsUSD  float64 `json:"savings_usd"`
+}
+
+func (u *UsageTotals) add(o UsageTotals) {
+	u.Requests += o.Requests
+	u.Hits += o.Hits
+	u.TokensSaved += o.TokensSaved
+	u.SavingsUSD += o.SavingsUSD
+}
+
+// DaySavings is the usage of one UTC day.
+type DaySavings struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+// ModelSavings is the usage of one model over the report period.
+type ModelSavings struct {
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// Coverage describes how much of the requested period the collector has
+// history for. History starts when the process starts and is kept for the
+// retention window.
+type Coverage struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Complete bool      `json:"complete"`
+}
+
+// SavingsReport breaks usage and savings over a period down by day and by
+// model.
+type SavingsReport struct {
+	Period   string    `json:"period"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Coverage Coverage  `json:"coverage"`
+	UsageTotals
+	ByDay   []DaySavings   `json:"by_day"`
+	ByModel []ModelSavings `json:"by_model"`
+}
+
+// SetSavingsRetention sets how many days of per-model totals are kept for
+// the savings report.
+func (c *Collector) SetSavingsRetention(days int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retentionDays = days
+	c.pruneDaily(time.Now())
+}
+
+// recordDaily adds a request to its day and model bucket. Caller must hold
+// the lock.
+func (c *Collector) recordDaily(now time.Time, model string, cacheHit bool, tokensSaved int, savings float64) {
+	day := now.UTC().Format(dayFormat)
+	models, ok := c.daily[day]
+	if !ok {
+		models = make(map[string]*UsageTotals)
+		c.daily[day] = models
+		c.pruneDaily(now)
+	}
+	if model == "" {
+		model = "unknown"
+	}
+	totals, ok := models[model]
+	if !ok {
+		totals = &UsageTotals{}
+		models[model] = totals
+	}
+	totals.Requests++
+	if cacheHit {
+		totals.Hits++
+		totals.TokensSaved += int64(tokensSaved)
+		totals.SavingsUSD += savings
+	}
+}
+
+// recordModel adds a request to the lifetime totals of its model. Caller
+// must hold the lock.
+func (c *Collector) recordModel(model string, cacheHit bool, tokensSaved int, savings float64) {
+	if model == "" {
+		model = "unknown"
+	}
+	totals, ok := c.models[model]
+	if !ok {
+		totals = &UsageTotals{}
+		c.models[model] = totals
+	}
+	totals.Requests++
+	if cacheHit {
+		totals.Hits++
+		totals.TokensSaved += int64(tokensSaved)
+		totals.SavingsUSD += savings
+	}
+}
+
+// pruneDaily drops days older than the retention window. Caller must hold
+// the lock.
+func (c *Collector) pruneDaily(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, -c.retentionDays+1).Format(dayFormat)
+	for day := range c.daily {
+		if day < oldest {
+			delete(c.daily, day)
+		}
+	}
+}
+
+// ParsePeriod parses a report period such as "30d" or "12h".
+func ParsePeriod(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period %q", s)
+	}
+	return d, nil
+}
+
+// Savings returns the savings report for the period ending at now. Days
+// are the finest resolution kept, so the first day counts in full.
+func (c *Collector) Savings(now time.Time, period time.Duration, label string) *SavingsReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	from := now.Add(-period)
+	report := &SavingsReport{
+		Period:  label,
+		From:    from,
+		To:      now,
+		ByDay:   []DaySavings{},
+		ByModel: []ModelSavings{},
+	}
+
+	// History starts at process start and reaches back at most the retention window
+	covered := c.startTime
+	if retained := now.UTC().AddDate(0, 0, -c.retentionDays+1).Truncate(24 * time.Hour); retained.After(covered) {
+		covered = retained
+	}
+	report.Coverage = Coverage{From: from, To: now, Complete: !covered.After(from)}
+	if covered.After(from) {
+		report.Coverage.From = covered
+	}
+
+	firstDay := from.UTC().Format(dayFormat)
+	byModel := make(map[string]*UsageTotals)
+	for day, models := range c.daily {
+		if day < firstDay {
+			continue
+		}
+		daySavings := DaySavings{Date: day}
+		for model, totals := range models {
+			daySavings.add(*totals)
+			if _, ok := byModel[model]; !ok {
+				byModel[model] = &UsageTotals{}
+			}
+			byModel[model].add(*totals)
+		}
+		report.add(daySavings.UsageTotals)
+		report.ByDay = append(report.ByDay, daySavings)
+	}
+
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Date < report.ByDay[j].Date })
+	for model, totals := range byModel {
+		report.ByModel = append(report.ByModel, ModelSavings{Model: model, UsageTotals: *totals})
+	}
+	sort.Slice(report.ByModel, func(i, j int) bool {
+		if report.ByModel[i].SavingsUSD != report.ByModel[j].SavingsUSD {
+			return report.ByModel[i].SavingsUSD > report.ByModel[j].SavingsUSD
+		}
+		return report.ByModel[i].Model < report.ByModel[j].Model
+	})
+	return report
+}
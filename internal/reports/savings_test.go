@@ -0,0 +1,69 @@
+package reports
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSavings(t *testing.T) {
+	c := NewCollector()
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	c.startTime = now.AddDate(0, 0, -10)
+
+	c.recordDaily(now.AddDate(0, 0, -2), "gpt-4", true, 1000, 0.002)
+	c.recordDaily(now.AddDate(0, 0, -2), "gpt-4", false, 0, 0)
+	c.recordDaily(now, "gpt-4o-mini", true, 500, 0.001)
+	c.recordDaily(now, "", false, 0, 0)
+
+	report := c.Savings(now, 30*24*time.Hour, "30d")
+	if report.Requests != 4 || report.Hits != 2 || report.TokensSaved != 1500 {
+		t.Errorf("unexpected totals: %+v", report.UsageTotals)
+	}
+	if len(report.ByDay) != 2 || report.ByDay[0].Date != "2024-05-29" || report.ByDay[1].Requests != 2 {
+		t.Errorf("unexpected days: %+v", report.ByDay)
+	}
+	if len(report.ByModel) != 3 || report.ByModel[0].Model != "gpt-4" || report.ByModel[0].Hits != 1 {
+		t.Errorf("expected models ordered by savings, got %+v", report.ByModel)
+	}
+	if report.Coverage.Complete || !report.Coverage.From.Equal(c.startTime) {
+		t.Errorf("expected partial coverage from start time, got %+v", report.Coverage)
+	}
+
+	// A period within the history is fully covered and excludes older days
+	report = c.Savings(now, 24*time.Hour, "1d")
+	if !report.Coverage.Complete || len(report.ByDay) != 1 || report.Requests != 2 {
+		t.Errorf("unexpected 1d report: %+v", report)
+	}
+}
+
+func TestSavingsRetention(t *testing.T) {
+	c := NewCollector()
+	now := time.Now()
+	c.recordDaily(now.AddDate(0, 0, -5), "gpt-4", true, 100, 0.0002)
+	c.recordDaily(now, "gpt-4", true, 100, 0.0002)
+
+	c.SetSavingsRetention(3)
+	if len(c.daily) != 1 {
+		t.Errorf("expected days beyond retention to be dropped, got %d days", len(c.daily))
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"0d", 0, true},
+		{"month", 0, true},
+		{"-1h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePeriod(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePeriod(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package rules evaluates simple JSONPath conditions that decide whether a
+// request may be cached.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Rule actions.
+const (
+	// ActionSkip disables caching when the condition holds.
+	ActionSkip = "skip"
+	// ActionRequire disables caching unless the condition holds.
+	ActionRequire = "require"
+)
+
+// Rule is a single cacheability condition.
+type Rule struct {
+	Path   string      `json:"path"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value,omitempty"`
+	Action string      `json:"action"`
+
+	steps []step
+}
+
+// step is one segment of a compiled path: an object key or an array index.
+type step struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// Parse decodes a JSON array of rules and compiles their paths.
+func Parse(s string) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules JSON: %w", err)
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) compile() error {
+	switch r.Op {
+	case "==", "!=", "exists", "!exists", "contains":
+	default:
+		return fmt.Errorf("unsupported op %q", r.Op)
+	}
+	switch r.Action {
+	case ActionSkip, ActionRequire:
+	default:
+		return fmt.Errorf("unsupported action %q", r.Action)
+	}
+
+	steps, err := parsePath(r.Path)
+	if err != nil {
+		return err
+	}
+	r.steps = steps
+	return nil
+}
+
+// parsePath compiles the supported JSONPath subset: $, .key and [index].
+func parsePath(path string) ([]step, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+
+	var steps []step
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("path %q has an empty key", path)
+			}
+			steps = append(steps, step{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("path %q has an invalid index", path)
+			}
+			steps = append(steps, step{index: idx, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q is not supported", path)
+		}
+	}
+	return steps, nil
+}
+
+// Matches reports whether the rule's condition holds for doc, a value
+// decoded from JSON into interface{}.
+func (r *Rule) Matches(doc interface{}) bool {
+	v, found := r.lookup(doc)
+	switch r.Op {
+	case "exists":
+		return found
+	case "!exists":
+		return !found
+	case "==":
+		return found && equal(v, r.Value)
+	case "!=":
+		return !found || !equal(v, r.Value)
+	case "contains":
+		return found && contains(v, r.Value)
+	}
+	return false
+}
+
+func (r *Rule) lookup(doc interface{}) (interface{}, bool) {
+	cur := doc
+	for _, s := range r.steps {
+		if s.isIdx {
+			arr, ok := cur.([]interface{})
+			if !ok || s.index >= len(arr) {
+				return nil, false
+			}
+			cur = arr[s.index]
+			continue
+		}
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[s.key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Cacheable applies rules in order and reports whether doc may be cached,
+// returning the first rule that forbids it.
+func Cacheable(rules []Rule, doc interface{}) (bool, *Rule) {
+	for i := range rules {
+		r := &rules[i]
+		matches := r.Matches(doc)
+		if (r.Action == ActionSkip && matches) || (r.Action == ActionRequire && !matches) {
+			return false, r
+		}
+	}
+	return true, nil
+}
+
+// String describes the rule for logs.
+func (r *Rule) String() string {
+	if r.Op == "exists" || r.Op == "!exists" {
+		return fmt.Sprintf("%s %s %s", r.Action, r.Path, r.Op)
+	}
+	return fmt.Sprintf("%s %s %s %v", r.Action, r.Path, r.Op, r.Value)
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(v, want interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		s, ok := want.(string)
+		return ok && strings.Contains(v, s)
+	case []interface{}:
+		for _, item := range v {
+			if equal(item, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package rules
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`[{"path": "messages", "op": "exists", "action": "skip"}]`,
+		`[{"path": "$.messages[x]", "op": "exists", "action": "skip"}]`,
+		`[{"path": "$.messages[0", "op": "exists", "action": "skip"}]`,
+		`[{"path": "$.model", "op": ">", "value": 1, "action": "skip"}]`,
+		`[{"path": "$.model", "op": "exists", "action": "drop"}]`,
+	}
+
+	for _, s := range tests {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected error for %s", s)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	doc := decode(t, `{
+		"model": "gpt-4",
+		"messages": [{"role": "system", "content": "Be brief"}, {"role": "user", "content": "hi"}],
+		"metadata": {"no_cache": true, "tags": ["a", "b"]}
+	}`)
+
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{`{"path": "$.messages[0].role", "op": "==", "value": "system", "action": "require"}`, true},
+		{`{"path": "$.messages[1].role", "op": "==", "value": "system", "action": "require"}`, false},
+		{`{"path": "$.metadata.no_cache", "op": "==", "value": true, "action": "skip"}`, true},
+		{`{"path": "$.model", "op": "!=", "value": "gpt-4", "action": "skip"}`, false},
+		{`{"path": "$.metadata.tags", "op": "contains", "value": "b", "action": "skip"}`, true},
+		{`{"path": "$.messages[0].content", "op": "contains", "value": "brief", "action": "skip"}`, true},
+		{`{"path": "$.messages[5]", "op": "exists", "action": "skip"}`, false},
+		{`{"path": "$.user", "op": "!exists", "action": "skip"}`, true},
+	}
+
+	for _, tt := range tests {
+		rules, err := Parse("[" + tt.rule + "]")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tt.rule, err)
+		}
+		if got := rules[0].Matches(doc); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.rule, tt.want, got)
+		}
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	rules, err := Parse(`[
+		{"path": "$.messages[0].role", "op": "==", "value": "system", "action": "require"},
+		{"path": "$.metadata.no_cache", "op": "==", "value": true, "action": "skip"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc  string
+		want bool
+	}{
+		{`{"messages": [{"role": "system"}]}`, true},
+		{`{"messages": [{"role": "user"}]}`, false},
+		{`{"messages": [{"role": "system"}], "metadata": {"no_cache": true}}`, false},
+	}
+
+	for _, tt := range tests {
+		ok, rule := Cacheable(rules, decode(t, tt.doc))
+		if ok != tt.want {
+			t.Errorf("%s: expected cacheable=%v, got %v", tt.doc, tt.want, ok)
+		}
+		if !ok && rule == nil {
+			t.Errorf("%s: expected the blocking rule to be returned", tt.doc)
+		}
+	}
+}
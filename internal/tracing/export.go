@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aqstack/mimir/internal/logger"
+)
+
+const (
+	// exportInterval is how often queued spans are sent to the collector.
+	exportInterval = 5 * time.Second
+	// exportBatchSize sends a batch early once this many spans are queued.
+	exportBatchSize = 512
+	// maxQueuedSpans bounds the spans held while the collector is slow or
+	// down; spans ended beyond it are dropped.
+	maxQueuedSpans = 4096
+)
+
+// Tracer batches ended spans and exports them to an OTLP/HTTP collector
+// as JSON.
+type Tracer struct {
+	url      string
+	resource []attribute
+	client   *http.Client
+	logger   *logger.Logger
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a tracer exporting to the OTLP/HTTP collector at endpoint,
+// e.g. http://localhost:4318. Spans are posted to its /v1/traces path
+// unless endpoint already names it.
+func New(endpoint, version string, log *logger.Logger) *Tracer {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	t := &Tracer{
+		url: url,
+		resource: []attribute{
+			{"service.name", "mimir"},
+			{"service.version", version},
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: log,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.exportLoop()
+	return t
+}
+
+// enqueue queues an ended span for export.
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, s)
+	if len(t.queue) == exportBatchSize {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// exportLoop sends queued spans every exportInterval, or sooner when a
+// full batch is waiting, until Shutdown.
+func (t *Tracer) exportLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.kick:
+		case <-t.stop:
+			return
+		}
+		t.Flush(context.Background())
+	}
+}
+
+// Flush exports every queued span.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.logger.Warn("dropped trace spans, export queue full", "spans", dropped)
+	}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > exportBatchSize {
+			n = exportBatchSize
+		}
+		if err := t.export(ctx, spans[:n]); err != nil {
+			t.logger.Warn("failed to export trace spans", "spans", len(spans), "error", err)
+			return err
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+// Shutdown stops the export loop and exports the spans still queued.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	close(t.stop)
+	<-t.done
+	return t.Flush(ctx)
+}
+
+// export posts one batch of spans to the collector.
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers decimal strings, as the OTLP JSON mapping requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// statusError is the OTLP status code of a failed span.
+const statusError = 2
+
+// encode builds the export request for spans.
+func (t *Tracer) encode(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attrs),
+		}
+		if s.parentID != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			span.Status = &otlpStatus{Code: statusError, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+		encoded[i] = span
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes(t.resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "mimir"}, Spans: encoded}},
+	}}}
+}
+
+func encodeAttributes(attrs []attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var value otlpValue
+		switch v := a.value.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case float64:
+			value.DoubleValue = &v
+		}
+		encoded = append(encoded, otlpAttribute{Key: a.key, Value: value})
+	}
+	return encoded
+}
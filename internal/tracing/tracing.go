@@ -0,0 +1,199 @@
+// Package tracing records OpenTelemetry spans for mimir and exports them
+// to an OTLP/HTTP collector, propagating W3C trace context in the
+// traceparent header.
+//
+// A nil *Tracer is valid and records nothing, so tracing costs nothing
+// when no collector is configured. Child spans are only recorded under a
+// span started by StartRequest; without one, Start returns a nil *Span,
+// whose methods are no-ops.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+)
+
+// Span is one timed operation within a trace.
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attribute
+	errMsg string
+	ended  bool
+}
+
+// attribute is a span attribute; value is a string, bool, int64 or float64.
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span carried by ctx, or nil.
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartRequest starts the server span for an incoming request, continuing
+// the trace in its traceparent header when there is one. A caller that
+// asked not to be sampled gets no span.
+func (t *Tracer) StartRequest(ctx context.Context, r *http.Request, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kindServer, start: time.Now()}
+	if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		span.traceID, span.parentID = traceID, parentID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts an internal span as a child of the span in ctx, or returns
+// a nil span when ctx carries none.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return start(ctx, name, kindInternal)
+}
+
+// StartClient is Start for a call to another service.
+func StartClient(ctx context.Context, name string) (context.Context, *Span) {
+	return start(ctx, name, kindClient)
+}
+
+func start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:   parent.tracer,
+		traceID:  parent.traceID,
+		parentID: parent.spanID,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttr sets an attribute on the span. Values other than strings,
+// bools, integers and floats are recorded as strings.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, bool, int64, float64:
+	case int:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// SetError marks the span failed with err, when err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMsg = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// Inject sets the traceparent header for an outgoing request made within
+// the span in ctx. Headers are left alone when ctx carries no span, so a
+// caller's own traceparent still passes through.
+func Inject(ctx context.Context, header http.Header) {
+	if span := spanFromContext(ctx); span != nil {
+		header.Set("traceparent", span.traceparent())
+	}
+}
+
+// traceparent formats the span as a sampled W3C traceparent.
+func (s *Span) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// parseTraceparent parses a W3C traceparent header. Later versions may
+// append fields, which are ignored; all-zero IDs are invalid.
+func parseTraceparent(value string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return traceID, parentID, false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return traceID, parentID, false, false
+	}
+	if traceID == ([16]byte{}) || parentID == ([8]byte{}) {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aqstack/mimir/internal/logger"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz", true, true},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, sampled, ok := parseTraceparent(tt.value)
+			if ok != tt.ok || sampled != tt.sampled {
+				t.Errorf("parseTraceparent(%q) = sampled %v, ok %v; want %v, %v", tt.value, sampled, ok, tt.sampled, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	ctx, span := tracer.StartRequest(context.Background(), r, "request")
+	if span != nil {
+		t.Fatal("expected no span from a nil tracer")
+	}
+	_, child := Start(ctx, "child")
+	if child != nil {
+		t.Fatal("expected no child span without a request span")
+	}
+	child.SetAttr("key", "value")
+	child.SetError(errors.New("boom"))
+	child.End()
+
+	header := http.Header{}
+	Inject(ctx, header)
+	if header.Get("traceparent") != "" {
+		t.Errorf("expected no traceparent, got %q", header.Get("traceparent"))
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected a nil tracer to shut down cleanly, got %v", err)
+	}
+}
+
+// collector is a fake OTLP/HTTP collector recording the spans it receives.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	path     string
+	resource []otlpAttribute
+	spans    []otlpSpan
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid export body: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.path = r.URL.Path
+		for _, rs := range req.ResourceSpans {
+			c.resource = rs.Resource.Attributes
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestTracerExport(t *testing.T) {
+	c := newCollector(t)
+	log := logger.New(false, logger.LevelInfo)
+	log.SetOutput(io.Discard)
+	tracer := New(c.URL, "1.2.3", log)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.StartRequest(context.Background(), r, "request")
+	span.SetAttr("model", "gpt-4")
+	span.SetAttr("cache_hit", false)
+
+	clientCtx, client := StartClient(ctx, "upstream.request")
+	header := http.Header{}
+	Inject(clientCtx, header)
+	client.SetAttr("status", 502)
+	client.SetAttr("similarity", 0.97)
+	client.SetError(errors.New("bad gateway"))
+	client.End()
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path != "/v1/traces" {
+		t.Errorf("expected spans posted to /v1/traces, got %q", c.path)
+	}
+	if len(c.resource) == 0 || c.resource[0].Key != "service.name" || *c.resource[0].Value.StringValue != "mimir" {
+		t.Errorf("expected service.name=mimir, got %+v", c.resource)
+	}
+	if len(c.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(c.spans))
+	}
+
+	child, root := c.spans[0], c.spans[1]
+	if root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the request span to continue the incoming trace, got %+v", root)
+	}
+	if root.Kind != kindServer || child.Kind != kindClient {
+		t.Errorf("expected server and client kinds, got %d and %d", root.Kind, child.Kind)
+	}
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+		t.Errorf("expected the client span under the request span, got %+v", child)
+	}
+	if want := "00-" + child.TraceID + "-" + child.SpanID + "-01"; header.Get("traceparent") != want {
+		t.Errorf("expected traceparent %q, got %q", want, header.Get("traceparent"))
+	}
+	if child.Status == nil || child.Status.Code != statusError || child.Status.Message != "bad gateway" {
+		t.Errorf("expected an error status, got %+v", child.Status)
+	}
+
+	attrs := map[string]otlpValue{}
+	for _, a := range append(root.Attributes, child.Attributes...) {
+		attrs[a.Key] = a.Value
+	}
+	if v := attrs["model"].StringValue; v == nil || *v != "gpt-4" {
+		t.Errorf("expected model=gpt-4, got %+v", attrs["model"])
+	}
+	if v := attrs["cache_hit"].BoolValue; v == nil || *v {
+		t.Errorf("expected cache_hit=false, got %+v", attrs["cache_hit"])
+	}
+	if v := attrs["status"].IntValue; v == nil || *v != "502" {
+		t.Errorf("expected status=502, got %+v", attrs["status"])
+	}
+	if v := attrs["similarity"].DoubleValue; v == nil || *v != 0.97 {
+		t.Errorf("expected similarity=0.97, got %+v", attrs["similarity"])
+	}
+}
+
+func TestStartRequestNotSampled(t *testing.T) {
+	tracer := &Tracer{}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if _, span := tracer.StartRequest(context.Background(), r, "request"); span != nil {
+		t.Error("expected no span for an unsampled trace")
+	}
+
+	r.Header.Del("traceparent")
+	_, span := tracer.StartRequest(context.Background(), r, "request")
+	if span == nil || span.traceID == ([16]byte{}) || span.parentID != ([8]byte{}) {
+		t.Errorf("expected a new root span, got %+v", span)
+	}
+	if !strings.HasPrefix(span.traceparent(), "00-") {
+		t.Errorf("unexpected traceparent %q", span.traceparent())
+	}
+}
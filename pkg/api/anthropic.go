@@ -0,0 +1,40 @@
+package api
+
+// AnthropicRequest represents an Anthropic Messages API request. Only the
+// fields mimir reads are declared; the original body is forwarded as-is.
+type AnthropicRequest struct {
+	Model     string             `json:"model"`
+	System    interface{}        `json:"system,omitempty"` // string or []AnthropicContentBlock
+	Messages  []AnthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+// AnthropicMessage represents a message in an Anthropic request.
+type AnthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // string or []AnthropicContentBlock
+}
+
+// AnthropicContentBlock represents a content block of an Anthropic message.
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+// AnthropicResponse represents an Anthropic Messages API response.
+type AnthropicResponse struct {
+	ID         string                  `json:"id"`
+	Type       string                  `json:"type"`
+	Role       string                  `json:"role"`
+	Content    []AnthropicContentBlock `json:"content"`
+	Model      string                  `json:"model"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicUsage represents token usage of an Anthropic response.
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
@@ -0,0 +1,33 @@
+package api
+
+// CompletionRequest represents a legacy OpenAI completions request. Only
+// the fields mimir reads are declared; the original body is forwarded
+// as-is.
+type CompletionRequest struct {
+	Model       string      `json:"model"`
+	Prompt      interface{} `json:"prompt"` // string, []string, or token arrays
+	Suffix      string      `json:"suffix,omitempty"`
+	MaxTokens   *int        `json:"max_tokens,omitempty"`
+	Temperature *float64    `json:"temperature,omitempty"`
+	N           *int        `json:"n,omitempty"`
+	Stream      bool        `json:"stream,omitempty"`
+	Echo        bool        `json:"echo,omitempty"`
+}
+
+// CompletionResponse represents a legacy OpenAI completions response.
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   Usage              `json:"usage"`
+}
+
+// CompletionChoice represents a choice of a legacy completions response.
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	FinishReason string      `json:"finish_reason"`
+	Logprobs     interface{} `json:"logprobs"`
+}
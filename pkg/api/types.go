@@ -1,27 +1,37 @@
 // Package api provides OpenAI-compatible API types for mimir.
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ChatCompletionRequest represents an OpenAI chat completion request.
 type ChatCompletionRequest struct {
-	Model            string          `json:"model"`
-	Messages         []Message       `json:"messages"`
-	Temperature      *float64        `json:"temperature,omitempty"`
-	TopP             *float64        `json:"top_p,omitempty"`
-	N                *int            `json:"n,omitempty"`
-	Stream           bool            `json:"stream,omitempty"`
-	Stop             []string        `json:"stop,omitempty"`
-	MaxTokens        *int            `json:"max_tokens,omitempty"`
-	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
-	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
-	User             string          `json:"user,omitempty"`
-	Functions        []Function      `json:"functions,omitempty"`
-	FunctionCall     interface{}     `json:"function_call,omitempty"`
-	Tools            []Tool          `json:"tools,omitempty"`
-	ToolChoice       interface{}     `json:"tool_choice,omitempty"`
-	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
-	Seed             *int            `json:"seed,omitempty"`
+	Model             string          `json:"model"`
+	Messages          []Message       `json:"messages"`
+	Temperature       *float64        `json:"temperature,omitempty"`
+	TopP              *float64        `json:"top_p,omitempty"`
+	N                 *int            `json:"n,omitempty"`
+	Stream            bool            `json:"stream,omitempty"`
+	StreamOptions     *StreamOptions  `json:"stream_options,omitempty"`
+	Stop              []string        `json:"stop,omitempty"`
+	MaxTokens         *int            `json:"max_tokens,omitempty"`
+	PresencePenalty   *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty,omitempty"`
+	User              string          `json:"user,omitempty"`
+	Functions         []Function      `json:"functions,omitempty"`
+	FunctionCall      interface{}     `json:"function_call,omitempty"`
+	Tools             []Tool          `json:"tools,omitempty"`
+	ToolChoice        interface{}     `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
+	Seed              *int            `json:"seed,omitempty"`
+}
+
+// StreamOptions configures a streaming chat completion.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Message represents a chat message.
@@ -115,6 +125,40 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+// ChatCompletionChunk represents one server-sent event of a streaming chat completion.
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+// ChunkChoice represents a choice delta in a streaming chunk.
+type ChunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        ChunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+// ChunkDelta is the incremental message content of a streaming chunk.
+type ChunkDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ChunkToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChunkToolCall is a piece of a streamed tool call. The first piece for an
+// Index carries the ID, type and function name; later ones append to the
+// function arguments.
+type ChunkToolCall struct {
+	Index    int          `json:"index"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
 // EmbeddingRequest represents an OpenAI embedding request.
 type EmbeddingRequest struct {
 	Input          interface{} `json:"input"` // string or []string
@@ -160,13 +204,54 @@ type APIError struct {
 
 // CacheEntry represents a cached response with metadata.
 type CacheEntry struct {
-	Request    ChatCompletionRequest  `json:"request"`
-	Response   ChatCompletionResponse `json:"response"`
-	Embedding  []float64              `json:"embedding"`
-	CreatedAt  time.Time              `json:"created_at"`
-	ExpiresAt  time.Time              `json:"expires_at"`
-	HitCount   int64                  `json:"hit_count"`
-	LastHitAt  time.Time              `json:"last_hit_at"`
+	// ID identifies this version of the entry; it is assigned on Set and
+	// changes whenever a new response is stored
+	ID string `json:"id,omitempty"`
+
+	Request      ChatCompletionRequest  `json:"request"`
+	Response     ChatCompletionResponse `json:"response"`
+	Embedding    []float64              `json:"embedding"`
+	RawEmbedding []float64              `json:"raw_embedding,omitempty"` // original vector when Embedding is normalized
+	CreatedAt    time.Time              `json:"created_at"`
+	ExpiresAt    time.Time              `json:"expires_at"`
+	HitCount     int64                  `json:"hit_count"`
+	LastHitAt    time.Time              `json:"last_hit_at"`
+	Partition    string                 `json:"partition,omitempty"`
+	EmbedModel   string                 `json:"embed_model,omitempty"` // model that produced Embedding
+
+	// UnitEmbedding is the unit-length copy of Embedding the memory cache
+	// compares against, so similarity is a plain dot product. It is
+	// derived on Set and never serialized.
+	UnitEmbedding []float64 `json:"-"`
+	// UnitEmbedding32 replaces UnitEmbedding and Embedding when the memory
+	// cache stores vectors at float32 precision.
+	UnitEmbedding32 []float32 `json:"-"`
+
+	// ThresholdOffset adjusts the similarity threshold for this entry:
+	// negative after passing audits (matches more readily), positive after
+	// failing them.
+	ThresholdOffset float64 `json:"threshold_offset,omitempty"`
+
+	// CompressedResponse holds the gzipped JSON of the full response when
+	// the cache compresses bodies; Response then keeps only its metadata
+	// and usage.
+	CompressedResponse []byte `json:"compressed_response,omitempty"`
+	// CompressedRaw marks CompressedResponse as a gzipped RawResponse
+	// rather than the JSON of Response.
+	CompressedRaw bool `json:"compressed_raw,omitempty"`
+
+	// RawResponse holds the verbatim body of responses from non-OpenAI APIs,
+	// such as Anthropic Messages; Response then keeps only its metadata and
+	// usage.
+	RawResponse json.RawMessage `json:"raw_response,omitempty"`
+
+	// ErrorStatus is the HTTP status of a cached upstream client error. The
+	// error body is kept in RawResponse and Response is empty.
+	ErrorStatus int `json:"error_status,omitempty"`
+
+	// Headers holds the upstream response headers named in the replay
+	// allowlist, by canonical name, to be sent again on hits
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 // CacheStats represents cache statistics.
@@ -177,4 +262,18 @@ type CacheStats struct {
 	HitRate        float64 `json:"hit_rate"`
 	AvgSimilarity  float64 `json:"avg_similarity"`
 	EstimatedSaved float64 `json:"estimated_saved_usd"`
+
+	// Churn: entries evicted or expired, and how many of those were never hit
+	Evictions     int64   `json:"evictions"`
+	EvictedUnused int64   `json:"evicted_unused"`
+	ChurnRate     float64 `json:"churn_rate"`
+
+	// Resurrections counts misses served by an entry recently evicted to
+	// make room, which was moved back into the cache
+	Resurrections int64 `json:"resurrections,omitempty"`
+
+	// DimensionMismatches counts lookups and writes whose embedding did not
+	// have the dimensions of the cached entries, usually after a change of
+	// embedding model
+	DimensionMismatches int64 `json:"dimension_mismatches,omitempty"`
 }